@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/assets"
+)
+
+// runAssets implements "smdis assets": map the resource paths a set of
+// files loads to the functions loading them, optionally checked against
+// the files of the bundle.
+func runAssets(args []string) int {
+	fs := flag.NewFlagSet("assets", flag.ExitOnError)
+	list := fs.String("files", "", "file listing the bundle's files, one path per line")
+	bundle := fs.String("bundle", "", "bundle directory to check the assets against")
+	asJSON := fs.Bool("json", false, "write JSON")
+	ext := fs.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fs)
+	df.addDeobfuscate(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis assets [-files list.txt | -bundle dir] [-json] <file.jsc|dir>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || *list != "" && *bundle != "" {
+		fs.Usage()
+		return 2
+	}
+
+	paths, err := collectFiles(fs.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	m := assets.New()
+	for _, path := range paths {
+		root, dec, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		m.AddDecoded(path, root, dec)
+	}
+	as, dyn := m.Assets()
+
+	var files []string
+	switch {
+	case *list != "":
+		files, err = readLines(*list)
+	case *bundle != "":
+		files, err = bundleFiles(*bundle)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	checked := files != nil
+	missing, unused := assets.Check(as, dyn, files)
+
+	if *asJSON {
+		out := struct {
+			Assets  []*assets.Asset  `json:"assets"`
+			Dynamic []assets.Dynamic `json:"dynamic"`
+			Missing []string         `json:"missing,omitempty"`
+			Unused  []string         `json:"unused,omitempty"`
+		}{Assets: as, Dynamic: dyn, Unused: unused}
+		if out.Dynamic == nil {
+			out.Dynamic = []assets.Dynamic{}
+		}
+		for _, a := range missing {
+			out.Missing = append(out.Missing, a.Path)
+		}
+		return writeJSON(out)
+	}
+	for _, a := range as {
+		fmt.Printf("%-6s %s\n", a.Type, a.Path)
+		for _, r := range a.Refs {
+			fmt.Printf("         %s:%d %s @%05X  %s arg %d\n", r.File, r.Line, r.Func, r.Off, r.API, r.Arg)
+		}
+	}
+	if len(dyn) > 0 {
+		fmt.Println("\ndynamic:")
+		for _, d := range dyn {
+			fmt.Printf("  %s:%d %s @%05X  %s(%s)\n", d.File, d.Line, d.Func, d.Off, d.API, d.Expr)
+		}
+	}
+	if checked {
+		fmt.Printf("\nmissing (%d):\n", len(missing))
+		for _, a := range missing {
+			fmt.Printf("  %s  (%s)\n", a.Path, strings.Join(a.Funcs, " "))
+		}
+		fmt.Printf("\nunused (%d):\n", len(unused))
+		for _, f := range unused {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d assets, %d dynamic references in %d files\n", len(as), len(dyn), len(paths))
+	return 0
+}
+
+// readLines returns the non-empty lines of a file.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := []string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out, sc.Err()
+}
+
+// bundleFiles returns the files under dir, relative to it.
+func bundleFiles(dir string) ([]string, error) {
+	out := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err == nil {
+			out = append(out, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/audit"
+	"github.com/zboralski/spidermonkey-dumper/sm33/query"
+)
+
+// runAudit implements "smdis audit": run the security rules over a set
+// of files.
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	rulesList := fs.String("rules", "", "comma-separated rule files to add; a rule replaces the built-in rule of its name")
+	noBuiltin := fs.Bool("no-builtin", false, "run only the rules of -rules")
+	format := fs.String("format", "text", "output format: text, json, sarif")
+	minSev := fs.String("severity", audit.Note, "report findings at this severity or above: note, warning, error")
+	list := fs.Bool("list", false, "list the rules and exit")
+	ext := fs.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis audit [-rules files] [-format text|json|sarif] [-severity level] <file.jsc|dir>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var rules []*query.Rule
+	if !*noBuiltin {
+		rules = audit.Builtin()
+	}
+	if *rulesList != "" {
+		for _, path := range strings.Split(*rulesList, ",") {
+			extra, err := query.LoadRules(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return 1
+			}
+			rules = audit.Merge(rules, extra)
+		}
+	}
+	if err := audit.Check(rules); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	switch *minSev {
+	case audit.Error, audit.Warning, audit.Note:
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown severity %q\n", *minSev)
+		return 2
+	}
+	if *list {
+		for _, r := range rules {
+			fmt.Printf("%-22s %-8s %s\n", r.Name, r.Fields["severity"], r.Fields["message"])
+		}
+		return 0
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	paths, err := collectFiles(fs.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	findings := []audit.Finding{}
+	for _, path := range paths {
+		root, _, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		findings = append(findings, audit.Filter(audit.Scan(path, root, rules), *minSev)...)
+	}
+
+	switch *format {
+	case "json":
+		return writeJSON(findings)
+	case "sarif":
+		if err := audit.WriteSARIF(os.Stdout, rules, findings); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	case "text":
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown format %q\n", *format)
+		return 2
+	}
+	for _, f := range findings {
+		fmt.Printf("%s:%d: %s: [%s] %s\n", f.File, f.Line, f.Severity, f.Rule, f.Message)
+		fmt.Printf("    %s @%05X  %s\n", f.Func, f.Off, f.Evidence)
+	}
+	fmt.Fprintf(os.Stderr, "%d findings in %d files\n", len(findings), len(paths))
+	return 0
+}
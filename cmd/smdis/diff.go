@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/diff"
+)
+
+// runDiff implements "smdis diff": compare two builds function by function.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis diff [-json] <old.jsc> <new.jsc>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	old, _, _, err := df.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	new, _, _, err := df.load(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s: %v\n", fs.Arg(1), err)
+		return 1
+	}
+	d := diff.Compare(old, new)
+	if *asJSON {
+		return writeJSON(d)
+	}
+	fmt.Printf("--- %s\n+++ %s\n", fs.Arg(0), fs.Arg(1))
+	d.WriteText(os.Stdout)
+	return 0
+}
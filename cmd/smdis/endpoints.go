@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/endpoints"
+)
+
+// runEndpoints implements "smdis endpoints": list the requests, sockets
+// and URL literals of a set of files.
+func runEndpoints(args []string) int {
+	fs := flag.NewFlagSet("endpoints", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON")
+	kinds := fs.String("kind", "", "comma-separated kinds to keep: xhr, websocket, url")
+	ext := fs.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fs)
+	df.addDeobfuscate(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis endpoints [-json] [-kind list] <file.jsc|dir>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	keep, err := parseKinds(*kinds, endpoints.XHR, endpoints.WebSocket, endpoints.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+
+	paths, err := collectFiles(fs.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	out := []*endpoints.Endpoint{}
+	for _, path := range paths {
+		root, dec, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		for _, e := range endpoints.ScanDecoded(path, root, dec) {
+			if len(keep) == 0 || keep[e.Kind] {
+				out = append(out, e)
+			}
+		}
+	}
+
+	if *asJSON {
+		return writeJSON(out)
+	}
+	for _, e := range out {
+		what := e.URL
+		if e.Method != "" {
+			what = e.Method + " " + what
+		}
+		fmt.Printf("%s:%d: %s @%05X  %-9s %s\n", e.File, e.Line, e.Func, e.Off, e.Kind, what)
+		for _, h := range e.Headers {
+			fmt.Printf("    header  %s: %s\n", h.Name, h.Value)
+		}
+		if e.Body != "" {
+			fmt.Printf("    body    %s\n", e.Body)
+		}
+		for _, m := range e.Messages {
+			fmt.Printf("    message %s\n", m)
+		}
+		if e.Kind != endpoints.URL {
+			for _, ev := range e.Evidence {
+				fmt.Printf("    @%05X  %s\n", ev.Off, ev.Text)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d endpoints in %d files\n", len(out), len(paths))
+	return 0
+}
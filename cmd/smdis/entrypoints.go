@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+)
+
+// runEntrypoints implements "smdis entrypoints": list, per file, the
+// functions the script registers as event handlers, scheduler
+// callbacks, action callbacks and timers.
+func runEntrypoints(args []string) int {
+	fs := flag.NewFlagSet("entrypoints", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON")
+	kinds := fs.String("kind", "", "comma-separated kinds to keep: event, touch, schedule, action, timer, callback")
+	ext := fs.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fs)
+	df.addDeobfuscate(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis entrypoints [-json] [-kind list] <file.jsc|dir>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	keep, err := parseKinds(*kinds, callgraph.EntryEvent, callgraph.EntryTouch, callgraph.EntrySchedule,
+		callgraph.EntryAction, callgraph.EntryTimer, callgraph.EntryCallback)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+
+	paths, err := collectFiles(fs.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	type fileEntries struct {
+		File    string            `json:"file"`
+		Entries []callgraph.Entry `json:"entries"`
+	}
+	out := []fileEntries{}
+	n := 0
+	for _, path := range paths {
+		root, dec, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		fe := fileEntries{File: path, Entries: []callgraph.Entry{}}
+		for _, e := range callgraph.BuildDecoded(root, dec).Entries() {
+			if len(keep) == 0 || keep[e.Kind] {
+				fe.Entries = append(fe.Entries, e)
+			}
+		}
+		n += len(fe.Entries)
+		out = append(out, fe)
+	}
+
+	if *asJSON {
+		return writeJSON(out)
+	}
+	for _, fe := range out {
+		if len(fe.Entries) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", fe.File)
+		for _, e := range fe.Entries {
+			fmt.Printf("  %-8s %-40s via %s  (%s @%05X L%d)\n", e.Kind, e.Func, e.Via, e.Caller, e.Offset, e.Line)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d entry points in %d files\n", n, len(paths))
+	return 0
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/interp"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+)
+
+// runEval implements "smdis eval": call one function of the script tree
+// in the sandboxed interpreter and print its return value.
+func runEval(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	funcName := fs.String("func", "main", "function to call, by display name as shown in the callgraph")
+	argList := fs.String("args", "", "arguments: a JSON array, or comma-separated values (numbers and booleans are converted)")
+	noMain := fs.Bool("no-main", false, "do not run the top-level script before calling -func")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis eval -func name [-args list] <file.jsc>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	root, _, opt, err := df.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	m := interp.New(opt)
+	v, err := evalFunc(m, root, *funcName, *argList, *noMain)
+	fmt.Fprintf(os.Stderr, "steps %d, heap %d bytes\n", m.Steps(), m.HeapBytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Println(interp.Inspect(v))
+	return 0
+}
+
+// evalFunc runs root, then calls the function named name with the closure
+// the run created for it, so it sees the globals and captured variables
+// it would see at runtime. Functions the run never reached are called
+// without an enclosing scope.
+func evalFunc(m *interp.Machine, root *sm33.Script, name, argList string, noMain bool) (interp.Value, error) {
+	if name == "main" {
+		return m.Run(root)
+	}
+	fn := names.Infer(root).Func(name)
+	if fn == nil {
+		return interp.Value{}, fmt.Errorf("no function named %q", name)
+	}
+	args, err := parseArgs(m, argList)
+	if err != nil {
+		return interp.Value{}, err
+	}
+
+	var closure *interp.Closure
+	m.OnLambda = func(c *interp.Closure) {
+		if c.Func == fn {
+			closure = c
+		}
+	}
+	if !noMain {
+		if _, err := m.Run(root); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: main: %v\n", err)
+		}
+	}
+	m.OnLambda = nil
+	if closure == nil {
+		closure = &interp.Closure{Func: fn}
+	}
+	return m.Call(interp.ObjValue(m.NewFunction(closure)), interp.ObjValue(m.Global), args)
+}
+
+// parseArgs converts an -args value to interpreter values.
+func parseArgs(m *interp.Machine, s string) ([]interp.Value, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var out []interp.Value
+	if strings.HasPrefix(s, "[") {
+		var list []any
+		if err := json.Unmarshal([]byte(s), &list); err != nil {
+			return nil, fmt.Errorf("-args: %v", err)
+		}
+		for _, a := range list {
+			out = append(out, jsonValue(m, a))
+		}
+		return out, nil
+	}
+	for _, a := range strings.Split(s, ",") {
+		switch f, err := strconv.ParseFloat(a, 64); {
+		case err == nil:
+			out = append(out, interp.Num(f))
+		case a == "true" || a == "false":
+			out = append(out, interp.Boolean(a == "true"))
+		default:
+			out = append(out, interp.Str(a))
+		}
+	}
+	return out, nil
+}
+
+func jsonValue(m *interp.Machine, a any) interp.Value {
+	switch a := a.(type) {
+	case float64:
+		return interp.Num(a)
+	case string:
+		return interp.Str(a)
+	case bool:
+		return interp.Boolean(a)
+	case []any:
+		elems := make([]interp.Value, len(a))
+		for i, e := range a {
+			elems[i] = jsonValue(m, e)
+		}
+		return interp.ObjValue(m.NewArray(elems))
+	case map[string]any:
+		o := m.NewObject()
+		keys := make([]string, 0, len(a))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			o.Set(k, jsonValue(m, a[k]))
+		}
+		return interp.ObjValue(o)
+	}
+	return interp.Value{Kind: interp.Null}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/fingerprint"
+)
+
+// runFingerprint implements "smdis fingerprint": list the fingerprints of
+// one file, or match the functions of two builds.
+func runFingerprint(args []string) int {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis fingerprint [-json] <file.jsc> [<new.jsc>]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var sets [][]*fingerprint.Fingerprint
+	for _, path := range fs.Args() {
+		root, _, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		sets = append(sets, fingerprint.Compute(root))
+	}
+
+	if len(sets) == 1 {
+		if *asJSON {
+			return writeJSON(sets[0])
+		}
+		for _, f := range sets[0] {
+			fmt.Printf("%s %s %5d ops %4d blocks  %s\n", f.Exact, f.CFG, f.Ops, f.Blocks, f.Name)
+		}
+		return 0
+	}
+
+	m := fingerprint.MatchTrees(sets[0], sets[1])
+	if *asJSON {
+		type match struct {
+			A     string  `json:"a"`
+			B     string  `json:"b"`
+			Score float64 `json:"score"`
+			By    string  `json:"by"`
+		}
+		out := struct {
+			Matches []match  `json:"matches"`
+			OnlyA   []string `json:"only_a"`
+			OnlyB   []string `json:"only_b"`
+		}{Matches: []match{}, OnlyA: []string{}, OnlyB: []string{}}
+		for _, x := range m.Matches {
+			out.Matches = append(out.Matches, match{x.A.Name, x.B.Name, x.Score, x.By})
+		}
+		for _, f := range m.OnlyA {
+			out.OnlyA = append(out.OnlyA, f.Name)
+		}
+		for _, f := range m.OnlyB {
+			out.OnlyB = append(out.OnlyB, f.Name)
+		}
+		return writeJSON(out)
+	}
+	for _, x := range m.Matches {
+		fmt.Printf("%.2f %-5s %s -> %s\n", x.Score, x.By, x.A.Name, x.B.Name)
+	}
+	for _, f := range m.OnlyA {
+		fmt.Printf("-          %s\n", f.Name)
+	}
+	for _, f := range m.OnlyB {
+		fmt.Printf("+          %s\n", f.Name)
+	}
+	return 0
+}
+
+// writeJSON prints v as indented JSON on stdout.
+func writeJSON(v any) int {
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s\n", js)
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/identify"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
+)
+
+// runIdentify implements "smdis identify": guess the engine and framework
+// version of a bundle of .jsc files.
+func runIdentify(args []string) int {
+	fset := flag.NewFlagSet("identify", flag.ExitOnError)
+	asJSON := fset.Bool("json", false, "write JSON instead of text")
+	dbPath := fset.String("sigdb", "", "also match library functions from this signature database")
+	ext := fset.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fset)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis identify [-json] [-sigdb sigs.json] <file.jsc|dir>...\n\nFlags:\n")
+		fset.PrintDefaults()
+	}
+	fset.Parse(args)
+	if fset.NArg() < 1 {
+		fset.Usage()
+		return 2
+	}
+	opt, err := parseMode(*df.mode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	opt.MaxReadBytes = *df.maxReadBytes
+
+	var db *sigdb.DB
+	if *dbPath != "" {
+		if db, err = sigdb.Load(*dbPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	}
+
+	paths, err := collectFiles(fset.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	id := identify.New(db, opt)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		id.Add(path, data)
+	}
+
+	r := id.Report()
+	if *asJSON {
+		return writeJSON(r)
+	}
+	fmt.Printf("%d files, %d decoded, %d distinct opcodes\n", r.Files, r.Decoded, r.Opcodes)
+	for _, sec := range []struct {
+		title string
+		cands []*identify.Candidate
+	}{{"engine", r.Engine}, {"framework", r.Framework}} {
+		fmt.Printf("\n%s\n", sec.title)
+		if len(sec.cands) == 0 {
+			fmt.Printf("  unknown\n")
+		}
+		for _, c := range sec.cands {
+			fmt.Printf("  %.2f %s\n", c.Confidence, c.Name)
+			for _, e := range c.Evidence {
+				fmt.Printf("       %s\n", e)
+			}
+		}
+	}
+	for _, sec := range []struct {
+		title  string
+		counts []identify.Count
+	}{{"jsversion", r.Versions}, {"features", r.Features}} {
+		if len(sec.counts) == 0 {
+			continue
+		}
+		var parts []string
+		for _, c := range sec.counts {
+			parts = append(parts, fmt.Sprintf("%s (%d)", c.Name, c.Files))
+		}
+		fmt.Printf("\n%s: %s\n", sec.title, strings.Join(parts, ", "))
+	}
+	if len(r.Errors) > 0 {
+		fmt.Printf("\n%d files not decoded\n", len(r.Errors))
+		for _, e := range r.Errors {
+			fmt.Printf("  %s\n", e)
+		}
+	}
+	return 0
+}
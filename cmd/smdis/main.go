@@ -4,16 +4,22 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33"
 	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
 	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph/render"
+	"github.com/zboralski/spidermonkey-dumper/sm33/classes"
 	"github.com/zboralski/spidermonkey-dumper/sm33/decompile"
+	"github.com/zboralski/spidermonkey-dumper/sm33/deobf"
 	"github.com/zboralski/spidermonkey-dumper/sm33/disasm"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
 	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
 )
 
@@ -25,16 +31,128 @@ func printDiag(d sm33.Diagnostic) {
 	}
 }
 
+// parseMode returns the options for a -mode flag value.
+func parseMode(name string) (sm33.Options, error) {
+	switch name {
+	case "strict":
+		return sm33.DefaultOptions(), nil
+	case "besteffort":
+		return sm33.Options{Mode: sm33.BestEffort}, nil
+	}
+	return sm33.Options{}, fmt.Errorf("unknown mode %q (use strict or besteffort)", name)
+}
+
+// decodeFlags are the decode and interpreter flags shared by subcommands.
+type decodeFlags struct {
+	mode         *string
+	maxReadBytes *int
+	maxSteps     *int
+	maxHeap      *int
+	deobfuscate  *bool
+}
+
+func addDecodeFlags(fs *flag.FlagSet) *decodeFlags {
+	return &decodeFlags{
+		mode:         fs.String("mode", "strict", "decode mode: strict, besteffort"),
+		maxReadBytes: fs.Int("max-read-bytes", 0, "max bytes for a single XDR bytes() field (0 uses default)"),
+		maxSteps:     fs.Int("max-steps", 0, "interpreter instruction limit (0 uses default)"),
+		maxHeap:      fs.Int("max-heap-bytes", 0, "interpreter allocation limit (0 uses default)"),
+	}
+}
+
+// addDeobfuscate adds the -deobfuscate flag, for subcommands whose
+// analyses take the decoded strings that load returns.
+func (d *decodeFlags) addDeobfuscate(fs *flag.FlagSet) {
+	d.deobfuscate = fs.Bool("deobfuscate", false, "decode javascript-obfuscator string arrays first")
+}
+
+// load decodes path with the flag settings, printing diagnostics. With
+// -deobfuscate it also returns the strings deobf decoded.
+func (d *decodeFlags) load(path string) (*sm33.Script, ir.Decoded, sm33.Options, error) {
+	opt, err := parseMode(*d.mode)
+	if err != nil {
+		return nil, nil, opt, err
+	}
+	opt.MaxReadBytes = *d.maxReadBytes
+	opt.MaxSteps = *d.maxSteps
+	opt.MaxHeapBytes = *d.maxHeap
+	res, err := xdr.DecodeFileOpt(path, opt)
+	if err != nil {
+		return nil, nil, opt, err
+	}
+	for _, diag := range res.Diags {
+		printDiag(diag)
+	}
+	var dec ir.Decoded
+	if d.deobfuscate != nil && *d.deobfuscate {
+		dob, err := deobf.Apply(res.Value, opt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: deobfuscate: %v\n", err)
+		}
+		if dob != nil {
+			dec = dob.Decoded
+		}
+	}
+	return res.Value, dec, opt, nil
+}
+
+// subcommands run instead of the disassembler when named as the first
+// argument. Each parses its own flags and returns an exit code.
+var subcommands = map[string]func(args []string) int{
+	"assets":      runAssets,
+	"audit":       runAudit,
+	"diff":        runDiff,
+	"endpoints":   runEndpoints,
+	"entrypoints": runEntrypoints,
+	"eval":        runEval,
+	"fingerprint": runFingerprint,
+	"identify":    runIdentify,
+	"project":     runProject,
+	"query":       runQuery,
+	"sigdb":       runSigdb,
+	"strings":     runStrings,
+	"trace":       runTrace,
+	"xref":        runXref,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	decompileFlag := flag.Bool("decompile", false, "decompile bytecode via LLM")
 	callgraphFlag := flag.Bool("callgraph", false, "generate callgraph SVG")
+	callgraphMode := flag.String("callgraph-mode", "unique", "callgraph edges: unique (one weighted edge per pair), all (one edge per call site)")
+	hideDefines := flag.Bool("hide-defines", false, "callgraph: hide containment edges from a function to the functions it defines")
 	cfgFlag := flag.Bool("controlflow", false, "generate control flow graph SVG")
+	annotate := flag.Bool("annotate", false, "annotate disassembly with def-use chains and folded constants")
+	xrefs := flag.Bool("xrefs", false, "list the call sites of each function above its disassembly")
+	deobfuscate := flag.Bool("deobfuscate", false, "decode javascript-obfuscator string arrays before analysis")
+	classesFlag := flag.Bool("classes", false, "reconstruct class hierarchies (JSON + class diagram SVG)")
 	backend := flag.String("backend", "claude-code", "LLM backend: claude-code, codex")
 	model := flag.String("model", "", "model name (backend-specific)")
 	modeName := flag.String("mode", "strict", "decode mode: strict, besteffort")
 	maxReadBytes := flag.Int("max-read-bytes", 0, "max bytes for a single XDR bytes() field (0 uses default)")
+	sigdbPath := flag.String("sigdb", "", "label known library functions from this signature database")
+	libraryView := flag.String("library", "label", "library functions in disassembly and callgraph: label, collapse, hide")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: smdis [flags] <file.jsc>\n\nFlags:\n")
+		fmt.Fprintf(os.Stderr, "usage: smdis [flags] <file.jsc>\n")
+		fmt.Fprintf(os.Stderr, "       smdis eval -func name [-args list] <file.jsc>\n")
+		fmt.Fprintf(os.Stderr, "       smdis trace [-stubs list] [-all] <file.jsc>\n")
+		fmt.Fprintf(os.Stderr, "       smdis fingerprint [-json] <file.jsc> [<new.jsc>]\n")
+		fmt.Fprintf(os.Stderr, "       smdis diff [-json] <old.jsc> <new.jsc>\n")
+		fmt.Fprintf(os.Stderr, "       smdis sigdb -lib name [-o sigs.json] <lib.jsc>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis identify [-json] [-sigdb sigs.json] <file.jsc|dir>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis project [-defines name] [-uses name] [-callgraph stem] <file.jsc|dir>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis xref [-json] [-kind kind] <file.jsc> <name>\n")
+		fmt.Fprintf(os.Stderr, "       smdis query [-C n] [-json] [-rules file] <file.jsc> [<query>]\n")
+		fmt.Fprintf(os.Stderr, "       smdis audit [-rules files] [-format text|json|sarif] <file.jsc|dir>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis strings [-format csv|json] [-match re] [-min n] <file.jsc|dir>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis assets [-files list.txt | -bundle dir] [-json] <file.jsc|dir>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis endpoints [-json] [-kind list] <file.jsc|dir>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis entrypoints [-json] [-kind list] <file.jsc|dir>...\n\nFlags:\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -44,17 +162,13 @@ func main() {
 		os.Exit(2)
 	}
 
-	var opt sm33.Options
-	switch *modeName {
-	case "strict":
-		opt = sm33.DefaultOptions()
-	case "besteffort":
-		opt = sm33.Options{Mode: sm33.BestEffort}
-	default:
-		fmt.Fprintf(os.Stderr, "error: unknown mode %q (use strict or besteffort)\n", *modeName)
+	opt, err := parseMode(*modeName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(2)
 	}
 	opt.MaxReadBytes = *maxReadBytes
+	view := disasm.View{Annotate: *annotate, Xrefs: *xrefs}
 
 	path := flag.Arg(0)
 	res, err := xdr.DecodeFileOpt(path, opt)
@@ -66,88 +180,95 @@ func main() {
 		printDiag(d)
 	}
 
-	ext := filepath.Ext(path)
-	base := strings.TrimSuffix(path, ext)
+	if *deobfuscate {
+		d, err := deobf.Apply(res.Value, opt)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "warning: deobfuscate: %v\n", err)
+		case d == nil:
+			fmt.Fprintf(os.Stderr, "deobfuscate: no string array decoder found\n")
+		default:
+			fmt.Fprintf(os.Stderr, "deobfuscate: %s\n", d)
+			view.Decoded = d.Decoded
+		}
+	}
 
-	// Callgraph mode
-	if *callgraphFlag {
-		dotPath, err := exec.LookPath("dot")
+	if *sigdbPath != "" {
+		db, err := sigdb.Load(*sigdbPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: graphviz not found (install with: brew install graphviz)\n")
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-
-		g := callgraph.Build(res.Value)
-		title := filepath.Base(path)
-		if res.Value.Filename != "" {
-			title = filepath.Base(res.Value.Filename)
+		if view.LibraryView, err = parseLibraryView(*libraryView); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
 		}
-		dot := render.DOT(g, title)
+		view.Library = sigdb.Functions(db.Match(res.Value))
+		fmt.Fprintf(os.Stderr, "sigdb: %d library functions\n", len(view.Library))
+	}
 
-		// Write .dot file
-		dotFile := base + ".dot"
-		if err := os.WriteFile(dotFile, []byte(dot), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: could not write %s: %v\n", dotFile, err)
-		}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
 
-		// Run dot to generate SVG and PNG
-		for _, ext := range []string{"svg", "png"} {
-			outFile := base + "." + ext
-			args := []string{"-T" + ext, "-o", outFile, dotFile}
-			if ext == "png" {
-				args = []string{"-T" + ext, "-Gdpi=200", "-o", outFile, dotFile}
-			}
-			cmd := exec.Command(dotPath, args...)
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				fmt.Fprintf(os.Stderr, "error: dot -T%s failed: %v\n", ext, err)
-				os.Exit(1)
-			}
-			fmt.Fprintf(os.Stderr, "wrote %s\n", outFile)
+	title := filepath.Base(path)
+	if res.Value.Filename != "" {
+		title = filepath.Base(res.Value.Filename)
+	}
+
+	// Callgraph mode
+	if *callgraphFlag {
+		graphMode, err := render.ParseMode(*callgraphMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
+		}
+		g := callgraph.BuildDecoded(res.Value, view.Decoded)
+		dot := render.DOTOpt(g, title, render.Options{
+			Mode:        graphMode,
+			HideDefines: *hideDefines,
+			Library:     view.Library,
+			LibraryView: view.LibraryView,
+		})
+		if err := writeGraph(dot, base); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "wrote %s\n", dotFile)
 		return
 	}
 
 	// CFG mode
 	if *cfgFlag {
-		dotPath, err := exec.LookPath("dot")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: graphviz not found (install with: brew install graphviz)\n")
+		g := callgraph.BuildCFG(res.Value)
+		dot := render.DOTCFG(g, title)
+		if err := writeGraph(dot, base+".cfg"); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
+		return
+	}
 
-		g := callgraph.BuildCFG(res.Value)
-		title := filepath.Base(path)
-		if res.Value.Filename != "" {
-			title = filepath.Base(res.Value.Filename)
+	// Class hierarchy mode
+	if *classesFlag {
+		m := classes.Extract(res.Value)
+		js, err := m.JSON()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
-		dot := render.DOTCFG(g, title)
-
-		dotFile := base + ".cfg.dot"
-		if err := os.WriteFile(dotFile, []byte(dot), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: could not write %s: %v\n", dotFile, err)
+		jsonFile := base + ".classes.json"
+		if err := os.WriteFile(jsonFile, append(js, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
-
-		for _, ext := range []string{"svg", "png"} {
-			outFile := base + ".cfg." + ext
-			args := []string{"-T" + ext, "-o", outFile, dotFile}
-			if ext == "png" {
-				args = []string{"-T" + ext, "-Gdpi=200", "-o", outFile, dotFile}
-			}
-			cmd := exec.Command(dotPath, args...)
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				fmt.Fprintf(os.Stderr, "error: dot -T%s failed: %v\n", ext, err)
-				os.Exit(1)
-			}
-			fmt.Fprintf(os.Stderr, "wrote %s\n", outFile)
+		fmt.Fprintf(os.Stderr, "wrote %s\n", jsonFile)
+		if err := writeGraph(render.DOTClasses(m, title), base+".classes"); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "wrote %s\n", dotFile)
 		return
 	}
 
-	disRes, err := disasm.DisasmTreeOpt(res.Value, opt)
+	disRes, err := disasm.DisasmTreeView(res.Value, opt, view)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -191,3 +312,70 @@ func main() {
 		}
 	}
 }
+
+// collectFiles expands the file and directory arguments of a bundle
+// command: files are taken as given, directories are walked for files
+// with extension ext.
+func collectFiles(roots []string, ext string) ([]string, error) {
+	var out []string
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && (path == root || strings.EqualFold(filepath.Ext(path), ext)) {
+				out = append(out, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// parseKinds splits the comma-separated -kind list of a bundle command
+// and rejects any kind not in valid. An empty list keeps every kind.
+func parseKinds(list string, valid ...string) (map[string]bool, error) {
+	keep := map[string]bool{}
+	for _, k := range strings.Split(list, ",") {
+		if k == "" {
+			continue
+		}
+		if !slices.Contains(valid, k) {
+			return nil, fmt.Errorf("unknown kind %q (want %s)", k, strings.Join(valid, ", "))
+		}
+		keep[k] = true
+	}
+	return keep, nil
+}
+
+// writeGraph writes stem.dot and renders stem.svg and stem.png with graphviz.
+func writeGraph(dot, stem string) error {
+	dotPath, err := exec.LookPath("dot")
+	if err != nil {
+		return fmt.Errorf("graphviz not found (install with: brew install graphviz)")
+	}
+
+	dotFile := stem + ".dot"
+	if err := os.WriteFile(dotFile, []byte(dot), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not write %s: %v\n", dotFile, err)
+	}
+
+	for _, ext := range []string{"svg", "png"} {
+		outFile := stem + "." + ext
+		args := []string{"-T" + ext, "-o", outFile, dotFile}
+		if ext == "png" {
+			args = []string{"-T" + ext, "-Gdpi=200", "-o", outFile, dotFile}
+		}
+		cmd := exec.Command(dotPath, args...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("dot -T%s failed: %v", ext, err)
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s\n", outFile)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", dotFile)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph/render"
+	"github.com/zboralski/spidermonkey-dumper/sm33/project"
+)
+
+// runProject implements "smdis project": index the globals of a set of
+// files, answer who defines or reads one, or draw the combined callgraph.
+func runProject(args []string) int {
+	fs := flag.NewFlagSet("project", flag.ExitOnError)
+	defines := fs.String("defines", "", "list the instructions that define this global")
+	uses := fs.String("uses", "", "list the instructions that read this global")
+	graph := fs.String("callgraph", "", "write the combined callgraph to <stem>.dot/.svg/.png")
+	hideDefines := fs.Bool("hide-defines", false, "callgraph: hide containment edges")
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	ext := fs.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis project [-defines name] [-uses name] [-callgraph stem] [-json] <file.jsc|dir>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	if *defines != "" && *uses != "" {
+		fmt.Fprintf(os.Stderr, "error: -defines and -uses are mutually exclusive\n")
+		return 2
+	}
+
+	paths, err := collectFiles(fs.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	p := project.New()
+	for _, path := range paths {
+		root, _, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		p.Add(path, root)
+	}
+
+	if *graph != "" {
+		opt := render.Options{HideDefines: *hideDefines}
+		for _, f := range p.Files {
+			opt.Clusters = append(opt.Clusters, render.Cluster{Name: f.Name, Nodes: f.Nodes()})
+		}
+		title := fmt.Sprintf("%d files", len(p.Files))
+		if err := writeGraph(render.DOTOpt(p.Graph(), title, opt), *graph); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if *defines != "" || *uses != "" {
+		refs := p.Defs[*defines]
+		if *uses != "" {
+			refs = p.Uses[*uses]
+		}
+		if *asJSON {
+			if refs == nil {
+				refs = []project.Ref{}
+			}
+			return writeJSON(refs)
+		}
+		for _, r := range refs {
+			fmt.Printf("%s:%d  %-8s @%05X  %s\n", r.File, r.Line, r.Op, r.Off, r.Func)
+		}
+		return 0
+	}
+
+	type global struct {
+		Name    string   `json:"name"`
+		Defs    int      `json:"defs"`
+		Uses    int      `json:"uses"`
+		Defined []string `json:"defined_in"`
+	}
+	out := []global{}
+	for _, name := range p.Globals() {
+		g := global{Name: name, Defs: len(p.Defs[name]), Uses: len(p.Uses[name]), Defined: []string{}}
+		seen := map[string]bool{}
+		for _, r := range p.Defs[name] {
+			if !seen[r.File] {
+				seen[r.File] = true
+				g.Defined = append(g.Defined, r.File)
+			}
+		}
+		out = append(out, g)
+	}
+	if *asJSON {
+		return writeJSON(out)
+	}
+	for _, g := range out {
+		where := strings.Join(g.Defined, ",")
+		if where == "" {
+			where = "-"
+		}
+		fmt.Printf("%4d def %5d use  %-32s %s\n", g.Defs, g.Uses, g.Name, where)
+	}
+	return 0
+}
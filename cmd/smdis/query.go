@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/disasm"
+	"github.com/zboralski/spidermonkey-dumper/sm33/query"
+)
+
+// runQuery implements "smdis query": match a pattern, or the rules of a
+// rule file, against the instructions of a script.
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	rulesPath := fs.String("rules", "", "run the rules of this file instead of a query")
+	context := fs.Int("C", 2, "instructions of context around each match")
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	save := fs.String("save", "", "append the query to this rule file as rule -name")
+	name := fs.String("name", "", "rule name for -save")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis query [-C n] [-json] [-save rules -name rule] <file.jsc> <query>\n")
+		fmt.Fprintf(os.Stderr, "       smdis query -rules file [-C n] [-json] <file.jsc>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var rules []*query.Rule
+	switch {
+	case *rulesPath != "" && fs.NArg() == 1:
+		var err error
+		if rules, err = query.LoadRules(*rulesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	case *rulesPath == "" && fs.NArg() == 2:
+		q, err := query.Parse(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 2
+		}
+		rules = []*query.Rule{{Name: *name, Query: q}}
+		if *save != "" {
+			if *name == "" {
+				fmt.Fprintf(os.Stderr, "error: -save needs -name\n")
+				return 2
+			}
+			if err := appendRule(*save, rules[0]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return 1
+			}
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	root, _, opt, err := df.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	type result struct {
+		Rule    string        `json:"rule,omitempty"`
+		Matches []query.Match `json:"matches"`
+	}
+	var results []result
+	for _, r := range rules {
+		ms := r.Query.Run(root)
+		if ms == nil {
+			ms = []query.Match{}
+		}
+		results = append(results, result{Rule: r.Name, Matches: ms})
+	}
+	if *asJSON {
+		if *rulesPath == "" {
+			return writeJSON(results[0].Matches)
+		}
+		return writeJSON(results)
+	}
+
+	ctx := &matchContext{lines: map[*sm33.Script][]string{}, opt: opt}
+	n := 0
+	for _, res := range results {
+		for _, m := range res.Matches {
+			if n > 0 {
+				fmt.Println()
+			}
+			n++
+			head := fmt.Sprintf("%s:%d @%05X", m.Func, m.Line, m.Off)
+			if res.Rule != "" && *rulesPath != "" {
+				head = "[" + res.Rule + "] " + head
+			}
+			fmt.Println(head + captures(m.Captures))
+			ctx.print(m, *context)
+		}
+	}
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "no matches\n")
+		return 1
+	}
+	return 0
+}
+
+// appendRule adds r to the rule file at path, creating it if needed.
+func appendRule(path string, r *query.Rule) error {
+	if old, err := query.LoadRules(path); err == nil {
+		for _, o := range old {
+			if o.Name == r.Name {
+				return fmt.Errorf("%s: rule %q already exists", path, r.Name)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if st, err := f.Stat(); err == nil && st.Size() > 0 {
+		fmt.Fprintln(f)
+	}
+	if err := query.WriteRule(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func captures(caps map[string]string) string {
+	if len(caps) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(caps))
+	for k := range caps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("$%s=%q", k, caps[k]))
+	}
+	return "  " + strings.Join(parts, " ")
+}
+
+// matchContext prints matches with the surrounding disassembly.
+type matchContext struct {
+	lines map[*sm33.Script][]string
+	opt   sm33.Options
+}
+
+func (c *matchContext) print(m query.Match, n int) {
+	lines, ok := c.lines[m.Script]
+	if !ok {
+		res, err := disasm.DisasmScriptOpt(m.Script, m.Func, false, c.opt)
+		if err == nil {
+			lines = strings.Split(strings.TrimRight(res.Value, "\n"), "\n")
+		}
+		c.lines[m.Script] = lines
+	}
+	at := func(off int) int {
+		prefix := fmt.Sprintf("%05X ", off)
+		for i, l := range lines {
+			if strings.HasPrefix(l, prefix) {
+				return i
+			}
+		}
+		return -1
+	}
+	first, last := at(m.Off), at(m.End)
+	if first < 0 || last < 0 {
+		return
+	}
+	hit := map[int]bool{}
+	for _, off := range m.Offs {
+		hit[at(off)] = true
+	}
+	for i := max(first-n, 0); i <= min(last+n, len(lines)-1); i++ {
+		mark := "   "
+		if hit[i] {
+			mark = " > "
+		}
+		fmt.Println(mark + strings.TrimRight(lines[i], " "))
+	}
+}
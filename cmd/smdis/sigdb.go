@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
+)
+
+// runSigdb implements "smdis sigdb": add the functions of library scripts
+// to a signature database, or label a script's functions from one.
+func runSigdb(args []string) int {
+	fs := flag.NewFlagSet("sigdb", flag.ExitOnError)
+	out := fs.String("o", "sigs.json", "database to create or extend")
+	lib := fs.String("lib", "", "library label for the added functions, e.g. cocos2d-js-3.13")
+	match := fs.String("match", "", "label the functions of <file.jsc> from this database instead")
+	asJSON := fs.Bool("json", false, "with -match: write JSON instead of text")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis sigdb -lib name [-o sigs.json] <lib.jsc>...\n")
+		fmt.Fprintf(os.Stderr, "       smdis sigdb -match sigs.json [-json] <file.jsc>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *match != "" {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		db, err := sigdb.Load(*match)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		root, _, _, err := df.load(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		labels := db.Match(root)
+		if *asJSON {
+			if labels == nil {
+				labels = []sigdb.Label{}
+			}
+			return writeJSON(labels)
+		}
+		for _, l := range labels {
+			fmt.Printf("%.2f %-5s %s = %s: %s\n", l.Score, l.By, l.Func, l.Library, l.Name)
+		}
+		return 0
+	}
+
+	if *lib == "" || fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	db := sigdb.New()
+	if _, err := os.Stat(*out); err == nil {
+		if db, err = sigdb.Load(*out); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	}
+	for _, path := range fs.Args() {
+		root, _, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		file := filepath.Base(path)
+		if root.Filename != "" {
+			file = filepath.Base(root.Filename)
+		}
+		n := db.Add(*lib, file, root)
+		fmt.Fprintf(os.Stderr, "%s: %d signatures\n", path, n)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if err := db.Write(f); err != nil {
+		f.Close()
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s (%d signatures)\n", *out, len(db.Signatures))
+	return 0
+}
+
+// parseLibraryView returns the view for a -library flag value.
+func parseLibraryView(name string) (sigdb.LibraryView, error) {
+	switch name {
+	case "label":
+		return sigdb.LibraryLabel, nil
+	case "collapse":
+		return sigdb.LibraryCollapse, nil
+	case "hide":
+		return sigdb.LibraryHide, nil
+	}
+	return sigdb.LibraryLabel, fmt.Errorf("unknown library view %q (use label, collapse or hide)", name)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/literals"
+)
+
+// runStrings implements "smdis strings": dump the atoms, string
+// constants, regexps and object-literal keys of a set of files.
+func runStrings(args []string) int {
+	fs := flag.NewFlagSet("strings", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format: csv, json")
+	match := fs.String("match", "", "only strings matching this regexp")
+	minLen := fs.Int("min", 0, "only strings of at least this many characters")
+	kinds := fs.String("kind", "", "comma-separated kinds to keep: atom, const, regexp, key")
+	op := fs.String("op", "", "only references by this opcode (string lists literals)")
+	ext := fs.String("ext", ".jsc", "extension of the files to read from directories")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis strings [-format csv|json] [-match re] [-min n] [-kind list] [-op name] <file.jsc|dir>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown format %q\n", *format)
+		return 2
+	}
+	var re *regexp.Regexp
+	if *match != "" {
+		var err error
+		if re, err = regexp.Compile(*match); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 2
+		}
+	}
+	keep := map[string]bool{}
+	for _, k := range strings.Split(*kinds, ",") {
+		if k != "" {
+			keep[k] = true
+		}
+	}
+
+	paths, err := collectFiles(fs.Args(), *ext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	r := literals.New()
+	for _, path := range paths {
+		root, _, _, err := df.load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+			return 1
+		}
+		r.Add(path, root)
+	}
+
+	out := []*literals.Entry{}
+	for _, e := range r.Entries() {
+		if len(keep) > 0 && !keep[e.Kind] ||
+			utf8.RuneCountInString(e.Value) < *minLen ||
+			re != nil && !re.MatchString(e.Value) {
+			continue
+		}
+		if *op != "" {
+			var refs []literals.Ref
+			for _, ref := range e.Refs {
+				if ref.Op == *op {
+					refs = append(refs, ref)
+				}
+			}
+			if len(refs) == 0 {
+				continue
+			}
+			e.Refs, e.Funcs = refs, nil
+			seen := map[string]bool{}
+			for _, ref := range refs {
+				if f := ref.File + ":" + ref.Func; !seen[f] {
+					seen[f] = true
+					e.Funcs = append(e.Funcs, f)
+				}
+			}
+		}
+		out = append(out, e)
+	}
+
+	if *format == "json" {
+		return writeJSON(out)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"kind", "encoding", "value", "refs", "funcs", "contexts"})
+	for _, e := range out {
+		var ctx []string
+		seen := map[string]bool{}
+		for _, ref := range e.Refs {
+			if c := ref.Context; c != "" && !seen[c] {
+				seen[c] = true
+				ctx = append(ctx, c)
+			}
+		}
+		w.Write([]string{e.Kind, e.Encoding, e.Value, strconv.Itoa(len(e.Refs)), strings.Join(e.Funcs, " "), strings.Join(ctx, "; ")})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
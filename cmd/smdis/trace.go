@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/trace"
+)
+
+// runTrace implements "smdis trace": run the top-level script against
+// recording stubs and write the JSON event trace to <file>.trace.json.
+func runTrace(args []string) int {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	stubs := fs.String("stubs", strings.Join(trace.DefaultStubs, ","), "globals served by recording stubs")
+	all := fs.Bool("all", false, "stub every undefined global, not just -stubs")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis trace [-stubs list] [-all] <file.jsc>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	path := fs.Arg(0)
+	root, _, opt, err := df.load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	cfg := trace.Config{All: *all}
+	for _, s := range strings.Split(*stubs, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			cfg.Stubs = append(cfg.Stubs, s)
+		}
+	}
+	t := trace.Run(root, opt, cfg)
+	if t.Error != "" {
+		fmt.Fprintf(os.Stderr, "warning: run stopped: %s\n", t.Error)
+	}
+
+	js, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + ".trace.json"
+	if err := os.WriteFile(out, append(js, '\n'), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s (%d events)\n", out, len(t.Events))
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/xref"
+)
+
+// runXref implements "smdis xref": list every reference to a name.
+func runXref(args []string) int {
+	fs := flag.NewFlagSet("xref", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	kind := fs.String("kind", "", "only references of this kind: atom, const, regexp, func, call")
+	df := addDecodeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: smdis xref [-json] [-kind kind] <file.jsc> <name>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	root, _, _, err := df.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	hits := []xref.Hit{}
+	for _, h := range xref.Build(root).Lookup(fs.Arg(1)) {
+		if *kind == "" || h.Kind == *kind {
+			hits = append(hits, h)
+		}
+	}
+	if *asJSON {
+		return writeJSON(hits)
+	}
+	if len(hits) == 0 {
+		fmt.Fprintf(os.Stderr, "no references to %q\n", fs.Arg(1))
+		return 1
+	}
+	for i, h := range hits {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s %s (%d)\n", h.Kind, strconv.Quote(h.Name), len(h.Refs))
+		for _, r := range h.Refs {
+			via := ""
+			if r.Via != "" {
+				via = " via " + r.Via
+			}
+			fmt.Printf("  %05X %5d  %-12s %s%s\n", r.Off, r.Line, r.Op, r.Func, via)
+		}
+	}
+	return 0
+}
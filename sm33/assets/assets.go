@@ -0,0 +1,480 @@
+// Package assets maps the resource files a game loads to the functions
+// that load them.
+//
+// Cocos2d-x scripts name their sprites, plists, audio and JSON files as
+// string arguments of loader APIs (cc.loader.load, addSpriteFrames,
+// cc.audioEngine.playEffect, new cc.Sprite(...)), either directly, after
+// constant folding, or through a resource table:
+//
+//	var res = { bg_png: "res/bg.png", click: "res/click.mp3" };
+//	var g_resources = [res.bg_png];
+//	cc.loader.load(g_resources, cb);
+//	new cc.Sprite(res.bg_png);
+//
+// A Map resolves such arguments across every file added to it, keeps the
+// ones it cannot resolve but that are partly literal ("res/" + name) as
+// dynamic references, and compares the result with a bundle's file list.
+package assets
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// Opcodes that build and name resource tables.
+const (
+	opSetprop       = 54
+	opObject        = 80
+	opNewinit       = 89
+	opNewarray      = 90
+	opNewobject     = 91
+	opInitprop      = 93
+	opInitelemArray = 96
+	opSetname       = 111
+	opSetgname      = 155
+)
+
+// api is a loader entry point.
+type api struct {
+	name string // full callee path, or ".method" for a method of any receiver
+	new  bool   // matched only as a constructor
+	args []int  // argument slots that name assets
+	typ  string // asset type when the extension does not tell
+}
+
+var apis = []api{
+	{"cc.loader.load", false, []int{0}, ""},
+	{"cc.loader.loadJson", false, []int{0}, "json"},
+	{"cc.loader.loadImg", false, []int{0}, "image"},
+	{"cc.loader.loadTxt", false, []int{0}, "text"},
+	{"cc.loader.loadRes", false, []int{0}, ""},
+	{"cc.loader.getRes", false, []int{0}, ""},
+	{".addSpriteFrames", false, []int{0, 1}, "plist"},
+	{".addImage", false, []int{0}, "image"},
+	{".addImageAsync", false, []int{0}, "image"},
+	{".getSpriteFrame", false, []int{0}, "frame"},
+	{".playEffect", false, []int{0}, "audio"},
+	{".playMusic", false, []int{0}, "audio"},
+	{".preloadEffect", false, []int{0}, "audio"},
+	{".preloadMusic", false, []int{0}, "audio"},
+	{"jsb.AudioEngine.play2d", false, []int{0}, "audio"},
+	{"jsb.AudioEngine.preload", false, []int{0}, "audio"},
+	{"cc.Sprite", true, []int{0}, "image"},
+	{"cc.Sprite.create", false, []int{0}, "image"},
+	{"cc.SpriteFrame", true, []int{0}, "image"},
+	{".setSpriteFrame", false, []int{0}, "frame"},
+	{"cc.Scale9Sprite", true, []int{0}, "image"},
+	{"ccui.Scale9Sprite", true, []int{0}, "image"},
+	{"ccui.ImageView", true, []int{0}, "image"},
+	{".loadTexture", false, []int{0}, "image"},
+	{"ccui.Button", true, []int{0, 1, 2}, "image"},
+	{".loadTextures", false, []int{0, 1, 2}, "image"},
+	{"cc.MenuItemImage", true, []int{0, 1, 2}, "image"},
+	{"cc.MenuItemImage.create", false, []int{0, 1, 2}, "image"},
+	{"cc.LabelBMFont", true, []int{1}, "font"},
+	{"cc.LabelBMFont.create", false, []int{1}, "font"},
+	{"cc.ParticleSystem", true, []int{0}, "plist"},
+	{"cc.ParticleSystem.create", false, []int{0}, "plist"},
+	{"cc.TMXTiledMap", true, []int{0}, "tmx"},
+	{"cc.TMXTiledMap.create", false, []int{0}, "tmx"},
+	{"sp.SkeletonAnimation", true, []int{0, 1}, "spine"},
+	{"sp.SkeletonAnimation.create", false, []int{0, 1}, "spine"},
+	{"ccs.load", false, []int{0}, "json"},
+	{"ccs.csLoader.createNode", false, []int{0}, ""},
+	{"ccs.uiReader.widgetFromJsonFile", false, []int{0}, "json"},
+	{"ccs.armatureDataManager.addArmatureFileInfo", false, []int{0, 1, 2}, ""},
+}
+
+// lookup returns the loader API c invokes, if any.
+func lookup(target string, c *ir.Call) *api {
+	isNew := c.Kind == ir.CallNew
+	for i := range apis {
+		a := &apis[i]
+		if a.new != isNew {
+			continue
+		}
+		if strings.HasPrefix(a.name, ".") && strings.HasSuffix(target, a.name) || target == a.name {
+			return a
+		}
+	}
+	return nil
+}
+
+// types maps file extensions to asset types.
+var types = map[string]string{
+	".png": "image", ".jpg": "image", ".jpeg": "image", ".webp": "image",
+	".pvr": "image", ".ccz": "image", ".pkm": "image", ".bmp": "image",
+	".plist": "plist",
+	".mp3":   "audio", ".ogg": "audio", ".wav": "audio", ".m4a": "audio",
+	".json": "json", ".exportjson": "json",
+	".fnt": "font", ".ttf": "font",
+	".tmx": "tmx", ".tsx": "tmx",
+	".atlas": "spine", ".skel": "spine",
+	".csb": "ccs",
+}
+
+// IsAsset reports whether a file name has an asset extension.
+func IsAsset(name string) bool {
+	_, ok := types[strings.ToLower(path.Ext(name))]
+	return ok
+}
+
+// Ref is one loader call argument naming an asset.
+type Ref struct {
+	File string `json:"file,omitempty"`
+	Func string `json:"func"`
+	Off  int    `json:"off"`
+	Line int    `json:"line"`
+	API  string `json:"api"` // folded callee path
+	Arg  int    `json:"arg"`
+}
+
+// Asset is one referenced path.
+type Asset struct {
+	Path  string   `json:"path"`
+	Type  string   `json:"type"` // image, plist, audio, json, font, tmx, spine, ccs, frame or the API's kind
+	Funcs []string `json:"funcs"`
+	Refs  []Ref    `json:"refs"`
+}
+
+// Dynamic is a loader argument that folds to no path.
+type Dynamic struct {
+	Ref
+	Expr   string `json:"expr"`
+	Prefix string `json:"prefix,omitempty"` // leading literal of a concatenation
+}
+
+// elem is a table or array member: a literal path or an expression
+// naming another member ("res.bg_png").
+type elem struct {
+	str string
+	key string
+	lit bool
+}
+
+// table is an object or array literal under construction.
+type table struct {
+	props map[string]elem
+	kids  map[string]*table
+	elems []elem
+}
+
+// pending is an argument awaiting resolution against every file's tables.
+type pending struct {
+	ref   Ref
+	typ   string
+	elems []elem
+	expr  *ir.Expr
+}
+
+// Map accumulates the asset references of a set of scripts.
+type Map struct {
+	values  map[string]string // "res.bg_png" → "res/bg.png"
+	arrays  map[string][]elem // "g_resources" → members
+	pending []pending
+}
+
+// New returns an empty map.
+func New() *Map {
+	return &Map{values: map[string]string{}, arrays: map[string][]elem{}}
+}
+
+// Add records the resource tables and loader calls of root, read from file.
+func (m *Map) Add(file string, root *sm33.Script) {
+	m.AddDecoded(file, root, nil)
+}
+
+// AddDecoded is Add with the deobfuscated call results of dec, as returned
+// by package deobf, taken as string literals.
+func (m *Map) AddDecoded(file string, root *sm33.Script, dec ir.Decoded) {
+	nm := names.Infer(root)
+	var walk func(s *sm33.Script, name string)
+	walk = func(s *sm33.Script, name string) {
+		m.scan(file, s, name, dec[s])
+		for _, obj := range s.Objects {
+			if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+				walk(fn.Script, nm.Of(fn))
+			}
+		}
+	}
+	walk(root, "main")
+}
+
+func (m *Map) scan(file string, s *sm33.Script, fn string, dec map[int]string) {
+	bc := s.Bytecode
+	tables := map[*ir.Expr]*table{}
+	var prev ir.Instr
+	have := false
+	member := func(e *ir.Expr) elem {
+		if e.IsLit(ir.LitString) {
+			return elem{str: e.Str, lit: true}
+		}
+		return elem{key: keyOf(e)}
+	}
+	calls := ir.SimulateDecoded(s, dec, func(in ir.Instr, st *ir.Stack) {
+		if have {
+			switch prev.Op {
+			case opNewinit, opNewarray, opObject:
+				tables[st.Peek(0)] = &table{props: map[string]elem{}, kids: map[string]*table{}}
+			case opNewobject:
+				t := &table{props: map[string]elem{}, kids: map[string]*table{}}
+				idx, _ := bytecode.GetUint32Index(bc, prev.Off)
+				if int(idx) < len(s.Objects) && s.Objects[idx].Literal != nil {
+					for _, p := range s.Objects[idx].Literal.Props {
+						if p.Value.Kind == sm33.ConstAtom {
+							t.props[p.Key] = elem{str: p.Value.Atom, lit: true}
+						}
+					}
+				}
+				tables[st.Peek(0)] = t
+			}
+		}
+		prev, have = in, true
+
+		atom := func() string { return ir.Atom(s, in.Off) }
+		switch in.Op {
+		case opInitprop:
+			t := tables[st.Peek(1)]
+			if t == nil {
+				return
+			}
+			if kid := tables[st.Peek(0)]; kid != nil {
+				t.kids[atom()] = kid
+			} else {
+				t.props[atom()] = member(st.Peek(0))
+			}
+		case opInitelemArray:
+			if t := tables[st.Peek(1)]; t != nil {
+				t.elems = append(t.elems, member(st.Peek(0)))
+			}
+		case opSetname, opSetgname:
+			if t := tables[st.Peek(0)]; t != nil {
+				m.define(atom(), t)
+			}
+		case opSetprop:
+			base := keyOf(st.Peek(1))
+			if base == "" {
+				return
+			}
+			name := base + "." + atom()
+			if t := tables[st.Peek(0)]; t != nil {
+				m.define(name, t)
+			} else if v := st.Peek(0); v.IsLit(ir.LitString) {
+				m.values[name] = v.Str
+			}
+		}
+	})
+
+	vals := constprop.AnalyzeDecoded(s, dec)
+	var lines *srcnote.Lines
+	for _, c := range calls {
+		target := vals.Target(c)
+		a := lookup(target, c)
+		if a == nil {
+			continue
+		}
+		if lines == nil {
+			lines = srcnote.NewLines(s)
+		}
+		for _, i := range a.args {
+			if i >= len(c.Args) {
+				continue
+			}
+			p := pending{
+				ref: Ref{File: file, Func: fn, Off: c.Offset, Line: lines.Line(c.Offset), API: target, Arg: i},
+				typ: a.typ,
+			}
+			arg := c.Args[i]
+			folded := vals.Fold(arg)
+			switch {
+			case folded.IsLit(ir.LitString):
+				p.elems = []elem{{str: folded.Str, lit: true}}
+			case tables[arg] != nil:
+				p.elems = tables[arg].elems
+			case keyOf(folded) != "":
+				p.elems = []elem{{key: keyOf(folded)}}
+			default:
+				p.expr = folded
+			}
+			m.pending = append(m.pending, p)
+		}
+	}
+}
+
+// define records the members of t under name.
+func (m *Map) define(name string, t *table) {
+	if len(t.elems) > 0 {
+		m.arrays[name] = t.elems
+	}
+	for k, v := range t.props {
+		if v.lit {
+			m.values[name+"."+k] = v.str
+		} else if v.key != "" {
+			m.arrays[name+"."+k] = []elem{v}
+		}
+	}
+	for k, kid := range t.kids {
+		m.define(name+"."+k, kid)
+	}
+}
+
+// keyOf renders a name or property chain ("res.ui.bg_png"), or "".
+func keyOf(e *ir.Expr) string {
+	switch e.Kind {
+	case ir.Name:
+		return e.Atom
+	case ir.Prop:
+		if base := keyOf(e.Obj); base != "" {
+			return base + "." + e.Atom
+		}
+	case ir.Elem:
+		if base := keyOf(e.Obj); base != "" && e.Index.IsLit(ir.LitString) {
+			return base + "." + e.Index.Str
+		}
+	}
+	return ""
+}
+
+// resolve expands members to literal paths; unresolved keys are dropped.
+func (m *Map) resolve(es []elem, depth int) []string {
+	var out []string
+	for _, e := range es {
+		switch {
+		case e.lit:
+			out = append(out, e.str)
+		case depth >= 8:
+		case m.values[e.key] != "":
+			out = append(out, m.values[e.key])
+		case m.arrays[e.key] != nil:
+			out = append(out, m.resolve(m.arrays[e.key], depth+1)...)
+		}
+	}
+	return out
+}
+
+// Assets returns the referenced assets sorted by path, and the loader
+// arguments that resolved to no path but are partly literal.
+func (m *Map) Assets() ([]*Asset, []Dynamic) {
+	byPath := map[string]*Asset{}
+	var dyn []Dynamic
+	for _, p := range m.pending {
+		paths := m.resolve(p.elems, 0)
+		if len(paths) == 0 {
+			if p.expr != nil && p.expr.Kind == ir.Op {
+				dyn = append(dyn, Dynamic{Ref: p.ref, Expr: p.expr.String(), Prefix: prefix(p.expr)})
+			}
+			continue
+		}
+		for _, raw := range paths {
+			pth, typ := classify(raw, p.typ)
+			if pth == "" {
+				continue
+			}
+			a := byPath[pth]
+			if a == nil {
+				a = &Asset{Path: pth, Type: typ}
+				byPath[pth] = a
+			}
+			a.Refs = append(a.Refs, p.ref)
+		}
+	}
+	out := make([]*Asset, 0, len(byPath))
+	for _, a := range byPath {
+		seen := map[string]bool{}
+		for _, r := range a.Refs {
+			f := r.Func
+			if r.File != "" {
+				f = r.File + ":" + f
+			}
+			if !seen[f] {
+				seen[f] = true
+				a.Funcs = append(a.Funcs, f)
+			}
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, dyn
+}
+
+// classify normalizes a loader argument and returns its type.
+func classify(raw, typ string) (string, string) {
+	p := strings.TrimSpace(raw)
+	if strings.HasPrefix(p, "#") {
+		return p[1:], "frame"
+	}
+	if typ == "frame" {
+		return p, typ
+	}
+	p = strings.TrimPrefix(strings.ReplaceAll(p, `\`, "/"), "./")
+	if t, ok := types[strings.ToLower(path.Ext(p))]; ok {
+		return p, t
+	}
+	if typ == "" {
+		typ = "file"
+	}
+	return p, typ
+}
+
+// prefix returns the leading literal of a concatenation.
+func prefix(e *ir.Expr) string {
+	for e != nil && e.Kind == ir.Op && len(e.Args) > 0 {
+		e = e.Args[0]
+	}
+	if e.IsLit(ir.LitString) {
+		return e.Str
+	}
+	return ""
+}
+
+// Check compares the assets with the files of a bundle, given as paths
+// relative to its root. An asset is present when a file equals its path
+// or ends with "/" + path (loaders search several roots). Frames name
+// sprite-frame cache entries, not files, and are never missing. A file
+// with an asset extension is unused when no asset names it and it does
+// not start with the prefix of a dynamic reference.
+func Check(assets []*Asset, dyn []Dynamic, files []string) (missing []*Asset, unused []string) {
+	norm := make([]string, len(files))
+	for i, f := range files {
+		norm[i] = strings.TrimPrefix(strings.ReplaceAll(f, `\`, "/"), "./")
+	}
+	used := make([]bool, len(norm))
+	for _, a := range assets {
+		if a.Type == "frame" {
+			continue
+		}
+		found := false
+		for i, f := range norm {
+			if f == a.Path || strings.HasSuffix(f, "/"+a.Path) {
+				used[i], found = true, true
+			}
+		}
+		if !found {
+			missing = append(missing, a)
+		}
+	}
+	for i, f := range norm {
+		if used[i] || !IsAsset(f) {
+			continue
+		}
+		covered := false
+		for _, d := range dyn {
+			if d.Prefix != "" && (strings.HasPrefix(f, d.Prefix) || strings.Contains(f, "/"+d.Prefix)) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			unused = append(unused, files[i])
+		}
+	}
+	return missing, unused
+}
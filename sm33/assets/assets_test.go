@@ -0,0 +1,93 @@
+package assets
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+// method emits the receiver-and-callee prefix of cc.<obj>.<name>(...).
+func method(obj, name uint32) []byte {
+	return Asm(AtomOp(OpGetgname, 0), AtomOp(OpGetprop, obj), []byte{OpDup}, AtomOp(OpCallprop, name), []byte{OpSwap})
+}
+
+// tables builds:
+//
+//	res = { bg_png: "res/bg.png" };
+//	g_resources = [res.bg_png];
+func tables() *sm33.Script {
+	return &sm33.Script{
+		Atoms: []string{"res", "res/bg.png", "bg_png", "g_resources"},
+		Bytecode: Asm(
+			AtomOp(OpBindgname, 0), []byte{OpNewinit, 1, 0, 0, 0},
+			AtomOp(OpString, 1), AtomOp(OpInitprop, 2), []byte{OpEndinit},
+			AtomOp(OpSetgname, 0), []byte{OpPop},
+			AtomOp(OpBindgname, 3), []byte{OpNewarray, 0, 0, 1},
+			AtomOp(OpGetgname, 0), AtomOp(OpGetprop, 2), []byte{OpInitelemArray, 0, 0, 0, OpEndinit},
+			AtomOp(OpSetgname, 3), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+}
+
+// loads builds:
+//
+//	cc.loader.load(g_resources);
+//	new cc.Sprite("#hero.png");
+//	cc.spriteFrameCache.addSpriteFrames("res/ui.plist");
+//	cc.audioEngine.playEffect("sfx/" + name);
+func loads() *sm33.Script {
+	return &sm33.Script{
+		Atoms: []string{
+			"cc", "loader", "load", "g_resources", "Sprite", "#hero.png",
+			"spriteFrameCache", "addSpriteFrames", "res/ui.plist",
+			"audioEngine", "playEffect", "sfx/", "name",
+		},
+		Bytecode: Asm(
+			method(1, 2), AtomOp(OpGetgname, 3), []byte{OpCall, 0, 1, OpPop}, // 0
+			AtomOp(OpGetgname, 0), AtomOp(OpGetprop, 4), []byte{OpUndefined}, AtomOp(OpString, 5), []byte{OpNew, 0, 1, OpPop}, // 26
+			method(6, 7), AtomOp(OpString, 8), []byte{OpCall, 0, 1, OpPop}, // 46
+			method(9, 10), AtomOp(OpString, 11), AtomOp(OpName, 12), []byte{OpAdd, OpCall, 0, 1, OpPop}, // 72
+			[]byte{OpRetrval},
+		),
+	}
+}
+
+func TestMap(t *testing.T) {
+	m := New()
+	m.Add("b.jsc", loads())
+	m.Add("a.jsc", tables())
+	as, dyn := m.Assets()
+	want := []struct {
+		path, typ, api string
+		off            int
+	}{
+		{"hero.png", "frame", "cc.Sprite", 42},
+		{"res/bg.png", "image", "cc.loader.load", 22},
+		{"res/ui.plist", "plist", "cc.spriteFrameCache.addSpriteFrames", 68},
+	}
+	if len(as) != len(want) {
+		t.Fatalf("assets = %+v", as)
+	}
+	for i, w := range want {
+		a := as[i]
+		if a.Path != w.path || a.Type != w.typ || len(a.Refs) != 1 || a.Refs[0].API != w.api || a.Refs[0].Off != w.off {
+			t.Errorf("asset %d = %+v, want %s %s by %s @%d", i, a, w.path, w.typ, w.api, w.off)
+		}
+		if len(a.Funcs) != 1 || a.Funcs[0] != "b.jsc:main" {
+			t.Errorf("%s funcs = %v", a.Path, a.Funcs)
+		}
+	}
+	if len(dyn) != 1 || dyn[0].Prefix != "sfx/" || dyn[0].API != "cc.audioEngine.playEffect" {
+		t.Fatalf("dynamic = %+v", dyn)
+	}
+
+	missing, unused := Check(as, dyn, []string{"assets/res/bg.png", "res/old.png", "sfx/click.mp3", "src/app.js"})
+	if len(missing) != 1 || missing[0].Path != "res/ui.plist" {
+		t.Errorf("missing = %+v", missing)
+	}
+	if len(unused) != 1 || unused[0] != "res/old.png" {
+		t.Errorf("unused = %v", unused)
+	}
+}
@@ -0,0 +1,198 @@
+// Package audit scans scripts for the issues a client security review
+// looks for: credentials in string literals, cleartext URLs, eval,
+// filesystem writes through jsb.fileUtils, in-app purchases verified on
+// the device, and debug switches left on.
+//
+// Each check is a query rule (see package query) carrying a severity and
+// a message, so rule files extend or replace the built-in set:
+//
+//	[staging-host]
+//	query    = $url=* /staging\.example\.com/
+//	severity = warning
+//	message  = staging host left in the build
+package audit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/query"
+)
+
+// Severities, from most to least severe; they are also the SARIF levels.
+const (
+	Error   = "error"
+	Warning = "warning"
+	Note    = "note"
+)
+
+var rank = map[string]int{Error: 3, Warning: 2, Note: 1}
+
+// builtin is the default rule set.
+const builtin = `
+[secret-literal]
+query    = $value=* /AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_\-]{35}|[rs]k_live_[0-9A-Za-z]{16,}|-----BEGIN [A-Z ]*PRIVATE KEY|xox[abprs]-[0-9A-Za-z\-]{10,}|gh[oprsu]_[0-9A-Za-z]{36}/
+severity = error
+message  = credential in a string literal
+
+[secret-property]
+query    = $value=string /^\S{8,}$/; $key=initprop|setprop|setname|setgname /(?i)(secret|api_?key|app_?key|access_?key|auth_?token|token|passw(or)?d|private_?key|salt)$/
+severity = error
+message  = string literal stored in a secret-named property
+
+[http-url]
+query    = $url=* /^(http|ws):\/\/[^\/\s]/
+severity = warning
+message  = cleartext URL
+
+[eval]
+query    = eval|spreadeval
+severity = error
+message  = eval of dynamic code
+
+[function-constructor]
+query    = new|call target="Function"
+severity = error
+message  = Function constructor compiles a string
+
+[file-write]
+query    = call /\.(write(String|Data|ValueMap|ValueVector)?ToFile|removeFile|removeDirectory|renameFile|createDirectory)$/
+severity = warning
+message  = filesystem write through jsb.fileUtils
+
+[iap-receipt-endpoint]
+query    = $url=* /(buy|sandbox)\.itunes\.apple\.com\/verifyReceipt|androidpublisher\/v[0-9]+\/applications\//
+severity = error
+message  = store receipt sent for verification from the client
+
+[iap-client-verify]
+query    = call /(?i)(verify|validate)_?(receipt|purchase|transaction|signature)$/
+severity = warning
+message  = purchase verified in client code
+
+[debug-flag]
+query    = true; $flag=initprop|setprop|setname|setgname /(?i)^(is_?)?(debug|debug_?mode|dev_?mode|cheats?|god_?mode|show_?fps|test_?mode|unlock_?all)$/
+severity = note
+message  = debug switch turned on
+
+[debug-mode]
+query    = one|int8|uint16 1..; $flag=initprop|setprop /^debugMode$/
+severity = note
+message  = engine debug mode enabled
+
+[display-stats]
+query    = call /\.setDisplayStats$/ arg0=/^true$/
+severity = note
+message  = FPS and draw statistics shown
+`
+
+// Builtin returns the built-in rules.
+func Builtin() []*query.Rule {
+	rules, err := query.ParseRules(strings.NewReader(builtin))
+	if err != nil {
+		panic("audit: built-in rules: " + err.Error())
+	}
+	return rules
+}
+
+// Check reports the first rule without a message or with an unknown
+// severity.
+func Check(rules []*query.Rule) error {
+	for _, r := range rules {
+		if rank[r.Fields["severity"]] == 0 {
+			return fmt.Errorf("rule %q: severity %q (want error, warning or note)", r.Name, r.Fields["severity"])
+		}
+		if r.Fields["message"] == "" {
+			return fmt.Errorf("rule %q has no message", r.Name)
+		}
+	}
+	return nil
+}
+
+// Merge returns base followed by extra; a rule of extra replaces the
+// base rule of the same name in place.
+func Merge(base, extra []*query.Rule) []*query.Rule {
+	out := append([]*query.Rule(nil), base...)
+	at := map[string]int{}
+	for i, r := range out {
+		at[r.Name] = i
+	}
+	for _, r := range extra {
+		if i, ok := at[r.Name]; ok {
+			out[i] = r
+			continue
+		}
+		at[r.Name] = len(out)
+		out = append(out, r)
+	}
+	return out
+}
+
+// Finding is one rule match.
+type Finding struct {
+	Rule     string            `json:"rule"`
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	File     string            `json:"file,omitempty"`
+	Func     string            `json:"func"`
+	Off      int               `json:"off"`
+	Line     int               `json:"line"`
+	Evidence string            `json:"evidence"` // matched instructions
+	Captures map[string]string `json:"captures,omitempty"`
+}
+
+// Scan runs rules over root, read from file, and returns the findings in
+// function then offset order.
+func Scan(file string, root *sm33.Script, rules []*query.Rule) []Finding {
+	nm := names.Infer(root)
+	order := map[string]int{}
+	var walk func(s *sm33.Script, name string)
+	walk = func(s *sm33.Script, name string) {
+		order[name] = len(order)
+		for _, obj := range s.Objects {
+			if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+				walk(fn.Script, nm.Of(fn))
+			}
+		}
+	}
+	walk(root, "main")
+
+	var out []Finding
+	for _, r := range rules {
+		for _, m := range r.Query.Run(root) {
+			out = append(out, Finding{
+				Rule:     r.Name,
+				Severity: r.Fields["severity"],
+				Message:  r.Fields["message"],
+				File:     file,
+				Func:     m.Func,
+				Off:      m.Off,
+				Line:     m.Line,
+				Evidence: strings.Join(m.Instrs, "; "),
+				Captures: m.Captures,
+			})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Func != b.Func {
+			return order[a.Func] < order[b.Func]
+		}
+		return a.Off < b.Off
+	})
+	return out
+}
+
+// Filter returns the findings at severity min or above.
+func Filter(fs []Finding, min string) []Finding {
+	var out []Finding
+	for _, f := range fs {
+		if rank[f.Severity] >= rank[min] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
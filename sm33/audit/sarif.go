@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/query"
+)
+
+// SARIF 2.1.0, the subset code scanning services read.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string          `json:"ruleId"`
+	RuleIndex  int             `json:"ruleIndex"`
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+	LogicalLocations []sarifLogical `json:"logicalLocations"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogical struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// WriteSARIF writes the findings of rules as a SARIF 2.1.0 log. Lines
+// are source lines of the compiled script; the bytecode offset and the
+// evidence go in each result's properties.
+func WriteSARIF(w io.Writer, rules []*query.Rule, findings []Finding) error {
+	driver := sarifDriver{
+		Name:           "smdis",
+		InformationURI: "https://github.com/zboralski/spidermonkey-dumper",
+		Rules:          []sarifRule{},
+	}
+	index := map[string]int{}
+	for _, r := range rules {
+		sr := sarifRule{ID: r.Name, ShortDescription: sarifMessage{r.Fields["message"]}}
+		sr.DefaultConfiguration.Level = r.Fields["severity"]
+		sr.Properties = map[string]string{"query": r.Query.Source}
+		index[r.Name] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, sr)
+	}
+	results := []sarifResult{}
+	for _, f := range findings {
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f.File)
+		if f.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.Line}
+		}
+		loc.LogicalLocations = []sarifLogical{{FullyQualifiedName: f.Func, Kind: "function"}}
+		props := map[string]any{"offset": f.Off, "evidence": f.Evidence}
+		if len(f.Captures) > 0 {
+			props["captures"] = f.Captures
+		}
+		results = append(results, sarifResult{
+			RuleID:     f.Rule,
+			RuleIndex:  index[f.Rule],
+			Level:      f.Severity,
+			Message:    sarifMessage{f.Message},
+			Locations:  []sarifLocation{loc},
+			Properties: props,
+		})
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
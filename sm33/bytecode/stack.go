@@ -0,0 +1,191 @@
+package bytecode
+
+// stackEffect holds nuses/ndefs from Opcodes.h. -1 means the count
+// depends on the operand (see StackUses/StackDefs).
+type stackEffect struct {
+	uses int8
+	defs int8
+}
+
+// stackEffects is the SM33 nuses/ndefs table, indexed by opcode.
+// Unused and unknown opcodes are zero-valued (0 uses, 0 defs).
+var stackEffects = [256]stackEffect{
+	1:   {0, 1},   // undefined
+	3:   {1, 0},   // enterwith
+	5:   {1, 0},   // return
+	7:   {1, 0},   // ifeq
+	8:   {1, 0},   // ifne
+	9:   {0, 1},   // arguments
+	10:  {2, 2},   // swap
+	11:  {-1, 0},  // popn
+	12:  {1, 2},   // dup
+	13:  {2, 4},   // dup2
+	14:  {1, 1},   // setconst
+	15:  {2, 1},   // bitor
+	16:  {2, 1},   // bitxor
+	17:  {2, 1},   // bitand
+	18:  {2, 1},   // eq
+	19:  {2, 1},   // ne
+	20:  {2, 1},   // lt
+	21:  {2, 1},   // le
+	22:  {2, 1},   // gt
+	23:  {2, 1},   // ge
+	24:  {2, 1},   // lsh
+	25:  {2, 1},   // rsh
+	26:  {2, 1},   // ursh
+	27:  {2, 1},   // add
+	28:  {2, 1},   // sub
+	29:  {2, 1},   // mul
+	30:  {2, 1},   // div
+	31:  {2, 1},   // mod
+	32:  {1, 1},   // not
+	33:  {1, 1},   // bitnot
+	34:  {1, 1},   // neg
+	35:  {1, 1},   // pos
+	36:  {0, 1},   // delname
+	37:  {1, 1},   // delprop
+	38:  {2, 1},   // delelem
+	39:  {1, 1},   // typeof
+	40:  {1, 1},   // void
+	41:  {3, 1},   // spreadcall
+	42:  {3, 1},   // spreadnew
+	43:  {3, 1},   // spreadeval
+	44:  {0, 1},   // dupat
+	53:  {1, 1},   // getprop
+	54:  {2, 1},   // setprop
+	55:  {2, 1},   // getelem
+	56:  {3, 1},   // setelem
+	58:  {-1, 1},  // call
+	59:  {0, 1},   // name
+	60:  {0, 1},   // double
+	61:  {0, 1},   // string
+	62:  {0, 1},   // zero
+	63:  {0, 1},   // one
+	64:  {0, 1},   // null
+	65:  {0, 1},   // this
+	66:  {0, 1},   // false
+	67:  {0, 1},   // true
+	68:  {1, 1},   // or
+	69:  {1, 1},   // and
+	70:  {1, 0},   // tableswitch
+	72:  {2, 1},   // stricteq
+	73:  {2, 1},   // strictne
+	75:  {1, 1},   // iter
+	76:  {1, 2},   // moreiter
+	77:  {0, 1},   // iternext
+	78:  {1, 0},   // enditer
+	79:  {-1, 1},  // funapply
+	80:  {0, 1},   // object
+	81:  {1, 0},   // pop
+	82:  {-1, 1},  // new
+	84:  {0, 1},   // getarg
+	85:  {1, 1},   // setarg
+	86:  {0, 1},   // getlocal
+	87:  {1, 1},   // setlocal
+	88:  {0, 1},   // uint16
+	89:  {0, 1},   // newinit
+	90:  {0, 1},   // newarray
+	91:  {0, 1},   // newobject
+	93:  {2, 1},   // initprop
+	94:  {3, 1},   // initelem
+	95:  {3, 2},   // initelem_inc
+	96:  {2, 1},   // initelem_array
+	97:  {2, 1},   // initprop_getter
+	98:  {2, 1},   // initprop_setter
+	99:  {3, 1},   // initelem_getter
+	100: {3, 1},   // initelem_setter
+	108: {-1, 1},  // funcall
+	110: {0, 1},   // bindname
+	111: {2, 1},   // setname
+	112: {1, 0},   // throw
+	113: {2, 1},   // in
+	114: {2, 1},   // instanceof
+	117: {2, 0},   // retsub
+	118: {0, 1},   // exception
+	121: {2, 1},   // case
+	122: {1, 0},   // default
+	123: {-1, 1},  // eval
+	130: {0, 1},   // lambda
+	131: {1, 1},   // lambda_arrow
+	132: {0, 1},   // callee
+	133: {-1, -1}, // pick
+	135: {0, 2},   // finally
+	136: {0, 1},   // getaliasedvar
+	137: {1, 1},   // setaliasedvar
+	143: {0, 1},   // getintrinsic
+	144: {2, 1},   // setintrinsic
+	145: {0, 1},   // bindintrinsic
+	151: {1, 0},   // throwing
+	152: {1, 0},   // setrval
+	154: {0, 1},   // getgname
+	155: {2, 1},   // setgname
+	160: {0, 1},   // regexp
+	184: {1, 1},   // callprop
+	188: {0, 1},   // uint24
+	193: {2, 1},   // callelem
+	194: {2, 1},   // mutateproto
+	195: {1, 1},   // getxprop
+	197: {1, 1},   // typeofexpr
+	203: {1, 1},   // yield
+	204: {2, 0},   // arraypush
+	214: {0, 1},   // bindgname
+	215: {0, 1},   // int8
+	216: {0, 1},   // int32
+	217: {1, 1},   // length
+	218: {0, 1},   // hole
+	224: {0, 1},   // rest
+	225: {1, 1},   // toid
+	226: {0, 1},   // implicitthis
+	228: {1, 1},   // tostring
+}
+
+// StackUses returns how many values the instruction at bc[off] pops.
+// Variable-arity opcodes (call family, popn, pick) read their operand.
+// Returns 0 if the operand is truncated.
+func StackUses(bc []byte, off int) int {
+	if off >= len(bc) {
+		return 0
+	}
+	op := bc[off]
+	if n := stackEffects[op].uses; n >= 0 {
+		return int(n)
+	}
+	switch op {
+	case 58, 79, 82, 108, 123: // call, funapply, new, funcall, eval
+		argc, ok := GetUint16(bc, off)
+		if !ok {
+			return 0
+		}
+		return 2 + int(argc)
+	case 11: // popn
+		n, ok := GetUint16(bc, off)
+		if !ok {
+			return 0
+		}
+		return int(n)
+	case 133: // pick
+		if off+2 > len(bc) {
+			return 0
+		}
+		return int(bc[off+1]) + 1
+	}
+	return 0
+}
+
+// StackDefs returns how many values the instruction at bc[off] pushes.
+func StackDefs(bc []byte, off int) int {
+	if off >= len(bc) {
+		return 0
+	}
+	op := bc[off]
+	if n := stackEffects[op].defs; n >= 0 {
+		return int(n)
+	}
+	if op == 133 { // pick
+		if off+2 > len(bc) {
+			return 0
+		}
+		return int(bc[off+1]) + 1
+	}
+	return 0
+}
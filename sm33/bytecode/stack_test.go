@@ -0,0 +1,27 @@
+package bytecode
+
+import "testing"
+
+func TestStackEffects(t *testing.T) {
+	tests := []struct {
+		name       string
+		bc         []byte
+		uses, defs int
+	}{
+		{"call 3", []byte{58, 0x00, 0x03}, 5, 1},
+		{"new 0", []byte{82, 0x00, 0x00}, 2, 1},
+		{"popn 4", []byte{11, 0x00, 0x04}, 4, 0},
+		{"pick 2", []byte{133, 0x02}, 3, 3},
+		{"swap", []byte{10}, 2, 2},
+		{"setprop", []byte{54, 0, 0, 0, 0}, 2, 1},
+		{"truncated call", []byte{58}, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := StackUses(tt.bc, 0); got != tt.uses {
+			t.Errorf("%s: uses = %d, want %d", tt.name, got, tt.uses)
+		}
+		if got := StackDefs(tt.bc, 0); got != tt.defs {
+			t.Errorf("%s: defs = %d, want %d", tt.name, got, tt.defs)
+		}
+	}
+}
@@ -4,14 +4,76 @@ import (
 	"fmt"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33"
-	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
 )
 
-// Edge represents a call from one function to another.
+// EdgeKind classifies the relationship an edge records.
+type EdgeKind int
+
+const (
+	Calls      EdgeKind = iota // plain call
+	Defines                    // caller's script contains the callee's definition
+	Constructs                 // new / spreadnew
+	Applies                    // Function.prototype.call or .apply
+	Registers                  // callee function passed to a call as a callback or handler
+)
+
+var edgeKindNames = [...]string{
+	Calls:      "calls",
+	Defines:    "defines",
+	Constructs: "constructs",
+	Applies:    "applies",
+	Registers:  "registers",
+}
+
+func (k EdgeKind) String() string {
+	if int(k) < len(edgeKindNames) {
+		return edgeKindNames[k]
+	}
+	return fmt.Sprintf("EdgeKind(%d)", int(k))
+}
+
+// edgeKind maps the invoking opcode of a call to an edge kind.
+func edgeKind(k ir.CallKind) EdgeKind {
+	switch k {
+	case ir.CallNew, ir.CallSpreadNew:
+		return Constructs
+	case ir.CallFuncall, ir.CallFunapply:
+		return Applies
+	}
+	return Calls
+}
+
+// Edge represents a relationship from one function to another.
+// A caller that reaches the same callee several times the same way yields
+// one edge carrying every call site.
 type Edge struct {
 	Caller string
-	Callee string
-	Args   []string // literal arguments observed at call site
+	Callee string   // inner function node, or full receiver path, e.g. "cc.director.getScheduler().schedule"
+	Kind   EdgeKind // calls, defines, constructs, applies or registers
+	Sites  []Site   // call sites in bytecode order; empty for definition edges
+	Count  int      // number of call sites
+}
+
+// Site is a single call instruction behind an edge.
+type Site struct {
+	Offset int         // bytecode offset of the call opcode
+	Line   int         // source line from the source notes
+	Kind   ir.CallKind // invoking opcode (call, new, funcall, ...)
+	Argc   int         // argument count from the call operand; -1 for spread calls
+	Args   []string    // argument expressions, one per slot
+	Target string      // callee expression as written at the call site
+	Via    string      // receiving call target, for Registers edges
+}
+
+// Args returns the arguments of the first call site.
+func (e Edge) Args() []string {
+	if len(e.Sites) == 0 {
+		return nil
+	}
+	return e.Sites[0].Args
 }
 
 // Graph holds the callgraph for a decoded script.
@@ -20,224 +82,149 @@ type Graph struct {
 	Edges []Edge
 }
 
-// opcode constants for call pattern detection.
+// opcode constants for call and property-chain detection.
 const (
-	opGetprop  = 53
-	opCall     = 58
-	opName     = 59
-	opNew      = 82
-	opFuncall  = 108
-	opFunapply = 79
-	opGetgname = 154
-	opCallprop = 184
-)
-
-// opcode constants for literal-pushing instructions.
-const (
-	opString = 61  // JOF_ATOM — pushes string from atom table
-	opDouble = 60  // JOF_DOUBLE — pushes double from consts
-	opInt8   = 215 // JOF_INT8
-	opInt32  = 216 // JOF_INT32
-	opUint16 = 88  // JOF_UINT16
-	opUint24 = 188 // JOF_UINT24
-	opZero   = 62
-	opOne    = 63
-	opNull   = 64
-	opTrue   = 67
-	opFalse  = 66
+	opGetprop    = 53
+	opCall       = 58
+	opName       = 59
+	opNew        = 82
+	opFuncall    = 108
+	opFunapply   = 79
+	opEval       = 123
+	opSpreadcall = 41
+	opSpreadnew  = 42
+	opSpreadeval = 43
+	opGetgname   = 154
+	opCallprop   = 184
 )
 
 // Build constructs a callgraph from a decoded Script.
 func Build(s *sm33.Script) *Graph {
+	return BuildDecoded(s, nil)
+}
+
+// BuildDecoded is Build with the deobfuscated call results of dec, as
+// returned by package deobf, taken as string literals.
+func BuildDecoded(s *sm33.Script, dec ir.Decoded) *Graph {
 	g := &Graph{}
-	g.walkScript(s, "main")
+	g.walkScript(s, "main", newResolver(s, dec))
 	g.dedup()
 	return g
 }
 
-// callInfo pairs a callee name with observed literal arguments.
-type callInfo struct {
-	callee string
-	args   []string
-}
-
 // walkScript extracts calls from a single script and recurses into inner functions.
-func (g *Graph) walkScript(s *sm33.Script, name string) {
+func (g *Graph) walkScript(s *sm33.Script, name string, r *resolver) {
 	g.Nodes = append(g.Nodes, name)
 
 	// Scan bytecode for call patterns
-	calls := scanCalls(s)
-	for _, ci := range calls {
-		g.Edges = append(g.Edges, Edge{Caller: name, Callee: ci.callee, Args: ci.args})
-	}
+	g.Edges = append(g.Edges, scanCalls(s, name, r)...)
 
 	// Recurse into inner functions
-	for i, obj := range s.Objects {
+	for _, obj := range s.Objects {
 		if obj.Kind != sm33.CkJSFunction || obj.Function == nil {
 			continue
 		}
 		fn := obj.Function
-		innerName := fn.Name
-		if innerName == "" {
-			innerName = fmt.Sprintf("anon#%d", i)
-		}
+		innerName := r.name(fn)
 
 		// The defining script contains this function
-		g.Edges = append(g.Edges, Edge{Caller: name, Callee: innerName})
+		g.Edges = append(g.Edges, Edge{Caller: name, Callee: innerName, Kind: Defines})
 
 		if fn.Script != nil && !fn.IsLazy {
-			g.walkScript(fn.Script, innerName)
+			g.walkScript(fn.Script, innerName, r)
 		} else {
 			g.Nodes = append(g.Nodes, innerName)
 		}
 	}
 }
 
-// scanCalls finds call targets and their literal arguments by scanning bytecode.
-func scanCalls(s *sm33.Script) []callInfo {
-	bc := s.Bytecode
-	var calls []callInfo
-	seen := map[string]bool{}
-
-	// Track last atom seen from getprop/getgname/name
-	lastAtom := ""
-	lastAtomOff := -1
-
-	// Track recently pushed literals (strings, numbers, booleans)
-	var litBuf []string
-
-	off := 0
-	for off < len(bc) {
-		op := bc[off]
-		n := bytecode.InstrLen(bc, off)
-		if n <= 0 {
-			break
+// scanCalls finds call targets and their arguments by simulating the
+// operand stack, with constants folded, grouping call sites by callee
+// and kind in first-seen order. Callees that resolve to an inner function are linked to its
+// node; inner functions passed as arguments, directly or carried by
+// listener objects, bound methods and actions (see callbacks), yield
+// Registers edges.
+func scanCalls(s *sm33.Script, caller string, r *resolver) []Edge {
+	type key struct {
+		callee string
+		kind   EdgeKind
+	}
+	var edges []Edge
+	index := map[key]int{}
+	add := func(callee string, kind EdgeKind, site Site) {
+		k := key{callee, kind}
+		i, ok := index[k]
+		if !ok {
+			i = len(edges)
+			index[k] = i
+			edges = append(edges, Edge{Caller: caller, Callee: callee, Kind: kind})
 		}
+		e := &edges[i]
+		e.Sites = append(e.Sites, site)
+		e.Count++
+	}
 
-		switch op {
-		// Literal-pushing opcodes — accumulate for arg tracking
-		case opString:
-			if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-				if int(idx) < len(s.Atoms) {
-					lit := s.Atoms[idx]
-					if len(lit) > 24 {
-						lit = lit[:24] + "\u2026"
-					}
-					litBuf = appendLit(litBuf, "\""+lit+"\"")
-				}
-			}
-		case opDouble:
-			if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-				if int(idx) < len(s.Consts) {
-					litBuf = appendLit(litBuf, formatConstLit(s.Consts[idx]))
-				}
-			}
-		case opInt8:
-			if v, ok := bytecode.GetInt8(bc, off); ok {
-				litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-			}
-		case opInt32:
-			if v, ok := bytecode.GetInt32(bc, off); ok {
-				litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-			}
-		case opUint16:
-			if v, ok := bytecode.GetUint16(bc, off); ok {
-				litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-			}
-		case opUint24:
-			if v, ok := bytecode.GetUint24(bc, off); ok {
-				litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-			}
-		case opZero:
-			litBuf = appendLit(litBuf, "0")
-		case opOne:
-			litBuf = appendLit(litBuf, "1")
-		case opNull:
-			litBuf = appendLit(litBuf, "null")
-		case opTrue:
-			litBuf = appendLit(litBuf, "true")
-		case opFalse:
-			litBuf = appendLit(litBuf, "false")
-
-		// Call opcodes — emit edge with captured literals
-		case opCallprop:
-			if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-				if int(idx) < len(s.Atoms) {
-					atom := s.Atoms[idx]
-					if !seen[atom] {
-						seen[atom] = true
-						calls = append(calls, callInfo{callee: atom, args: cloneLits(litBuf)})
-					}
-				}
-			}
-			litBuf = litBuf[:0]
-			lastAtom = ""
-
-		case opGetprop, opGetgname, opName:
-			if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-				if int(idx) < len(s.Atoms) {
-					lastAtom = s.Atoms[idx]
-					lastAtomOff = off
-				}
-			}
-
-		case opCall, opNew, opFuncall, opFunapply:
-			if lastAtom != "" && off-lastAtomOff < 20 {
-				if !seen[lastAtom] {
-					seen[lastAtom] = true
-					calls = append(calls, callInfo{callee: lastAtom, args: cloneLits(litBuf)})
-				}
+	lines := srcnote.NewLines(s)
+	vals := constprop.AnalyzeDecoded(s, r.decoded[s])
+	carried := callbacks(s, r)
+	for _, c := range vals.Calls() {
+		site := Site{
+			Offset: c.Offset,
+			Line:   lines.Line(c.Offset),
+			Kind:   c.Kind,
+			Argc:   c.Argc,
+			Args:   formatArgs(vals.FoldAll(c.Args)),
+			Target: vals.Target(c),
+		}
+		callee := site.Target
+		if fn := r.resolve(s, c.Callee); fn != nil {
+			callee = r.name(fn)
+		}
+		add(callee, edgeKind(c.Kind), site)
+		for _, a := range c.Args {
+			fn := r.resolve(s, a)
+			if fn == nil {
+				continue
 			}
-			litBuf = litBuf[:0]
-			lastAtom = ""
+			cb := site
+			cb.Via = site.Target
+			add(r.name(fn), Registers, cb)
+		}
+		for _, fn := range carried[c.Offset] {
+			cb := site
+			cb.Via = site.Target
+			add(r.name(fn), Registers, cb)
 		}
-
-		off += n
-	}
-
-	return calls
-}
-
-// appendLit adds a literal to the buffer, capped at 6.
-func appendLit(buf []string, lit string) []string {
-	if len(buf) >= 6 {
-		return buf
 	}
-	return append(buf, lit)
+	return edges
 }
 
-// cloneLits returns a copy of the literal buffer, or nil if empty.
-func cloneLits(buf []string) []string {
-	if len(buf) == 0 {
+// formatArgs renders call arguments one per slot, shortening long strings.
+func formatArgs(args []*ir.Expr) []string {
+	if len(args) == 0 {
 		return nil
 	}
-	cp := make([]string, len(buf))
-	copy(cp, buf)
-	return cp
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = formatArg(a)
+	}
+	return out
 }
 
-// formatConstLit renders a Const as a short literal string.
-func formatConstLit(c sm33.Const) string {
-	switch c.Kind {
-	case sm33.ConstInt:
-		return fmt.Sprintf("%d", c.Int)
-	case sm33.ConstDouble:
-		return fmt.Sprintf("%g", c.Double)
-	case sm33.ConstAtom:
-		s := c.Atom
-		if len(s) > 24 {
-			s = s[:24] + "\u2026"
+// formatArg renders one argument expression for graph labels.
+func formatArg(e *ir.Expr) string {
+	if e.IsLit(ir.LitString) {
+		lit := e.Str
+		if len(lit) > 64 {
+			lit = lit[:64] + "\u2026"
 		}
-		return "\"" + s + "\""
-	case sm33.ConstTrue:
-		return "true"
-	case sm33.ConstFalse:
-		return "false"
-	case sm33.ConstNull:
-		return "null"
-	default:
-		return ""
+		return "\"" + lit + "\""
+	}
+	s := e.String()
+	if len(s) > 32 {
+		s = s[:32] + "\u2026"
 	}
+	return s
 }
 
 // dedup removes duplicate nodes.
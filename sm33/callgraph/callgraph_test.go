@@ -0,0 +1,72 @@
+package callgraph
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+func TestEdgeSites(t *testing.T) {
+	s, err := xdr.DecodeFile("../disasm/testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := Build(s)
+
+	var edge *Edge
+	type key struct {
+		caller, callee string
+		kind           EdgeKind
+	}
+	pairs := map[key]bool{}
+	for i, e := range g.Edges {
+		k := key{e.Caller, e.Callee, e.Kind}
+		if pairs[k] {
+			t.Errorf("duplicate edge %s -%s-> %s", e.Caller, e.Kind, e.Callee)
+		}
+		pairs[k] = true
+		if e.Count != len(e.Sites) {
+			t.Errorf("%s -> %s: count %d, %d sites", e.Caller, e.Callee, e.Count, len(e.Sites))
+		}
+		if e.Callee == "loadingBarBg.getContentSize" {
+			edge = &g.Edges[i]
+		}
+	}
+	if edge == nil {
+		t.Fatal("no edge to loadingBarBg.getContentSize")
+	}
+	if edge.Count != 4 {
+		t.Fatalf("count = %d, want 4", edge.Count)
+	}
+	for i := 1; i < len(edge.Sites); i++ {
+		prev, cur := edge.Sites[i-1], edge.Sites[i]
+		if cur.Offset <= prev.Offset || cur.Line < prev.Line {
+			t.Errorf("sites out of order: %+v then %+v", prev, cur)
+		}
+	}
+	if first := edge.Sites[0]; first.Line != 46 {
+		t.Errorf("first site line = %d, want 46", first.Line)
+	}
+}
+
+func TestEdgeKinds(t *testing.T) {
+	s, err := xdr.DecodeFile("../disasm/testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := Build(s)
+	kinds := map[string]EdgeKind{}
+	for _, e := range g.Edges {
+		kinds[e.Caller+" -> "+e.Callee+" "+e.Kind.String()] = e.Kind
+	}
+	for _, want := range []string{
+		"main -> SplashScene<.ctor defines",
+		"main -> cc.Scene.extend calls",
+		"SplashScene<.ctor -> cc.Sprite constructs",
+		"SplashScene<.ctor -> SplashScene<.ctor/< registers",
+	} {
+		if _, ok := kinds[want]; !ok {
+			t.Errorf("missing edge %q", want)
+		}
+	}
+}
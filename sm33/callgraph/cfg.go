@@ -1,12 +1,14 @@
 package callgraph
 
 import (
-	"fmt"
 	"sort"
 	"strings"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33"
 	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
 )
 
 // Control flow opcodes.
@@ -23,8 +25,22 @@ const (
 	opCase        = 121
 	opDefault     = 122
 	opTableswitch = 70
+	opRetsub      = 117
+	opTry         = 134
+	opIter        = 75
+	opGenerator   = 202
+	opYield       = 203
 )
 
+// Try note kinds (JSTryNoteKind).
+const (
+	tryCatch   = 0
+	tryFinally = 1
+)
+
+// jsiterForEach is the JSOP_ITER flag set by for-each-in loops.
+const jsiterForEach = 0x2
+
 // Comparison opcodes — emit property access context.
 const (
 	opEq       = 18
@@ -36,14 +52,22 @@ const (
 // CallSite records a call found during bytecode scanning.
 type CallSite struct {
 	Offset int
-	Callee string
-	Args   []string
+	Line   int         // source line
+	Callee string      // inner function name, or full receiver path
+	Kind   ir.CallKind // invoking opcode
+	Argc   int         // argument count; -1 for spread calls
+	Args   []string    // argument expressions, one per slot
+	Func   int         // index in CFGGraph.Funcs of the resolved inner function; -1 if external
+
+	fn *sm33.Function // resolved inner function, mapped to Func after the walk
 }
 
 // Successor describes a control flow edge to another basic block.
 type Successor struct {
 	BlockID int
 	Cond    string // "" (unconditional), "T" (true), "F" (false)
+	Back    bool   // back edge: the target dominates this block
+	Via     string // "" (plain flow), "gosub", "retsub", "catch", "finally" or "resume"
 }
 
 // PropAccess records a property read or name lookup that isn't a call target.
@@ -60,13 +84,32 @@ type BasicBlock struct {
 	Props []PropAccess // property accesses not consumed by calls
 	Succs []Successor
 	Term  bool // ends with return/throw
+	// Suspend marks a generator suspend point (yield, or the initial
+	// generator op); the successor is the resume point.
+	Suspend bool
+
+	Preds       []int // predecessor block IDs
+	Idom        int   // immediate dominator; -1 for the entry and unreachable blocks
+	Ipdom       int   // immediate post-dominator; -1 if it is the function exit, NoExit if no exit is reachable
+	Loop        int   // innermost loop, index in FuncCFG.Loops; -1 outside loops
+	LoopDepth   int   // number of loops enclosing the block
+	Irreducible bool  // part of an irreducible region
 }
 
+// NoExit is the Ipdom of a block from which no path reaches the function
+// exit, such as the blocks of an infinite loop.
+const NoExit = -2
+
 // FuncCFG is a per-function control flow graph.
 type FuncCFG struct {
 	Name     string
 	Blocks   []*BasicBlock
 	Children []int // indices of child functions in CFGGraph.Funcs
+
+	Loops       []*Loop // natural loops, ordered by header
+	Irreducible [][]int // block IDs of each irreducible region
+
+	loopKinds map[int]string // loop kind by header offset, from source notes
 }
 
 // CFGGraph holds the full program CFG.
@@ -77,28 +120,49 @@ type CFGGraph struct {
 // BuildCFG constructs a control flow graph from a decoded Script.
 func BuildCFG(s *sm33.Script) *CFGGraph {
 	g := &CFGGraph{}
-	g.walkCFG(s, "main")
+	funcs := map[*sm33.Function]int{}
+	g.walkCFG(s, "main", newResolver(s, nil), funcs)
+
+	// Link resolved call sites to their function's CFG.
+	for _, f := range g.Funcs {
+		f.analyze()
+		for _, block := range f.Blocks {
+			for i := range block.Calls {
+				c := &block.Calls[i]
+				c.Func = -1
+				if idx, ok := funcs[c.fn]; ok && c.fn != nil {
+					c.Func = idx
+				}
+			}
+		}
+	}
 	return g
 }
 
-func (g *CFGGraph) walkCFG(s *sm33.Script, name string) {
+// BuildFuncCFG constructs the analyzed control flow graph of s alone,
+// without its inner functions. Call sites are left unresolved.
+func BuildFuncCFG(s *sm33.Script, name string) *FuncCFG {
+	f := buildFuncCFG(s, name, nil)
+	f.analyze()
+	return f
+}
+
+func (g *CFGGraph) walkCFG(s *sm33.Script, name string, r *resolver, funcs map[*sm33.Function]int) {
 	parentIdx := len(g.Funcs)
-	cfg := buildFuncCFG(s, name)
+	cfg := buildFuncCFG(s, name, r)
 	g.Funcs = append(g.Funcs, cfg)
 
-	for i, obj := range s.Objects {
+	for _, obj := range s.Objects {
 		if obj.Kind != sm33.CkJSFunction || obj.Function == nil {
 			continue
 		}
 		fn := obj.Function
-		innerName := fn.Name
-		if innerName == "" {
-			innerName = fmt.Sprintf("anon#%d", i)
-		}
+		innerName := r.name(fn)
 		childIdx := len(g.Funcs)
+		funcs[fn] = childIdx
 		g.Funcs[parentIdx].Children = append(g.Funcs[parentIdx].Children, childIdx)
 		if fn.Script != nil && !fn.IsLazy {
-			g.walkCFG(fn.Script, innerName)
+			g.walkCFG(fn.Script, innerName, r, funcs)
 		} else {
 			g.Funcs = append(g.Funcs, &FuncCFG{
 				Name:   innerName,
@@ -109,7 +173,7 @@ func (g *CFGGraph) walkCFG(s *sm33.Script, name string) {
 }
 
 // buildFuncCFG splits a function's bytecode into basic blocks and annotates calls.
-func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
+func buildFuncCFG(s *sm33.Script, name string, r *resolver) *FuncCFG {
 	bc := s.Bytecode
 	if len(bc) == 0 {
 		return &FuncCFG{Name: name, Blocks: []*BasicBlock{{ID: 0}}}
@@ -134,7 +198,7 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 		}
 		switch op {
 		case opGoto, opIfeq, opIfne, opOr, opAnd, opCase, opDefault, opGosub,
-			opReturn, opRetrval, opThrow, opTableswitch:
+			opReturn, opRetrval, opThrow, opTableswitch, opRetsub, opYield, opGenerator:
 			next := off + n
 			if next < len(bc) {
 				blockStarts[next] = true
@@ -143,6 +207,18 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 		off += n
 	}
 
+	// Catch and finally handlers start blocks.
+	for _, tn := range s.TryNotes {
+		if tn.Kind != tryCatch && tn.Kind != tryFinally {
+			continue
+		}
+		if h := int(s.MainOffset + tn.Start + tn.Length); h < len(bc) {
+			blockStarts[h] = true
+		}
+	}
+
+	subs := finallySubs(bc)
+
 	// 2. Sort starts, build blocks
 	starts := make([]int, 0, len(blockStarts))
 	for s := range blockStarts {
@@ -161,13 +237,19 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 		offsetToBlock[start] = i
 	}
 
+	// Call sites come from the stack model, keyed by call offset.
+	callsAt := map[int]*ir.Call{}
+	lines := srcnote.NewLines(s)
+	vals := constprop.Analyze(s)
+	for _, c := range vals.Calls() {
+		callsAt[c.Offset] = c
+	}
+
 	// 3. Walk each block: find calls, property accesses, and successors
 	for _, block := range blocks {
 		off := block.Start
-		var litBuf []string
-		lastAtom := ""
-		lastAtomOff := -1
 		var propChain []string // tracks .foo.bar chains
+		prev := -1             // offset of the previous instruction
 
 		for off < block.End {
 			op := bc[off]
@@ -177,81 +259,29 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 			}
 
 			switch op {
-			// Literal pushes
-			case opString:
-				if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-					if int(idx) < len(s.Atoms) {
-						lit := s.Atoms[idx]
-						if len(lit) > 24 {
-							lit = lit[:24] + "\u2026"
-						}
-						litBuf = appendLit(litBuf, "\""+lit+"\"")
-					}
-				}
-			case opDouble:
-				if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-					if int(idx) < len(s.Consts) {
-						litBuf = appendLit(litBuf, formatConstLit(s.Consts[idx]))
-					}
-				}
-			case opInt8:
-				if v, ok := bytecode.GetInt8(bc, off); ok {
-					litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-				}
-			case opInt32:
-				if v, ok := bytecode.GetInt32(bc, off); ok {
-					litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-				}
-			case opUint16:
-				if v, ok := bytecode.GetUint16(bc, off); ok {
-					litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-				}
-			case opUint24:
-				if v, ok := bytecode.GetUint24(bc, off); ok {
-					litBuf = appendLit(litBuf, fmt.Sprintf("%d", v))
-				}
-			case opZero:
-				litBuf = appendLit(litBuf, "0")
-			case opOne:
-				litBuf = appendLit(litBuf, "1")
-			case opNull:
-				litBuf = appendLit(litBuf, "null")
-			case opTrue:
-				litBuf = appendLit(litBuf, "true")
-			case opFalse:
-				litBuf = appendLit(litBuf, "false")
-
 			// Calls
 			case opCallprop:
-				if idx, ok := bytecode.GetUint32Index(bc, off); ok {
-					if int(idx) < len(s.Atoms) {
-						block.Calls = append(block.Calls, CallSite{
-							Offset: off, Callee: s.Atoms[idx], Args: cloneLits(litBuf),
-						})
-					}
-				}
-				litBuf = litBuf[:0]
-				lastAtom = ""
 				propChain = propChain[:0]
 
 			case opGetprop, opGetgname, opName:
 				if idx, ok := bytecode.GetUint32Index(bc, off); ok {
 					if int(idx) < len(s.Atoms) {
-						atom := s.Atoms[idx]
-						lastAtom = atom
-						lastAtomOff = off
-						propChain = append(propChain, atom)
+						propChain = append(propChain, s.Atoms[idx])
 					}
 				}
 
-			case opCall, opNew, opFuncall, opFunapply:
-				if lastAtom != "" && off-lastAtomOff < 20 {
-					block.Calls = append(block.Calls, CallSite{
-						Offset: off, Callee: lastAtom, Args: cloneLits(litBuf),
-					})
+			case opCall, opNew, opFuncall, opFunapply, opEval,
+				opSpreadcall, opSpreadnew, opSpreadeval:
+				if c := callsAt[off]; c != nil {
+					site := CallSite{
+						Offset: off, Line: lines.Line(off), Callee: vals.Target(c), Kind: c.Kind, Argc: c.Argc, Args: formatArgs(vals.FoldAll(c.Args)),
+					}
+					if fn := r.resolve(s, c.Callee); fn != nil {
+						site.Callee = r.name(fn)
+						site.fn = fn
+					}
+					block.Calls = append(block.Calls, site)
 				}
-				litBuf = litBuf[:0]
-				lastAtom = ""
 				propChain = propChain[:0]
 
 			// Comparisons — emit property chain with compared value
@@ -266,12 +296,11 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 						cmpOp += "="
 					}
 					label := chain
-					if len(litBuf) > 0 {
-						label += " " + cmpOp + " " + litBuf[len(litBuf)-1]
+					if c := vals.At(prev); c != nil {
+						label += " " + cmpOp + " " + formatArg(c)
 					}
 					block.Props = append(block.Props, PropAccess{Name: label})
 					propChain = propChain[:0]
-					litBuf = litBuf[:0]
 				}
 
 			// Successors (control flow)
@@ -339,7 +368,7 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 				}
 				block.Term = true
 
-			case opDefault, opGosub:
+			case opDefault:
 				if jumpOff, ok := bytecode.GetJumpOffset(bc, off); ok {
 					target := off + int(jumpOff)
 					if bid, ok := offsetToBlock[target]; ok {
@@ -348,10 +377,42 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 				}
 				block.Term = true
 
+			case opGosub:
+				// Enter the finally subroutine; its retsub returns to the
+				// continuation. Without a matching retsub, fall through.
+				if jumpOff, ok := bytecode.GetJumpOffset(bc, off); ok {
+					target := off + int(jumpOff)
+					if bid, ok := offsetToBlock[target]; ok {
+						block.Succs = append(block.Succs, Successor{BlockID: bid, Via: "gosub"})
+					}
+					if !subs.returns[target] {
+						if bid, ok := offsetToBlock[off+n]; ok {
+							block.Succs = append(block.Succs, Successor{BlockID: bid})
+						}
+					}
+				}
+				block.Term = true
+
+			case opRetsub:
+				for _, cont := range subs.conts[subs.owner[off]] {
+					if bid, ok := offsetToBlock[cont]; ok {
+						block.Succs = append(block.Succs, Successor{BlockID: bid, Via: "retsub"})
+					}
+				}
+				block.Term = true
+
+			case opYield, opGenerator:
+				if bid, ok := offsetToBlock[off+n]; ok {
+					block.Succs = append(block.Succs, Successor{BlockID: bid, Via: "resume"})
+				}
+				block.Suspend = true
+				block.Term = true
+
 			case opReturn, opRetrval, opThrow:
 				block.Term = true
 			}
 
+			prev = off
 			off += n
 		}
 
@@ -371,5 +432,110 @@ func buildFuncCFG(s *sm33.Script, name string) *FuncCFG {
 
 	}
 
-	return &FuncCFG{Name: name, Blocks: blocks}
+	// Exception edges: any block of a try body can throw, so each one,
+	// from the block holding the try op to the last before the handler,
+	// gets an edge to the handler.
+	for _, tn := range s.TryNotes {
+		if tn.Kind != tryCatch && tn.Kind != tryFinally {
+			continue
+		}
+		start := int(s.MainOffset + tn.Start)
+		end := start + int(tn.Length)
+		handler, ok := offsetToBlock[end]
+		if !ok || start >= len(bc) {
+			continue
+		}
+		if start > 0 && bc[start-1] == opTry {
+			start--
+		}
+		via := "catch"
+		if tn.Kind == tryFinally {
+			via = "finally"
+		}
+		for id := blockAt(starts, start); id < len(blocks) && blocks[id].Start < end; id++ {
+			from := blocks[id]
+			from.Succs = append(from.Succs, Successor{BlockID: handler, Via: via})
+		}
+	}
+
+	return &FuncCFG{Name: name, Blocks: blocks, loopKinds: loopKinds(s)}
+}
+
+// finally maps the finally subroutines of a function: gosub targets, the
+// continuations each returns to, and which subroutine each retsub ends.
+type finally struct {
+	conts   map[int][]int // subroutine start → gosub continuation offsets
+	owner   map[int]int   // retsub offset → subroutine start
+	returns map[int]bool  // subroutine starts that end in a retsub
+}
+
+// finallySubs pairs gosub targets with retsubs. Finally bodies nest in
+// offset order, so the innermost open subroutine owns the next retsub.
+func finallySubs(bc []byte) finally {
+	f := finally{conts: map[int][]int{}, owner: map[int]int{}, returns: map[int]bool{}}
+	for off := 0; off < len(bc); {
+		n := bytecode.InstrLen(bc, off)
+		if n <= 0 {
+			break
+		}
+		if bc[off] == opGosub {
+			if jumpOff, ok := bytecode.GetJumpOffset(bc, off); ok {
+				target := off + int(jumpOff)
+				f.conts[target] = append(f.conts[target], off+n)
+			}
+		}
+		off += n
+	}
+	var open []int
+	for off := 0; off < len(bc); {
+		n := bytecode.InstrLen(bc, off)
+		if n <= 0 {
+			break
+		}
+		if _, ok := f.conts[off]; ok {
+			open = append(open, off)
+		}
+		if bc[off] == opRetsub && len(open) > 0 {
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+			f.owner[off] = start
+			f.returns[start] = true
+		}
+		off += n
+	}
+	return f
+}
+
+// loopKinds classifies iterator loops by the offset their entry jump
+// targets: SpiderMonkey tags that goto with a for-in or for-of note.
+func loopKinds(s *sm33.Script) map[int]string {
+	bc := s.Bytecode
+	kinds := map[int]string{}
+	for _, n := range srcnote.Decode(s.Srcnotes) {
+		if n.Type != srcnote.ForIn && n.Type != srcnote.ForOf {
+			continue
+		}
+		if n.Offset >= len(bc) || bc[n.Offset] != opGoto {
+			continue
+		}
+		jumpOff, ok := bytecode.GetJumpOffset(bc, n.Offset)
+		if !ok {
+			continue
+		}
+		kind := "for-of"
+		if n.Type == srcnote.ForIn {
+			kind = "for-in"
+			// The iter op just before the goto carries the for-each flag.
+			if n.Offset >= 2 && bc[n.Offset-2] == opIter && bc[n.Offset-1]&jsiterForEach != 0 {
+				kind = "for-each"
+			}
+		}
+		kinds[n.Offset+int(jumpOff)] = kind
+	}
+	return kinds
+}
+
+// blockAt returns the index of the block containing off.
+func blockAt(starts []int, off int) int {
+	return sort.SearchInts(starts, off+1) - 1
 }
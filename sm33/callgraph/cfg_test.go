@@ -0,0 +1,141 @@
+package callgraph
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// jump encodes a JOF_JUMP instruction.
+func jump(op uint8, off int32) []byte {
+	return []byte{op, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off)}
+}
+
+func cat(parts ...[]byte) []byte {
+	var bc []byte
+	for _, p := range parts {
+		bc = append(bc, p...)
+	}
+	return bc
+}
+
+const (
+	opPop       = 81
+	opFinally   = 135
+	opLoophead  = 109
+	opIternext  = 77
+	opLoopentry = 227
+	opMoreiter  = 76
+	opEnditer   = 78
+	opUndefined = 1
+	opZero      = 62
+)
+
+func TestFinallyCFG(t *testing.T) {
+	// try { 0; } finally { }
+	s := &sm33.Script{
+		Bytecode: cat(
+			[]byte{opTry, opZero, opPop}, // 0
+			jump(opGosub, 10),            // 3 → 13
+			jump(opGoto, 7),              // 8 → 15
+			[]byte{opFinally, opRetsub},  // 13
+			[]byte{opRetrval},            // 15
+		),
+		TryNotes: []sm33.TryNote{{Kind: tryFinally, Start: 1, Length: 12}},
+	}
+	f := BuildCFG(s).Funcs[0]
+	if len(f.Blocks) != 4 {
+		t.Fatalf("got %d blocks, want 4", len(f.Blocks))
+	}
+	has := func(from, to int, via string) bool {
+		for _, s := range f.Blocks[from].Succs {
+			if s.BlockID == to && s.Via == via {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(0, 2, "gosub") || !has(0, 2, "finally") {
+		t.Errorf("try block succs = %+v, want gosub and finally edges to block 2", f.Blocks[0].Succs)
+	}
+	if !has(2, 1, "retsub") {
+		t.Errorf("finally block succs = %+v, want retsub to block 1", f.Blocks[2].Succs)
+	}
+	if f.Blocks[1].Idom != 2 || !f.Dominates(0, 3) {
+		t.Errorf("continuation idom = %d, want 2", f.Blocks[1].Idom)
+	}
+}
+
+func TestCatchEdges(t *testing.T) {
+	// try { if (0) 0; } catch (e) { }
+	s := &sm33.Script{
+		Bytecode: cat(
+			[]byte{opTry, opZero},      // 0
+			jump(opIfeq, 7),            // 2 → 9
+			[]byte{opZero, opPop},      // 7
+			jump(opGoto, 7),            // 9 → 16
+			[]byte{opUndefined, opPop}, // 14: handler
+			[]byte{opRetrval},          // 16
+		),
+		TryNotes: []sm33.TryNote{{Kind: tryCatch, Start: 1, Length: 13}},
+	}
+	f := BuildCFG(s).Funcs[0]
+	if len(f.Blocks) != 5 {
+		t.Fatalf("got %d blocks, want 5", len(f.Blocks))
+	}
+	for id, b := range f.Blocks {
+		catch := false
+		for _, s := range b.Succs {
+			if s.Via == "catch" {
+				catch = s.BlockID == 3
+			}
+		}
+		if want := id < 3; catch != want {
+			t.Errorf("block %d succs = %+v, catch edge to handler = %v, want %v", id, b.Succs, catch, want)
+		}
+	}
+}
+
+func TestGeneratorCFG(t *testing.T) {
+	// function* () { yield; }
+	s := &sm33.Script{Bytecode: []byte{opGenerator, opUndefined, opYield, opPop, opRetrval}}
+	f := BuildCFG(s).Funcs[0]
+	if len(f.Blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3", len(f.Blocks))
+	}
+	for i := 0; i < 2; i++ {
+		b := f.Blocks[i]
+		if !b.Suspend || len(b.Succs) != 1 || b.Succs[0].BlockID != i+1 || b.Succs[0].Via != "resume" {
+			t.Errorf("block %d: suspend=%v succs=%+v", i, b.Suspend, b.Succs)
+		}
+	}
+}
+
+func TestForInLoop(t *testing.T) {
+	// for (x in o) {}
+	s := &sm33.Script{
+		Bytecode: cat(
+			[]byte{opIter, 1},                     // 0
+			jump(opGoto, 8),                       // 2 → 10
+			[]byte{opLoophead, opIternext, opPop}, // 7
+			[]byte{opLoopentry, 0, opMoreiter},    // 10
+			jump(opIfne, -6),                      // 13 → 7
+			[]byte{opEnditer, opRetrval},          // 18
+		),
+		Srcnotes: []byte{byte(srcnote.ForIn)<<3 | 2, 11, 0},
+	}
+	f := BuildCFG(s).Funcs[0]
+	if len(f.Loops) != 1 {
+		t.Fatalf("got %d loops, want 1", len(f.Loops))
+	}
+	l := f.Loops[0]
+	if l.Kind != "for-in" || f.Blocks[l.Header].Start != 10 {
+		t.Errorf("loop kind=%q header @%d, want for-in @10", l.Kind, f.Blocks[l.Header].Start)
+	}
+
+	s.Bytecode[1] = 1 | jsiterForEach
+	if l := BuildCFG(s).Funcs[0].Loops[0]; l.Kind != "for-each" {
+		t.Errorf("loop kind=%q, want for-each", l.Kind)
+	}
+}
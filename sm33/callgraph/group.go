@@ -0,0 +1,58 @@
+package callgraph
+
+// Collapse returns a copy of g with every node listed in group replaced by
+// its group name. Edges inside a group are dropped and edges that become
+// parallel are merged, keeping all their call sites.
+func (g *Graph) Collapse(group map[string]string) *Graph {
+	node := func(n string) string {
+		if to, ok := group[n]; ok {
+			return to
+		}
+		return n
+	}
+	out := &Graph{}
+	for _, n := range g.Nodes {
+		out.Nodes = append(out.Nodes, node(n))
+	}
+	out.dedup()
+
+	type key struct {
+		caller, callee string
+		kind           EdgeKind
+	}
+	index := map[key]int{}
+	for _, e := range g.Edges {
+		_, grouped := group[e.Callee]
+		e.Caller, e.Callee = node(e.Caller), node(e.Callee)
+		if grouped && e.Caller == e.Callee {
+			continue
+		}
+		k := key{e.Caller, e.Callee, e.Kind}
+		if i, ok := index[k]; ok {
+			m := &out.Edges[i]
+			m.Sites = append(m.Sites[:len(m.Sites):len(m.Sites)], e.Sites...)
+			m.Count += e.Count
+			continue
+		}
+		index[k] = len(out.Edges)
+		out.Edges = append(out.Edges, e)
+	}
+	return out
+}
+
+// Remove returns a copy of g without the nodes drop reports and the edges
+// that touch them.
+func (g *Graph) Remove(drop func(node string) bool) *Graph {
+	out := &Graph{}
+	for _, n := range g.Nodes {
+		if !drop(n) {
+			out.Nodes = append(out.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if !drop(e.Caller) && !drop(e.Callee) {
+			out.Edges = append(out.Edges, e)
+		}
+	}
+	return out
+}
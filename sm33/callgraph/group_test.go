@@ -0,0 +1,54 @@
+package callgraph
+
+import "testing"
+
+func TestCollapseRemove(t *testing.T) {
+	g := &Graph{
+		Nodes: []string{"main", "a", "b", "c"},
+		Edges: []Edge{
+			{Caller: "main", Callee: "a", Kind: Calls, Sites: []Site{{Offset: 1}}, Count: 1},
+			{Caller: "main", Callee: "b", Kind: Calls, Sites: []Site{{Offset: 2}}, Count: 1},
+			{Caller: "a", Callee: "b", Kind: Calls, Sites: []Site{{Offset: 3}}, Count: 1},
+			{Caller: "b", Callee: "c", Kind: Calls, Sites: []Site{{Offset: 4}}, Count: 1},
+			{Caller: "c", Callee: "c", Kind: Calls, Sites: []Site{{Offset: 5}}, Count: 1},
+		},
+	}
+
+	c := g.Collapse(map[string]string{"a": "[lib]", "b": "[lib]"})
+	if want := []string{"main", "[lib]", "c"}; !equalStrings(c.Nodes, want) {
+		t.Errorf("nodes = %v, want %v", c.Nodes, want)
+	}
+	var got []string
+	for _, e := range c.Edges {
+		got = append(got, e.Caller+">"+e.Callee)
+	}
+	if want := []string{"main>[lib]", "[lib]>c", "c>c"}; !equalStrings(got, want) {
+		t.Errorf("edges = %v, want %v", got, want)
+	}
+	if e := c.Edges[0]; e.Count != 2 || len(e.Sites) != 2 {
+		t.Errorf("merged edge = %+v", e)
+	}
+	if len(g.Edges[0].Sites) != 1 {
+		t.Errorf("Collapse modified the original graph")
+	}
+
+	r := g.Remove(func(n string) bool { return n == "b" })
+	if want := []string{"main", "a", "c"}; !equalStrings(r.Nodes, want) {
+		t.Errorf("nodes = %v, want %v", r.Nodes, want)
+	}
+	if len(r.Edges) != 2 {
+		t.Errorf("edges = %+v", r.Edges)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,385 @@
+package callgraph
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Loop is a natural loop: a header block plus every block that reaches one
+// of its back edges without passing through the header. Back edges that
+// share a header form a single loop.
+type Loop struct {
+	Header  int
+	Kind    string // "for-in", "for-each" or "for-of" for iterator loops; "" otherwise
+	Latches []int  // sources of the back edges into Header
+	Blocks  []int  // member block IDs, sorted, header included
+	Exits   []int  // blocks outside the loop entered from inside it
+	Parent  int    // index in FuncCFG.Loops of the enclosing loop; -1 if outermost
+	Depth   int    // nesting depth, 1 for outermost loops
+}
+
+// Contains reports whether block id belongs to the loop.
+func (l *Loop) Contains(id int) bool {
+	i := sort.SearchInts(l.Blocks, id)
+	return i < len(l.Blocks) && l.Blocks[i] == id
+}
+
+// LoopSummary counts the loop structure of one function.
+type LoopSummary struct {
+	Loops       int
+	MaxDepth    int
+	BackEdges   int
+	Irreducible int // irreducible regions
+}
+
+func (s LoopSummary) String() string {
+	if s.Loops == 0 && s.Irreducible == 0 {
+		return "no loops"
+	}
+	out := fmt.Sprintf("%d loop", s.Loops)
+	if s.Loops != 1 {
+		out += "s"
+	}
+	if s.MaxDepth > 1 {
+		out += fmt.Sprintf(", depth %d", s.MaxDepth)
+	}
+	if s.Irreducible > 0 {
+		out += fmt.Sprintf(", %d irreducible", s.Irreducible)
+	}
+	return out
+}
+
+// Summary counts the loops, back edges and irreducible regions of f.
+func (f *FuncCFG) Summary() LoopSummary {
+	s := LoopSummary{Loops: len(f.Loops), Irreducible: len(f.Irreducible)}
+	for _, l := range f.Loops {
+		s.BackEdges += len(l.Latches)
+		if l.Depth > s.MaxDepth {
+			s.MaxDepth = l.Depth
+		}
+	}
+	return s
+}
+
+// Dominates reports whether every path from the entry to block b passes
+// through block a. Unreachable blocks are dominated by nothing.
+func (f *FuncCFG) Dominates(a, b int) bool {
+	if !f.reachable(b) {
+		return false
+	}
+	for ; b >= 0; b = f.Blocks[b].Idom {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// PostDominates reports whether every path from block b to the function
+// exit passes through block a.
+func (f *FuncCFG) PostDominates(a, b int) bool {
+	for ; b >= 0; b = f.Blocks[b].Ipdom {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FuncCFG) reachable(id int) bool {
+	return id == 0 || (id > 0 && id < len(f.Blocks) && f.Blocks[id].Idom >= 0)
+}
+
+// analyze fills in predecessors, dominators, post-dominators, back edges,
+// natural loops and irreducible regions.
+func (f *FuncCFG) analyze() {
+	n := len(f.Blocks)
+	succs := make([][]int, n)
+	preds := make([][]int, n)
+	for _, b := range f.Blocks {
+		for _, s := range b.Succs {
+			if s.BlockID < 0 || s.BlockID >= n || contains(succs[b.ID], s.BlockID) {
+				continue
+			}
+			succs[b.ID] = append(succs[b.ID], s.BlockID)
+			preds[s.BlockID] = append(preds[s.BlockID], b.ID)
+		}
+	}
+	for _, b := range f.Blocks {
+		b.Preds = preds[b.ID]
+		b.Loop = -1
+	}
+	if n == 0 {
+		return
+	}
+
+	// Dominators over the forward graph.
+	order, retreating := dfs(n, 0, func(v int) []int { return succs[v] })
+	idom := idoms(n, 0, order, func(v int) []int { return preds[v] })
+	for i, b := range f.Blocks {
+		b.Idom = idom[i]
+	}
+
+	// Post-dominators over the reverse graph, rooted at a virtual exit
+	// (node n) that every block without successors flows into.
+	var exits []int
+	for v := 0; v < n; v++ {
+		if len(succs[v]) == 0 {
+			exits = append(exits, v)
+		}
+	}
+	rsucc := func(v int) []int {
+		if v == n {
+			return exits
+		}
+		return preds[v]
+	}
+	rpred := func(v int) []int {
+		if v < n && len(succs[v]) == 0 {
+			return []int{n}
+		}
+		return succs[v]
+	}
+	rorder, _ := dfs(n+1, n, rsucc)
+	ipdom := idoms(n+1, n, rorder, rpred)
+	for i, b := range f.Blocks {
+		switch b.Ipdom = ipdom[i]; b.Ipdom {
+		case n:
+			b.Ipdom = -1
+		case -1:
+			b.Ipdom = NoExit
+		}
+	}
+
+	// Back edges: the target dominates the source.
+	latches := map[int][]int{}
+	for _, b := range f.Blocks {
+		for i, s := range b.Succs {
+			if f.reachable(b.ID) && f.Dominates(s.BlockID, b.ID) {
+				b.Succs[i].Back = true
+				if !contains(latches[s.BlockID], b.ID) {
+					latches[s.BlockID] = append(latches[s.BlockID], b.ID)
+				}
+			}
+		}
+	}
+
+	// Natural loops, one per header.
+	headers := make([]int, 0, len(latches))
+	for h := range latches {
+		headers = append(headers, h)
+	}
+	sort.Ints(headers)
+	for _, h := range headers {
+		l := &Loop{Header: h, Kind: f.loopKinds[f.Blocks[h].Start], Latches: latches[h], Parent: -1}
+		sort.Ints(l.Latches)
+		in := map[int]bool{h: true}
+		work := append([]int(nil), l.Latches...)
+		for len(work) > 0 {
+			v := work[len(work)-1]
+			work = work[:len(work)-1]
+			if in[v] || !f.reachable(v) {
+				continue
+			}
+			in[v] = true
+			work = append(work, preds[v]...)
+		}
+		for v := range in {
+			l.Blocks = append(l.Blocks, v)
+		}
+		sort.Ints(l.Blocks)
+		for _, v := range l.Blocks {
+			for _, s := range succs[v] {
+				if !in[s] && !contains(l.Exits, s) {
+					l.Exits = append(l.Exits, s)
+				}
+			}
+		}
+		sort.Ints(l.Exits)
+		f.Loops = append(f.Loops, l)
+	}
+
+	// Nesting: a loop's parent is the smallest other loop holding its header.
+	for i, l := range f.Loops {
+		for j, o := range f.Loops {
+			if i == j || len(o.Blocks) <= len(l.Blocks) || !o.Contains(l.Header) {
+				continue
+			}
+			if l.Parent < 0 || len(o.Blocks) < len(f.Loops[l.Parent].Blocks) {
+				l.Parent = j
+			}
+		}
+	}
+	for _, l := range f.Loops {
+		for p := l; p != nil; {
+			l.Depth++
+			if p.Parent < 0 {
+				break
+			}
+			p = f.Loops[p.Parent]
+		}
+	}
+	for i, l := range f.Loops {
+		for _, v := range l.Blocks {
+			b := f.Blocks[v]
+			if b.Loop < 0 || len(l.Blocks) < len(f.Loops[b.Loop].Blocks) {
+				b.Loop = i
+				b.LoopDepth = l.Depth
+			}
+		}
+	}
+
+	// Irreducible regions: strongly connected components entered by a
+	// retreating edge whose target does not dominate its source.
+	comp := sccs(n, succs)
+	marked := map[int]bool{}
+	for _, e := range retreating {
+		if f.Dominates(e[1], e[0]) || marked[comp[e[1]]] {
+			continue
+		}
+		c := comp[e[1]]
+		marked[c] = true
+		var region []int
+		for v := 0; v < n; v++ {
+			if comp[v] == c {
+				region = append(region, v)
+				f.Blocks[v].Irreducible = true
+			}
+		}
+		f.Irreducible = append(f.Irreducible, region)
+	}
+}
+
+// dfs returns the postorder of the nodes reachable from entry and the
+// retreating edges (to a node still on the DFS stack) met on the way.
+func dfs(n, entry int, succ func(int) []int) (order []int, retreating [][2]int) {
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := make([]int, n)
+	var visit func(v int)
+	visit = func(v int) {
+		color[v] = grey
+		for _, s := range succ(v) {
+			switch color[s] {
+			case white:
+				visit(s)
+			case grey:
+				retreating = append(retreating, [2]int{v, s})
+			}
+		}
+		color[v] = black
+		order = append(order, v)
+	}
+	visit(entry)
+	return order, retreating
+}
+
+// idoms computes immediate dominators with the iterative algorithm of
+// Cooper, Harvey and Kennedy, given the DFS postorder from entry.
+// The entry and unreachable nodes get -1.
+func idoms(n, entry int, order []int, pred func(int) []int) []int {
+	po := make([]int, n)
+	idom := make([]int, n)
+	for i := range idom {
+		po[i], idom[i] = -1, -1
+	}
+	for i, v := range order {
+		po[v] = i
+	}
+	intersect := func(a, b int) int {
+		for a != b {
+			for po[a] < po[b] {
+				a = idom[a]
+			}
+			for po[b] < po[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	idom[entry] = entry
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 1; i >= 0; i-- {
+			v := order[i]
+			if v == entry {
+				continue
+			}
+			d := -1
+			for _, p := range pred(v) {
+				if idom[p] < 0 {
+					continue
+				}
+				if d < 0 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d >= 0 && idom[v] != d {
+				idom[v] = d
+				changed = true
+			}
+		}
+	}
+	idom[entry] = -1
+	return idom
+}
+
+// sccs labels each node with its strongly connected component (Tarjan).
+func sccs(n int, succs [][]int) []int {
+	index := make([]int, n)
+	low := make([]int, n)
+	comp := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range index {
+		index[i], comp[i] = -1, -1
+	}
+	var stack []int
+	next, ncomp := 0, 0
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, s := range succs[v] {
+			if index[s] < 0 {
+				visit(s)
+				low[v] = min(low[v], low[s])
+			} else if onStack[s] {
+				low[v] = min(low[v], index[s])
+			}
+		}
+		if low[v] == index[v] {
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				comp[w] = ncomp
+				if w == v {
+					break
+				}
+			}
+			ncomp++
+		}
+	}
+	for v := 0; v < n; v++ {
+		if index[v] < 0 {
+			visit(v)
+		}
+	}
+	return comp
+}
+
+func contains(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
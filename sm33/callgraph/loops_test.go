@@ -0,0 +1,104 @@
+package callgraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+// funcCFG builds an analyzed FuncCFG from successor lists.
+func funcCFG(succs ...[]int) *FuncCFG {
+	f := &FuncCFG{}
+	for id, ss := range succs {
+		b := &BasicBlock{ID: id}
+		for _, s := range ss {
+			b.Succs = append(b.Succs, Successor{BlockID: s})
+		}
+		b.Term = len(ss) == 0
+		f.Blocks = append(f.Blocks, b)
+	}
+	f.analyze()
+	return f
+}
+
+func TestNestedLoops(t *testing.T) {
+	// 0 → 1 ⇄ … outer loop 1..4, inner loop 2..3, exit 5.
+	f := funcCFG(
+		[]int{1},    // 0
+		[]int{2, 5}, // 1 outer header
+		[]int{3},    // 2 inner header
+		[]int{2, 4}, // 3 inner latch
+		[]int{1},    // 4 outer latch
+		nil,         // 5 return
+	)
+	if len(f.Loops) != 2 {
+		t.Fatalf("got %d loops, want 2", len(f.Loops))
+	}
+	outer, inner := f.Loops[0], f.Loops[1]
+	if outer.Header != 1 || !reflect.DeepEqual(outer.Blocks, []int{1, 2, 3, 4}) || outer.Depth != 1 {
+		t.Errorf("outer = %+v", *outer)
+	}
+	if inner.Header != 2 || !reflect.DeepEqual(inner.Blocks, []int{2, 3}) || inner.Depth != 2 || inner.Parent != 0 {
+		t.Errorf("inner = %+v", *inner)
+	}
+	if !reflect.DeepEqual(outer.Exits, []int{5}) || !reflect.DeepEqual(inner.Exits, []int{4}) {
+		t.Errorf("exits outer=%v inner=%v", outer.Exits, inner.Exits)
+	}
+	if f.Blocks[3].Loop != 1 || f.Blocks[3].LoopDepth != 2 || f.Blocks[4].LoopDepth != 1 || f.Blocks[5].Loop != -1 {
+		t.Errorf("block loops: 3=%d/%d 4=%d 5=%d",
+			f.Blocks[3].Loop, f.Blocks[3].LoopDepth, f.Blocks[4].LoopDepth, f.Blocks[5].Loop)
+	}
+	if !f.Blocks[3].Succs[0].Back || f.Blocks[3].Succs[1].Back || !f.Blocks[4].Succs[0].Back {
+		t.Errorf("back edges not marked")
+	}
+	if !reflect.DeepEqual(f.Blocks[2].Preds, []int{1, 3}) {
+		t.Errorf("preds(2) = %v", f.Blocks[2].Preds)
+	}
+	if got := f.Summary(); got != (LoopSummary{Loops: 2, MaxDepth: 2, BackEdges: 2}) {
+		t.Errorf("summary = %+v", got)
+	}
+}
+
+func TestDominators(t *testing.T) {
+	// Diamond: 0 → {1, 2} → 3.
+	f := funcCFG([]int{1, 2}, []int{3}, []int{3}, nil)
+	for id, want := range []int{-1, 0, 0, 0} {
+		if f.Blocks[id].Idom != want {
+			t.Errorf("idom(%d) = %d, want %d", id, f.Blocks[id].Idom, want)
+		}
+	}
+	for id, want := range []int{3, 3, 3, -1} {
+		if f.Blocks[id].Ipdom != want {
+			t.Errorf("ipdom(%d) = %d, want %d", id, f.Blocks[id].Ipdom, want)
+		}
+	}
+	if f.Dominates(1, 3) || !f.Dominates(0, 3) || !f.PostDominates(3, 0) {
+		t.Error("dominance queries wrong")
+	}
+	if len(f.Loops) != 0 || len(f.Irreducible) != 0 {
+		t.Errorf("unexpected loops %v / irreducible %v", f.Loops, f.Irreducible)
+	}
+}
+
+func TestNoExit(t *testing.T) {
+	// 0 → 1 ⇄ 2, with no way out of the loop; 3 is the unreachable return.
+	f := funcCFG([]int{1}, []int{2}, []int{1}, nil)
+	for id, want := range []int{NoExit, NoExit, NoExit, -1} {
+		if f.Blocks[id].Ipdom != want {
+			t.Errorf("ipdom(%d) = %d, want %d", id, f.Blocks[id].Ipdom, want)
+		}
+	}
+}
+
+func TestIrreducible(t *testing.T) {
+	// Two entries into the cycle 1 ⇄ 2.
+	f := funcCFG([]int{1, 2}, []int{2}, []int{1, 3}, nil)
+	if len(f.Loops) != 0 {
+		t.Errorf("got %d natural loops, want 0", len(f.Loops))
+	}
+	if !reflect.DeepEqual(f.Irreducible, [][]int{{1, 2}}) {
+		t.Errorf("irreducible = %v, want [[1 2]]", f.Irreducible)
+	}
+	if !f.Blocks[1].Irreducible || f.Blocks[3].Irreducible {
+		t.Error("irreducible flags wrong")
+	}
+}
@@ -0,0 +1,285 @@
+package callgraph
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+// opcode constants for object literals that can carry handlers.
+const (
+	opObject    = 80
+	opNewinit   = 89
+	opNewobject = 91
+)
+
+// carrier finds the inner functions that reach a call other than as a
+// plain function argument: handlers in object literals
+// (cc.EventListener.create({onTouchBegan: function ...})), bound methods
+// (this.update.bind(this)), and functions passed on inside call results
+// or variables (runAction(cc.sequence(cc.callFunc(fn)))). Variables are
+// read as they stand at each call, so a later store does not leak into
+// an earlier registration.
+type carrier struct {
+	s       *sm33.Script
+	r       *resolver
+	objs    map[*ir.Expr][]*sm33.Function // object literal → handlers stored in it
+	held    map[string][]*sm33.Function   // variable or property path → functions stored in it
+	results map[int][]*sm33.Function      // call offset → functions its result carries
+	passed  map[int][]*sm33.Function      // call offset → carried functions its arguments pass
+}
+
+// callbacks maps the offset of each call of s to the inner functions its
+// arguments carry that are not themselves function values (those get
+// Registers edges directly). A call whose result is the argument of
+// another call defers to that call, so runAction(cc.sequence(...)) is
+// the registration rather than cc.sequence. Object literals passed to
+// extend are class bodies, not handlers.
+func callbacks(s *sm33.Script, r *resolver) map[int][]*sm33.Function {
+	cr := &carrier{
+		s:       s,
+		r:       r,
+		objs:    map[*ir.Expr][]*sm33.Function{},
+		held:    map[string][]*sm33.Function{},
+		results: map[int][]*sm33.Function{},
+		passed:  map[int][]*sm33.Function{},
+	}
+	bc := s.Bytecode
+	var prev ir.Instr
+	have := false
+	calls := ir.SimulateDecoded(s, r.decoded[s], func(in ir.Instr, st *ir.Stack) {
+		if have {
+			switch prev.Op {
+			case opNewinit, opNewobject, opObject:
+				cr.objs[st.Peek(0)] = nil
+			}
+		}
+		prev, have = in, true
+		if st.Len() == 0 {
+			return
+		}
+		var key string
+		switch in.Op {
+		case opInitprop:
+			if st.Len() < 2 {
+				return
+			}
+			if obj := st.Peek(1); cr.isObject(obj) {
+				cr.objs[obj] = appendFuncs(cr.objs[obj], cr.carried(st.Peek(0)))
+			}
+			return
+		case opCall, opNew, opFuncall, opFunapply, opEval:
+			cr.call(in, st)
+			return
+		case opSetlocal:
+			v, _ := bytecode.GetLocalno(bc, in.Off)
+			key = "local " + strconv.Itoa(int(v))
+		case opSetarg:
+			v, _ := bytecode.GetArgno(bc, in.Off)
+			key = "arg " + strconv.Itoa(int(v))
+		case opSetname, opSetgname:
+			key = ir.Atom(s, in.Off)
+		case opSetprop:
+			if st.Len() < 2 {
+				return
+			}
+			if path := st.Peek(1).Path(); path != "" {
+				key = path + "." + ir.Atom(s, in.Off)
+			}
+		}
+		if key != "" {
+			cr.held[key] = cr.carried(st.Peek(0))
+		}
+	})
+
+	consumed := map[*ir.Call]bool{}
+	for _, c := range calls {
+		for _, a := range c.Args {
+			if a.Kind == ir.CallResult && a.Call != nil {
+				consumed[a.Call] = true
+			}
+		}
+	}
+	out := map[int][]*sm33.Function{}
+	for _, c := range calls {
+		if consumed[c] || c.Callee != nil && c.Callee.LastAtom() == "extend" {
+			continue
+		}
+		if fns := cr.passed[c.Offset]; len(fns) > 0 {
+			out[c.Offset] = fns
+		}
+	}
+	return out
+}
+
+// call records, before the call at in runs, the functions its arguments
+// carry and, for a bound method, the function being bound.
+func (cr *carrier) call(in ir.Instr, st *ir.Stack) {
+	argc, _ := bytecode.GetUint16(cr.s.Bytecode, in.Off)
+	n := int(argc)
+	if st.Len() < n+2 {
+		return
+	}
+	var result, passed []*sm33.Function
+	if callee := st.Peek(n + 1); callee.Kind == ir.Prop && callee.Atom == "bind" {
+		result = cr.carried(callee.Obj)
+	}
+	for i := n - 1; i >= 0; i-- {
+		a := st.Peek(i)
+		fns := cr.carried(a)
+		result = appendFuncs(result, fns)
+		if cr.r.resolve(cr.s, a) == nil {
+			passed = appendFuncs(passed, fns)
+		}
+	}
+	cr.results[in.Off] = result
+	cr.passed[in.Off] = passed
+}
+
+func (cr *carrier) isObject(e *ir.Expr) bool {
+	_, ok := cr.objs[e]
+	return ok
+}
+
+// carried returns the inner functions e is or holds.
+func (cr *carrier) carried(e *ir.Expr) []*sm33.Function {
+	if e == nil {
+		return nil
+	}
+	if fn := cr.r.resolve(cr.s, e); fn != nil {
+		return []*sm33.Function{fn}
+	}
+	if cr.isObject(e) {
+		return cr.objs[e]
+	}
+	switch e.Kind {
+	case ir.CallResult:
+		if e.Call != nil {
+			return cr.results[e.Call.Offset]
+		}
+	case ir.Local:
+		return cr.held["local "+strconv.Itoa(e.Slot)]
+	case ir.Arg:
+		return cr.held["arg "+strconv.Itoa(e.Slot)]
+	case ir.Name, ir.Prop:
+		if path := e.Path(); path != "" {
+			return cr.held[path]
+		}
+	}
+	return nil
+}
+
+// appendFuncs appends the functions of add not already in fns.
+func appendFuncs(fns, add []*sm33.Function) []*sm33.Function {
+next:
+	for _, fn := range add {
+		for _, have := range fns {
+			if have == fn {
+				continue next
+			}
+		}
+		fns = append(fns, fn)
+	}
+	return fns
+}
+
+// Entry kinds, from the API a function is registered with.
+const (
+	EntryEvent    = "event"    // event manager and listener objects
+	EntryTouch    = "touch"    // widget touch and click handlers
+	EntrySchedule = "schedule" // scheduler callbacks
+	EntryAction   = "action"   // cc.callFunc inside an action
+	EntryTimer    = "timer"    // setTimeout and setInterval
+	EntryCallback = "callback" // any other function argument
+)
+
+// registrars classifies registering calls by the last segment of their
+// target. Outer registrations rank before the wrappers they receive, so
+// runAction wins over cc.callFunc and addListener over
+// cc.EventListener.create.
+var registrars = []struct {
+	name string
+	kind string
+}{
+	{"addListener", EntryEvent},
+	{"addEventListener", EntryEvent},
+	{"addCustomListener", EntryEvent},
+	{"addEventListenerWithSceneGraphPriority", EntryEvent},
+	{"addEventListenerWithFixedPriority", EntryEvent},
+	{"addTouchEventListener", EntryTouch},
+	{"addClickEventListener", EntryTouch},
+	{"addCallback", EntryTouch},
+	{"schedule", EntrySchedule},
+	{"scheduleOnce", EntrySchedule},
+	{"scheduleCallbackForTarget", EntrySchedule},
+	{"runAction", EntryAction},
+	{"setTimeout", EntryTimer},
+	{"setInterval", EntryTimer},
+	{"create", EntryEvent}, // cc.EventListener.create
+	{"callFunc", EntryAction},
+	{"CallFunc", EntryAction},
+}
+
+// registrar returns the kind of a registering call target and its rank
+// (lower is more specific).
+func registrar(via string) (string, int) {
+	if strings.HasSuffix(via, "CallFunc.create") {
+		return EntryAction, len(registrars)
+	}
+	last := via[strings.LastIndex(via, ".")+1:]
+	for i, rg := range registrars {
+		if last != rg.name {
+			continue
+		}
+		if rg.name == "create" && !strings.Contains(via, "EventListener") {
+			break
+		}
+		return rg.kind, i
+	}
+	return EntryCallback, len(registrars) + 1
+}
+
+// Entry is an inner function the host invokes: one the script registers
+// as a handler or callback rather than calls itself.
+type Entry struct {
+	Func   string `json:"func"`   // inner function node
+	Kind   string `json:"kind"`   // event, touch, schedule, action, timer or callback
+	Via    string `json:"via"`    // registering call target
+	Caller string `json:"caller"` // function making the registration
+	Offset int    `json:"offset"`
+	Line   int    `json:"line"`
+}
+
+// Entries returns the registered functions of g in node order, each with
+// its most specific registration.
+func (g *Graph) Entries() []Entry {
+	best := map[string]Entry{}
+	rank := map[string]int{}
+	for _, e := range g.Edges {
+		if e.Kind != Registers {
+			continue
+		}
+		for _, site := range e.Sites {
+			kind, rk := registrar(site.Via)
+			if r, ok := rank[e.Callee]; ok && r <= rk {
+				continue
+			}
+			rank[e.Callee] = rk
+			best[e.Callee] = Entry{Func: e.Callee, Kind: kind, Via: site.Via, Caller: e.Caller, Offset: site.Offset, Line: site.Line}
+		}
+	}
+	pos := map[string]int{}
+	for i, n := range g.Nodes {
+		pos[n] = i
+	}
+	out := make([]Entry, 0, len(best))
+	for _, e := range best {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return pos[out[i].Func] < pos[out[j].Func] })
+	return out
+}
@@ -0,0 +1,105 @@
+package callgraph
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+func TestRegistrations(t *testing.T) {
+	fn := func(name string) *sm33.Object {
+		return FnObject(&sm33.Script{Bytecode: []byte{OpRetrval}}, name)
+	}
+	method := func(recv []byte, name uint32) []byte {
+		return Asm(recv, []byte{OpDup}, AtomOp(OpCallprop, name), []byte{OpSwap})
+	}
+	bc := Asm(
+		// cc.eventManager.addListener(cc.EventListener.create({onTouchBegan: began}), this);
+		method(Asm(AtomOp(OpName, 0), AtomOp(OpGetprop, 1)), 2),
+		method(Asm(AtomOp(OpName, 0), AtomOp(OpGetprop, 3)), 4),
+		[]byte{OpNewinit, 1, 0, 0, 0}, AtomOp(OpLambda, 0), AtomOp(OpInitprop, 5), []byte{OpEndinit},
+		ArgcOp(OpCall, 1), []byte{OpThis}, ArgcOp(OpCall, 2), []byte{OpPop},
+		// this.runAction(cc.sequence(cc.delayTime(1), cc.callFunc(done)));
+		method([]byte{OpThis}, 6),
+		method(AtomOp(OpName, 0), 7),
+		method(AtomOp(OpName, 0), 8), []byte{OpOne}, ArgcOp(OpCall, 1),
+		method(AtomOp(OpName, 0), 9), AtomOp(OpLambda, 1), ArgcOp(OpCall, 1),
+		ArgcOp(OpCall, 2), ArgcOp(OpCall, 1), []byte{OpPop},
+		// cc.Layer.extend({onEnter: onEnter});
+		method(Asm(AtomOp(OpName, 0), AtomOp(OpGetprop, 10)), 11),
+		[]byte{OpNewinit, 1, 0, 0, 0}, AtomOp(OpLambda, 2), AtomOp(OpInitprop, 12), []byte{OpEndinit},
+		ArgcOp(OpCall, 1), []byte{OpPop},
+		// var f = tick; node.addTouchEventListener(f.bind(this));
+		AtomOp(OpLambda, 3), LocalOp(OpSetlocal, 0), []byte{OpPop},
+		method(AtomOp(OpName, 13), 14),
+		method(LocalOp(OpGetlocal, 0), 15), []byte{OpThis}, ArgcOp(OpCall, 1), ArgcOp(OpCall, 1), []byte{OpPop},
+		[]byte{OpRetrval},
+	)
+	s := &sm33.Script{
+		Nvars:    1,
+		Bindings: []string{"f"},
+		Atoms: []string{
+			"cc", "eventManager", "addListener", "EventListener", "create", "onTouchBegan",
+			"runAction", "sequence", "delayTime", "callFunc", "Layer", "extend", "onEnter",
+			"node", "addTouchEventListener", "bind",
+		},
+		Objects:  []*sm33.Object{fn("began"), fn("done"), fn("enter"), fn("tick")},
+		Bytecode: bc,
+	}
+	g := Build(s)
+
+	vias := map[string][]string{}
+	for _, e := range g.Edges {
+		if e.Kind == Registers {
+			for _, site := range e.Sites {
+				vias[e.Callee] = append(vias[e.Callee], site.Via)
+			}
+		}
+	}
+	if v := vias["done"]; len(v) != 2 || v[0] != "cc.callFunc" || v[1] != "this.runAction" {
+		t.Errorf("done registered via %v", v)
+	}
+	if v := vias["enter"]; len(v) != 0 {
+		t.Errorf("class method registered via %v", v)
+	}
+
+	want := []Entry{
+		{Func: "began", Kind: EntryEvent, Via: "cc.eventManager.addListener"},
+		{Func: "done", Kind: EntryAction, Via: "this.runAction"},
+		{Func: "tick", Kind: EntryTouch, Via: "node.addTouchEventListener"},
+	}
+	got := g.Entries()
+	if len(got) != len(want) {
+		t.Fatalf("entries = %+v", got)
+	}
+	for i, w := range want {
+		if e := got[i]; e.Func != w.Func || e.Kind != w.Kind || e.Via != w.Via || e.Caller != "main" {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
+
+func TestRegistrationReadsVariableAtCall(t *testing.T) {
+	// var f = a; this.schedule(f); f = b;
+	fn := func(name string) *sm33.Object {
+		return FnObject(&sm33.Script{Bytecode: []byte{OpRetrval}}, name)
+	}
+	s := &sm33.Script{
+		Nvars:    1,
+		Bindings: []string{"f"},
+		Atoms:    []string{"schedule"},
+		Objects:  []*sm33.Object{fn("a"), fn("b")},
+		Bytecode: Asm(
+			AtomOp(OpLambda, 0), LocalOp(OpSetlocal, 0), []byte{OpPop},
+			[]byte{OpThis, OpDup}, AtomOp(OpCallprop, 0), []byte{OpSwap},
+			LocalOp(OpGetlocal, 0), ArgcOp(OpCall, 1), []byte{OpPop},
+			AtomOp(OpLambda, 1), LocalOp(OpSetlocal, 0), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+	got := Build(s).Entries()
+	if len(got) != 1 || got[0].Func != "a" || got[0].Via != "this.schedule" {
+		t.Errorf("entries = %+v, want a via this.schedule", got)
+	}
+}
@@ -38,21 +38,21 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 	}
 	b.WriteByte('\n')
 
-	funcIndex := map[string]int{}
-	for fi, f := range g.Funcs {
-		funcIndex[f.Name] = fi
-	}
 	externalSeen := map[string]bool{}
 
 	for fi, f := range g.Funcs {
 		clusterID := fmt.Sprintf("cluster_%d", fi)
+		name := f.Name
+		if sum := f.Summary(); sum.Loops > 0 || sum.Irreducible > 0 {
+			name += " · " + sum.String()
+		}
 		fmt.Fprintf(&b, "  subgraph %s {\n", clusterID)
-		fmt.Fprintf(&b, "    label=<<font face=\"Helvetica Neue,Helvetica\" point-size=\"8\" color=\"%s\">%s</font>>;\n", sumi, dotEscape(f.Name))
+		fmt.Fprintf(&b, "    label=<<font face=\"Helvetica Neue,Helvetica\" point-size=\"8\" color=\"%s\">%s</font>>;\n", sumi, dotEscape(name))
 		fmt.Fprintf(&b, "    style=dotted;\n    color=%q;\n    penwidth=0.3;\n", nezumi)
 
 		hasContent := map[int]bool{}
 		for _, block := range f.Blocks {
-			if len(block.Calls) > 0 || len(block.Props) > 0 || len(block.Succs) > 1 {
+			if len(block.Calls) > 0 || len(block.Props) > 0 || len(block.Succs) > 1 || block.Suspend {
 				hasContent[block.ID] = true
 			}
 		}
@@ -64,33 +64,78 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 				hasContent[block.ID] = true
 			}
 		}
+		for _, l := range f.Loops {
+			hasContent[l.Header] = true
+		}
 
-		for _, block := range f.Blocks {
-			if !hasContent[block.ID] {
-				continue
-			}
+		writeNode := func(indent string, block *callgraph.BasicBlock) {
 			nodeID := blockNodeID(fi, block.ID)
 			label := buildBlockLabel(block, f.Name, block.ID == 0)
+			extra := ""
+			if block.Irreducible {
+				extra = fmt.Sprintf(", color=%q, style=dashed, penwidth=0.6", shu)
+			} else if block.Loop >= 0 && f.Loops[block.Loop].Header == block.ID {
+				extra = fmt.Sprintf(", color=%q, penwidth=0.8", shu)
+			}
 
 			if block.ID == 0 {
-				fmt.Fprintf(&b, "    %s [label=%s, style=filled, fillcolor=%q, fontcolor=%q, color=%q, penwidth=0];\n",
-					nodeID, label, sumi, kinari, sumi)
+				fmt.Fprintf(&b, "%s%s [label=%s, style=filled, fillcolor=%q, fontcolor=%q, color=%q, penwidth=0];\n",
+					indent, nodeID, label, sumi, kinari, sumi)
 			} else if len(block.Succs) > 1 {
 				if len(block.Calls) == 0 && len(block.Props) == 0 {
-					fmt.Fprintf(&b, "    %s [label=\"\", shape=diamond, width=0.15, height=0.15, color=%q, penwidth=0.3];\n",
-						nodeID, sumi)
+					if extra == "" {
+						extra = fmt.Sprintf(", color=%q, penwidth=0.3", sumi)
+					}
+					fmt.Fprintf(&b, "%s%s [label=\"\", shape=diamond, width=0.15, height=0.15%s];\n",
+						indent, nodeID, extra)
 				} else {
-					fmt.Fprintf(&b, "    %s [label=%s];\n", nodeID, label)
+					fmt.Fprintf(&b, "%s%s [label=%s%s];\n", indent, nodeID, label, extra)
 				}
 			} else if block.Term && len(block.Succs) == 0 {
 				if len(block.Calls) == 0 && len(block.Props) == 0 {
-					fmt.Fprintf(&b, "    %s [label=\"ret\", shape=plaintext, fontsize=8, fontcolor=%q];\n",
-						nodeID, nezumi)
+					fmt.Fprintf(&b, "%s%s [label=\"ret\", shape=plaintext, fontsize=8, fontcolor=%q];\n",
+						indent, nodeID, nezumi)
 				} else {
-					fmt.Fprintf(&b, "    %s [label=%s];\n", nodeID, label)
+					fmt.Fprintf(&b, "%s%s [label=%s%s];\n", indent, nodeID, label, extra)
 				}
 			} else {
-				fmt.Fprintf(&b, "    %s [label=%s];\n", nodeID, label)
+				fmt.Fprintf(&b, "%s%s [label=%s%s];\n", indent, nodeID, label, extra)
+			}
+		}
+
+		// Blocks outside loops, then one nested cluster per loop, shaded
+		// darker with depth.
+		for _, block := range f.Blocks {
+			if hasContent[block.ID] && block.Loop < 0 {
+				writeNode("    ", block)
+			}
+		}
+		var writeLoop func(li int, indent string)
+		writeLoop = func(li int, indent string) {
+			l := f.Loops[li]
+			fmt.Fprintf(&b, "%ssubgraph cluster_%d_loop%d {\n", indent, fi, li)
+			kind := l.Kind
+			if kind == "" {
+				kind = "loop"
+			}
+			fmt.Fprintf(&b, "%s  label=<<font point-size=\"7\" color=\"%s\">%s @%d</font>>;\n", indent, shu, kind, f.Blocks[l.Header].Start)
+			fmt.Fprintf(&b, "%s  style=filled;\n%s  fillcolor=%q;\n%s  color=%q;\n%s  penwidth=0.3;\n",
+				indent, indent, loopShade(l.Depth), indent, shu, indent)
+			for _, block := range f.Blocks {
+				if hasContent[block.ID] && block.Loop == li {
+					writeNode(indent+"  ", block)
+				}
+			}
+			for ci, c := range f.Loops {
+				if c.Parent == li {
+					writeLoop(ci, indent+"  ")
+				}
+			}
+			fmt.Fprintf(&b, "%s}\n", indent)
+		}
+		for li, l := range f.Loops {
+			if l.Parent < 0 {
+				writeLoop(li, "    ")
 			}
 		}
 
@@ -104,19 +149,21 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 			type resolvedEdge struct {
 				targetID int
 				cond     string
+				back     bool
+				via      string
 			}
 			var resolved []resolvedEdge
 			for _, succ := range block.Succs {
-				tid := resolveTarget(f, succ.BlockID, hasContent)
+				tid, back := resolveTarget(f, succ, hasContent)
 				if tid >= 0 {
-					resolved = append(resolved, resolvedEdge{tid, succ.Cond})
+					resolved = append(resolved, resolvedEdge{tid, succ.Cond, back, succ.Via})
 				}
 			}
 
 			if len(resolved) == 2 && resolved[0].targetID == resolved[1].targetID &&
 				resolved[0].cond != "" && resolved[1].cond != "" {
 				dstID := blockNodeID(fi, resolved[0].targetID)
-				fmt.Fprintf(&b, "    %s -> %s;\n", srcID, dstID)
+				fmt.Fprintf(&b, "    %s -> %s%s;\n", srcID, dstID, backEdgeAttrs(resolved[0].back || resolved[1].back, shu))
 			} else {
 				seen := map[int]bool{}
 				for _, re := range resolved {
@@ -125,7 +172,17 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 					}
 					seen[re.targetID] = true
 					dstID := blockNodeID(fi, re.targetID)
-					if re.cond != "" {
+					if re.back {
+						fmt.Fprintf(&b, "    %s -> %s%s;\n", srcID, dstID, backEdgeAttrs(true, shu))
+					} else if re.via != "" {
+						// Finally subroutine, exception handler and generator resume edges.
+						color, style := ai, "dashed"
+						if re.via == "catch" || re.via == "finally" {
+							color, style = shu, "dotted"
+						}
+						fmt.Fprintf(&b, "    %s -> %s [color=%q, style=%s, label=<<font point-size=\"7\" color=\"%s\">%s</font>>];\n",
+							srcID, dstID, color, style, color, re.via)
+					} else if re.cond != "" {
 						color := ai
 						if re.cond == "F" {
 							color = shu
@@ -149,7 +206,7 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 			srcID := blockNodeID(fi, block.ID)
 			edgeSeen := map[string]bool{}
 			for _, call := range block.Calls {
-				if targetFI, ok := funcIndex[call.Callee]; ok && targetFI != fi {
+				if targetFI := call.Func; targetFI >= 0 && targetFI != fi {
 					dstID := blockNodeID(targetFI, 0)
 					edgeKey := srcID + "->" + dstID
 					if edgeSeen[edgeKey] {
@@ -159,7 +216,7 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 					targetCluster := fmt.Sprintf("cluster_%d", targetFI)
 					fmt.Fprintf(&b, "  %s -> %s [lhead=%q, color=%q, penwidth=0.5];\n",
 						srcID, dstID, targetCluster, ai)
-				} else if _, ok := funcIndex[call.Callee]; !ok {
+				} else if call.Func < 0 {
 					calleeNodeID := dotID(call.Callee)
 					if !externalSeen[call.Callee] {
 						externalSeen[call.Callee] = true
@@ -208,21 +265,42 @@ func DOTCFG(g *callgraph.CFGGraph, title string) string {
 	return b.String()
 }
 
-// resolveTarget follows chains of empty blocks to find the next visible block.
-func resolveTarget(f *callgraph.FuncCFG, blockID int, visible map[int]bool) int {
+// resolveTarget follows chains of empty blocks to find the next visible
+// block, reporting whether the chain crosses a loop back edge.
+func resolveTarget(f *callgraph.FuncCFG, succ callgraph.Successor, visible map[int]bool) (int, bool) {
+	blockID, back := succ.BlockID, succ.Back
 	visited := map[int]bool{}
 	for !visible[blockID] {
 		if visited[blockID] || blockID < 0 || blockID >= len(f.Blocks) {
-			return -1
+			return -1, false
 		}
 		visited[blockID] = true
 		block := f.Blocks[blockID]
 		if len(block.Succs) == 0 {
-			return -1
+			return -1, false
 		}
 		blockID = block.Succs[0].BlockID
+		back = back || block.Succs[0].Back
+	}
+	return blockID, back
+}
+
+// backEdgeAttrs styles a loop back edge: bold vermillion, curving back
+// against the rank direction.
+func backEdgeAttrs(back bool, color string) string {
+	if !back {
+		return ""
+	}
+	return fmt.Sprintf(" [color=%q, penwidth=0.8, constraint=false]", color)
+}
+
+// loopShade returns the fill of a loop cluster, darker for deeper loops.
+func loopShade(depth int) string {
+	shades := []string{"#F3ECE1", "#ECE2D2", "#E4D7C3", "#DCCCB4"}
+	if depth > len(shades) {
+		depth = len(shades)
 	}
-	return blockID
+	return shades[depth-1]
 }
 
 // blockNodeID creates a unique DOT node ID for a basic block.
@@ -0,0 +1,98 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/classes"
+)
+
+// maxClassMembers limits how many fields or methods a class box lists.
+const maxClassMembers = 24
+
+// DOTClasses renders a class model as a Graphviz class diagram: one box
+// per class (name, fields, methods), hollow-arrow edges to base classes.
+// Base classes defined outside the file appear as plain labels.
+// Style: NASA/Bauhaus, matching DOT.
+func DOTClasses(m *classes.Model, title string) string {
+	const (
+		nasaBlue = "#0B3D91"
+		nasaRed  = "#FC3D21"
+		black    = "#1A1A1A"
+		gray     = "#9E9E9E"
+		lightBg  = "#F5F5F5"
+	)
+
+	var b strings.Builder
+	b.WriteString("digraph classes {\n")
+	b.WriteString("  rankdir=BT;\n")
+	b.WriteString("  nodesep=0.4;\n")
+	b.WriteString("  ranksep=0.6;\n")
+	fmt.Fprintf(&b, "  bgcolor=%q;\n", lightBg)
+	fmt.Fprintf(&b, "  node [shape=plaintext, fontname=\"Helvetica Neue,Helvetica,Arial\", fontsize=9, fontcolor=%q];\n", black)
+	fmt.Fprintf(&b, "  edge [color=%q, penwidth=0.5, arrowsize=0.7, arrowhead=empty];\n", black)
+	if title != "" {
+		fmt.Fprintf(&b, "  labelloc=t;\n  labeljust=l;\n")
+		fmt.Fprintf(&b, "  label=<<font face=\"Helvetica Neue,Helvetica\" point-size=\"8\" color=\"%s\">%s</font>>;\n", black, dotEscape(title))
+	}
+	b.WriteByte('\n')
+
+	defined := map[string]bool{}
+	for _, c := range m.Classes {
+		defined[c.Name] = true
+	}
+
+	for _, c := range m.Classes {
+		var l strings.Builder
+		fmt.Fprintf(&l, `<table border="1" cellborder="0" cellspacing="0" cellpadding="3" bgcolor="white" color="%s">`, black)
+		fmt.Fprintf(&l, `<tr><td bgcolor="%s"><font color="white"><b>%s</b></font></td></tr>`, nasaBlue, dotEscape(c.Name))
+		if len(c.Fields) > 0 {
+			l.WriteString(`<tr><td align="left" balign="left">`)
+			for i, f := range c.Fields {
+				if i == maxClassMembers {
+					fmt.Fprintf(&l, `<font color="%s">+%d more</font><br/>`, gray, len(c.Fields)-i)
+					break
+				}
+				l.WriteString(dotEscape(f.Name))
+				if f.Init != "" {
+					fmt.Fprintf(&l, ` <font color="%s">= %s</font>`, gray, dotEscape(f.Init))
+				}
+				l.WriteString("<br/>")
+			}
+			l.WriteString("</td></tr>")
+		}
+		if len(c.Methods) > 0 {
+			l.WriteString(`<tr><td align="left" balign="left">`)
+			for i, meth := range c.Methods {
+				if i == maxClassMembers {
+					fmt.Fprintf(&l, `<font color="%s">+%d more</font><br/>`, gray, len(c.Methods)-i)
+					break
+				}
+				l.WriteString(dotEscape(meth.Name) + "()")
+				if meth.Super {
+					fmt.Fprintf(&l, ` <font color="%s">_super</font>`, gray)
+				}
+				l.WriteString("<br/>")
+			}
+			l.WriteString("</td></tr>")
+		}
+		l.WriteString("</table>")
+		fmt.Fprintf(&b, "  %s [label=<%s>];\n", dotID(c.Name), l.String())
+	}
+	b.WriteByte('\n')
+
+	externalSeen := map[string]bool{}
+	for _, c := range m.Classes {
+		if c.Base == "" {
+			continue
+		}
+		if !defined[c.Base] && !externalSeen[c.Base] {
+			externalSeen[c.Base] = true
+			fmt.Fprintf(&b, "  %s [label=%q, fontcolor=%q, fontsize=8];\n", dotID(c.Base), c.Base, nasaRed)
+		}
+		fmt.Fprintf(&b, "  %s -> %s;\n", dotID(c.Name), dotID(c.Base))
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
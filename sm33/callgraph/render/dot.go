@@ -2,14 +2,68 @@ package render
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
 )
 
-// DOT renders the callgraph in Graphviz DOT format.
-// Style: NASA/Bauhaus — geometric, monochrome, thin rules, sparse color.
+// Mode selects how repeated calls between two functions are drawn.
+type Mode int
+
+const (
+	// Unique draws one edge per caller/callee pair, weighted by call count.
+	Unique Mode = iota
+	// All draws one edge per call site.
+	All
+)
+
+// ParseMode parses a callgraph mode name ("unique" or "all").
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "unique":
+		return Unique, nil
+	case "all":
+		return All, nil
+	}
+	return Unique, fmt.Errorf("unknown callgraph mode %q (use unique or all)", name)
+}
+
+// Options controls callgraph rendering.
+type Options struct {
+	Mode        Mode
+	HideDefines bool // omit containment (defines) edges
+
+	// Library marks known library functions by node name. They are drawn
+	// muted with their library name, or per LibraryView collapsed into one
+	// node per library or left out.
+	Library     map[string]sigdb.LibraryFunc
+	LibraryView sigdb.LibraryView
+
+	// Clusters draws groups of nodes in labelled boxes.
+	Clusters []Cluster
+}
+
+// Cluster is a group of nodes drawn in one box, such as the functions of
+// one file in a project graph. Nodes named "<Name>:<func>" are labelled
+// <func> inside it.
+type Cluster struct {
+	Name  string
+	Nodes []string
+}
+
+// DOT renders the callgraph in Graphviz DOT format with default options.
 func DOT(g *callgraph.Graph, title string) string {
+	return DOTOpt(g, title, Options{})
+}
+
+// DOTOpt renders the callgraph in Graphviz DOT format.
+// Style: NASA/Bauhaus — geometric, monochrome, thin rules, sparse color.
+// Edge kinds: calls (vee), constructs (hollow triangle), applies (double
+// vee), registers (blue dashed, hollow dot), defines (gray dotted, hollow
+// diamond).
+func DOTOpt(g *callgraph.Graph, title string, opt Options) string {
 	const (
 		nasaBlue = "#0B3D91"
 		nasaRed  = "#FC3D21"
@@ -33,6 +87,8 @@ func DOT(g *callgraph.Graph, title string) string {
 	}
 	b.WriteByte('\n')
 
+	g, libNodes := libraryView(g, opt)
+
 	innerFuncs := map[string]bool{}
 	for _, n := range g.Nodes {
 		innerFuncs[n] = true
@@ -40,30 +96,52 @@ func DOT(g *callgraph.Graph, title string) string {
 
 	externalSeen := map[string]bool{}
 
-	for _, n := range g.Nodes {
+	node := func(indent, n, label string) {
 		id := dotID(n)
 		switch {
-		case n == "main":
-			fmt.Fprintf(&b, "  %s [label=%q, fillcolor=%q, fontcolor=white, penwidth=0];\n", id, n, nasaBlue)
-		case strings.HasPrefix(n, "anon#"):
-			fmt.Fprintf(&b, "  %s [label=%q, style=\"filled,dashed\", color=%q, fontcolor=%q];\n", id, n, gray, gray)
+		case libNodes[n] != "":
+			fmt.Fprintf(&b, "%s%s [label=%q, style=\"filled,dashed\", fillcolor=%q, color=%q, fontcolor=%q];\n", indent, id, libNodes[n], lightBg, gray, gray)
+		case label == "main":
+			fmt.Fprintf(&b, "%s%s [label=%q, fillcolor=%q, fontcolor=white, penwidth=0];\n", indent, id, label, nasaBlue)
+		case strings.HasPrefix(label[strings.LastIndex(label, "/")+1:], "anon#"):
+			fmt.Fprintf(&b, "%s%s [label=%q, style=\"filled,dashed\", color=%q, fontcolor=%q];\n", indent, id, label, gray, gray)
 		default:
-			fmt.Fprintf(&b, "  %s [label=%q];\n", id, n)
+			fmt.Fprintf(&b, "%s%s [label=%q];\n", indent, id, label)
+		}
+	}
+	clustered := map[string]bool{}
+	for i, c := range opt.Clusters {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%q;\n    labeljust=l;\n    fontsize=8;\n    fontcolor=%q;\n    color=%q;\n    penwidth=0.5;\n", c.Name, gray, gray)
+		for _, n := range c.Nodes {
+			if innerFuncs[n] && !clustered[n] {
+				clustered[n] = true
+				node("    ", n, strings.TrimPrefix(n, c.Name+":"))
+			}
+		}
+		b.WriteString("  }\n")
+	}
+	for _, n := range g.Nodes {
+		if !clustered[n] {
+			node("  ", n, n)
 		}
 	}
 	b.WriteByte('\n')
 
 	for _, e := range g.Edges {
+		if e.Kind == callgraph.Defines && opt.HideDefines {
+			continue
+		}
 		callerID := dotID(e.Caller)
 		calleeID := dotID(e.Callee)
-		labelAttr := formatEdgeLabel(e.Args)
-		if innerFuncs[e.Callee] {
-			if labelAttr == "" {
-				fmt.Fprintf(&b, "  %s -> %s;\n", callerID, calleeID)
-			} else {
-				fmt.Fprintf(&b, "  %s -> %s [%s];\n", callerID, calleeID, labelAttr[2:]) // strip leading ", "
-			}
-		} else {
+		var style string
+		pen := 0.5
+		switch {
+		case e.Kind == callgraph.Defines:
+			style, pen = fmt.Sprintf("color=%q, style=dotted, arrowhead=odiamond", gray), 0.3
+		case e.Kind == callgraph.Registers:
+			style = fmt.Sprintf("color=%q, style=dashed, arrowhead=odot", nasaBlue)
+		case !innerFuncs[e.Callee]:
 			if !externalSeen[e.Callee] {
 				externalSeen[e.Callee] = true
 				if isAllCaps(e.Callee) {
@@ -73,10 +151,33 @@ func DOT(g *callgraph.Graph, title string) string {
 				}
 			}
 			if isAllCaps(e.Callee) {
-				fmt.Fprintf(&b, "  %s -> %s [color=%q, style=dotted, penwidth=0.3%s];\n", callerID, calleeID, gray, labelAttr)
+				style, pen = fmt.Sprintf("color=%q, style=dotted", gray), 0.3
 			} else {
-				fmt.Fprintf(&b, "  %s -> %s [color=%q, style=dashed, penwidth=0.4%s];\n", callerID, calleeID, nasaRed, labelAttr)
+				style, pen = fmt.Sprintf("color=%q, style=dashed", nasaRed), 0.4
+			}
+		}
+		switch e.Kind {
+		case callgraph.Constructs:
+			style = joinAttrs(style, "arrowhead=onormal")
+		case callgraph.Applies:
+			style = joinAttrs(style, "arrowhead=veevee")
+		}
+
+		switch {
+		case len(e.Sites) == 0:
+			writeEdge(&b, callerID, calleeID, style, pen, "")
+		case opt.Mode == All:
+			for _, site := range e.Sites {
+				writeEdge(&b, callerID, calleeID, style, pen, formatEdgeLabel(siteArgs(e.Kind, site), site.Line, 1))
 			}
+		default:
+			// Weight repeated calls: thicker stroke and a tighter layout pull.
+			attrs := style
+			if e.Count > 1 {
+				pen *= 1 + math.Log2(float64(e.Count))
+				attrs = joinAttrs(attrs, fmt.Sprintf("weight=%d", e.Count))
+			}
+			writeEdge(&b, callerID, calleeID, attrs, pen, formatEdgeLabel(siteArgs(e.Kind, e.Sites[0]), 0, e.Count))
 		}
 	}
 
@@ -84,10 +185,70 @@ func DOT(g *callgraph.Graph, title string) string {
 	return b.String()
 }
 
+// libraryView applies opt's library view to g. It returns the graph to
+// draw and the labels of its library nodes.
+func libraryView(g *callgraph.Graph, opt Options) (*callgraph.Graph, map[string]string) {
+	labels := map[string]string{}
+	if len(opt.Library) == 0 {
+		return g, labels
+	}
+	switch opt.LibraryView {
+	case sigdb.LibraryHide:
+		return g.Remove(func(n string) bool {
+			_, ok := opt.Library[n]
+			return ok
+		}), labels
+	case sigdb.LibraryCollapse:
+		group := map[string]string{}
+		for n, lf := range opt.Library {
+			group[n] = "[" + lf.Library + "]"
+			labels[group[n]] = lf.Library
+		}
+		return g.Collapse(group), labels
+	}
+	for n, lf := range opt.Library {
+		labels[n] = n + "\n" + lf.Library
+	}
+	return g, labels
+}
+
+// siteArgs returns the label arguments for a call site. Callback
+// registrations are labelled with the call that received the function.
+func siteArgs(kind callgraph.EdgeKind, site callgraph.Site) []string {
+	if kind == callgraph.Registers {
+		return []string{"via " + site.Via}
+	}
+	return site.Args
+}
+
+// joinAttrs appends a DOT attribute to a comma-separated list.
+func joinAttrs(attrs, attr string) string {
+	if attrs == "" {
+		return attr
+	}
+	return attrs + ", " + attr
+}
+
+// writeEdge emits one edge statement. The default penwidth (0.5) is omitted.
+func writeEdge(b *strings.Builder, from, to, attrs string, pen float64, label string) {
+	if pen != 0.5 {
+		attrs = joinAttrs(attrs, fmt.Sprintf("penwidth=%.2g", pen))
+	}
+	attrs += label
+	attrs = strings.TrimPrefix(attrs, ", ")
+	if attrs == "" {
+		fmt.Fprintf(b, "  %s -> %s;\n", from, to)
+		return
+	}
+	fmt.Fprintf(b, "  %s -> %s [%s];\n", from, to, attrs)
+}
+
 // formatEdgeLabel returns a DOT label attribute fragment for edge args.
-// Returns "" if no args, or `, label=<...>` with per-arg coloring.
-func formatEdgeLabel(args []string) string {
-	if len(args) == 0 {
+// A non-zero line is prefixed as "L12", and a count above one is appended
+// as "×N". Returns "" if there is nothing to show, or `, label=<...>` with
+// per-arg coloring.
+func formatEdgeLabel(args []string, line, count int) string {
+	if len(args) == 0 && line == 0 && count <= 1 {
 		return ""
 	}
 	const (
@@ -97,7 +258,11 @@ func formatEdgeLabel(args []string) string {
 	)
 	var b strings.Builder
 	b.WriteString(`, label=<`)
-	fmt.Fprintf(&b, `<font face="Helvetica Neue,Helvetica" point-size="7" color="%s"> (`, teal)
+	fmt.Fprintf(&b, `<font face="Helvetica Neue,Helvetica" point-size="7" color="%s"> `, teal)
+	if line > 0 {
+		fmt.Fprintf(&b, "L%d ", line)
+	}
+	b.WriteString("(")
 	for i, arg := range args {
 		if i > 0 {
 			b.WriteString(", ")
@@ -110,6 +275,10 @@ func formatEdgeLabel(args []string) string {
 			b.WriteString(dotEscape(arg))
 		}
 	}
-	b.WriteString(")</font>>")
+	b.WriteString(")")
+	if count > 1 {
+		fmt.Fprintf(&b, " \u00d7%d", count)
+	}
+	b.WriteString("</font>>")
 	return b.String()
 }
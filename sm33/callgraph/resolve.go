@@ -0,0 +1,384 @@
+package callgraph
+
+import (
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+)
+
+// opcode constants for stores that can bind a function value.
+const (
+	opSetconst      = 14
+	opSetprop       = 54
+	opSetarg        = 85
+	opSetlocal      = 87
+	opInitprop      = 93
+	opSetname       = 111
+	opDeffun        = 127
+	opSetaliasedvar = 137
+	opSetgname      = 155
+)
+
+// bindKind distinguishes the binding spaces a function can be stored in.
+type bindKind uint8
+
+const (
+	bindArg bindKind = iota
+	bindLocal
+	bindAliased
+	bindName // global or dynamically scoped name
+)
+
+// bindKey identifies one binding. script is nil for names.
+type bindKey struct {
+	script *sm33.Script
+	kind   bindKind
+	slot   int
+	name   string
+}
+
+// resolver links callee expressions to the inner functions of one file.
+//
+// It records which function object flows into which argument, local,
+// aliased variable, global name or property, and which bindings hold
+// `this`. A binding assigned two different functions is ambiguous and
+// never resolves; anything it cannot prove stays external.
+//
+// Methods reached through `this` resolve against the class of the
+// function using it: the object literal it was defined in
+// (Foo = Base.extend({...})), the constructor whose prototype or `this`
+// it was stored on, then the base classes named in extend calls.
+type resolver struct {
+	names    *names.Names
+	parent   map[*sm33.Script]*sm33.Script
+	funcs    map[*sm33.Script]*sm33.Function
+	bindings map[bindKey]*sm33.Function // nil value: ambiguous
+	thisVars map[bindKey]*sm33.Script   // binding → script whose `this` it holds
+	props    map[string]*sm33.Function  // full path, e.g. "Foo.prototype.bar"
+	classes  map[*sm33.Function]*class  // method or constructor → its class
+	named    map[string]*class          // class by the path it is stored at
+	decoded  ir.Decoded                 // deobfuscated call results per script
+}
+
+// class is the set of members `this` can reach in one class's methods.
+type class struct {
+	members map[string]*sm33.Function // nil value: ambiguous
+	base    string                    // path of the extended class, if known
+}
+
+// maxBases bounds the extend chain member lookups follow.
+const maxBases = 16
+
+// newResolver scans every script reachable from root for function stores,
+// with the call results of dec taken as string literals.
+func newResolver(root *sm33.Script, dec ir.Decoded) *resolver {
+	r := &resolver{
+		decoded:  dec,
+		names:    names.Infer(root),
+		parent:   map[*sm33.Script]*sm33.Script{},
+		funcs:    map[*sm33.Script]*sm33.Function{},
+		bindings: map[bindKey]*sm33.Function{},
+		thisVars: map[bindKey]*sm33.Script{},
+		props:    map[string]*sm33.Function{},
+		classes:  map[*sm33.Function]*class{},
+		named:    map[string]*class{},
+	}
+	r.index(root)
+	r.collect(root)
+	return r
+}
+
+// index records parent links for the whole tree.
+func (r *resolver) index(s *sm33.Script) {
+	for _, obj := range s.Objects {
+		fn := obj.Function
+		if obj.Kind != sm33.CkJSFunction || fn == nil {
+			continue
+		}
+		if fn.Script != nil {
+			r.parent[fn.Script] = s
+			r.funcs[fn.Script] = fn
+			r.index(fn.Script)
+		}
+	}
+}
+
+// collect records the stores of s and its inner functions, outermost first
+// so inner scripts see bindings made by their parents.
+func (r *resolver) collect(s *sm33.Script) {
+	bc := s.Bytecode
+	objs := map[*ir.Expr]*class{} // object literals and extend results of s
+	var prev ir.Instr
+	have := false
+	ir.SimulateDecoded(s, r.decoded[s], func(in ir.Instr, st *ir.Stack) {
+		if have && st.Len() > 0 {
+			switch prev.Op {
+			case opNewinit, opNewobject, opObject:
+				objs[st.Peek(0)] = &class{members: map[string]*sm33.Function{}}
+			case opCall, opNew:
+				if c := st.Peek(0).Call; c != nil && c.Callee != nil && c.Callee.Kind == ir.Prop &&
+					c.Callee.Atom == "extend" && len(c.Args) > 0 && objs[c.Args[0]] != nil {
+					cls := objs[c.Args[0]]
+					cls.base = c.Callee.Obj.Path()
+					objs[st.Peek(0)] = cls
+				}
+			}
+		}
+		prev, have = in, true
+		off := in.Off
+		if in.Op == opDeffun {
+			if fn := r.object(s, int(objIndex(bc, off))); fn != nil && fn.Name != "" {
+				r.bind(bindKey{kind: bindName, name: fn.Name}, fn)
+			}
+			return
+		}
+		if st.Len() == 0 {
+			return
+		}
+		val := st.Peek(0)
+		var key bindKey
+		switch in.Op {
+		case opSetarg:
+			v, _ := bytecode.GetArgno(bc, off)
+			key = bindKey{script: s, kind: bindArg, slot: int(v)}
+		case opSetlocal:
+			v, _ := bytecode.GetLocalno(bc, off)
+			key = bindKey{script: s, kind: bindLocal, slot: int(v)}
+		case opSetaliasedvar:
+			if off+5 > len(bc) {
+				return
+			}
+			hops := int(bc[off+1])
+			slot := int(bc[off+2])<<16 | int(bc[off+3])<<8 | int(bc[off+4])
+			owner := r.scopeOwner(s, hops)
+			if owner == nil {
+				return
+			}
+			key = bindKey{script: owner, kind: bindAliased, slot: slot}
+		case opSetname, opSetgname, opSetconst:
+			name := ir.Atom(s, off)
+			if cls := objs[val]; cls != nil && name != "" {
+				r.nameClass(name, cls)
+			}
+			key = bindKey{kind: bindName, name: name}
+		case opSetprop:
+			if st.Len() < 2 {
+				return
+			}
+			obj, name := st.Peek(1), ir.Atom(s, off)
+			path := obj.Path()
+			if cls := objs[val]; cls != nil && path != "" {
+				if name == "prototype" {
+					r.nameClass(path, cls)
+				} else {
+					r.nameClass(path+"."+name, cls)
+				}
+			}
+			fn := r.resolve(s, val)
+			if fn == nil {
+				return
+			}
+			if owner := r.thisOwner(s, obj); owner != nil {
+				if cls := r.classOf(owner, true); cls != nil {
+					cls.add(name, fn)
+				}
+				return
+			}
+			if path == "" {
+				return
+			}
+			bindProp(r.props, path+"."+name, fn)
+			if base, ok := strings.CutSuffix(path, ".prototype"); ok {
+				cls := r.named[base]
+				if cls == nil {
+					cls = &class{members: map[string]*sm33.Function{}}
+					r.named[base] = cls
+				}
+				cls.add(name, fn)
+				r.setClass(fn, cls)
+			}
+			return
+		case opInitprop:
+			if st.Len() < 2 {
+				return
+			}
+			if fn := r.resolve(s, val); fn != nil {
+				if cls := objs[st.Peek(1)]; cls != nil {
+					cls.add(ir.Atom(s, off), fn)
+					r.setClass(fn, cls)
+				}
+			}
+			return
+		default:
+			return
+		}
+		if owner := r.thisOwner(s, val); owner != nil {
+			r.thisVars[key] = owner
+		} else {
+			r.bind(key, r.resolve(s, val))
+		}
+	})
+	for _, obj := range s.Objects {
+		if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+			r.collect(fn.Script)
+		}
+	}
+}
+
+// nameClass records cls as the class stored at path, merging it into a
+// class already known there (one built from Foo.prototype.x stores).
+func (r *resolver) nameClass(path string, cls *class) {
+	old := r.named[path]
+	if old == nil || old == cls {
+		r.named[path] = cls
+		return
+	}
+	for name, fn := range cls.members {
+		old.add(name, fn)
+	}
+	if old.base == "" {
+		old.base = cls.base
+	}
+}
+
+// setClass records the class of a method unless it already has one.
+func (r *resolver) setClass(fn *sm33.Function, cls *class) {
+	if _, ok := r.classes[fn]; !ok {
+		r.classes[fn] = cls
+	}
+}
+
+// classOf returns the class whose `this` the function script s sees: the
+// class it was defined in, else the class stored under its name. With
+// create, a constructor that has neither gets a fresh class.
+func (r *resolver) classOf(s *sm33.Script, create bool) *class {
+	fn := r.funcs[s]
+	if fn == nil {
+		return nil
+	}
+	if cls := r.classes[fn]; cls != nil {
+		return cls
+	}
+	if fn.Name != "" {
+		if cls := r.named[fn.Name]; cls != nil {
+			r.classes[fn] = cls
+			return cls
+		}
+	}
+	if !create {
+		return nil
+	}
+	cls := &class{members: map[string]*sm33.Function{}}
+	r.classes[fn] = cls
+	if fn.Name != "" {
+		r.named[fn.Name] = cls
+	}
+	return cls
+}
+
+// member looks name up in cls and then in its base classes.
+func (r *resolver) member(cls *class, name string) *sm33.Function {
+	for i := 0; cls != nil && i < maxBases; i++ {
+		if fn, ok := cls.members[name]; ok {
+			return fn
+		}
+		cls = r.named[cls.base]
+	}
+	return nil
+}
+
+func (c *class) add(name string, fn *sm33.Function) {
+	bindProp(c.members, name, fn)
+}
+
+// bind records a store. Storing a non-function or a second, different
+// function makes the binding ambiguous.
+func (r *resolver) bind(key bindKey, fn *sm33.Function) {
+	if old, ok := r.bindings[key]; fn == nil || ok && old != fn {
+		r.bindings[key] = nil
+		return
+	}
+	r.bindings[key] = fn
+}
+
+func bindProp(m map[string]*sm33.Function, key string, fn *sm33.Function) {
+	if old, ok := m[key]; ok && old != fn {
+		m[key] = nil
+		return
+	}
+	m[key] = fn
+}
+
+// resolve returns the inner function e evaluates to, or nil.
+func (r *resolver) resolve(s *sm33.Script, e *ir.Expr) *sm33.Function {
+	if r == nil || e == nil {
+		return nil
+	}
+	switch e.Kind {
+	case ir.Lambda:
+		return r.object(s, e.Func)
+	case ir.Arg:
+		return r.bindings[bindKey{script: s, kind: bindArg, slot: e.Slot}]
+	case ir.Local:
+		return r.bindings[bindKey{script: s, kind: bindLocal, slot: e.Slot}]
+	case ir.Aliased:
+		if owner := r.scopeOwner(s, e.Hops); owner != nil {
+			return r.bindings[bindKey{script: owner, kind: bindAliased, slot: e.Slot}]
+		}
+	case ir.Name:
+		return r.bindings[bindKey{kind: bindName, name: e.Atom}]
+	case ir.Prop:
+		if path := e.Path(); path != "" {
+			if fn, ok := r.props[path]; ok {
+				return fn
+			}
+		}
+		if owner := r.thisOwner(s, e.Obj); owner != nil {
+			return r.member(r.classOf(owner, false), e.Atom)
+		}
+	}
+	return nil
+}
+
+// thisOwner returns the script whose `this` e is or holds (var self =
+// this), or nil.
+func (r *resolver) thisOwner(s *sm33.Script, e *ir.Expr) *sm33.Script {
+	switch e.Kind {
+	case ir.This:
+		return s
+	case ir.Local:
+		return r.thisVars[bindKey{script: s, kind: bindLocal, slot: e.Slot}]
+	case ir.Aliased:
+		if owner := r.scopeOwner(s, e.Hops); owner != nil {
+			return r.thisVars[bindKey{script: owner, kind: bindAliased, slot: e.Slot}]
+		}
+	}
+	return nil
+}
+
+// scopeOwner returns the function script an aliased access from s with
+// the given hop count refers to, or nil.
+func (r *resolver) scopeOwner(s *sm33.Script, hops int) *sm33.Script {
+	return ir.ScopeOwner(s, hops, func(s *sm33.Script) *sm33.Script { return r.parent[s] })
+}
+
+// object returns the function in s.Objects[i], or nil.
+func (r *resolver) object(s *sm33.Script, i int) *sm33.Function {
+	if i < 0 || i >= len(s.Objects) || s.Objects[i].Kind != sm33.CkJSFunction {
+		return nil
+	}
+	return s.Objects[i].Function
+}
+
+// name returns the graph node name of a function.
+func (r *resolver) name(fn *sm33.Function) string {
+	return r.names.Of(fn)
+}
+
+func objIndex(bc []byte, off int) uint32 {
+	v, _ := bytecode.GetUint32Index(bc, off)
+	return v
+}
@@ -0,0 +1,116 @@
+package callgraph
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+func TestResolveLocalLambda(t *testing.T) {
+	// var f = function g() {}; f(); h();
+	inner := &sm33.Function{Name: "g", Script: &sm33.Script{Bytecode: []byte{opRetrval}}}
+	s := &sm33.Script{
+		Nvars:    1,
+		Bindings: []string{"f"},
+		Atoms:    []string{"h"},
+		Objects:  []*sm33.Object{{Kind: sm33.CkJSFunction, Function: inner}},
+		Bytecode: []byte{
+			130, 0, 0, 0, 0, // lambda 0
+			opSetlocal, 0, 0, 0, // setlocal 0
+			81,          // pop
+			86, 0, 0, 0, // getlocal 0
+			1,            // undefined
+			opCall, 0, 0, // call 0
+			81,
+			opName, 0, 0, 0, 0, // name "h"
+			1,
+			opCall, 0, 0,
+			81,
+		},
+	}
+	g := Build(s)
+	var callees []string
+	for _, e := range g.Edges {
+		if e.Kind == Calls {
+			callees = append(callees, e.Callee)
+		}
+	}
+	if len(callees) != 2 || callees[0] != "g" || callees[1] != "h" {
+		t.Fatalf("callees = %v, want [g h]", callees)
+	}
+
+	cfg := BuildCFG(s)
+	calls := cfg.Funcs[0].Blocks[0].Calls
+	if len(calls) != 2 {
+		t.Fatalf("got %d CFG calls, want 2", len(calls))
+	}
+	if calls[0].Func != 1 || cfg.Funcs[1].Name != "g" {
+		t.Errorf("call 0 linked to func %d, want 1 (g)", calls[0].Func)
+	}
+	if calls[1].Func != -1 {
+		t.Errorf("external call linked to func %d", calls[1].Func)
+	}
+}
+
+func TestResolveSample(t *testing.T) {
+	s, err := xdr.DecodeFile("../disasm/testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := Build(s)
+	edges := map[string]bool{}
+	for _, e := range g.Edges {
+		edges[e.Caller+" -> "+e.Callee] = true
+	}
+	for _, want := range []string{
+		"SplashScene<.checkCb -> SplashScene<.loadGame",                   // this.loadGame
+		"SplashScene<.ctor/cc.game.onPassCheck -> SplashScene<.checkGame", // self.checkGame via aliased var
+		"SplashScene<.ctor -> SplashScene<.ctor/cc.game.onPassCheck",      // cc.game.onPassCheck = function
+		"SplashScene<.ctor -> cc.Sprite",                                  // external stays external
+	} {
+		if !edges[want] {
+			t.Errorf("missing edge %q", want)
+		}
+	}
+}
+
+func TestResolveThisByClass(t *testing.T) {
+	// A = cc.Layer.extend({foo: ...}); B = cc.Layer.extend({bar: ...});
+	// C = A.extend({baz: ...}); bar and baz both call this.foo().
+	method := func(name string) *sm33.Object {
+		return &sm33.Object{Kind: sm33.CkJSFunction, Function: &sm33.Function{Name: name, Script: &sm33.Script{
+			Atoms:    []string{"foo"},
+			Bytecode: []byte{65, 12, opCallprop, 0, 0, 0, 0, 10, opCall, 0, 0, 81, opRetrval},
+		}}}
+	}
+	a := func(op byte, i byte) []byte { return []byte{op, 0, 0, 0, i} }
+	class := func(name byte, base []byte, member byte, fn byte) []byte {
+		return cat(a(214, name), base, []byte{12}, a(opCallprop, 2), []byte{10, opNewinit, 1, 0, 0, 0},
+			a(130, fn), a(opInitprop, member), []byte{92, opCall, 0, 1}, a(opSetgname, name), []byte{81})
+	}
+	layer := cat(a(opName, 0), a(opGetprop, 1))
+	s := &sm33.Script{
+		Atoms:   []string{"cc", "Layer", "extend", "A", "foo", "B", "bar", "C", "baz"},
+		Objects: []*sm33.Object{method("fooA"), method("barB"), method("bazC")},
+		Bytecode: cat(
+			class(3, layer, 4, 0),
+			class(5, layer, 6, 1),
+			class(7, a(opName, 3), 8, 2),
+			[]byte{opRetrval},
+		),
+	}
+	g := Build(s)
+	callee := map[string]string{}
+	for _, e := range g.Edges {
+		if e.Kind == Calls {
+			callee[e.Caller] = e.Callee
+		}
+	}
+	if c := callee["barB"]; c != "this.foo" {
+		t.Errorf("barB calls %q, want external this.foo", c)
+	}
+	if c := callee["bazC"]; c != "fooA" {
+		t.Errorf("bazC calls %q, want inherited fooA", c)
+	}
+}
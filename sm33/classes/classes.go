@@ -0,0 +1,380 @@
+// Package classes reconstructs Cocos2d-x class hierarchies from SM33
+// bytecode.
+//
+// Two definition styles are recognized:
+//
+//	var Foo = cc.Layer.extend({ ctor: function () { this._super(); ... }, ... })
+//	Foo.prototype.bar = function () {}  /  Foo.prototype = Object.create(Base.prototype)
+//
+// For each class the model records the base class, methods (with the
+// inner functions they define and whether they call this._super), and
+// fields: literal defaults from the extend object plus this.x stores in
+// the constructor.
+package classes
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// opcode constants for stores that name or populate a class.
+const (
+	opSetprop       = 54
+	opSetarg        = 85
+	opSetlocal      = 87
+	opInitprop      = 93
+	opSetname       = 111
+	opSetaliasedvar = 137
+	opSetgname      = 155
+)
+
+// Model is the set of classes found in one script tree.
+type Model struct {
+	Classes []*Class `json:"classes"`
+}
+
+// Class is one reconstructed class.
+type Class struct {
+	Name    string    `json:"name"`
+	Base    string    `json:"base,omitempty"` // base class path, e.g. "cc.Layer"
+	Style   string    `json:"style"`          // "extend" or "prototype"
+	Line    int       `json:"line,omitempty"` // source line of the definition
+	Fields  []*Field  `json:"fields,omitempty"`
+	Methods []*Method `json:"methods,omitempty"`
+}
+
+// Method is a function member of a class.
+type Method struct {
+	Name  string   `json:"name"`
+	Func  string   `json:"func"`            // display name of the function
+	Super bool     `json:"super,omitempty"` // calls this._super
+	Inner []string `json:"inner,omitempty"` // display names of nested functions
+}
+
+// Field is a data member of a class.
+type Field struct {
+	Name   string `json:"name"`
+	Init   string `json:"init,omitempty"` // initializer expression
+	Source string `json:"source"`         // "literal" (extend object), "prototype" or "ctor"
+}
+
+// JSON renders the model as indented JSON.
+func (m *Model) JSON() ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false) // keep display names like "Foo<.ctor" readable
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
+
+// Class returns the class with the given name, or nil.
+func (m *Model) Class(name string) *Class {
+	for _, c := range m.Classes {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Extract reconstructs the classes defined anywhere in the tree rooted at s.
+func Extract(s *sm33.Script) *Model {
+	x := &extractor{
+		names:  names.Infer(s),
+		byName: map[string]*Class{},
+		parent: map[*sm33.Script]*sm33.Script{},
+		funcs:  map[string]*sm33.Function{},
+	}
+	x.scan(s)
+	// Prototype-style constructors are the functions named after the class.
+	for _, c := range x.model.Classes {
+		if c.Style == "prototype" {
+			if fn := x.funcs[c.Name]; fn != nil && fn.Script != nil {
+				x.ctorFields(c, fn.Script)
+			}
+		}
+	}
+	return &x.model
+}
+
+// extractor carries the state of one Extract run.
+type extractor struct {
+	model  Model
+	names  *names.Names
+	byName map[string]*Class
+	parent map[*sm33.Script]*sm33.Script
+	funcs  map[string]*sm33.Function // display name → function
+}
+
+// entry is one initprop of an object literal.
+type entry struct {
+	key string
+	val *ir.Expr
+}
+
+func (x *extractor) scan(s *sm33.Script) {
+	for _, obj := range s.Objects {
+		if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil {
+			x.funcs[x.names.Of(fn)] = fn
+			if fn.Script != nil {
+				x.parent[fn.Script] = s
+			}
+		}
+	}
+
+	lits := map[*ir.Expr][]entry{}
+	named := map[*ir.Call]string{}
+	var lines *srcnote.Lines
+	line := func(off int) int {
+		if lines == nil {
+			lines = srcnote.NewLines(s)
+		}
+		return lines.Line(off)
+	}
+
+	calls := ir.Simulate(s, func(in ir.Instr, st *ir.Stack) {
+		if st.Len() == 0 {
+			return
+		}
+		val := st.Peek(0)
+		switch in.Op {
+		case opInitprop:
+			if st.Len() >= 2 {
+				obj := st.Peek(1)
+				lits[obj] = append(lits[obj], entry{ir.Atom(s, in.Off), val})
+			}
+			return
+		case opSetprop:
+			if st.Len() < 2 {
+				return
+			}
+			obj, prop := st.Peek(1).Path(), ir.Atom(s, in.Off)
+			if strings.HasSuffix(obj, ".prototype") {
+				// Foo.prototype.bar = ...
+				c := x.class(strings.TrimSuffix(obj, ".prototype"), "prototype", line(in.Off))
+				x.member(c, s, prop, val, "prototype")
+			} else if prop == "prototype" && obj != "" {
+				// Foo.prototype = Object.create(Base.prototype) / new Base() / {...}
+				c := x.class(obj, "prototype", line(in.Off))
+				if base := protoBase(val); base != "" && c.Base == "" {
+					c.Base = base
+				}
+				for _, e := range lits[val] {
+					x.member(c, s, e.key, e.val, "prototype")
+				}
+			}
+		}
+		if val.Kind == ir.CallResult && isExtend(val.Call) {
+			if name := x.storeTarget(s, in, st); name != "" {
+				if _, ok := named[val.Call]; !ok {
+					named[val.Call] = name
+				}
+			}
+		}
+	})
+
+	for _, c := range calls {
+		switch {
+		case isExtend(c):
+			name := named[c]
+			if name == "" {
+				name = x.ctorClassName(s, lits, c)
+			}
+			if name == "" {
+				continue
+			}
+			cls := x.class(name, "extend", line(c.Offset))
+			if cls.Base == "" {
+				cls.Base = c.Callee.Obj.Path()
+			}
+			if len(c.Args) > 0 {
+				for _, e := range lits[c.Args[0]] {
+					x.member(cls, s, e.key, e.val, "literal")
+				}
+			}
+		case isInherits(c):
+			// cc.inherits(Child, Base) / goog.inherits(Child, Base)
+			child, base := c.Args[0].Path(), c.Args[1].Path()
+			if child != "" && base != "" {
+				cls := x.class(child, "prototype", line(c.Offset))
+				if cls.Base == "" {
+					cls.Base = base
+				}
+			}
+		}
+	}
+
+	for _, obj := range s.Objects {
+		if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+			x.scan(fn.Script)
+		}
+	}
+}
+
+// class returns the class named name, creating it on first sight.
+func (x *extractor) class(name, style string, line int) *Class {
+	if c := x.byName[name]; c != nil {
+		return c
+	}
+	c := &Class{Name: name, Style: style, Line: line}
+	x.byName[name] = c
+	x.model.Classes = append(x.model.Classes, c)
+	return c
+}
+
+// member adds a literal entry or prototype store to c.
+func (x *extractor) member(c *Class, s *sm33.Script, key string, val *ir.Expr, source string) {
+	if val.Kind == ir.Lambda && val.Func >= 0 && val.Func < len(s.Objects) {
+		if fn := s.Objects[val.Func].Function; fn != nil {
+			x.method(c, key, fn)
+			return
+		}
+	}
+	addField(c, key, val, source)
+}
+
+func (x *extractor) method(c *Class, key string, fn *sm33.Function) {
+	for _, m := range c.Methods {
+		if m.Name == key {
+			return
+		}
+	}
+	m := &Method{Name: key, Func: x.names.Of(fn)}
+	if fn.Script != nil {
+		for _, call := range ir.Calls(fn.Script) {
+			if call.Callee.Kind == ir.Prop && call.Callee.Atom == "_super" && call.Callee.Obj.Kind == ir.This {
+				m.Super = true
+				break
+			}
+		}
+		m.Inner = x.inner(fn.Script, nil)
+		if key == "ctor" {
+			x.ctorFields(c, fn.Script)
+		}
+	}
+	c.Methods = append(c.Methods, m)
+}
+
+// inner lists the display names of every function nested in s.
+func (x *extractor) inner(s *sm33.Script, out []string) []string {
+	for _, obj := range s.Objects {
+		if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil {
+			out = append(out, x.names.Of(fn))
+			if fn.Script != nil {
+				out = x.inner(fn.Script, out)
+			}
+		}
+	}
+	return out
+}
+
+// ctorFields records this.x = ... stores in a constructor body.
+func (x *extractor) ctorFields(c *Class, s *sm33.Script) {
+	ir.Simulate(s, func(in ir.Instr, st *ir.Stack) {
+		if in.Op == opSetprop && st.Len() >= 2 && st.Peek(1).Kind == ir.This {
+			addField(c, ir.Atom(s, in.Off), st.Peek(0), "ctor")
+		}
+	})
+}
+
+func addField(c *Class, name string, val *ir.Expr, source string) {
+	for _, f := range c.Fields {
+		if f.Name == name {
+			return
+		}
+	}
+	init := val.String()
+	if len(init) > 48 {
+		init = init[:48] + "…"
+	}
+	c.Fields = append(c.Fields, &Field{Name: name, Init: init, Source: source})
+}
+
+// storeTarget names the binding the value on top of the stack is stored
+// into by instruction in, or "".
+func (x *extractor) storeTarget(s *sm33.Script, in ir.Instr, st *ir.Stack) string {
+	bc := s.Bytecode
+	switch in.Op {
+	case opSetname, opSetgname:
+		return ir.Atom(s, in.Off)
+	case opSetprop:
+		if obj := st.Peek(1).Path(); obj != "" {
+			return obj + "." + ir.Atom(s, in.Off)
+		}
+	case opSetlocal:
+		v, _ := bytecode.GetLocalno(bc, in.Off)
+		if slot := int(s.Nargs) + int(v); uint32(v) < s.Nvars && slot < len(s.Bindings) {
+			return s.Bindings[slot]
+		}
+	case opSetarg:
+		v, _ := bytecode.GetArgno(bc, in.Off)
+		if int(v) < len(s.Bindings) {
+			return s.Bindings[v]
+		}
+	case opSetaliasedvar:
+		if in.Off+5 <= len(bc) {
+			hops := int(bc[in.Off+1])
+			slot := int(bc[in.Off+2])<<16 | int(bc[in.Off+3])<<8 | int(bc[in.Off+4])
+			parent := func(s *sm33.Script) *sm33.Script { return x.parent[s] }
+			if owner := ir.ScopeOwner(s, hops, parent); owner != nil {
+				return ir.AliasedName(owner, slot)
+			}
+		}
+	}
+	return ""
+}
+
+// ctorClassName recovers the name of an unassigned extend call from the
+// display atom SpiderMonkey gave its methods ("Foo<.ctor" → "Foo").
+func (x *extractor) ctorClassName(s *sm33.Script, lits map[*ir.Expr][]entry, c *ir.Call) string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	for _, e := range lits[c.Args[0]] {
+		if e.val.Kind != ir.Lambda || e.val.Func < 0 || e.val.Func >= len(s.Objects) {
+			continue
+		}
+		if fn := s.Objects[e.val.Func].Function; fn != nil {
+			if i := strings.Index(fn.Name, "<."); i > 0 {
+				return fn.Name[:i]
+			}
+		}
+	}
+	return ""
+}
+
+// isExtend reports whether c is Base.extend({...}).
+func isExtend(c *ir.Call) bool {
+	return c != nil && c.Kind == ir.CallNormal && c.Callee.Kind == ir.Prop &&
+		c.Callee.Atom == "extend" && c.Callee.Obj.Path() != ""
+}
+
+// isInherits reports whether c is a two-argument X.inherits(Child, Base).
+func isInherits(c *ir.Call) bool {
+	return c.Kind == ir.CallNormal && c.Callee.Kind == ir.Prop && c.Callee.Atom == "inherits" && len(c.Args) == 2
+}
+
+// protoBase extracts the base class from a value assigned to Foo.prototype.
+func protoBase(e *ir.Expr) string {
+	if e.Kind != ir.CallResult || e.Call == nil {
+		return ""
+	}
+	c := e.Call
+	switch {
+	case c.Kind == ir.CallNew:
+		return c.Callee.Path()
+	case c.Callee.Path() == "Object.create" && len(c.Args) > 0:
+		return strings.TrimSuffix(c.Args[0].Path(), ".prototype")
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package classes
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+func TestExtractExtend(t *testing.T) {
+	s, err := xdr.DecodeFile("../disasm/testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Extract(s)
+	c := m.Class("SplashScene")
+	if c == nil {
+		t.Fatalf("SplashScene not found in %d classes", len(m.Classes))
+	}
+	if c.Base != "cc.Scene" || c.Style != "extend" {
+		t.Errorf("SplashScene base=%q style=%q, want cc.Scene extend", c.Base, c.Style)
+	}
+	var ctor *Method
+	for _, meth := range c.Methods {
+		if meth.Name == "ctor" {
+			ctor = meth
+		}
+	}
+	if ctor == nil || !ctor.Super {
+		t.Fatalf("ctor = %+v, want a method calling _super", ctor)
+	}
+	found := false
+	for _, in := range ctor.Inner {
+		found = found || in == "SplashScene<.ctor/cc.game.onPassCheck"
+	}
+	if !found {
+		t.Errorf("ctor inner = %v, want cc.game.onPassCheck callback", ctor.Inner)
+	}
+	fields := map[string]string{}
+	for _, f := range c.Fields {
+		fields[f.Name] = f.Init
+	}
+	if fields["count"] != "0" {
+		t.Errorf("field count init = %q, want 0", fields["count"])
+	}
+}
+
+func TestExtractPrototype(t *testing.T) {
+	// Foo.prototype = Object.create(Base.prototype);
+	// Foo.prototype.bar = function () {};
+	s := &sm33.Script{
+		Atoms: []string{"Foo", "Object", "create", "Base", "prototype", "bar"},
+		Objects: []*sm33.Object{
+			{Kind: sm33.CkJSFunction, Function: &sm33.Function{Script: &sm33.Script{}}},
+		},
+		Bytecode: Asm(
+			AtomOp(OpName, 0),
+			AtomOp(OpName, 1), []byte{OpDup}, AtomOp(OpCallprop, 2), []byte{OpSwap},
+			AtomOp(OpName, 3), AtomOp(OpGetprop, 4),
+			[]byte{OpCall, 0, 1},
+			AtomOp(OpSetprop, 4), []byte{OpPop},
+			AtomOp(OpName, 0), AtomOp(OpGetprop, 4), AtomOp(OpLambda, 0), AtomOp(OpSetprop, 5), []byte{OpPop},
+		),
+	}
+	m := Extract(s)
+	c := m.Class("Foo")
+	if c == nil {
+		t.Fatalf("Foo not found in %d classes", len(m.Classes))
+	}
+	if c.Base != "Base" || c.Style != "prototype" {
+		t.Errorf("Foo base=%q style=%q, want Base prototype", c.Base, c.Style)
+	}
+	if len(c.Methods) != 1 || c.Methods[0].Name != "bar" {
+		t.Errorf("Foo methods = %+v, want [bar]", c.Methods)
+	}
+}
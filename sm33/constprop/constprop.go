@@ -0,0 +1,662 @@
+// Package constprop folds constant values through the stack IR.
+//
+// Obfuscated scripts assemble keys, URLs and method names at run time:
+//
+//	var k = "ht" + "tp";                    → "http"
+//	u = k + "://" + String.fromCharCode(97)  → "http://a"
+//	obj["get" + "Item"](...)                 → obj.getItem(...)
+//
+// Analyze tracks which argument and local slots hold a known literal at
+// each instruction (a must-analysis over the instruction graph: a slot is
+// constant only if every path stores the same literal) and Fold evaluates
+// expressions over those literals, through arithmetic, string
+// concatenation, string indexing and a small set of side-effect-free
+// helpers such as String.fromCharCode and "s".charAt(i).
+package constprop
+
+import (
+	"encoding/base64"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+// Opcodes the analysis interprets.
+const (
+	opGoto        = 6
+	opBitor       = 15
+	opBitxor      = 16
+	opBitand      = 17
+	opLsh         = 24
+	opRsh         = 25
+	opUrsh        = 26
+	opAdd         = 27
+	opSub         = 28
+	opMul         = 29
+	opDiv         = 30
+	opMod         = 31
+	opNeg         = 34
+	opPos         = 35
+	opGetprop     = 53
+	opDouble      = 60
+	opString      = 61
+	opZero        = 62
+	opOne         = 63
+	opNull        = 64
+	opFalse       = 66
+	opTrue        = 67
+	opUint16      = 88
+	opUint24      = 188
+	opInt8        = 215
+	opInt32       = 216
+	opGetelem     = 55
+	opCall        = 58
+	opTableswitch = 70
+	opDefault     = 122
+	opGetarg      = 84
+	opSetarg      = 85
+	opGetlocal    = 86
+	opSetlocal    = 87
+	opThrow       = 112
+	opRetsub      = 117
+	opReturn      = 5
+	opRetrval     = 153
+	opLength      = 217
+)
+
+// maxString caps folded strings so hostile input cannot blow up memory.
+const maxString = 1 << 16
+
+// maxDepth bounds Fold recursion.
+const maxDepth = 32
+
+// slot identifies an argument or local.
+type slot struct {
+	local bool
+	n     int
+}
+
+// env maps slots to the literal they hold; absent slots are not constant.
+type env map[slot]*ir.Expr
+
+// Values holds the constants known in one script.
+type Values struct {
+	s       *sm33.Script
+	index   map[int]int // instruction offset → index in envs
+	envs    []env       // slot constants on entry to each instruction; nil if unreached
+	results map[int]*ir.Expr
+	calls   []*ir.Call
+}
+
+// Analyze computes the slot constants of s and the value each
+// instruction produces.
+func Analyze(s *sm33.Script) *Values {
+	return AnalyzeDecoded(s, nil)
+}
+
+// AnalyzeDecoded is Analyze with the decoded call results of s (see
+// ir.SimulateDecoded).
+func AnalyzeDecoded(s *sm33.Script, dec map[int]string) *Values {
+	v := &Values{s: s, index: map[int]int{}, results: map[int]*ir.Expr{}}
+	bc := s.Bytecode
+	instrs := ir.Decode(bc)
+	for i, in := range instrs {
+		v.index[in.Off] = i
+	}
+
+	// Stored values and instruction results from the stack model.
+	stored := map[int]*ir.Expr{}
+	var prev *ir.Instr
+	v.calls = ir.SimulateDecoded(s, dec, func(in ir.Instr, st *ir.Stack) {
+		if prev != nil && prev.Next() == in.Off && st.Len() > 0 && produces(prev.Op) {
+			v.results[prev.Off] = st.Peek(0)
+		}
+		if (in.Op == opSetarg || in.Op == opSetlocal) && st.Len() > 0 {
+			stored[in.Off] = st.Peek(0)
+		}
+		p := in
+		prev = &p
+	})
+
+	// Predecessors in the instruction graph.
+	preds := make([][]int, len(instrs))
+	for i, in := range instrs {
+		if fallsThrough(in.Op) && i+1 < len(instrs) {
+			preds[i+1] = append(preds[i+1], i)
+		}
+		for _, t := range ir.JumpTargets(bc, in.Off) {
+			if j, ok := v.index[t]; ok {
+				preds[j] = append(preds[j], i)
+			}
+		}
+	}
+
+	// Round-robin to a fixed point. Environments only lose entries, so
+	// this terminates; the step cap guards against pathological input.
+	v.envs = make([]env, len(instrs))
+	out := make([]env, len(instrs))
+	maxRounds := 64
+	for changed, round := true, 0; changed && round < maxRounds; round++ {
+		changed = false
+		for i, in := range instrs {
+			var cur env
+			if i == 0 {
+				cur = env{}
+			} else {
+				for _, p := range preds[i] {
+					cur = meet(cur, out[p])
+				}
+			}
+			v.envs[i] = cur
+			next := cur
+			if cur != nil && (in.Op == opSetarg || in.Op == opSetlocal) {
+				next = cur.clone()
+				k := storeSlot(bc, in.Off)
+				if c := v.Fold(stored[in.Off]); isLit(c) {
+					next[k] = c
+				} else {
+					delete(next, k)
+				}
+			}
+			if !equalEnv(next, out[i]) {
+				out[i] = next
+				changed = true
+			}
+		}
+	}
+	return v
+}
+
+// Calls returns the call sites found by the stack model.
+func (v *Values) Calls() []*ir.Call { return v.calls }
+
+// At returns the literal produced by the instruction at off, pushed
+// directly or computed (a concatenation, call, slot load or index), or
+// nil.
+func (v *Values) At(off int) *ir.Expr {
+	if c := v.Fold(v.results[off]); isLit(c) {
+		return c
+	}
+	return nil
+}
+
+// Computed is At for instructions that compute their value: it is nil
+// for direct literal pushes, whose operand already shows the value.
+func (v *Values) Computed(off int) *ir.Expr {
+	if e := v.results[off]; e == nil || e.Kind == ir.Lit {
+		return nil
+	}
+	return v.At(off)
+}
+
+// Fold evaluates e over the known constants. It returns a literal when e
+// is constant, e with folded operands where parts of it are (a computed
+// property name becomes a plain property), and e itself otherwise.
+func (v *Values) Fold(e *ir.Expr) *ir.Expr {
+	return v.fold(e, 0)
+}
+
+// Target returns the folded callee path of c, e.g. "obj.getItem" for
+// obj["get" + "Item"](...).
+func (v *Values) Target(c *ir.Call) string {
+	return v.Fold(c.Callee).String()
+}
+
+// FoldAll folds each expression of es.
+func (v *Values) FoldAll(es []*ir.Expr) []*ir.Expr {
+	out := make([]*ir.Expr, len(es))
+	for i, e := range es {
+		out[i] = v.Fold(e)
+	}
+	return out
+}
+
+func (v *Values) fold(e *ir.Expr, depth int) *ir.Expr {
+	if e == nil || depth > maxDepth {
+		return e
+	}
+	switch e.Kind {
+	case ir.Arg, ir.Local:
+		if i, ok := v.index[e.Off]; ok && v.envs[i] != nil {
+			if c := v.envs[i][slot{local: e.Kind == ir.Local, n: e.Slot}]; c != nil {
+				return c
+			}
+		}
+	case ir.Op:
+		args := make([]*ir.Expr, len(e.Args))
+		for i, a := range e.Args {
+			args[i] = v.fold(a, depth+1)
+		}
+		if c := evalOp(e.Op, args); c != nil {
+			return c
+		}
+		return &ir.Expr{Kind: ir.Op, Op: e.Op, Args: args}
+	case ir.Prop:
+		obj := v.fold(e.Obj, depth+1)
+		if e.Atom == "length" && obj.IsLit(ir.LitString) {
+			return num(float64(len(utf16.Encode([]rune(obj.Str)))))
+		}
+		if obj != e.Obj {
+			return &ir.Expr{Kind: ir.Prop, Obj: obj, Atom: e.Atom}
+		}
+	case ir.Elem:
+		obj, idx := v.fold(e.Obj, depth+1), v.fold(e.Index, depth+1)
+		if obj.IsLit(ir.LitString) && idx.IsLit(ir.LitNumber) {
+			if c := charAt(obj.Str, idx.Num); c != nil {
+				return c
+			}
+		}
+		if idx.IsLit(ir.LitString) && isIdent(idx.Str) {
+			return &ir.Expr{Kind: ir.Prop, Obj: obj, Atom: idx.Str}
+		}
+		if obj != e.Obj || idx != e.Index {
+			return &ir.Expr{Kind: ir.Elem, Obj: obj, Index: idx}
+		}
+	case ir.CallResult:
+		if e.Call == nil || e.Call.Kind != ir.CallNormal {
+			return e
+		}
+		args := make([]*ir.Expr, len(e.Call.Args))
+		for i, a := range e.Call.Args {
+			args[i] = v.fold(a, depth+1)
+		}
+		if c := v.evalCall(e.Call.Callee, args, depth); c != nil {
+			return c
+		}
+	}
+	return e
+}
+
+// evalCall evaluates side-effect-free helpers over literal arguments.
+func (v *Values) evalCall(callee *ir.Expr, args []*ir.Expr, depth int) *ir.Expr {
+	if callee == nil {
+		return nil
+	}
+	switch callee.Kind {
+	case ir.Name:
+		switch callee.Atom {
+		case "String":
+			if len(args) == 1 && isLit(args[0]) {
+				return str(toString(args[0]))
+			}
+		case "parseInt":
+			if len(args) >= 1 && isLit(args[0]) {
+				radix := 10
+				if len(args) > 1 && args[1].IsLit(ir.LitNumber) {
+					radix = int(args[1].Num)
+				}
+				if n, err := strconv.ParseInt(strings.TrimSpace(toString(args[0])), radix, 64); err == nil {
+					return num(float64(n))
+				}
+			}
+		case "atob":
+			if len(args) == 1 && args[0].IsLit(ir.LitString) {
+				if b, err := base64.StdEncoding.DecodeString(args[0].Str); err == nil {
+					return latin1(b)
+				}
+			}
+		}
+	case ir.Prop:
+		if callee.Atom == "fromCharCode" && callee.Obj != nil && callee.Obj.Kind == ir.Name && callee.Obj.Atom == "String" {
+			units := make([]uint16, len(args))
+			for i, a := range args {
+				if !a.IsLit(ir.LitNumber) {
+					return nil
+				}
+				units[i] = uint16(toInt32(a.Num))
+			}
+			return str(string(utf16.Decode(units)))
+		}
+		recv := v.fold(callee.Obj, depth+1)
+		if !recv.IsLit(ir.LitString) {
+			return nil
+		}
+		return stringMethod(recv.Str, callee.Atom, args)
+	}
+	return nil
+}
+
+// stringMethod evaluates a String.prototype method on a literal receiver.
+func stringMethod(s, method string, args []*ir.Expr) *ir.Expr {
+	u := utf16.Encode([]rune(s))
+	numArg := func(i int, def float64) (float64, bool) {
+		if i >= len(args) {
+			return def, true
+		}
+		if args[i].IsLit(ir.LitNumber) {
+			return args[i].Num, true
+		}
+		return 0, false
+	}
+	switch method {
+	case "toString", "valueOf":
+		if len(args) == 0 {
+			return str(s)
+		}
+	case "toUpperCase":
+		return str(strings.ToUpper(s))
+	case "toLowerCase":
+		return str(strings.ToLower(s))
+	case "trim":
+		return str(strings.TrimSpace(s))
+	case "concat":
+		var b strings.Builder
+		b.WriteString(s)
+		for _, a := range args {
+			if !isLit(a) {
+				return nil
+			}
+			b.WriteString(toString(a))
+		}
+		return str(b.String())
+	case "charAt":
+		if i, ok := numArg(0, 0); ok {
+			return charAt(s, i)
+		}
+	case "charCodeAt":
+		if i, ok := numArg(0, 0); ok {
+			if i, ok := unitIndex(i, len(u)); ok {
+				return num(float64(u[i]))
+			}
+		}
+	case "slice", "substring", "substr":
+		a, ok1 := numArg(0, 0)
+		b, ok2 := numArg(1, float64(len(u)))
+		if !ok1 || !ok2 {
+			return nil
+		}
+		n := len(u)
+		start, end := bound(a, n), bound(b, n)
+		switch method {
+		case "slice":
+			start, end = relIndex(start, n), relIndex(end, n)
+		case "substring":
+			start, end = clamp(start, n), clamp(end, n)
+			if start > end {
+				start, end = end, start
+			}
+		case "substr":
+			start = relIndex(start, n)
+			end = clamp(start+clamp(end, n), n)
+		}
+		if start >= end {
+			return str("")
+		}
+		return str(string(utf16.Decode(u[start:end])))
+	}
+	return nil
+}
+
+// evalOp folds an operator over literal operands, or returns nil.
+func evalOp(op uint8, args []*ir.Expr) *ir.Expr {
+	for _, a := range args {
+		if !isLit(a) {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		switch op {
+		case opNeg:
+			return num(-toNumber(args[0]))
+		case opPos:
+			return num(toNumber(args[0]))
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return nil
+	}
+	a, b := args[0], args[1]
+	switch op {
+	case opAdd:
+		if a.IsLit(ir.LitString) || b.IsLit(ir.LitString) {
+			s := toString(a) + toString(b)
+			if len(s) > maxString {
+				return nil
+			}
+			return str(s)
+		}
+		return num(toNumber(a) + toNumber(b))
+	case opSub:
+		return num(toNumber(a) - toNumber(b))
+	case opMul:
+		return num(toNumber(a) * toNumber(b))
+	case opDiv:
+		return num(toNumber(a) / toNumber(b))
+	case opMod:
+		return num(math.Mod(toNumber(a), toNumber(b)))
+	case opBitor:
+		return num(float64(toInt32(toNumber(a)) | toInt32(toNumber(b))))
+	case opBitxor:
+		return num(float64(toInt32(toNumber(a)) ^ toInt32(toNumber(b))))
+	case opBitand:
+		return num(float64(toInt32(toNumber(a)) & toInt32(toNumber(b))))
+	case opLsh:
+		return num(float64(toInt32(toNumber(a)) << (uint32(toInt32(toNumber(b))) & 31)))
+	case opRsh:
+		return num(float64(toInt32(toNumber(a)) >> (uint32(toInt32(toNumber(b))) & 31)))
+	case opUrsh:
+		return num(float64(uint32(toInt32(toNumber(a))) >> (uint32(toInt32(toNumber(b))) & 31)))
+	}
+	return nil
+}
+
+// produces reports whether the result of op is worth folding.
+func produces(op uint8) bool {
+	switch op {
+	case opAdd, opCall, opGetarg, opGetlocal, opGetelem, opGetprop, opLength,
+		opString, opDouble, opInt8, opInt32, opUint16, opUint24,
+		opZero, opOne, opNull, opTrue, opFalse:
+		return true
+	}
+	return false
+}
+
+// fallsThrough reports whether control can continue past op.
+func fallsThrough(op uint8) bool {
+	switch op {
+	case opGoto, opDefault, opTableswitch, opRetsub, opReturn, opRetrval, opThrow:
+		return false
+	}
+	return true
+}
+
+func storeSlot(bc []byte, off int) slot {
+	if bc[off] == opSetarg {
+		n, _ := bytecode.GetArgno(bc, off)
+		return slot{n: int(n)}
+	}
+	n, _ := bytecode.GetLocalno(bc, off)
+	return slot{local: true, n: int(n)}
+}
+
+// meet intersects two environments; nil (unreached) is the identity.
+func meet(a, b env) env {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	out := env{}
+	for k, x := range a {
+		if y, ok := b[k]; ok && sameLit(x, y) {
+			out[k] = x
+		}
+	}
+	return out
+}
+
+func (e env) clone() env {
+	out := make(env, len(e))
+	for k, v := range e {
+		out[k] = v
+	}
+	return out
+}
+
+func equalEnv(a, b env) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for k, x := range a {
+		if y, ok := b[k]; !ok || !sameLit(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameLit(a, b *ir.Expr) bool {
+	return a.LitKind == b.LitKind && a.String() == b.String()
+}
+
+func isLit(e *ir.Expr) bool {
+	return e != nil && e.Kind == ir.Lit
+}
+
+func str(s string) *ir.Expr {
+	return &ir.Expr{Kind: ir.Lit, LitKind: ir.LitString, Str: s}
+}
+
+func num(f float64) *ir.Expr {
+	return &ir.Expr{Kind: ir.Lit, LitKind: ir.LitNumber, Num: f}
+}
+
+// latin1 maps each byte to the code point of the same value, as atob does.
+func latin1(b []byte) *ir.Expr {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return str(string(r))
+}
+
+// charAt returns the UTF-16 unit of s at i, or nil when i is not an
+// integer index into s: "abc"[5] is undefined, not "".
+func charAt(s string, i float64) *ir.Expr {
+	u := utf16.Encode([]rune(s))
+	n, ok := unitIndex(i, len(u))
+	if !ok {
+		return nil
+	}
+	return str(string(utf16.Decode(u[n : n+1])))
+}
+
+// unitIndex converts i to an index into n units, when it is a finite
+// integer in range.
+func unitIndex(i float64, n int) (int, bool) {
+	if i != math.Trunc(i) || i < 0 || i >= float64(n) {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// bound converts a slice argument to an int in [-n, n] without
+// overflowing: NaN is 0 and larger magnitudes clamp.
+func bound(f float64, n int) int {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f > float64(n):
+		return n
+	case f < -float64(n):
+		return -n
+	}
+	return int(f)
+}
+
+// toString implements ToString for literals.
+func toString(e *ir.Expr) string {
+	switch e.LitKind {
+	case ir.LitString:
+		return e.Str
+	case ir.LitNumber:
+		return numberString(e.Num)
+	}
+	return e.String()
+}
+
+// numberString renders a number the way JavaScript's ToString does for
+// the common cases: integers without exponent below 1e21.
+func numberString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == math.Trunc(f) && math.Abs(f) < 1e21:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// toNumber implements ToNumber for literals.
+func toNumber(e *ir.Expr) float64 {
+	switch e.LitKind {
+	case ir.LitNumber:
+		return e.Num
+	case ir.LitBool:
+		if e.Bool {
+			return 1
+		}
+		return 0
+	case ir.LitNull:
+		return 0
+	case ir.LitString:
+		t := strings.TrimSpace(e.Str)
+		if t == "" {
+			return 0
+		}
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+// toInt32 implements ToInt32.
+func toInt32(f float64) int32 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return int32(uint32(int64(math.Trunc(math.Mod(f, 1<<32)))))
+}
+
+func relIndex(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	return clamp(i, n)
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// isIdent reports whether s can be written as a dotted property name.
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || r == '$' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
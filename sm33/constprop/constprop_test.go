@@ -0,0 +1,122 @@
+package constprop
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+func TestFoldConcat(t *testing.T) {
+	// var k = "ht" + "tp"; f(k + "://");
+	s := &sm33.Script{
+		Atoms: []string{"ht", "tp", "://", "f"},
+		Bytecode: Asm(
+			AtomOp(OpString, 0), AtomOp(OpString, 1), []byte{OpAdd}, // 0, 5, 10
+			LocalOp(OpSetlocal, 0), []byte{OpPop}, // 11, 15
+			AtomOp(OpName, 3), []byte{OpUndefined}, // 16, 21
+			LocalOp(OpGetlocal, 0), AtomOp(OpString, 2), []byte{OpAdd}, // 22, 26, 31
+			ArgcOp(OpCall, 1), []byte{OpPop}, // 32, 35
+			[]byte{OpRetrval},
+		),
+	}
+	v := Analyze(s)
+	if c := v.At(10); c == nil || c.Str != "http" {
+		t.Errorf("add @10 = %v, want \"http\"", c)
+	}
+	if c := v.At(22); c == nil || c.Str != "http" {
+		t.Errorf("getlocal @22 = %v, want \"http\"", c)
+	}
+	if c := v.At(0); c == nil || c.Str != "ht" {
+		t.Errorf("string @0 = %v, want \"ht\"", c)
+	}
+	if c := v.Computed(0); c != nil {
+		t.Errorf("computed string @0 = %v, want nil", c)
+	}
+	calls := v.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	if got := v.Fold(calls[0].Args[0]); got.Str != "http://" {
+		t.Errorf("arg = %s, want \"http://\"", got)
+	}
+}
+
+func TestMergeUnknown(t *testing.T) {
+	// var k = "a"; if (true) k = "b"; k;
+	s := &sm33.Script{
+		Atoms: []string{"a", "b"},
+		Bytecode: Asm(
+			AtomOp(OpString, 0), LocalOp(OpSetlocal, 0), []byte{OpPop}, // 0, 5, 9
+			[]byte{OpTrue}, []byte{OpIfeq, 0, 0, 0, 15}, // 10, 11
+			AtomOp(OpString, 1), LocalOp(OpSetlocal, 0), []byte{OpPop}, // 16, 21, 25
+			LocalOp(OpGetlocal, 0), []byte{OpPop}, // 26, 30
+			[]byte{OpRetrval},
+		),
+	}
+	v := Analyze(s)
+	if c := v.At(26); c != nil {
+		t.Errorf("getlocal @26 = %s, want unknown", c)
+	}
+}
+
+func TestFoldCalls(t *testing.T) {
+	// String.fromCharCode(104, 105); obj["get" + "Item"]();
+	s := &sm33.Script{
+		Atoms: []string{"String", "fromCharCode", "obj", "get", "Item"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap}, // 0, 5, 6, 11
+			[]byte{OpInt8, 104, OpInt8, 105}, ArgcOp(OpCall, 2), []byte{OpPop}, // 12, 16, 19
+			AtomOp(OpName, 2), []byte{OpDup}, AtomOp(OpString, 3), AtomOp(OpString, 4), []byte{OpAdd},
+			[]byte{OpCallelem, OpSwap}, ArgcOp(OpCall, 0), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+	v := Analyze(s)
+	if c := v.At(16); c == nil || c.Str != "hi" {
+		t.Errorf("call @16 = %v, want \"hi\"", c)
+	}
+	calls := v.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if got := v.Target(calls[1]); got != "obj.getItem" {
+		t.Errorf("target = %q, want obj.getItem", got)
+	}
+}
+
+func TestFoldIndex(t *testing.T) {
+	v := Analyze(&sm33.Script{Bytecode: []byte{OpRetrval}})
+	lit := func(s string) *ir.Expr { return &ir.Expr{Kind: ir.Lit, LitKind: ir.LitString, Str: s} }
+	n := func(f float64) *ir.Expr { return &ir.Expr{Kind: ir.Lit, LitKind: ir.LitNumber, Num: f} }
+	method := func(name string, args ...*ir.Expr) *ir.Expr {
+		callee := &ir.Expr{Kind: ir.Prop, Obj: lit("abc"), Atom: name}
+		return &ir.Expr{Kind: ir.CallResult, Call: &ir.Call{Kind: ir.CallNormal, Callee: callee, Args: args}}
+	}
+	for _, tc := range []struct {
+		e    *ir.Expr
+		want string // "" when the expression must not fold to a string
+	}{
+		{&ir.Expr{Kind: ir.Elem, Obj: lit("abc"), Index: n(1)}, "b"},
+		{&ir.Expr{Kind: ir.Elem, Obj: lit("abc"), Index: n(5)}, ""},
+		{&ir.Expr{Kind: ir.Elem, Obj: lit("abc"), Index: n(math.NaN())}, ""},
+		{&ir.Expr{Kind: ir.Elem, Obj: lit("abc"), Index: n(1.5)}, ""},
+		{method("charAt", n(2)), "c"},
+		{method("charAt", n(math.Inf(1))), ""},
+		{method("charAt", n(math.NaN())), ""},
+		{method("charCodeAt", n(math.Inf(1))), ""},
+		{method("substr", n(1), n(math.Inf(1))), "bc"},
+		{method("slice", n(math.Inf(-1))), "abc"},
+	} {
+		got := v.Fold(tc.e)
+		if tc.want == "" {
+			if got.IsLit(ir.LitString) || got.IsLit(ir.LitNumber) {
+				t.Errorf("%v folded to %v", tc.e, got)
+			}
+		} else if !got.IsLit(ir.LitString) || got.Str != tc.want {
+			t.Errorf("%v = %v, want %q", tc.e, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package dataflow
+
+import "math/bits"
+
+// bitset is a fixed-size set of small integers.
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (b bitset) set(i int)      { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int)    { b[i/64] &^= 1 << (i % 64) }
+func (b bitset) has(i int) bool { return b[i/64]&(1<<(i%64)) != 0 }
+
+func (b bitset) reset() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func (b bitset) copy() bitset { return append(bitset(nil), b...) }
+
+func (b bitset) union(o bitset) {
+	for i := range b {
+		b[i] |= o[i]
+	}
+}
+
+func (b bitset) subtract(o bitset) {
+	for i := range b {
+		b[i] &^= o[i]
+	}
+}
+
+func (b bitset) equal(o bitset) bool {
+	for i := range b {
+		if b[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// vars lists the members of b as variables.
+func (b bitset) vars(all []Var) []Var {
+	var out []Var
+	for w, word := range b {
+		for word != 0 {
+			i := w*64 + bits.TrailingZeros64(word)
+			out = append(out, all[i])
+			word &= word - 1
+		}
+	}
+	return out
+}
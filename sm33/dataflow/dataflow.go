@@ -0,0 +1,335 @@
+// Package dataflow computes reaching definitions, def-use chains and
+// liveness for the variable slots of one function: arguments
+// (getarg/setarg), frame locals (getlocal/setlocal) and call-object slots
+// (getaliasedvar/setaliasedvar), over the function's FuncCFG.
+//
+// Every variable the function touches also has an implicit entry
+// definition (offset -1): the parameter value for arguments, undefined for
+// locals, and whatever the scope holds for aliased slots.
+//
+// Aliased slots live in a call object that inner functions share, so any
+// call may read or write them; the analysis only sees this function's own
+// accesses and never reports their stores as dead.
+package dataflow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+)
+
+// Slot access opcodes.
+const (
+	opGetarg        = 84
+	opSetarg        = 85
+	opGetlocal      = 86
+	opSetlocal      = 87
+	opGetaliasedvar = 136
+	opSetaliasedvar = 137
+)
+
+// Entry is the offset of the implicit definition at function entry.
+const Entry = -1
+
+// VarKind is the storage class of a variable slot.
+type VarKind int
+
+const (
+	Arg     VarKind = iota // formal argument
+	Local                  // frame local
+	Aliased                // call-object slot, addressed by hops and slot
+)
+
+// Var identifies one variable slot.
+type Var struct {
+	Kind VarKind
+	Slot int
+	Hops int // scope hops, Aliased only
+}
+
+// String renders v as a1 (argument), v3 (local) or c4 (aliased slot;
+// c4^1 one scope out).
+func (v Var) String() string {
+	switch v.Kind {
+	case Arg:
+		return fmt.Sprintf("a%d", v.Slot)
+	case Local:
+		return fmt.Sprintf("v%d", v.Slot)
+	}
+	if v.Hops > 0 {
+		return fmt.Sprintf("c%d^%d", v.Slot, v.Hops)
+	}
+	return fmt.Sprintf("c%d", v.Slot)
+}
+
+// Def is one definition of a variable, with the uses it reaches.
+type Def struct {
+	Off  int // instruction offset; Entry for the implicit entry definition
+	Var  Var
+	Uses []int // offsets of the uses it reaches, sorted
+}
+
+// Use is one read of a variable, with the definitions that reach it.
+type Use struct {
+	Off  int
+	Var  Var
+	Defs []int // offsets of the reaching definitions, sorted; may include Entry
+}
+
+// Result holds the dataflow facts of one function.
+type Result struct {
+	Defs []*Def // in offset order, entry definitions first
+	Uses []*Use // in offset order
+
+	defAt   map[int]*Def
+	useAt   map[int]*Use
+	liveIn  [][]Var
+	liveOut [][]Var
+}
+
+// DefAt returns the definition at offset off, or nil.
+func (r *Result) DefAt(off int) *Def { return r.defAt[off] }
+
+// UseAt returns the use at offset off, or nil.
+func (r *Result) UseAt(off int) *Use { return r.useAt[off] }
+
+// LiveIn returns the variables live on entry to block id.
+func (r *Result) LiveIn(id int) []Var { return r.liveIn[id] }
+
+// LiveOut returns the variables live on exit from block id.
+func (r *Result) LiveOut(id int) []Var { return r.liveOut[id] }
+
+// Dead returns the stores to arguments and locals that no use reaches.
+func (r *Result) Dead() []*Def {
+	var out []*Def
+	for _, d := range r.Defs {
+		if d.Off != Entry && d.Var.Kind != Aliased && len(d.Uses) == 0 {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// access is one slot read or write.
+type access struct {
+	off int
+	v   int // index into vars
+	def bool
+}
+
+// Analyze computes the dataflow facts of s over its control flow graph f,
+// as built by callgraph.BuildFuncCFG.
+func Analyze(s *sm33.Script, f *callgraph.FuncCFG) *Result {
+	r := &Result{defAt: map[int]*Def{}, useAt: map[int]*Use{}}
+	n := len(f.Blocks)
+	r.liveIn = make([][]Var, n)
+	r.liveOut = make([][]Var, n)
+
+	// Collect the slot accesses of every block.
+	var vars []Var
+	varIndex := map[Var]int{}
+	index := func(v Var) int {
+		if i, ok := varIndex[v]; ok {
+			return i
+		}
+		varIndex[v] = len(vars)
+		vars = append(vars, v)
+		return len(vars) - 1
+	}
+	accs := make([][]access, n)
+	bc := s.Bytecode
+	for _, b := range f.Blocks {
+		for off := b.Start; off < b.End; {
+			k := bytecode.InstrLen(bc, off)
+			if k <= 0 {
+				break
+			}
+			if v, def, ok := slotAccess(bc, off); ok {
+				accs[b.ID] = append(accs[b.ID], access{off: off, v: index(v), def: def})
+			}
+			off += k
+		}
+	}
+
+	// Definitions: one entry definition per variable, then the stores.
+	var defs []*Def
+	defsOf := make([][]int, len(vars)) // var → def indices
+	for i, v := range vars {
+		defsOf[i] = append(defsOf[i], len(defs))
+		defs = append(defs, &Def{Off: Entry, Var: v})
+	}
+	defID := map[int]int{} // store offset → def index
+	for _, b := range f.Blocks {
+		for _, a := range accs[b.ID] {
+			if a.def {
+				defID[a.off] = len(defs)
+				defsOf[a.v] = append(defsOf[a.v], len(defs))
+				defs = append(defs, &Def{Off: a.off, Var: vars[a.v]})
+			}
+		}
+	}
+
+	// Reaching definitions: forward, may.
+	gen := make([]bitset, n)
+	kill := make([]bitset, n)
+	for _, b := range f.Blocks {
+		gen[b.ID], kill[b.ID] = newBitset(len(defs)), newBitset(len(defs))
+		for _, a := range accs[b.ID] {
+			if !a.def {
+				continue
+			}
+			for _, d := range defsOf[a.v] {
+				gen[b.ID].clear(d)
+				kill[b.ID].set(d)
+			}
+			gen[b.ID].set(defID[a.off])
+		}
+	}
+	in := make([]bitset, n)
+	out := make([]bitset, n)
+	for i := range in {
+		in[i], out[i] = newBitset(len(defs)), newBitset(len(defs))
+	}
+	if n > 0 {
+		for i := range vars {
+			in[0].set(defsOf[i][0])
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			if b.ID != 0 {
+				in[b.ID].reset()
+			}
+			for _, p := range b.Preds {
+				in[b.ID].union(out[p])
+			}
+			next := in[b.ID].copy()
+			next.subtract(kill[b.ID])
+			next.union(gen[b.ID])
+			if !next.equal(out[b.ID]) {
+				out[b.ID] = next
+				changed = true
+			}
+		}
+	}
+
+	// Def-use chains: replay each block from its reaching set.
+	for _, b := range f.Blocks {
+		cur := in[b.ID].copy()
+		for _, a := range accs[b.ID] {
+			if a.def {
+				for _, d := range defsOf[a.v] {
+					cur.clear(d)
+				}
+				cur.set(defID[a.off])
+				continue
+			}
+			u := &Use{Off: a.off, Var: vars[a.v]}
+			for _, d := range defsOf[a.v] {
+				if cur.has(d) {
+					u.Defs = append(u.Defs, defs[d].Off)
+					defs[d].Uses = append(defs[d].Uses, a.off)
+				}
+			}
+			sort.Ints(u.Defs)
+			r.Uses = append(r.Uses, u)
+			r.useAt[a.off] = u
+		}
+	}
+	sort.Slice(r.Uses, func(i, j int) bool { return r.Uses[i].Off < r.Uses[j].Off })
+	for _, d := range defs {
+		sort.Ints(d.Uses)
+		if d.Off != Entry {
+			r.defAt[d.Off] = d
+		}
+	}
+	sort.SliceStable(defs, func(i, j int) bool { return defs[i].Off < defs[j].Off })
+	r.Defs = defs
+
+	// Liveness: backward, may.
+	use := make([]bitset, n)
+	def := make([]bitset, n)
+	for _, b := range f.Blocks {
+		use[b.ID], def[b.ID] = newBitset(len(vars)), newBitset(len(vars))
+		for _, a := range accs[b.ID] {
+			if a.def {
+				def[b.ID].set(a.v)
+			} else if !def[b.ID].has(a.v) {
+				use[b.ID].set(a.v)
+			}
+		}
+	}
+	liveIn := make([]bitset, n)
+	liveOut := make([]bitset, n)
+	for i := range liveIn {
+		liveIn[i], liveOut[i] = newBitset(len(vars)), newBitset(len(vars))
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			b := f.Blocks[i]
+			liveOut[i].reset()
+			for _, s := range b.Succs {
+				liveOut[i].union(liveIn[s.BlockID])
+			}
+			next := liveOut[i].copy()
+			next.subtract(def[i])
+			next.union(use[i])
+			if !next.equal(liveIn[i]) {
+				liveIn[i] = next
+				changed = true
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		r.liveIn[i] = liveIn[i].vars(vars)
+		r.liveOut[i] = liveOut[i].vars(vars)
+	}
+	return r
+}
+
+// Annotation renders the def-use comment for the instruction at off, or "":
+//
+//	def v3 used at loc_00012,loc_00040
+//	def v3 dead
+func (r *Result) Annotation(off int) string {
+	d := r.defAt[off]
+	if d == nil {
+		return ""
+	}
+	if len(d.Uses) == 0 {
+		if d.Var.Kind == Aliased {
+			return fmt.Sprintf("def %s", d.Var)
+		}
+		return fmt.Sprintf("def %s dead", d.Var)
+	}
+	locs := make([]string, len(d.Uses))
+	for i, u := range d.Uses {
+		locs[i] = fmt.Sprintf("loc_%05X", u)
+	}
+	return fmt.Sprintf("def %s used at %s", d.Var, strings.Join(locs, ","))
+}
+
+// slotAccess decodes a variable access at off.
+func slotAccess(bc []byte, off int) (v Var, def bool, ok bool) {
+	switch bc[off] {
+	case opGetarg, opSetarg:
+		n, ok := bytecode.GetArgno(bc, off)
+		return Var{Kind: Arg, Slot: int(n)}, bc[off] == opSetarg, ok
+	case opGetlocal, opSetlocal:
+		n, ok := bytecode.GetLocalno(bc, off)
+		return Var{Kind: Local, Slot: int(n)}, bc[off] == opSetlocal, ok
+	case opGetaliasedvar, opSetaliasedvar:
+		if off+5 > len(bc) {
+			return Var{}, false, false
+		}
+		slot := int(bc[off+2])<<16 | int(bc[off+3])<<8 | int(bc[off+4])
+		return Var{Kind: Aliased, Slot: slot, Hops: int(bc[off+1])}, bc[off] == opSetaliasedvar, true
+	}
+	return Var{}, false, false
+}
@@ -0,0 +1,99 @@
+package dataflow
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+)
+
+const (
+	opGoto    = 6
+	opIfeq    = 7
+	opPop     = 81
+	opZero    = 62
+	opOne     = 63
+	opTrue    = 67
+	opTry     = 134
+	opRetrval = 153
+
+	tryCatch = 0 // JSTRY_CATCH
+)
+
+func TestDefUse(t *testing.T) {
+	// var x = 0; if (true) x = 1; var y = x;
+	s := &sm33.Script{Bytecode: []byte{
+		opZero, opSetlocal, 0, 0, 0, opPop, // 0: def v0 @1
+		opTrue, opIfeq, 0, 0, 0, 11, // 6
+		opOne, opSetlocal, 0, 0, 0, opPop, // 12: def v0 @13
+		opGetlocal, 0, 0, 0, // 18: use v0
+		opSetlocal, 0, 0, 1, opPop, // 22: dead store to v1
+		opRetrval,
+	}}
+	f := callgraph.BuildFuncCFG(s, "f")
+	r := Analyze(s, f)
+
+	if u := r.UseAt(18); u == nil || !reflect.DeepEqual(u.Defs, []int{1, 13}) {
+		t.Fatalf("use @18 = %+v, want defs [1 13]", u)
+	}
+	if d := r.DefAt(1); d == nil || !reflect.DeepEqual(d.Uses, []int{18}) {
+		t.Errorf("def @1 = %+v, want uses [18]", d)
+	}
+	dead := r.Dead()
+	if len(dead) != 1 || dead[0].Off != 22 || dead[0].Var.String() != "v1" {
+		t.Errorf("dead = %+v, want v1 @22", dead)
+	}
+	if got := r.Annotation(1); got != "def v0 used at loc_00012" {
+		t.Errorf("annotation @1 = %q", got)
+	}
+	if got := r.Annotation(22); got != "def v1 dead" {
+		t.Errorf("annotation @22 = %q", got)
+	}
+
+	v0 := []Var{{Kind: Local, Slot: 0}}
+	if got := r.LiveOut(0); !reflect.DeepEqual(got, v0) {
+		t.Errorf("live out of block 0 = %v, want [v0]", got)
+	}
+	if got := r.LiveIn(1); len(got) != 0 {
+		t.Errorf("live in of block 1 = %v, want none", got)
+	}
+	if got := r.LiveIn(2); !reflect.DeepEqual(got, v0) {
+		t.Errorf("live in of block 2 = %v, want [v0]", got)
+	}
+}
+
+func TestEntryDef(t *testing.T) {
+	// function (a) { return a; }
+	s := &sm33.Script{Nargs: 1, Bytecode: []byte{opGetarg, 0, 0, opRetrval}}
+	r := Analyze(s, callgraph.BuildFuncCFG(s, "f"))
+	if u := r.UseAt(0); u == nil || !reflect.DeepEqual(u.Defs, []int{Entry}) {
+		t.Errorf("use @0 = %+v, want the entry definition", u)
+	}
+	if got := r.LiveIn(0); !reflect.DeepEqual(got, []Var{{Kind: Arg}}) {
+		t.Errorf("live in = %v, want [a0]", got)
+	}
+}
+
+func TestTryCatchUse(t *testing.T) {
+	// var x; try { x = 0; if (true) x = 1; } catch (e) { x; }
+	s := &sm33.Script{
+		Bytecode: []byte{
+			opTry,
+			opZero, opSetlocal, 0, 0, 0, opPop, // 1: def v0 @2
+			opTrue, opIfeq, 0, 0, 0, 11, // 7
+			opOne, opSetlocal, 0, 0, 0, opPop, // 13: def v0 @14
+			opGoto, 0, 0, 0, 10, // 19
+			opGetlocal, 0, 0, 0, opPop, // 24: handler, use v0
+			opRetrval,
+		},
+		TryNotes: []sm33.TryNote{{Kind: tryCatch, Start: 1, Length: 23}},
+	}
+	r := Analyze(s, callgraph.BuildFuncCFG(s, "f"))
+	if u := r.UseAt(24); u == nil || !reflect.DeepEqual(u.Defs, []int{2, 14}) {
+		t.Errorf("use @24 = %+v, want defs [2 14]", u)
+	}
+	if dead := r.Dead(); len(dead) != 0 {
+		t.Errorf("dead = %+v, want none", dead)
+	}
+}
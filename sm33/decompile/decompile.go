@@ -51,6 +51,9 @@ RULES:
 - Write idiomatic JS: use const/let, modern patterns, meaningful names.
 - The comment block IS the analysis. Keep it concise (3-6 lines).
 - Reconstruct control flow naturally. No mechanical 1:1 opcode translation.
+- Function labels in the bytecode (e.g. Foo.prototype.bar, GameLayer.onEnter)
+  are display names recovered from assignment context. Use them to name and
+  place each function; anon#N marks a function with no naming context.
 
 Bytecode:
 {{.Disasm}}
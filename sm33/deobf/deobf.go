@@ -0,0 +1,476 @@
+// Package deobf undoes the string array transform of javascript-obfuscator.
+//
+// Obfuscated files start with three pieces:
+//
+//	var _0x1234 = ['aGVsbG8=', ...];                  // string array
+//	(function (a, n) { ... a.push(a.shift()) ... })(_0x1234, 0x1a3);
+//	var _0x5678 = function (i, key) { i = i - 0x0; ... return s; };
+//
+// and every string literal becomes a call such as _0x5678('0x1f') or
+// _0x5678('0x1f', 'Kx2#'). Apply finds the array and its decoder, runs the
+// top-level script in the sandboxed interpreter so the rotation IIFE
+// reorders the array exactly as it would at load time, then calls the
+// decoder itself, in the same interpreter, for each call site whose
+// arguments are constant. Whatever the decoder does (index bias, base64
+// with its own alphabet, RC4, caching) happens as it would at run time.
+// The results are returned in Decoder.Decoded, which disassembly, the
+// stack IR and the analyses built on it take as an explicit argument.
+package deobf
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/interp"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+// Opcodes the detector matches.
+const (
+	opName          = 59
+	opString        = 61
+	opGetarg        = 84
+	opSetarg        = 85
+	opGetlocal      = 86
+	opSetlocal      = 87
+	opNewarray      = 90
+	opEndinit       = 92
+	opInitelemArray = 96
+	opSetname       = 111
+	opDeffun        = 127
+	opLambda        = 130
+	opGetaliasedvar = 136
+	opSetaliasedvar = 137
+	opGetgname      = 154
+	opSetgname      = 155
+)
+
+// Decoder describes a recovered string array and its decoder function.
+type Decoder struct {
+	Name    string   // decoder function binding, e.g. "_0x5678"
+	Aliases []string // other bindings the decoder was copied into
+	Array   string   // string array binding
+	Strings []string // array entries after rotation
+	Rotated int      // positions the rotation moved the array by
+
+	Sites  int // call sites decoded
+	Failed int // decoder calls left alone (non-constant, or the decoder failed)
+
+	// Decoded maps each decoded call site to the string it returns.
+	Decoded ir.Decoded
+}
+
+func (d *Decoder) String() string {
+	return fmt.Sprintf("%s: %d strings in %s (rotated %d), %d call sites decoded, %d left",
+		d.Name, len(d.Strings), d.Array, d.Rotated, d.Sites, d.Failed)
+}
+
+// tree indexes a script tree.
+type tree struct {
+	root    *sm33.Script
+	scripts []*sm33.Script
+	parent  map[*sm33.Script]*sm33.Script
+}
+
+func newTree(root *sm33.Script) *tree {
+	t := &tree{root: root, parent: map[*sm33.Script]*sm33.Script{}}
+	var walk func(s *sm33.Script)
+	walk = func(s *sm33.Script) {
+		t.scripts = append(t.scripts, s)
+		for _, o := range s.Objects {
+			if o.Function != nil && o.Function.Script != nil && !o.Function.IsLazy {
+				t.parent[o.Function.Script] = s
+				walk(o.Function.Script)
+			}
+		}
+	}
+	walk(root)
+	return t
+}
+
+func (t *tree) parentOf(s *sm33.Script) *sm33.Script { return t.parent[s] }
+
+// stringArray is an array literal of string constants stored to a binding.
+type stringArray struct {
+	s       *sm33.Script
+	name    string
+	global  bool
+	strings []string
+}
+
+// Apply detects the string array transform in the script tree rooted at s
+// and decodes its call sites into Decoder.Decoded. It returns nil and no
+// error when s does not carry the transform.
+func Apply(s *sm33.Script, opt sm33.Options) (*Decoder, error) {
+	t := newTree(s)
+	arr, fn, name := t.find()
+	if arr == nil {
+		return nil, nil
+	}
+	d := &Decoder{Name: name, Array: arr.name, Decoded: ir.Decoded{}}
+
+	rt, err := t.setup(arr, fn, name, opt)
+	if err != nil {
+		return nil, fmt.Errorf("deobf: %s: %w", d.Name, err)
+	}
+	d.Strings = rt.strings
+	d.Rotated = rotation(arr.strings, rt.strings)
+
+	names := t.aliases(name)
+	for n := range names {
+		if n != name {
+			d.Aliases = append(d.Aliases, n)
+		}
+	}
+	sort.Strings(d.Aliases)
+	for _, sc := range t.scripts {
+		t.rewrite(sc, d, rt, names)
+	}
+	return d, nil
+}
+
+// find returns the largest string array that some function reads, the
+// first such function and the binding that holds it.
+func (t *tree) find() (*stringArray, *sm33.Script, string) {
+	var arrays []*stringArray
+	for _, s := range t.scripts {
+		arrays = append(arrays, t.arrays(s)...)
+	}
+	var best *stringArray
+	var bestFn *sm33.Script
+	var bestName string
+	for _, a := range arrays {
+		if best != nil && len(a.strings) <= len(best.strings) {
+			continue
+		}
+		for _, fn := range t.scripts {
+			if fn == t.root || fn == a.s || !t.reads(fn, a.name) {
+				continue
+			}
+			if name := t.bindingOf(fn); name != "" {
+				best, bestFn, bestName = a, fn, name
+				break
+			}
+		}
+	}
+	return best, bestFn, bestName
+}
+
+// arrays finds newarray initialisers made only of string constants and
+// stored straight into a binding.
+func (t *tree) arrays(s *sm33.Script) []*stringArray {
+	var out []*stringArray
+	bc := s.Bytecode
+	instrs := ir.Decode(bc)
+	for i := 0; i < len(instrs); i++ {
+		if instrs[i].Op != opNewarray {
+			continue
+		}
+		n, _ := bytecode.GetUint24(bc, instrs[i].Off)
+		j := i + 1
+		var strs []string
+		for ; j+1 < len(instrs) && len(strs) < int(n); j += 2 {
+			if instrs[j].Op != opString || instrs[j+1].Op != opInitelemArray {
+				break
+			}
+			strs = append(strs, ir.Atom(s, instrs[j].Off))
+		}
+		if len(strs) == 0 || len(strs) != int(n) || j+1 >= len(instrs) || instrs[j].Op != opEndinit {
+			continue
+		}
+		store := instrs[j+1]
+		name, global := t.accessName(s, store.Off)
+		if name == "" || !isStore(store.Op) {
+			continue
+		}
+		out = append(out, &stringArray{s: s, name: name, global: global, strings: strs})
+		i = j + 1
+	}
+	return out
+}
+
+// reads reports whether fn loads the binding name.
+func (t *tree) reads(fn *sm33.Script, name string) bool {
+	for _, in := range ir.Decode(fn.Bytecode) {
+		if isStore(in.Op) {
+			continue
+		}
+		if n, _ := t.accessName(fn, in.Off); n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingOf returns the binding a function is stored into when defined:
+// var f = function () {}, f = function () {} or function f() {}.
+func (t *tree) bindingOf(fn *sm33.Script) string {
+	p := t.parent[fn]
+	if p == nil {
+		return ""
+	}
+	idx := -1
+	for i, o := range p.Objects {
+		if o.Function != nil && o.Function.Script == fn {
+			idx = i
+		}
+	}
+	instrs := ir.Decode(p.Bytecode)
+	for i, in := range instrs {
+		if (in.Op != opLambda && in.Op != opDeffun) || index(p, in.Off) != idx {
+			continue
+		}
+		if in.Op == opDeffun {
+			return p.Objects[idx].Function.Name
+		}
+		if i+1 < len(instrs) && isStore(instrs[i+1].Op) {
+			n, _ := t.accessName(p, instrs[i+1].Off)
+			return n
+		}
+	}
+	if idx >= 0 {
+		return p.Objects[idx].Function.Name
+	}
+	return ""
+}
+
+// accessName names the binding a variable access refers to, and reports
+// whether it is a global.
+func (t *tree) accessName(s *sm33.Script, off int) (string, bool) {
+	bc := s.Bytecode
+	switch bc[off] {
+	case opName, opGetgname, opSetname, opSetgname:
+		return ir.Atom(s, off), true
+	case opGetarg, opSetarg:
+		n, _ := bytecode.GetArgno(bc, off)
+		return ir.BindingName(s, int(n), false), false
+	case opGetlocal, opSetlocal:
+		n, _ := bytecode.GetLocalno(bc, off)
+		return ir.BindingName(s, int(n), true), false
+	case opGetaliasedvar, opSetaliasedvar:
+		if off+5 > len(bc) {
+			return "", false
+		}
+		hops := int(bc[off+1])
+		slot := int(bc[off+2])<<16 | int(bc[off+3])<<8 | int(bc[off+4])
+		if owner := ir.ScopeOwner(s, hops, t.parentOf); owner != nil {
+			return ir.AliasedName(owner, slot), false
+		}
+	}
+	return "", false
+}
+
+// exprName names the binding an IR expression loads.
+func (t *tree) exprName(s *sm33.Script, e *ir.Expr) string {
+	if e == nil {
+		return ""
+	}
+	switch e.Kind {
+	case ir.Name:
+		return e.Atom
+	case ir.Arg, ir.Local:
+		return e.Text
+	case ir.Aliased:
+		if owner := ir.ScopeOwner(s, e.Hops, t.parentOf); owner != nil {
+			return ir.AliasedName(owner, e.Slot)
+		}
+	}
+	return ""
+}
+
+func isStore(op uint8) bool {
+	switch op {
+	case opSetname, opSetgname, opSetarg, opSetlocal, opSetaliasedvar:
+		return true
+	}
+	return false
+}
+
+// runtime is the interpreter state after the top-level script has set up
+// the string array and its decoder.
+type runtime struct {
+	m       *interp.Machine
+	decoder interp.Value
+	strings []string // the array as the decoder sees it
+}
+
+// setup runs the top-level script so the rotation IIFE reorders the
+// array, and returns the machine with the decoder function it defined.
+// The run stops at the first thing the sandbox cannot model, which in
+// obfuscated files comes well after the array, rotation and decoder are
+// set up.
+func (t *tree) setup(arr *stringArray, fn *sm33.Script, name string, opt sm33.Options) (*runtime, error) {
+	m := interp.New(opt)
+	var closure *interp.Closure
+	m.OnLambda = func(c *interp.Closure) {
+		if c.Func.Script == fn && closure == nil {
+			closure = c
+		}
+	}
+	_, runErr := m.Run(t.root)
+	m.OnLambda = nil
+
+	var v interp.Value
+	switch {
+	case arr.global:
+		v = m.Global.Get(arr.name)
+	case closure != nil:
+		v = t.capturedArray(fn, closure, arr.name)
+	}
+	if v.Kind != interp.Obj || v.Obj.Class != "Array" || len(v.Obj.Elems) != len(arr.strings) {
+		if runErr != nil {
+			return nil, fmt.Errorf("string array not set up: %w", runErr)
+		}
+		return nil, fmt.Errorf("string array %s not set up", arr.name)
+	}
+	rt := &runtime{m: m, strings: make([]string, len(v.Obj.Elems))}
+	for i, e := range v.Obj.Elems {
+		if e.Kind != interp.String {
+			return nil, fmt.Errorf("string array %s holds a %v at %d", arr.name, e, i)
+		}
+		rt.strings[i] = e.Str
+	}
+
+	// Call the decoder through its global binding when it has one, so
+	// state it keeps on itself (a cache, an initialised flag) is shared
+	// with the script; otherwise through the closure the run created.
+	if g := m.Global.Get(name); g.IsCallable() {
+		rt.decoder = g
+	} else if closure != nil {
+		rt.decoder = interp.ObjValue(m.NewFunction(closure))
+	} else {
+		return nil, fmt.Errorf("decoder %s not set up", name)
+	}
+	return rt, nil
+}
+
+// capturedArray reads the array through the decoder closure's scope, for
+// arrays held in a function scope rather than a global.
+func (t *tree) capturedArray(fn *sm33.Script, c *interp.Closure, name string) interp.Value {
+	bc := fn.Bytecode
+	for _, in := range ir.Decode(bc) {
+		if in.Op != opGetaliasedvar {
+			continue
+		}
+		if n, _ := t.accessName(fn, in.Off); n != name {
+			continue
+		}
+		hops := int(bc[in.Off+1])
+		slot := int(bc[in.Off+2])<<16 | int(bc[in.Off+3])<<8 | int(bc[in.Off+4])
+		// Hops count from inside the decoder; the closure holds the scope
+		// outside its own call object and named-lambda environment.
+		if fn.HasCallObject() {
+			hops--
+		}
+		if fn.Bits&sm33.FlagFunNeedsDeclEnvObject != 0 {
+			hops--
+		}
+		if hops >= 0 {
+			return c.Env.Get(hops, slot)
+		}
+	}
+	return interp.Value{}
+}
+
+// rotation returns how many positions left rotated is from orig, or -1.
+func rotation(orig, rotated []string) int {
+	n := len(orig)
+	for k := 0; k < n; k++ {
+		ok := true
+		for i := 0; i < n && ok; i++ {
+			ok = rotated[i] == orig[(i+k)%n]
+		}
+		if ok {
+			return k
+		}
+	}
+	return -1
+}
+
+// aliases returns the decoder binding plus every binding it is copied
+// into (var d = _0x5678), transitively.
+func (t *tree) aliases(name string) map[string]bool {
+	names := map[string]bool{name: true}
+	for changed := true; changed; {
+		changed = false
+		for _, s := range t.scripts {
+			ir.Simulate(s, func(in ir.Instr, st *ir.Stack) {
+				if !isStore(in.Op) || st.Len() == 0 || !names[t.exprName(s, st.Peek(0))] {
+					return
+				}
+				if n, _ := t.accessName(s, in.Off); n != "" && !names[n] {
+					names[n] = true
+					changed = true
+				}
+			})
+		}
+	}
+	return names
+}
+
+// rewrite decodes the decoder calls of s by calling the decoder with
+// their folded arguments.
+func (t *tree) rewrite(s *sm33.Script, d *Decoder, rt *runtime, names map[string]bool) {
+	vals := constprop.Analyze(s)
+	for _, c := range vals.Calls() {
+		if c.Kind != ir.CallNormal || len(c.Args) == 0 || !names[t.exprName(s, c.Callee)] {
+			continue
+		}
+		str, ok := rt.call(vals.FoldAll(c.Args))
+		if !ok {
+			d.Failed++
+			continue
+		}
+		if d.Decoded[s] == nil {
+			d.Decoded[s] = map[int]string{}
+		}
+		d.Decoded[s][c.Offset] = str
+		d.Sites++
+	}
+}
+
+// call runs the decoder over constant arguments, with a fresh step
+// budget. It fails when an argument is not a literal or the decoder does
+// not return a string.
+func (rt *runtime) call(args []*ir.Expr) (string, bool) {
+	vs := make([]interp.Value, len(args))
+	for i, a := range args {
+		v, ok := value(a)
+		if !ok {
+			return "", false
+		}
+		vs[i] = v
+	}
+	rt.m.ResetSteps()
+	v, err := rt.m.Call(rt.decoder, interp.Value{}, vs)
+	if err != nil || v.Kind != interp.String {
+		return "", false
+	}
+	return v.Str, true
+}
+
+// value converts a folded literal to an interpreter value.
+func value(e *ir.Expr) (interp.Value, bool) {
+	if e == nil || e.Kind != ir.Lit {
+		return interp.Value{}, false
+	}
+	switch e.LitKind {
+	case ir.LitString:
+		return interp.Str(e.Str), true
+	case ir.LitNumber:
+		return interp.Num(e.Num), true
+	case ir.LitBool:
+		return interp.Boolean(e.Bool), true
+	case ir.LitNull:
+		return interp.Value{Kind: interp.Null}, true
+	}
+	return interp.Value{}, true
+}
+
+func index(s *sm33.Script, off int) int {
+	idx, _ := bytecode.GetUint32Index(s.Bytecode, off)
+	return int(idx)
+}
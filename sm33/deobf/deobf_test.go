@@ -0,0 +1,399 @@
+package deobf
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+// obfuscated builds:
+//
+//	var _0xa = ['world', 'hello'];
+//	(function (arr, n) { while (n--) arr.push(arr.shift()); })(_0xa, 1);
+//	var _0xd = function (i) { i = i - 0; return _0xa[i]; };
+//	f(_0xd('0x0'));
+//	_0xd('0x1');
+func obfuscated() *sm33.Script {
+	rot := &sm33.Script{
+		Nargs: 2,
+		Atoms: []string{"push", "shift"},
+		Bytecode: Asm(
+			JumpOp(OpGoto, 32),
+			ArgcOp(OpGetarg, 0), []byte{OpDup}, AtomOp(OpCallprop, 0), []byte{OpSwap},
+			ArgcOp(OpGetarg, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap},
+			ArgcOp(OpCall, 0), ArgcOp(OpCall, 1), []byte{OpPop},
+			ArgcOp(OpGetarg, 1), []byte{OpDup, OpOne, OpSub}, ArgcOp(OpSetarg, 1), []byte{OpPop},
+			JumpOp(OpIfne, -37),
+			[]byte{OpRetrval},
+		),
+	}
+	dec := &sm33.Script{
+		Nargs:    1,
+		Bindings: []string{"i"},
+		Atoms:    []string{"i", "_0xa"},
+		Bytecode: Asm(
+			ArgcOp(OpGetarg, 0), []byte{OpZero, OpSub}, ArgcOp(OpSetarg, 0), []byte{OpPop},
+			AtomOp(OpName, 1), ArgcOp(OpGetarg, 0), []byte{OpGetelem, OpReturn},
+		),
+	}
+	return &sm33.Script{
+		Atoms:   []string{"_0xa", "world", "hello", "_0xd", "0x0", "0x1", "f"},
+		Objects: []*sm33.Object{FnObject(rot, ""), FnObject(dec, "")},
+		Bytecode: Asm(
+			AtomOp(OpBindname, 0), []byte{OpNewarray, 0, 0, 2},
+			AtomOp(OpString, 1), []byte{OpInitelemArray, 0, 0, 0},
+			AtomOp(OpString, 2), []byte{OpInitelemArray, 0, 0, 1},
+			[]byte{OpEndinit}, AtomOp(OpSetname, 0), []byte{OpPop},
+			AtomOp(OpLambda, 0), []byte{OpUndefined}, AtomOp(OpName, 0), []byte{OpOne},
+			ArgcOp(OpCall, 2), []byte{OpPop},
+			AtomOp(OpBindname, 3), AtomOp(OpLambda, 1), AtomOp(OpSetname, 3), []byte{OpPop},
+			AtomOp(OpName, 6), []byte{OpUndefined},
+			AtomOp(OpName, 3), []byte{OpUndefined}, AtomOp(OpString, 4), ArgcOp(OpCall, 1),
+			ArgcOp(OpCall, 1), []byte{OpPop},
+			AtomOp(OpName, 3), []byte{OpUndefined}, AtomOp(OpString, 5), ArgcOp(OpCall, 1), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+}
+
+func TestApply(t *testing.T) {
+	s := obfuscated()
+	d, err := Apply(s, sm33.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("no decoder found")
+	}
+	if d.Name != "_0xd" || d.Array != "_0xa" || d.Rotated != 1 {
+		t.Errorf("decoder = %s", d)
+	}
+	if d.Sites != 2 || d.Failed != 0 {
+		t.Errorf("sites = %d, failed = %d, want 2, 0", d.Sites, d.Failed)
+	}
+
+	var got []string
+	for _, c := range ir.SimulateDecoded(s, d.Decoded[s], nil) {
+		if c.Target() == "f" {
+			got = append(got, c.Args[0].String())
+		}
+	}
+	if len(got) != 1 || got[0] != `"hello"` {
+		t.Errorf("f args = %v, want [\"hello\"]", got)
+	}
+	var plain []string
+	for _, str := range d.Decoded[s] {
+		plain = append(plain, str)
+	}
+	if len(plain) != 2 {
+		t.Errorf("decoded = %v", d.Decoded[s])
+	}
+}
+
+func TestNoTransform(t *testing.T) {
+	s := &sm33.Script{Bytecode: []byte{OpRetrval}}
+	if d, err := Apply(s, sm33.DefaultOptions()); d != nil || err != nil {
+		t.Errorf("Apply = %v, %v, want nil, nil", d, err)
+	}
+}
+
+// Decoder atoms and locals.
+const (
+	aArray = iota
+	aAlphabet
+	aEmpty
+	aIndexOf
+	aCharAt
+	aLength
+	aString
+	aFromCharCode
+	aPercent
+	aZeros
+	aCharCodeAt
+	aToString
+	aSlice
+	aDecodeURIComponent
+)
+
+const (
+	lS = iota
+	lOut
+	lBc
+	lBs
+	lIdx
+	lC
+	lP
+	lK
+	lSt
+	lJ
+	lN
+	lX
+	lRes
+	lY
+	nLocals
+)
+
+// decoderScript assembles javascript-obfuscator's base64 decoder, and
+// with rc4 its RC4 variant, statement for statement:
+//
+//	function (i, key) {
+//		i = i - 0;
+//		s = _0xa[i];
+//		out = ''; bc = 0; bs = 0; idx = 0;
+//		while (idx < s.length) {
+//			c = ALPHABET.indexOf(s.charAt(idx));
+//			idx = idx + 1;
+//			if (!(c < 64)) continue;
+//			if (bc % 4) { bs = bs * 64 + c; bc = bc + 1; out = out + String.fromCharCode(255 & bs >> (-2 * bc & 6)); }
+//			else { bs = c; bc = bc + 1; }
+//		}
+//		p = ''; k = 0;
+//		while (k < out.length) { p = p + '%' + ('00' + out.charCodeAt(k).toString(16)).slice(-2); k = k + 1; }
+//		s = decodeURIComponent(p);
+//		return s; // base64
+//		st = []; j = 0; res = ''; n = 0;
+//		while (n < 256) { st[n] = n; n = n + 1; }
+//		n = 0;
+//		while (n < 256) { j = (j + st[n] + key.charCodeAt(n % key.length)) % 256; x = st[n]; st[n] = st[j]; st[j] = x; n = n + 1; }
+//		n = 0; j = 0; y = 0;
+//		while (y < s.length) {
+//			n = (n + 1) % 256; j = (j + st[n]) % 256; x = st[n]; st[n] = st[j]; st[j] = x;
+//			res = res + String.fromCharCode(s.charCodeAt(y) ^ st[(st[n] + st[j]) % 256]);
+//			y = y + 1;
+//		}
+//		return res;
+//	}
+func decoderScript(rc4 bool) *sm33.Script {
+	p := NewProgram()
+	u16 := func(v uint16) { p.Op(OpUint16, byte(v>>8), byte(v)) }
+	i8 := func(v int8) { p.Op(OpInt8, byte(v)) }
+	inc := func(l byte) { p.Getlocal(l).Op(OpOne, OpAdd).Setlocal(l) }
+	fromCharCode := func(arg func()) {
+		p.Atom(OpName, aString).Method(aFromCharCode, arg).Call(1)
+	}
+
+	p.Getarg(0).Op(OpZero, OpSub, OpSetarg, 0, 0, OpPop)
+	p.Atom(OpName, aArray).Getarg(0).Op(OpGetelem).Setlocal(lS)
+	p.Atom(OpString, aEmpty).Setlocal(lOut)
+	p.Op(OpZero).Setlocal(lBc).Op(OpZero).Setlocal(lBs).Op(OpZero).Setlocal(lIdx)
+	p.Label("b64")
+	p.Getlocal(lIdx).Getlocal(lS).Atom(OpGetprop, aLength).Op(OpLt).Jump(OpIfeq, "b64end")
+	p.Atom(OpString, aAlphabet).Method(aIndexOf, func() {
+		p.Getlocal(lS).Method(aCharAt, func() { p.Getlocal(lIdx) }).Call(1)
+	}).Call(1).Setlocal(lC)
+	inc(lIdx)
+	p.Getlocal(lC)
+	i8(64)
+	p.Op(OpLt).Jump(OpIfeq, "b64")
+	p.Getlocal(lBc)
+	i8(4)
+	p.Op(OpMod).Jump(OpIfeq, "first")
+	p.Getlocal(lBs)
+	i8(64)
+	p.Op(OpMul).Getlocal(lC).Op(OpAdd).Setlocal(lBs)
+	inc(lBc)
+	p.Getlocal(lOut)
+	fromCharCode(func() {
+		u16(255)
+		p.Getlocal(lBs)
+		i8(-2)
+		p.Getlocal(lBc).Op(OpMul)
+		i8(6)
+		p.Op(OpBitand, OpRsh, OpBitand)
+	})
+	p.Op(OpAdd).Setlocal(lOut).Jump(OpGoto, "b64")
+	p.Label("first")
+	p.Getlocal(lC).Setlocal(lBs)
+	inc(lBc)
+	p.Jump(OpGoto, "b64")
+	p.Label("b64end")
+
+	p.Atom(OpString, aEmpty).Setlocal(lP).Op(OpZero).Setlocal(lK)
+	p.Label("pct")
+	p.Getlocal(lK).Getlocal(lOut).Atom(OpGetprop, aLength).Op(OpLt).Jump(OpIfeq, "pctend")
+	p.Getlocal(lP).Atom(OpString, aPercent).Op(OpAdd).Atom(OpString, aZeros)
+	p.Getlocal(lOut).Method(aCharCodeAt, func() { p.Getlocal(lK) }).Call(1)
+	p.Method(aToString, func() { i8(16) }).Call(1)
+	p.Op(OpAdd).Method(aSlice, func() { i8(-2) }).Call(1)
+	p.Op(OpAdd).Setlocal(lP)
+	inc(lK)
+	p.Jump(OpGoto, "pct")
+	p.Label("pctend")
+	p.Atom(OpName, aDecodeURIComponent).Op(OpUndefined).Getlocal(lP).Call(1).Setlocal(lS)
+	if !rc4 {
+		p.Getlocal(lS).Op(OpReturn)
+		return decoder(p, 1)
+	}
+
+	// elem pushes st[l].
+	elem := func(l byte) { p.Getlocal(lSt).Getlocal(l).Op(OpGetelem) }
+	swap := func() {
+		elem(lN)
+		p.Setlocal(lX)
+		p.Getlocal(lSt).Getlocal(lN)
+		elem(lJ)
+		p.Op(OpSetelem, OpPop)
+		p.Getlocal(lSt).Getlocal(lJ).Getlocal(lX).Op(OpSetelem, OpPop)
+	}
+	mod256 := func() { u16(256); p.Op(OpMod) }
+	p.Op(OpNewarray, 0, 0, 0, OpEndinit).Setlocal(lSt)
+	p.Op(OpZero).Setlocal(lJ).Atom(OpString, aEmpty).Setlocal(lRes).Op(OpZero).Setlocal(lN)
+	p.Label("init")
+	p.Getlocal(lN)
+	u16(256)
+	p.Op(OpLt).Jump(OpIfeq, "initend")
+	p.Getlocal(lSt).Getlocal(lN).Getlocal(lN).Op(OpSetelem, OpPop)
+	inc(lN)
+	p.Jump(OpGoto, "init")
+	p.Label("initend")
+
+	p.Op(OpZero).Setlocal(lN)
+	p.Label("ks")
+	p.Getlocal(lN)
+	u16(256)
+	p.Op(OpLt).Jump(OpIfeq, "ksend")
+	p.Getlocal(lJ)
+	elem(lN)
+	p.Op(OpAdd).Getarg(1).Method(aCharCodeAt, func() {
+		p.Getlocal(lN).Getarg(1).Atom(OpGetprop, aLength).Op(OpMod)
+	}).Call(1).Op(OpAdd)
+	mod256()
+	p.Setlocal(lJ)
+	swap()
+	inc(lN)
+	p.Jump(OpGoto, "ks")
+	p.Label("ksend")
+
+	p.Op(OpZero).Setlocal(lN).Op(OpZero).Setlocal(lJ).Op(OpZero).Setlocal(lY)
+	p.Label("prga")
+	p.Getlocal(lY).Getlocal(lS).Atom(OpGetprop, aLength).Op(OpLt).Jump(OpIfeq, "prgaend")
+	p.Getlocal(lN).Op(OpOne, OpAdd)
+	mod256()
+	p.Setlocal(lN)
+	p.Getlocal(lJ)
+	elem(lN)
+	p.Op(OpAdd)
+	mod256()
+	p.Setlocal(lJ)
+	swap()
+	p.Getlocal(lRes)
+	fromCharCode(func() {
+		p.Getlocal(lS).Method(aCharCodeAt, func() { p.Getlocal(lY) }).Call(1)
+		p.Getlocal(lSt)
+		elem(lN)
+		elem(lJ)
+		p.Op(OpAdd)
+		mod256()
+		p.Op(OpGetelem, OpBitxor)
+	})
+	p.Op(OpAdd).Setlocal(lRes)
+	inc(lY)
+	p.Jump(OpGoto, "prga")
+	p.Label("prgaend")
+	p.Getlocal(lRes).Op(OpReturn)
+	return decoder(p, 2)
+}
+
+func decoder(p *Program, nargs uint16) *sm33.Script {
+	return &sm33.Script{
+		Nargs: nargs,
+		Nvars: nLocals,
+		Atoms: []string{
+			"_0xa", "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+/=", "",
+			"indexOf", "charAt", "length", "String", "fromCharCode", "%", "00",
+			"charCodeAt", "toString", "slice", "decodeURIComponent",
+		},
+		Bytecode: p.Bytes(),
+	}
+}
+
+// fixture is a decoder call site: f(_0xd('0xN'[, key])).
+type fixture struct {
+	enc, key, want string
+}
+
+// Encoded with javascript-obfuscator's btoa (lowercase-first alphabet)
+// over the UTF-8 bytes of the string, after RC4 with the key when there
+// is one, and checked against the JavaScript decoder under node.
+var (
+	base64Fixtures = []fixture{
+		{"AgvSBg8=", "", "hello"},
+		{"y2mUzgLYzwn0B3i=", "", "cc.director"},
+		{"AmoPBgXVihFdTNjSza==", "", "héllo wörld"},
+		{"z2v0u2nOzwr1BgvY", "", "getScheduler"},
+		{"5PEL5PYS6kQE", "", "日本語"},
+	}
+	rc4Fixtures = []fixture{
+		{"dmoLqLNdUCoKW6vNWQxcLmkxgG==", "Kx2#", "getScheduler"},
+		{"ySojW7zFiCkTW6WKqa==", "a", "runAction"},
+		{"sgNdTCkjWQjqW7K=", "0)zP", "ünïcode"},
+	}
+)
+
+// encoded builds var _0xa = [...]; var _0xd = <dec>; f(_0xd(...)) for
+// each fixture, in order.
+func encoded(dec *sm33.Script, fx []fixture) *sm33.Script {
+	atoms := []string{"_0xa", "_0xd", "f"}
+	add := func(a string) uint32 {
+		atoms = append(atoms, a)
+		return uint32(len(atoms) - 1)
+	}
+	p := NewProgram()
+	p.Atom(OpBindname, 0).Op(OpNewarray, 0, 0, byte(len(fx)))
+	for i, f := range fx {
+		p.Atom(OpString, add(f.enc)).Op(OpInitelemArray, 0, 0, byte(i))
+	}
+	p.Op(OpEndinit).Atom(OpSetname, 0).Op(OpPop)
+	p.Atom(OpBindname, 1).Atom(OpLambda, 0).Atom(OpSetname, 1).Op(OpPop)
+	for i, f := range fx {
+		p.Atom(OpName, 2).Op(OpUndefined).Atom(OpName, 1).Op(OpUndefined)
+		p.Atom(OpString, add("0x"+strconv.FormatInt(int64(i), 16)))
+		argc := uint16(1)
+		if f.key != "" {
+			p.Atom(OpString, add(f.key))
+			argc = 2
+		}
+		p.Call(argc).Call(1).Op(OpPop)
+	}
+	p.Op(OpRetrval)
+	return &sm33.Script{Atoms: atoms, Objects: []*sm33.Object{FnObject(dec, "")}, Bytecode: p.Bytes()}
+}
+
+func TestDecoderKnownAnswers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rc4  bool
+		fx   []fixture
+	}{
+		{"base64", false, base64Fixtures},
+		{"rc4", true, rc4Fixtures},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := encoded(decoderScript(tc.rc4), tc.fx)
+			d, err := Apply(s, sm33.DefaultOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d == nil || d.Name != "_0xd" || d.Sites != len(tc.fx) || d.Failed != 0 {
+				t.Fatalf("decoder = %v", d)
+			}
+			var got []string
+			for _, c := range ir.SimulateDecoded(s, d.Decoded[s], nil) {
+				if c.Target() == "f" {
+					got = append(got, c.Args[0].Str)
+				}
+			}
+			if len(got) != len(tc.fx) {
+				t.Fatalf("f args = %q", got)
+			}
+			for i, f := range tc.fx {
+				if got[i] != f.want {
+					t.Errorf("_0xd(%q) = %q, want %q", f.enc, got[i], f.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package diff compares two builds of a script tree function by function.
+//
+// Functions are paired with fingerprint.MatchTrees, so offsets, atom
+// indices and anonymous names can shift freely. A matched pair is changed
+// when its Listing differs; the diff then carries an instruction-level
+// edit script plus the string literals and constants that appeared or
+// disappeared. Callgraph edges are compared with new-build function names
+// translated to their old-build counterparts.
+package diff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	"github.com/zboralski/spidermonkey-dumper/sm33/fingerprint"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+)
+
+// Diff is the comparison of an old and a new tree.
+type Diff struct {
+	Added     []string    `json:"added"`   // functions only in the new tree
+	Removed   []string    `json:"removed"` // functions only in the old tree
+	Changed   []*FuncDiff `json:"changed"`
+	Unchanged int         `json:"unchanged"`
+	Edges     Change      `json:"edges"` // callgraph edges, "caller kind callee"
+}
+
+// FuncDiff describes one changed function.
+type FuncDiff struct {
+	Old     string  `json:"old"`
+	New     string  `json:"new"`
+	Score   float64 `json:"score"`
+	By      string  `json:"by"` // how the pair was matched
+	Lines   []Line  `json:"lines"`
+	Strings Change  `json:"strings"`
+	Consts  Change  `json:"consts"`
+}
+
+// Change lists the members of a set that were added and removed.
+type Change struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// Empty reports whether nothing changed.
+func (c Change) Empty() bool { return len(c.Added) == 0 && len(c.Removed) == 0 }
+
+// Compare diffs old against new.
+func Compare(old, new *sm33.Script) *Diff {
+	fa, fb := fingerprint.Compute(old), fingerprint.Compute(new)
+	m := fingerprint.MatchTrees(fa, fb)
+	na, nb := names.Infer(old), names.Infer(new)
+
+	// Name new-tree functions after their old counterparts, so renamed
+	// anonymous functions do not show up as changes in listings and edges.
+	rename := map[string]string{}
+	for _, x := range m.Matches {
+		rename[x.B.Name] = x.A.Name
+	}
+	nameB := func(fn *sm33.Function) string {
+		if n, ok := rename[nb.Of(fn)]; ok {
+			return n
+		}
+		return nb.Of(fn)
+	}
+
+	d := &Diff{Added: []string{}, Removed: []string{}, Changed: []*FuncDiff{}}
+	for _, f := range m.OnlyA {
+		d.Removed = append(d.Removed, f.Name)
+	}
+	for _, f := range m.OnlyB {
+		d.Added = append(d.Added, f.Name)
+	}
+	for _, x := range m.Matches {
+		sa, sb := script(old, x.A), script(new, x.B)
+		la, lb := Listing(sa, na.Of), Listing(sb, nameB)
+		strA, constA := literals(sa)
+		strB, constB := literals(sb)
+		if equal(la, lb) {
+			d.Unchanged++
+			continue
+		}
+		d.Changed = append(d.Changed, &FuncDiff{
+			Old:     x.A.Name,
+			New:     x.B.Name,
+			Score:   x.Score,
+			By:      x.By,
+			Lines:   lines(la, lb),
+			Strings: change(strA, strB),
+			Consts:  change(constA, constB),
+		})
+	}
+	d.Edges = change(edges(callgraph.Build(old), nil), edges(callgraph.Build(new), rename))
+	return d
+}
+
+// script returns the bytecode a fingerprint was computed from.
+func script(root *sm33.Script, f *fingerprint.Fingerprint) *sm33.Script {
+	if f.Func == nil {
+		return root
+	}
+	return f.Func.Script
+}
+
+// edges renders the callgraph edges, renaming function nodes.
+func edges(g *callgraph.Graph, rename map[string]string) []string {
+	node := func(n string) string {
+		if r, ok := rename[n]; ok {
+			return r
+		}
+		return n
+	}
+	var out []string
+	for _, e := range g.Edges {
+		out = append(out, node(e.Caller)+" "+e.Kind.String()+" "+node(e.Callee))
+	}
+	return out
+}
+
+// change returns the distinct members of b missing from a and vice versa,
+// sorted.
+func change(a, b []string) Change {
+	in := func(xs []string) map[string]bool {
+		m := map[string]bool{}
+		for _, x := range xs {
+			m[x] = true
+		}
+		return m
+	}
+	ia, ib := in(a), in(b)
+	c := Change{Added: []string{}, Removed: []string{}}
+	for x := range ib {
+		if !ia[x] {
+			c.Added = append(c.Added, x)
+		}
+	}
+	for x := range ia {
+		if !ib[x] {
+			c.Removed = append(c.Removed, x)
+		}
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+	return c
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// context is the number of unchanged lines shown around each change.
+const context = 2
+
+// WriteText writes d as a unified-style report.
+func (d *Diff) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%d changed, %d added, %d removed, %d unchanged\n",
+		len(d.Changed), len(d.Added), len(d.Removed), d.Unchanged)
+	for _, n := range d.Removed {
+		fmt.Fprintf(w, "- %s\n", n)
+	}
+	for _, n := range d.Added {
+		fmt.Fprintf(w, "+ %s\n", n)
+	}
+	for _, f := range d.Changed {
+		title := f.Old
+		if f.New != f.Old {
+			title += " -> " + f.New
+		}
+		fmt.Fprintf(w, "\n@@ %s (%s %.2f)\n", title, f.By, f.Score)
+		writeHunks(w, f.Lines)
+		writeChange(w, "strings", f.Strings)
+		writeChange(w, "consts", f.Consts)
+	}
+	if !d.Edges.Empty() {
+		fmt.Fprintf(w, "\nedges\n")
+		for _, e := range d.Edges.Removed {
+			fmt.Fprintf(w, "- %s\n", e)
+		}
+		for _, e := range d.Edges.Added {
+			fmt.Fprintf(w, "+ %s\n", e)
+		}
+	}
+}
+
+// writeHunks prints changed lines with context lines around them; runs
+// of hidden unchanged lines become "...".
+func writeHunks(w io.Writer, ls []Line) {
+	show := make([]bool, len(ls))
+	for i, l := range ls {
+		if l.Op == " " {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(ls)-1, i+context); j++ {
+			show[j] = true
+		}
+	}
+	hidden := false
+	for i, l := range ls {
+		if !show[i] {
+			hidden = true
+			continue
+		}
+		if hidden {
+			fmt.Fprintf(w, "  ...\n")
+			hidden = false
+		}
+		fmt.Fprintf(w, "%s %s\n", l.Op, l.Text)
+	}
+}
+
+func writeChange(w io.Writer, what string, c Change) {
+	if c.Empty() {
+		return
+	}
+	var parts []string
+	for _, x := range c.Removed {
+		parts = append(parts, "-"+x)
+	}
+	for _, x := range c.Added {
+		parts = append(parts, "+"+x)
+	}
+	fmt.Fprintf(w, "  %s: %s\n", what, strings.Join(parts, " "))
+}
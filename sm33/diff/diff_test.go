@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+func fn(name string, nargs uint16, atoms []string, bc []byte) *sm33.Object {
+	return FnObject(&sm33.Script{Nargs: nargs, Atoms: atoms, Bytecode: bc}, name)
+}
+
+// build returns main calling getX(1), getX and an anonymous helper. The
+// new build shifts atoms, objects and offsets, changes a constant and a
+// string in the helper and adds a cc.log() call to main.
+func build(next bool) *sm33.Script {
+	x, k, str, ln := uint32(0), byte(2), uint32(1), []byte(nil)
+	atoms := []string{"x", "v1"}
+	if next {
+		x, k, str, ln = 1, 3, 0, []byte{OpLineno, 0, 9}
+		atoms = []string{"v2", "x"}
+	}
+	getX := fn("getX", 1, atoms, Asm(ln,
+		[]byte{OpGetarg, 0, 0}, AtomOp(OpGetprop, x), []byte{OpOne, OpAdd, OpReturn}))
+	helper := fn("", 1, atoms, Asm(ln,
+		[]byte{OpGetarg, 0, 0}, []byte{OpIfeq, 0, 0, 0, 8},
+		[]byte{OpInt8, k, OpReturn},
+		AtomOp(OpString, str), []byte{OpReturn}))
+	objs := []*sm33.Object{getX, helper}
+	mainAtoms := []string{"getX", "cc", "log"}
+	mainBC := Asm(AtomOp(OpName, 0), []byte{OpUndefined, OpOne}, []byte{OpCall, 0, 1, OpPop})
+	if next {
+		objs = []*sm33.Object{helper, getX}
+		mainBC = Asm(mainBC, AtomOp(OpName, 1), []byte{OpDup}, AtomOp(OpCallprop, 2), []byte{OpSwap},
+			[]byte{OpCall, 0, 0, OpPop})
+	}
+	return &sm33.Script{Atoms: mainAtoms, Objects: objs, Bytecode: Asm(mainBC, []byte{OpRetrval})}
+}
+
+func TestCompare(t *testing.T) {
+	d := Compare(build(false), build(true))
+	if d.Unchanged != 1 || len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 2 {
+		t.Fatalf("unchanged %d, added %v, removed %v, changed %d", d.Unchanged, d.Added, d.Removed, len(d.Changed))
+	}
+	h := d.Changed[1]
+	if h.Old != "anon#1" || h.New != "anon#0" {
+		t.Errorf("helper matched %s -> %s", h.Old, h.New)
+	}
+	var got []string
+	for _, l := range h.Lines {
+		got = append(got, l.Op+l.Text)
+	}
+	want := []string{" getarg 0", " ifeq L1", "-int8 2", "+int8 3", " return", " L1:", `-string "v1"`, `+string "v2"`, " return"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if c := h.Consts; len(c.Added) != 1 || c.Added[0] != "3" || len(c.Removed) != 1 || c.Removed[0] != "2" {
+		t.Errorf("consts = %+v", c)
+	}
+	if c := h.Strings; len(c.Added) != 1 || c.Added[0] != `"v2"` {
+		t.Errorf("strings = %+v", c)
+	}
+	if e := d.Edges; len(e.Added) != 1 || e.Added[0] != "main calls cc.log" || len(e.Removed) != 0 {
+		t.Errorf("edges = %+v", e)
+	}
+
+	var b bytes.Buffer
+	d.WriteText(&b)
+	if !strings.Contains(b.String(), "@@ anon#1 -> anon#0") || !strings.Contains(b.String(), "+ main calls cc.log") {
+		t.Errorf("text:\n%s", b.String())
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	s := &sm33.Script{Bytecode: Asm(
+		[]byte{OpZero, OpOne},
+		[]byte{OpUint16, 0x01, 0x00},
+		[]byte{OpUint24, 0x01, 0x00, 0x00},
+		[]byte{OpInt8, 0xff},
+		[]byte{OpInt32, 0xff, 0xff, 0xff, 0xfe},
+		AtomOp(OpDouble, 0),
+		[]byte{OpReturn})}
+	s.Consts = []sm33.Const{{Kind: sm33.ConstDouble, Double: 1.5}}
+	_, consts := literals(s)
+	want := "0 1 256 65536 -1 -2 1.5"
+	if got := strings.Join(consts, " "); got != want {
+		t.Errorf("consts = %s, want %s", got, want)
+	}
+}
+
+func TestTruncatedOperand(t *testing.T) {
+	s := &sm33.Script{Bytecode: []byte{OpIter}}
+	if got := operand(s, ir.Instr{Off: 0, Op: OpIter, Len: 2}, nil, nil); got != "" {
+		t.Errorf("operand = %q, want empty", got)
+	}
+}
@@ -0,0 +1,208 @@
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+// Opcodes the listing renders specially.
+const (
+	opNop    = 0
+	opDouble = 60
+	opString = 61
+	opZero   = 62
+	opOne    = 63
+	opUint16 = 88
+	opLineno = 119
+	opUint24 = 188
+	opInt8   = 215
+	opInt32  = 216
+)
+
+// Listing renders the instructions of s without offsets: jump targets
+// become labels L1, L2, ... in offset order, atoms are quoted strings,
+// constants their value and inner functions their display name. Line
+// number and nop instructions are dropped.
+func Listing(s *sm33.Script, name func(*sm33.Function) string) []string {
+	bc := s.Bytecode
+	instrs := ir.Decode(bc)
+	labels := map[int]string{}
+	var targets []int
+	for _, in := range instrs {
+		for _, t := range ir.JumpTargets(bc, in.Off) {
+			if _, ok := labels[t]; !ok {
+				labels[t] = ""
+				targets = append(targets, t)
+			}
+		}
+	}
+	sort.Ints(targets)
+	for i, t := range targets {
+		labels[t] = "L" + strconv.Itoa(i+1)
+	}
+
+	var out []string
+	for _, in := range instrs {
+		if l := labels[in.Off]; l != "" {
+			out = append(out, l+":")
+		}
+		if in.Op == opNop || in.Op == opLineno {
+			continue
+		}
+		line := in.Name()
+		if op := operand(s, in, labels, name); op != "" {
+			line += " " + op
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func operand(s *sm33.Script, in ir.Instr, labels map[int]string, name func(*sm33.Function) string) string {
+	bc := s.Bytecode
+	if in.Off+in.Len > len(bc) {
+		return ""
+	}
+	switch bytecode.JofType(bytecode.Opcodes[in.Op].Format) {
+	case bytecode.JOF_JUMP, bytecode.JOF_TABLESWITCH:
+		var out string
+		for i, t := range ir.JumpTargets(bc, in.Off) {
+			if i > 0 {
+				out += " "
+			}
+			out += labels[t]
+		}
+		return out
+	case bytecode.JOF_ATOM, bytecode.JOF_ATOMOBJECT:
+		return strconv.Quote(ir.Atom(s, in.Off))
+	case bytecode.JOF_DOUBLE:
+		if c, ok := constAt(s, in.Off); ok {
+			return ir.ConstExpr(c).String()
+		}
+	case bytecode.JOF_OBJECT:
+		if idx := index(s, in.Off); idx < len(s.Objects) {
+			if fn := s.Objects[idx].Function; fn != nil {
+				return "function " + name(fn)
+			}
+		}
+		return "object"
+	case bytecode.JOF_REGEXP:
+		if idx := index(s, in.Off); idx < len(s.Regexps) {
+			return "/" + s.Regexps[idx].Source + "/"
+		}
+	case bytecode.JOF_INT8:
+		v, _ := bytecode.GetInt8(bc, in.Off)
+		return strconv.Itoa(int(v))
+	case bytecode.JOF_INT32:
+		v, _ := bytecode.GetInt32(bc, in.Off)
+		return strconv.Itoa(int(v))
+	case bytecode.JOF_UINT8:
+		return strconv.Itoa(int(bc[in.Off+1]))
+	case bytecode.JOF_UINT16, bytecode.JOF_QARG:
+		v, _ := bytecode.GetUint16(bc, in.Off)
+		return strconv.Itoa(int(v))
+	case bytecode.JOF_UINT24, bytecode.JOF_LOCAL:
+		v, _ := bytecode.GetUint24(bc, in.Off)
+		return strconv.Itoa(int(v))
+	case bytecode.JOF_SCOPECOORD:
+		slot, _ := bytecode.GetUint24(bc, in.Off+1)
+		return fmt.Sprintf("%d,%d", bc[in.Off+1], slot)
+	}
+	return ""
+}
+
+// literals returns the string literals and numeric constants s pushes.
+func literals(s *sm33.Script) (strs, consts []string) {
+	bc := s.Bytecode
+	for _, in := range ir.Decode(bc) {
+		switch in.Op {
+		case opString:
+			strs = append(strs, strconv.Quote(ir.Atom(s, in.Off)))
+		case opDouble:
+			if c, ok := constAt(s, in.Off); ok {
+				consts = append(consts, ir.ConstExpr(c).String())
+			}
+		case opZero:
+			consts = append(consts, "0")
+		case opOne:
+			consts = append(consts, "1")
+		case opUint16, opUint24, opInt8, opInt32:
+			consts = append(consts, operand(s, in, nil, nil))
+		}
+	}
+	return strs, consts
+}
+
+func constAt(s *sm33.Script, off int) (sm33.Const, bool) {
+	if idx := index(s, off); idx < len(s.Consts) {
+		return s.Consts[idx], true
+	}
+	return sm33.Const{}, false
+}
+
+func index(s *sm33.Script, off int) int {
+	idx, _ := bytecode.GetUint32Index(s.Bytecode, off)
+	return int(idx)
+}
+
+// Line is one line of an edit script.
+type Line struct {
+	Op   string `json:"op"` // " " (kept), "-" (removed) or "+" (added)
+	Text string `json:"text"`
+}
+
+// maxCells bounds the LCS table; larger functions diff as a whole
+// replacement.
+const maxCells = 1 << 24
+
+// lines returns the edit script turning a into b, from a longest common
+// subsequence.
+func lines(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n*m > maxCells {
+		var out []Line
+		for _, x := range a {
+			out = append(out, Line{"-", x})
+		}
+		for _, y := range b {
+			out = append(out, Line{"+", y})
+		}
+		return out
+	}
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []Line
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			out = append(out, Line{" ", a[i]})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, Line{"-", a[i]})
+			i++
+		default:
+			out = append(out, Line{"+", b[j]})
+			j++
+		}
+	}
+	return out
+}
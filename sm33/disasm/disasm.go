@@ -2,22 +2,65 @@ package disasm
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33"
 	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/dataflow"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
+	"github.com/zboralski/spidermonkey-dumper/sm33/xref"
 )
 
 const commentCol = 60
 
+// View selects what a listing shows beyond the instructions themselves.
+// It is separate from sm33.Options, which governs decoding.
+type View struct {
+	// Annotate adds analysis comments: def-use chains for argument, local
+	// and aliased variable stores, and the folded value of computed
+	// constants.
+	Annotate bool
+
+	// Xrefs adds a comment listing the call sites of each inner function
+	// above its disassembly.
+	Xrefs bool
+
+	// Library marks known library functions by display name, typically
+	// from a signature database (package sigdb). LibraryView selects how
+	// they are shown.
+	Library     map[string]sigdb.LibraryFunc
+	LibraryView sigdb.LibraryView
+
+	// Decoded shows the plain string each deobfuscated call returns
+	// (package deobf) and folds it into the annotations.
+	Decoded ir.Decoded
+}
+
 // DisasmScriptOpt produces disassembly text with mode-aware error handling.
 func DisasmScriptOpt(s *sm33.Script, funcName string, header bool, opt sm33.Options) (sm33.Result[string], error) {
+	return DisasmScriptView(s, funcName, header, opt, View{})
+}
+
+// DisasmScriptView is DisasmScriptOpt with the listing extras of v.
+func DisasmScriptView(s *sm33.Script, funcName string, header bool, opt sm33.Options, v View) (sm33.Result[string], error) {
 	var b strings.Builder
 	var diags []sm33.Diagnostic
 	bc := s.Bytecode
 	labels := bytecode.CollectLabels(bc)
 	maxSteps := opt.EffectiveMaxSteps()
 
+	var df *dataflow.Result
+	var vals *constprop.Values
+	if v.Annotate {
+		df = dataflow.Analyze(s, callgraph.BuildFuncCFG(s, funcName))
+		vals = constprop.AnalyzeDecoded(s, v.Decoded[s])
+	}
+
 	if header {
 		b.WriteString("loc     op\n")
 		b.WriteString("-----   --\n")
@@ -260,6 +303,27 @@ func DisasmScriptOpt(s *sm33.Script, funcName string, header bool, opt sm33.Opti
 		}
 		b.WriteString(strings.Repeat(" ", pad))
 
+		if df != nil {
+			if a := df.Annotation(off); a != "" {
+				if comment != "" {
+					comment += ", "
+				}
+				comment += a
+			}
+		}
+		if str, ok := v.Decoded[s][off]; ok {
+			if comment != "" {
+				comment += ", "
+			}
+			comment += "= " + strconv.Quote(str)
+		} else if vals != nil {
+			if c := vals.Computed(off); c != nil {
+				if comment != "" {
+					comment += ", "
+				}
+				comment += "= " + c.String()
+			}
+		}
 		if comment != "" {
 			fmt.Fprintf(&b, "; %s", comment)
 		}
@@ -298,6 +362,11 @@ func tagFunc(diags []sm33.Diagnostic, name string) {
 
 // DisasmTreeOpt produces disassembly for a script and all inner functions with options.
 func DisasmTreeOpt(s *sm33.Script, opt sm33.Options) (sm33.Result[string], error) {
+	return DisasmTreeView(s, opt, View{})
+}
+
+// DisasmTreeView is DisasmTreeOpt with the listing extras of v.
+func DisasmTreeView(s *sm33.Script, opt sm33.Options, v View) (sm33.Result[string], error) {
 	var b strings.Builder
 	var allDiags []sm33.Diagnostic
 
@@ -307,7 +376,7 @@ func DisasmTreeOpt(s *sm33.Script, opt sm33.Options) (sm33.Result[string], error
 	}
 
 	// Main script
-	res, err := DisasmScriptOpt(s, "main", true, opt)
+	res, err := DisasmScriptView(s, "main", true, opt, v)
 	b.WriteString(res.Value)
 	tagFunc(res.Diags, "main")
 	allDiags = append(allDiags, res.Diags...)
@@ -317,17 +386,21 @@ func DisasmTreeOpt(s *sm33.Script, opt sm33.Options) (sm33.Result[string], error
 	b.WriteByte('\n')
 
 	// Inner functions (from objects)
-	for i, obj := range s.Objects {
+	nm := names.Infer(s)
+	var xr *xref.Index
+	if v.Xrefs {
+		xr = xref.Build(s)
+	}
+	for _, obj := range s.Objects {
 		if obj.Kind == sm33.CkJSFunction && obj.Function != nil && obj.Function.Script != nil {
-			name := obj.Function.Name
-			diagName := name
-			if name == "" {
-				name = "unknown"
-				diagName = fmt.Sprintf("anon#%d", i)
+			name := nm.Of(obj.Function)
+			if library(&b, name, v) {
+				continue
 			}
-			res, err := DisasmScriptOpt(obj.Function.Script, name, false, opt)
+			writeXrefs(&b, xr, name)
+			res, err := DisasmScriptView(obj.Function.Script, name, false, opt, v)
 			b.WriteString(res.Value)
-			tagFunc(res.Diags, diagName)
+			tagFunc(res.Diags, name)
 			allDiags = append(allDiags, res.Diags...)
 			if err != nil {
 				return sm33.Result[string]{Value: b.String(), Diags: allDiags}, err
@@ -339,7 +412,10 @@ func DisasmTreeOpt(s *sm33.Script, opt sm33.Options) (sm33.Result[string], error
 	// Recurse into inner function objects
 	for _, obj := range s.Objects {
 		if obj.Kind == sm33.CkJSFunction && obj.Function != nil && obj.Function.Script != nil {
-			res, err := disasmInnerOpt(obj.Function.Script, 1, opt)
+			if _, lib := v.Library[nm.Of(obj.Function)]; lib && v.LibraryView != sigdb.LibraryLabel {
+				continue
+			}
+			res, err := disasmInnerOpt(obj.Function.Script, 1, nm, xr, opt, v)
 			b.WriteString(res.Value)
 			allDiags = append(allDiags, res.Diags...)
 			if err != nil {
@@ -351,24 +427,67 @@ func DisasmTreeOpt(s *sm33.Script, opt sm33.Options) (sm33.Result[string], error
 	return sm33.Result[string]{Value: b.String(), Diags: allDiags}, nil
 }
 
+// library writes the label of a known library function and reports
+// whether its body and inner functions are left out.
+func library(b *strings.Builder, name string, v View) bool {
+	lf, ok := v.Library[name]
+	if !ok {
+		return false
+	}
+	switch v.LibraryView {
+	case sigdb.LibraryHide:
+		return true
+	case sigdb.LibraryCollapse:
+		fmt.Fprintf(b, "%s\n; library %s: %s, collapsed\n\n", name, lf.Library, lf.Name)
+		return true
+	}
+	fmt.Fprintf(b, "; library %s: %s\n", lf.Library, lf.Name)
+	return false
+}
+
+// maxXrefs caps the call sites listed in a function header.
+const maxXrefs = 8
+
+// writeXrefs writes the call sites of function name, if xr is set.
+func writeXrefs(b *strings.Builder, xr *xref.Index, name string) {
+	if xr == nil {
+		return
+	}
+	refs := xr.Callers(name)
+	if len(refs) == 0 {
+		return
+	}
+	b.WriteString("; xrefs:")
+	for i, r := range refs {
+		if i == maxXrefs {
+			fmt.Fprintf(b, " +%d more", len(refs)-maxXrefs)
+			break
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, " %s@%05X %s", r.Func, r.Off, r.Op)
+	}
+	b.WriteByte('\n')
+}
+
 // disasmInnerOpt recursively disassembles inner functions with options.
-func disasmInnerOpt(s *sm33.Script, depth int, opt sm33.Options) (sm33.Result[string], error) {
+func disasmInnerOpt(s *sm33.Script, depth int, nm *names.Names, xr *xref.Index, opt sm33.Options, v View) (sm33.Result[string], error) {
 	if depth > 5 {
 		return sm33.Result[string]{}, nil
 	}
 	var b strings.Builder
 	var diags []sm33.Diagnostic
-	for i, obj := range s.Objects {
+	for _, obj := range s.Objects {
 		if obj.Kind == sm33.CkJSFunction && obj.Function != nil && obj.Function.Script != nil {
-			name := obj.Function.Name
-			diagName := name
-			if name == "" {
-				name = "unknown"
-				diagName = fmt.Sprintf("anon#%d", i)
+			name := nm.Of(obj.Function)
+			if library(&b, name, v) {
+				continue
 			}
-			res, err := DisasmScriptOpt(obj.Function.Script, name, false, opt)
+			writeXrefs(&b, xr, name)
+			res, err := DisasmScriptView(obj.Function.Script, name, false, opt, v)
 			b.WriteString(res.Value)
-			tagFunc(res.Diags, diagName)
+			tagFunc(res.Diags, name)
 			diags = append(diags, res.Diags...)
 			if err != nil {
 				if opt.Mode == sm33.Strict {
@@ -376,13 +495,13 @@ func disasmInnerOpt(s *sm33.Script, depth int, opt sm33.Options) (sm33.Result[st
 				}
 				diags = append(diags, sm33.Diagnostic{
 					Kind: "invalid",
-					Func: diagName,
+					Func: name,
 					Msg:  fmt.Sprintf("inner function %q: %v", name, err),
 				})
 				continue
 			}
 			b.WriteByte('\n')
-			inner, err := disasmInnerOpt(obj.Function.Script, depth+1, opt)
+			inner, err := disasmInnerOpt(obj.Function.Script, depth+1, nm, xr, opt, v)
 			b.WriteString(inner.Value)
 			diags = append(diags, inner.Diags...)
 			if err != nil {
@@ -391,7 +510,7 @@ func disasmInnerOpt(s *sm33.Script, depth int, opt sm33.Options) (sm33.Result[st
 				}
 				diags = append(diags, sm33.Diagnostic{
 					Kind: "invalid",
-					Func: diagName,
+					Func: name,
 					Msg:  fmt.Sprintf("inner recursion: %v", err),
 				})
 			}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
 	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
 )
 
@@ -173,6 +174,61 @@ func TestBestEffortDiagnosticFunc(t *testing.T) {
 	})
 }
 
+func TestLibraryView(t *testing.T) {
+	inner := &sm33.Script{Bytecode: []byte{0x00}}
+	outer := &sm33.Script{
+		Bytecode: []byte{0x00},
+		Objects:  []*sm33.Object{{Kind: sm33.CkJSFunction, Function: &sm33.Function{Name: "inner", Script: inner}}},
+	}
+	s := &sm33.Script{
+		Bytecode: []byte{0x00},
+		Objects:  []*sm33.Object{{Kind: sm33.CkJSFunction, Function: &sm33.Function{Name: "lib", Script: outer}}},
+	}
+	lib := map[string]sigdb.LibraryFunc{"lib": {Library: "cocos", Name: "cc.Node.ctor"}}
+
+	for _, tc := range []struct {
+		view       sigdb.LibraryView
+		has, hasnt []string
+	}{
+		{sigdb.LibraryLabel, []string{"; library cocos: cc.Node.ctor\nlib\n", "\ninner\n"}, nil},
+		{sigdb.LibraryCollapse, []string{"lib\n; library cocos: cc.Node.ctor, collapsed\n"}, []string{"inner"}},
+		{sigdb.LibraryHide, nil, []string{"lib", "inner"}},
+	} {
+		res, err := DisasmTreeView(s, sm33.DefaultOptions(), View{Library: lib, LibraryView: tc.view})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tc.has {
+			if !strings.Contains(res.Value, want) {
+				t.Errorf("view %d: missing %q in:\n%s", tc.view, want, res.Value)
+			}
+		}
+		for _, bad := range tc.hasnt {
+			if strings.Contains(res.Value, bad) {
+				t.Errorf("view %d: unexpected %q in:\n%s", tc.view, bad, res.Value)
+			}
+		}
+	}
+}
+
+func TestXrefs(t *testing.T) {
+	s, err := xdr.DecodeFile("testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := DisasmTreeView(s, sm33.DefaultOptions(), View{Xrefs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "; xrefs: SplashScene<.checkCb@000CB calls\nSplashScene<.hotUpdate\n"
+	if !strings.Contains(res.Value, want) {
+		t.Errorf("missing %q", want)
+	}
+	if plain := DisasmTree(s); strings.Contains(plain, "; xrefs:") {
+		t.Errorf("xrefs without View.Xrefs")
+	}
+}
+
 func FuzzDisasm(f *testing.F) {
 	// Seed with bytecode snippets from known opcodes
 	seeds := [][]byte{
@@ -0,0 +1,489 @@
+// Package endpoints inventories the network traffic of a script: the
+// XMLHttpRequests it opens (new XMLHttpRequest, cc.loader.getXMLHttpRequest),
+// the WebSockets it connects, and every http(s) or ws(s) URL literal.
+//
+// A request is assembled from the calls on one receiver within a function:
+//
+//	var xhr = cc.loader.getXMLHttpRequest();
+//	xhr.open("POST", HOST + "/login");
+//	xhr.setRequestHeader("Content-Type", "application/json");
+//	xhr.send(JSON.stringify({ uid: uid, token: "x" }));
+//
+// gives a POST to "https://api.example/login" (HOST folded by constprop)
+// with one header and the body `json {uid: uid, token: "x"}`. Parts of a
+// URL that do not fold are kept as {expr} placeholders; object literals,
+// including ones filled in by later property stores, are rendered as
+// shapes.
+package endpoints
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// Opcodes that build object literals and store values.
+const (
+	opAdd           = 27
+	opSetprop       = 54
+	opString        = 61
+	opObject        = 80
+	opSetarg        = 85
+	opSetlocal      = 87
+	opNewinit       = 89
+	opNewobject     = 91
+	opInitprop      = 93
+	opSetname       = 111
+	opSetaliasedvar = 137
+	opSetgname      = 155
+)
+
+// Kinds of endpoints.
+const (
+	XHR       = "xhr"
+	WebSocket = "websocket"
+	URL       = "url"
+)
+
+// constructors create a connection object of a kind.
+var constructors = map[string]string{
+	"XMLHttpRequest":              XHR,
+	"cc.loader.getXMLHttpRequest": XHR,
+	"WebSocket":                   WebSocket,
+	"cc.WebSocket":                WebSocket,
+}
+
+var (
+	urlRe   = regexp.MustCompile(`^(?i)(https?|wss?)://`)
+	methods = map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true, "HEAD": true, "OPTIONS": true, "PATCH": true}
+)
+
+// Header is one setRequestHeader call.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Evidence is one instruction an endpoint was assembled from.
+type Evidence struct {
+	Off  int    `json:"off"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// Endpoint is one request, socket or URL literal of a function.
+type Endpoint struct {
+	Kind     string     `json:"kind"`
+	File     string     `json:"file,omitempty"`
+	Func     string     `json:"func"`
+	Off      int        `json:"off"` // first evidence
+	Line     int        `json:"line"`
+	URL      string     `json:"url,omitempty"`
+	Method   string     `json:"method,omitempty"`
+	Headers  []Header   `json:"headers,omitempty"`
+	Body     string     `json:"body,omitempty"`     // xhr send argument
+	Messages []string   `json:"messages,omitempty"` // websocket send arguments
+	Evidence []Evidence `json:"evidence"`
+
+	recv  string
+	order int
+}
+
+// Scan returns the endpoints of root, read from file, in function order
+// and then by offset.
+func Scan(file string, root *sm33.Script) []*Endpoint {
+	return ScanDecoded(file, root, nil)
+}
+
+// ScanDecoded is Scan with the deobfuscated call results of dec, as
+// returned by package deobf, taken as string literals.
+func ScanDecoded(file string, root *sm33.Script, dec ir.Decoded) []*Endpoint {
+	nm := names.Infer(root)
+	var out []*Endpoint
+	fs := &fileState{kinds: map[string]string{}, urls: map[string]string{}}
+	order := 0
+	var walk func(s *sm33.Script, name string)
+	walk = func(s *sm33.Script, name string) {
+		for _, e := range scan(s, name, fs, dec[s]) {
+			e.File, e.order = file, order
+			out = append(out, e)
+		}
+		order++
+		for _, obj := range s.Objects {
+			if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+				walk(fn.Script, nm.Of(fn))
+			}
+		}
+	}
+	walk(root, "main")
+	for _, e := range out {
+		if e.URL == "" && e.recv != "" {
+			e.URL = fs.urls[e.recv]
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].order != out[j].order {
+			return out[i].order < out[j].order
+		}
+		return out[i].Off < out[j].Off
+	})
+	return out
+}
+
+// fileState is what the functions of a file share about globals and
+// properties of this: the connection kind stored in each and the URL a
+// socket was created with.
+type fileState struct {
+	kinds map[string]string
+	urls  map[string]string
+}
+
+// shape is an object literal and the properties stored into it.
+type shape struct {
+	keys []string
+	vals map[string]*ir.Expr
+}
+
+func (sh *shape) set(k string, v *ir.Expr) {
+	if _, ok := sh.vals[k]; !ok {
+		sh.keys = append(sh.keys, k)
+	}
+	sh.vals[k] = v
+}
+
+// scanner holds the per-function state of scan.
+type scanner struct {
+	vals     *constprop.Values
+	shapes   map[*ir.Expr]*shape
+	vars     map[string]*shape   // variable key → object literal stored in it
+	assigned map[string]*ir.Expr // local variable key → last value stored in it
+	kinds    map[string]string   // variable key → connection kind
+	dests    map[*ir.Call]string // constructor call → variable key it is stored in
+}
+
+func scan(s *sm33.Script, fn string, fs *fileState, dec map[int]string) []*Endpoint {
+	sc := &scanner{
+		vals:     constprop.AnalyzeDecoded(s, dec),
+		shapes:   map[*ir.Expr]*shape{},
+		vars:     map[string]*shape{},
+		assigned: map[string]*ir.Expr{},
+		kinds:    map[string]string{},
+		dests:    map[*ir.Call]string{},
+	}
+	bc := s.Bytecode
+	var prev ir.Instr
+	have := false
+	var urlLits []Evidence
+	calls := ir.SimulateDecoded(s, dec, func(in ir.Instr, st *ir.Stack) {
+		if have {
+			switch prev.Op {
+			case opNewinit, opNewobject, opObject:
+				sh := &shape{vals: map[string]*ir.Expr{}}
+				if prev.Op != opNewinit {
+					idx, _ := bytecode.GetUint32Index(bc, prev.Off)
+					if int(idx) < len(s.Objects) && s.Objects[idx].Literal != nil {
+						for _, p := range s.Objects[idx].Literal.Props {
+							sh.set(p.Key, ir.ConstExpr(p.Value))
+						}
+					}
+				}
+				sc.shapes[st.Peek(0)] = sh
+			}
+		}
+		prev, have = in, true
+
+		atom := func() string { return ir.Atom(s, in.Off) }
+		var dest string
+		switch in.Op {
+		case opString:
+			if a := atom(); urlRe.MatchString(a) {
+				urlLits = append(urlLits, Evidence{Off: in.Off, Text: a})
+			}
+			return
+		case opInitprop:
+			if sh := sc.shapes[st.Peek(1)]; sh != nil {
+				sh.set(atom(), st.Peek(0))
+			}
+			return
+		case opSetprop:
+			base := varKey(st.Peek(1))
+			if sh := sc.shape(st.Peek(1)); sh != nil {
+				sh.set(atom(), st.Peek(0))
+			}
+			if base == "" {
+				return
+			}
+			dest = base + "." + atom()
+		case opSetname, opSetgname:
+			dest = atom()
+		case opSetlocal:
+			v, _ := bytecode.GetLocalno(bc, in.Off)
+			dest = fmt.Sprintf("local:%d", v)
+		case opSetarg:
+			v, _ := bytecode.GetArgno(bc, in.Off)
+			dest = fmt.Sprintf("arg:%d", v)
+		case opSetaliasedvar:
+			if in.Off+5 <= len(bc) {
+				dest = fmt.Sprintf("aliased:%d:%d", bc[in.Off+1], int(bc[in.Off+2])<<16|int(bc[in.Off+3])<<8|int(bc[in.Off+4]))
+			}
+		default:
+			return
+		}
+		v := st.Peek(0)
+		if !globalKey(dest) {
+			sc.assigned[dest] = v
+		}
+		if sh := sc.shapes[v]; sh != nil {
+			sc.vars[dest] = sh
+		}
+		if v.Kind == ir.CallResult && v.Call != nil {
+			if k := sc.constructs(v.Call); k != "" {
+				sc.kinds[dest] = k
+				if globalKey(dest) {
+					fs.kinds[dest] = k
+				}
+				sc.dests[v.Call] = dest
+			}
+		}
+	})
+
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].Offset < calls[j].Offset })
+	lines := srcnote.NewLines(s)
+	var out []*Endpoint
+	open := map[string]*Endpoint{} // receiver key → request being assembled
+	evidence := func(c *ir.Call) Evidence {
+		args := make([]string, len(c.Args))
+		for i, a := range sc.vals.FoldAll(c.Args) {
+			args[i] = a.String()
+		}
+		return Evidence{Off: c.Offset, Line: lines.Line(c.Offset), Text: sc.vals.Target(c) + "(" + strings.Join(args, ", ") + ")"}
+	}
+	endpoint := func(kind, recv string, c *ir.Call) *Endpoint {
+		ev := evidence(c)
+		e := &Endpoint{Kind: kind, Func: fn, Off: ev.Off, Line: ev.Line, Evidence: []Evidence{ev}, recv: recv}
+		out = append(out, e)
+		if recv != "" {
+			open[recv] = e
+		}
+		return e
+	}
+	for _, c := range calls {
+		if k := sc.constructs(c); k != "" {
+			recv := sc.dests[c]
+			if k == XHR {
+				if recv != "" {
+					delete(open, recv)
+				}
+				continue
+			}
+			e := endpoint(k, recv, c)
+			if len(c.Args) > 0 {
+				e.URL = sc.template(c.Args[0], 0)
+				if recv != "" && globalKey(recv) {
+					fs.urls[recv] = e.URL
+				}
+			}
+			continue
+		}
+		if c.Callee == nil || c.Callee.Kind != ir.Prop {
+			continue
+		}
+		recv := varKey(c.This)
+		kind := sc.kinds[recv]
+		if kind == "" && recv != "" {
+			kind = fs.kinds[recv]
+		}
+		if kind == "" && c.This != nil && c.This.Kind == ir.CallResult && c.This.Call != nil {
+			kind = sc.constructs(c.This.Call)
+		}
+		cur := open[recv]
+		if recv == "" {
+			cur = nil
+		}
+		switch c.Callee.Atom {
+		case "open":
+			if len(c.Args) < 2 {
+				continue
+			}
+			m := sc.vals.Fold(c.Args[0])
+			if kind != XHR && !(m.IsLit(ir.LitString) && methods[strings.ToUpper(m.Str)]) {
+				continue
+			}
+			e := endpoint(XHR, recv, c)
+			e.URL = sc.template(c.Args[1], 0)
+			if m.IsLit(ir.LitString) {
+				e.Method = strings.ToUpper(m.Str)
+			} else {
+				e.Method = "{" + m.String() + "}"
+			}
+		case "setRequestHeader":
+			if len(c.Args) < 2 || kind != XHR && cur == nil {
+				continue
+			}
+			if cur == nil {
+				cur = endpoint(XHR, recv, c)
+			} else {
+				cur.Evidence = append(cur.Evidence, evidence(c))
+			}
+			cur.Headers = append(cur.Headers, Header{Name: sc.template(c.Args[0], 0), Value: sc.template(c.Args[1], 0)})
+		case "send":
+			if kind == "" && cur != nil {
+				kind = cur.Kind
+			}
+			if kind != XHR && kind != WebSocket {
+				continue
+			}
+			if cur == nil || cur.Kind != kind {
+				cur = endpoint(kind, recv, c)
+			} else {
+				cur.Evidence = append(cur.Evidence, evidence(c))
+			}
+			if len(c.Args) == 0 {
+				continue
+			}
+			body := sc.body(c.Args[0])
+			if kind == XHR {
+				cur.Body = body
+			} else {
+				cur.Messages = append(cur.Messages, body)
+			}
+		}
+	}
+
+	// URL literals not already part of a request of this function.
+	for _, u := range urlLits {
+		dup := false
+		for _, e := range out {
+			if strings.Contains(e.URL, u.Text) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			u.Line = lines.Line(u.Off)
+			out = append(out, &Endpoint{Kind: URL, Func: fn, Off: u.Off, Line: u.Line, URL: u.Text, Evidence: []Evidence{u}})
+		}
+	}
+	return out
+}
+
+// constructs returns the kind of connection c creates, if any.
+func (sc *scanner) constructs(c *ir.Call) string {
+	k := constructors[sc.vals.Target(c)]
+	if k == WebSocket && c.Kind != ir.CallNew || k == XHR && c.Kind == ir.CallNew && sc.vals.Target(c) != "XMLHttpRequest" {
+		return ""
+	}
+	return k
+}
+
+// shape returns the object literal e is, or is stored in.
+func (sc *scanner) shape(e *ir.Expr) *shape {
+	if sh := sc.shapes[e]; sh != nil {
+		return sh
+	}
+	if k := varKey(e); k != "" {
+		return sc.vars[k]
+	}
+	return nil
+}
+
+// value folds e, looking through a local variable to the value last
+// stored in it when the variable itself does not fold.
+func (sc *scanner) value(e *ir.Expr) *ir.Expr {
+	f := sc.vals.Fold(e)
+	if f == nil || f.Kind == ir.Lit {
+		return f
+	}
+	if k := varKey(f); k != "" && !globalKey(k) {
+		if v := sc.assigned[k]; v != nil && v != e {
+			return sc.vals.Fold(v)
+		}
+	}
+	return f
+}
+
+// template renders a string expression with its unfolded parts as
+// {expr} placeholders.
+func (sc *scanner) template(e *ir.Expr, depth int) string {
+	e = sc.value(e)
+	switch {
+	case e.IsLit(ir.LitString):
+		return e.Str
+	case e.Kind == ir.Lit:
+		return e.String()
+	case e.Kind == ir.Op && e.Op == opAdd && len(e.Args) == 2 && depth < 16:
+		return sc.template(e.Args[0], depth+1) + sc.template(e.Args[1], depth+1)
+	}
+	return "{" + e.String() + "}"
+}
+
+// body renders a send argument: JSON.stringify of an object literal as
+// "json {shape}", other object literals as their shape, strings as
+// templates.
+func (sc *scanner) body(e *ir.Expr) string {
+	if sc.shape(e) == nil {
+		e = sc.value(e)
+	}
+	if e.Kind == ir.CallResult && e.Call != nil && sc.vals.Target(e.Call) == "JSON.stringify" && len(e.Call.Args) > 0 {
+		return "json " + sc.render(e.Call.Args[0], 0)
+	}
+	if sh := sc.shape(e); sh != nil {
+		return sc.render(e, 0)
+	}
+	if e.IsLit(ir.LitString) || e.Kind == ir.Op && e.Op == opAdd {
+		return fmt.Sprintf("%q", sc.template(e, 0))
+	}
+	return e.String()
+}
+
+// render renders an object literal as {key: value, ...}, recursing into
+// nested literals.
+func (sc *scanner) render(e *ir.Expr, depth int) string {
+	sh := sc.shape(e)
+	if sh == nil || depth > 4 {
+		return sc.vals.Fold(e).String()
+	}
+	parts := make([]string, len(sh.keys))
+	for i, k := range sh.keys {
+		parts[i] = k + ": " + sc.render(sh.vals[k], depth+1)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// varKey identifies the variable or property path e reads, or "".
+func varKey(e *ir.Expr) string {
+	if e == nil {
+		return ""
+	}
+	switch e.Kind {
+	case ir.Name:
+		return e.Atom
+	case ir.This:
+		return "this"
+	case ir.Local:
+		return fmt.Sprintf("local:%d", e.Slot)
+	case ir.Arg:
+		return fmt.Sprintf("arg:%d", e.Slot)
+	case ir.Aliased:
+		return fmt.Sprintf("aliased:%d:%d", e.Hops, e.Slot)
+	case ir.Prop:
+		if base := varKey(e.Obj); base != "" {
+			return base + "." + e.Atom
+		}
+	}
+	return ""
+}
+
+// globalKey reports whether a variable key names the same storage in
+// every function of a file (a global or a property of this).
+func globalKey(k string) bool {
+	return !strings.HasPrefix(k, "local:") && !strings.HasPrefix(k, "arg:") && !strings.HasPrefix(k, "aliased:")
+}
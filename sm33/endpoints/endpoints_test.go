@@ -0,0 +1,75 @@
+package endpoints
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+// callOn emits recv.<method>( with the receiver left as this.
+func callOn(recv []byte, method uint32) []byte {
+	return Asm(recv, []byte{OpDup}, AtomOp(OpCallprop, method), []byte{OpSwap})
+}
+
+// tree builds:
+//
+//	var xhr = cc.loader.getXMLHttpRequest();
+//	xhr.open("POST", "https://api.example" + "/login");
+//	xhr.setRequestHeader("Content-Type", "application/json");
+//	var msg = { uid: uid };
+//	msg.token = "x";
+//	xhr.send(JSON.stringify(msg));
+//	this.ws = new WebSocket("wss://chat.example/ws");
+//	this.ws.send("ping");
+//	"https://cdn.example/x";
+func tree() *sm33.Script {
+	xhr := LocalOp(OpGetlocal, 0)
+	return &sm33.Script{
+		Atoms: []string{
+			"cc", "loader", "getXMLHttpRequest", "open", "POST", "https://api.example", "/login", // 0
+			"setRequestHeader", "Content-Type", "application/json", "uid", "token", "x", // 7
+			"send", "JSON", "stringify", "WebSocket", "wss://chat.example/ws", "ws", "ping", // 13
+			"https://cdn.example/x", // 20
+		},
+		Bytecode: Asm(
+			callOn(Asm(AtomOp(OpGetgname, 0), AtomOp(OpGetprop, 1)), 2), []byte{OpCall, 0, 0}, LocalOp(OpSetlocal, 0), []byte{OpPop}, // 0
+			callOn(xhr, 3), AtomOp(OpString, 4), AtomOp(OpString, 5), AtomOp(OpString, 6), []byte{OpAdd, OpCall, 0, 2, OpPop}, // 25
+			callOn(xhr, 7), AtomOp(OpString, 8), AtomOp(OpString, 9), []byte{OpCall, 0, 2, OpPop}, // 56
+			[]byte{OpNewinit, 1, 0, 0, 0}, AtomOp(OpName, 10), AtomOp(OpInitprop, 10), []byte{OpEndinit}, LocalOp(OpSetlocal, 1), []byte{OpPop}, // 81
+			LocalOp(OpGetlocal, 1), AtomOp(OpString, 12), AtomOp(OpSetprop, 11), []byte{OpPop}, // 102
+			callOn(xhr, 13), callOn(AtomOp(OpGetgname, 14), 15), LocalOp(OpGetlocal, 1), []byte{OpCall, 0, 1, OpCall, 0, 1, OpPop}, // 117
+			[]byte{OpThis}, AtomOp(OpGetgname, 16), []byte{OpUndefined}, AtomOp(OpString, 17), []byte{OpNew, 0, 1}, AtomOp(OpSetprop, 18), []byte{OpPop}, // 151
+			callOn(Asm([]byte{OpThis}, AtomOp(OpGetprop, 18)), 13), AtomOp(OpString, 19), []byte{OpCall, 0, 1, OpPop}, // 172
+			AtomOp(OpString, 20), []byte{OpPop}, // 194
+			[]byte{OpRetrval},
+		),
+	}
+}
+
+func TestScan(t *testing.T) {
+	es := Scan("t.jsc", tree())
+	if len(es) != 3 {
+		t.Fatalf("endpoints = %+v", es)
+	}
+	x := es[0]
+	if x.Kind != XHR || x.Method != "POST" || x.URL != "https://api.example/login" || x.Off != 52 || x.File != "t.jsc" || x.Func != "main" {
+		t.Errorf("xhr = %+v", x)
+	}
+	if len(x.Headers) != 1 || x.Headers[0] != (Header{"Content-Type", "application/json"}) {
+		t.Errorf("headers = %+v", x.Headers)
+	}
+	if x.Body != `json {uid: uid, token: "x"}` {
+		t.Errorf("body = %q", x.Body)
+	}
+	if len(x.Evidence) != 3 || x.Evidence[1].Off != 77 || x.Evidence[2].Off != 147 {
+		t.Errorf("evidence = %+v", x.Evidence)
+	}
+	ws := es[1]
+	if ws.Kind != WebSocket || ws.URL != "wss://chat.example/ws" || len(ws.Messages) != 1 || ws.Messages[0] != `"ping"` || len(ws.Evidence) != 2 {
+		t.Errorf("websocket = %+v", ws)
+	}
+	if u := es[2]; u.Kind != URL || u.URL != "https://cdn.example/x" || u.Off != 194 {
+		t.Errorf("url = %+v", u)
+	}
+}
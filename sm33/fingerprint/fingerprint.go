@@ -0,0 +1,308 @@
+// Package fingerprint computes per-function fingerprints that survive a
+// rebuild, and matches the functions of two script trees with them.
+//
+// A fingerprint has three parts:
+//
+//   - Exact hashes the normalized instruction sequence: opcode names with
+//     operands reduced to classes (an atom is "id" or "str", a number
+//     literal is "int" or "num", a lambda is "fn"), jump offsets and line
+//     numbers dropped, argument counts and variable slots kept. It is
+//     independent of offsets, atom and constant indices, and names.
+//   - CFG hashes the shape of the control flow graph: blocks in offset
+//     order with their successor kinds, loop depths and terminators.
+//   - MinHash is a signature over trigrams of the normalized sequence;
+//     together with the referenced atom strings it gives a fuzzy
+//     Similarity score in [0, 1] for functions that changed slightly.
+package fingerprint
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+)
+
+// Opcodes with operand classes of their own.
+const (
+	opNop         = 0
+	opDouble      = 60
+	opTableswitch = 70
+	opObject      = 80
+	opUint16      = 88
+	opNewobject   = 91
+	opLineno      = 119
+	opDeffun      = 127
+	opLambda      = 130
+	opLambdaArrow = 131
+	opRegexp      = 160
+	opUint24      = 188
+	opInt8        = 215
+	opInt32       = 216
+)
+
+// NumHashes is the length of a MinHash signature.
+const NumHashes = 64
+
+// Fingerprint identifies one function of a script tree.
+type Fingerprint struct {
+	Name    string   `json:"name"` // display name, see package names
+	Nargs   int      `json:"nargs"`
+	Ops     int      `json:"ops"` // normalized instruction count
+	Blocks  int      `json:"blocks"`
+	Exact   string   `json:"exact"`
+	CFG     string   `json:"cfg"`
+	MinHash []uint64 `json:"minhash"`
+	Atoms   []string `json:"atoms,omitempty"` // distinct atoms referenced, sorted
+
+	Func *sm33.Function `json:"-"` // nil for main
+}
+
+// Compute fingerprints main and every function of the tree, depth-first
+// in object order.
+func Compute(root *sm33.Script) []*Fingerprint {
+	n := names.Infer(root)
+	out := []*Fingerprint{Of(root, "main")}
+	var walk func(s *sm33.Script)
+	walk = func(s *sm33.Script) {
+		for _, obj := range s.Objects {
+			fn := obj.Function
+			if obj.Kind != sm33.CkJSFunction || fn == nil || fn.Script == nil {
+				continue
+			}
+			fp := Of(fn.Script, n.Of(fn))
+			fp.Func = fn
+			out = append(out, fp)
+			walk(fn.Script)
+		}
+	}
+	walk(root)
+	return out
+}
+
+// Of fingerprints the bytecode of s alone.
+func Of(s *sm33.Script, name string) *Fingerprint {
+	tokens := Normalize(s)
+	fp := &Fingerprint{
+		Name:    name,
+		Nargs:   int(s.Nargs),
+		Ops:     len(tokens),
+		Exact:   hash(strconv.Itoa(int(s.Nargs)) + "|" + strings.Join(tokens, " ")),
+		MinHash: minHash(tokens),
+		Atoms:   atoms(s),
+	}
+	cfg := callgraph.BuildFuncCFG(s, name)
+	fp.Blocks = len(cfg.Blocks)
+	fp.CFG = cfgHash(cfg)
+	return fp
+}
+
+// Normalize returns the normalized instruction sequence of s, one token
+// per instruction.
+func Normalize(s *sm33.Script) []string {
+	bc := s.Bytecode
+	var out []string
+	for _, in := range ir.Decode(bc) {
+		if in.Op == opNop || in.Op == opLineno {
+			continue
+		}
+		tok := in.Name()
+		if class := operandClass(s, in); class != "" {
+			tok += ":" + class
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+func operandClass(s *sm33.Script, in ir.Instr) string {
+	bc := s.Bytecode
+	if in.Off+in.Len > len(bc) {
+		return ""
+	}
+	switch in.Op {
+	case opUint16, opUint24, opInt8, opInt32:
+		return "int"
+	case opDouble:
+		return "num"
+	case opDeffun, opLambda, opLambdaArrow:
+		return "fn"
+	case opObject, opNewobject:
+		return "obj"
+	case opRegexp:
+		return "re"
+	case opTableswitch:
+		return strconv.Itoa(len(ir.JumpTargets(bc, in.Off)))
+	}
+	switch bytecode.JofType(bytecode.Opcodes[in.Op].Format) {
+	case bytecode.JOF_ATOM, bytecode.JOF_ATOMOBJECT:
+		return atomClass(ir.Atom(s, in.Off))
+	case bytecode.JOF_UINT16, bytecode.JOF_QARG:
+		v, _ := bytecode.GetUint16(bc, in.Off)
+		return strconv.Itoa(int(v))
+	case bytecode.JOF_UINT8:
+		return strconv.Itoa(int(bc[in.Off+1]))
+	case bytecode.JOF_UINT24, bytecode.JOF_LOCAL:
+		v, _ := bytecode.GetUint24(bc, in.Off)
+		return strconv.Itoa(int(v))
+	case bytecode.JOF_SCOPECOORD:
+		slot, _ := bytecode.GetUint24(bc, in.Off+1)
+		return fmt.Sprintf("%d.%d", bc[in.Off+1], slot)
+	}
+	return ""
+}
+
+// atomClass reduces an atom to "id" for identifier-like names and "str"
+// for any other string, so renamed properties and edited literals keep
+// the shape of the code.
+func atomClass(a string) string {
+	if a == "" {
+		return "str"
+	}
+	for i, r := range a {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return "str"
+		}
+	}
+	return "id"
+}
+
+// atoms returns the distinct atoms the instructions of s reference.
+func atoms(s *sm33.Script) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, in := range ir.Decode(s.Bytecode) {
+		switch bytecode.JofType(bytecode.Opcodes[in.Op].Format) {
+		case bytecode.JOF_ATOM, bytecode.JOF_ATOMOBJECT:
+			if a := ir.Atom(s, in.Off); !seen[a] {
+				seen[a] = true
+				out = append(out, a)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// cfgHash hashes block structure: per block, its successors relative to
+// block order, their condition and edge kind, and whether it terminates.
+func cfgHash(f *callgraph.FuncCFG) string {
+	var b strings.Builder
+	for _, blk := range f.Blocks {
+		fmt.Fprintf(&b, "%d:%d:%t:%t[", blk.ID, blk.LoopDepth, blk.Term, blk.Suspend)
+		for _, s := range blk.Succs {
+			fmt.Fprintf(&b, "%d%s%s%t,", s.BlockID-blk.ID, s.Cond, s.Via, s.Back)
+		}
+		b.WriteString("]")
+	}
+	return hash(b.String())
+}
+
+func hash(s string) string {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// minHash returns the signature of the trigram set of tokens.
+func minHash(tokens []string) []uint64 {
+	sig := make([]uint64, NumHashes)
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	n := 3
+	if len(tokens) < n {
+		n = len(tokens)
+	}
+	for i := 0; i+n <= len(tokens) && n > 0; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+n], " ")))
+		x := h.Sum64()
+		for k := range sig {
+			if v := mix(x ^ seeds[k]); v < sig[k] {
+				sig[k] = v
+			}
+		}
+	}
+	return sig
+}
+
+// seeds are the per-hash salts of the MinHash family.
+var seeds = func() [NumHashes]uint64 {
+	var s [NumHashes]uint64
+	for i := range s {
+		s[i] = mix(uint64(i) + 1)
+	}
+	return s
+}()
+
+// mix is the splitmix64 finalizer.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Similarity scores how alike two functions are, from 0 (unrelated) to 1
+// (same normalized code, same atoms). Instruction trigrams weigh most,
+// then referenced atoms, then control flow shape.
+func Similarity(a, b *Fingerprint) float64 {
+	if a.Exact == b.Exact && equalStrings(a.Atoms, b.Atoms) {
+		return 1
+	}
+	ops := 0.0
+	if len(a.MinHash) == NumHashes && len(b.MinHash) == NumHashes {
+		same := 0
+		for k := range a.MinHash {
+			if a.MinHash[k] == b.MinHash[k] {
+				same++
+			}
+		}
+		ops = float64(same) / NumHashes
+	}
+	cfg := 0.0
+	if a.CFG == b.CFG {
+		cfg = 1
+	}
+	return 0.6*ops + 0.3*jaccard(a.Atoms, b.Atoms) + 0.1*cfg
+}
+
+// jaccard returns |a∩b| / |a∪b| for sorted sets; two empty sets are equal.
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	inter := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			inter++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
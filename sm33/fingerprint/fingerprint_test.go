@@ -0,0 +1,93 @@
+package fingerprint
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+func fn(name string, nargs uint16, atoms []string, bc []byte) *sm33.Object {
+	return FnObject(&sm33.Script{Nargs: nargs, Atoms: atoms, Bytecode: bc}, name)
+}
+
+// build returns a tree of three functions: getX (named), an anonymous
+// getter of cc.director.getWinSize(), and an anonymous arithmetic helper.
+// The rebuild reorders atoms and objects, adds line numbers and changes
+// the helper slightly.
+func build(rebuild bool) *sm33.Script {
+	x, cc, dir, ws, ln := uint32(0), uint32(0), uint32(1), uint32(2), uint32(1)
+	atomsA := []string{"cc", "director", "getWinSize"}
+	atomsH := []string{"x", "len"}
+	var lineno []byte
+	if rebuild {
+		cc, dir, ws, ln = 2, 0, 1, 0
+		atomsA = []string{"director", "getWinSize", "cc"}
+		atomsH = []string{"len", "x"}
+		x = 1
+		lineno = []byte{OpLineno, 0, 7}
+	}
+	getX := fn("getX", 1, atomsH, Asm(lineno,
+		[]byte{OpGetarg, 0, 0}, AtomOp(OpGetprop, x), []byte{OpOne, OpAdd, OpReturn}))
+	winSize := fn("", 0, atomsA, Asm(
+		AtomOp(OpName, cc), AtomOp(OpGetprop, dir),
+		[]byte{OpDup}, AtomOp(OpCallprop, ws), []byte{OpSwap}, []byte{OpCall, 0, 0}, []byte{OpReturn}))
+	tail := []byte{OpReturn}
+	if rebuild {
+		tail = []byte{OpNeg, OpReturn}
+	}
+	helper := fn("", 2, atomsH, Asm(
+		[]byte{OpGetarg, 0, 0, OpInt8, 1, OpAdd, OpGetarg, 0, 1, OpInt8, 2, OpMul, OpSub},
+		[]byte{OpGetarg, 0, 0}, AtomOp(OpGetprop, ln), []byte{OpAdd},
+		[]byte{OpGetarg, 0, 1}, AtomOp(OpGetprop, ln), []byte{OpSub},
+		[]byte{OpDup, OpSetlocal, 0, 0, 0, OpPop, OpGetlocal, 0, 0, 0},
+		tail))
+	objs := []*sm33.Object{getX, winSize, helper}
+	if rebuild {
+		objs = []*sm33.Object{helper, winSize, getX}
+	}
+	return &sm33.Script{Objects: objs, Bytecode: []byte{OpRetrval}}
+}
+
+func TestStableAcrossRebuild(t *testing.T) {
+	a, b := Compute(build(false)), Compute(build(true))
+	if len(a) != 4 || len(b) != 4 {
+		t.Fatalf("got %d and %d fingerprints, want 4", len(a), len(b))
+	}
+	// getX and the getter are the same code at shifted offsets and atom
+	// indices; the helper gained an instruction.
+	if a[1].Exact != b[3].Exact || a[2].Exact != b[2].Exact || a[3].Exact == b[1].Exact {
+		t.Errorf("exact hashes: %s %s %s vs %s %s %s", a[1].Exact, a[2].Exact, a[3].Exact, b[3].Exact, b[2].Exact, b[1].Exact)
+	}
+	if s := Similarity(a[3], b[1]); s < MinScore || s >= 1 {
+		t.Errorf("helper similarity = %.2f, want in [%.1f, 1)", s, MinScore)
+	}
+	if s := Similarity(a[1], a[2]); s >= MinScore {
+		t.Errorf("unrelated similarity = %.2f", s)
+	}
+
+	m := MatchTrees(a, b)
+	want := map[string]string{"main": "main", "getX": "getX", "anon#1": "anon#1", "anon#2": "anon#0"}
+	if len(m.Matches) != len(want) || len(m.OnlyA) != 0 || len(m.OnlyB) != 0 {
+		t.Fatalf("matches %d, only %d/%d", len(m.Matches), len(m.OnlyA), len(m.OnlyB))
+	}
+	for _, x := range m.Matches {
+		if want[x.A.Name] != x.B.Name {
+			t.Errorf("%s matched %s (%s %.2f), want %s", x.A.Name, x.B.Name, x.By, x.Score, want[x.A.Name])
+		}
+	}
+	if by := m.Matches[3].By; by != "fuzzy" {
+		t.Errorf("helper matched by %s, want fuzzy", by)
+	}
+}
+
+func TestTruncatedOperand(t *testing.T) {
+	s := &sm33.Script{Bytecode: []byte{OpIter}}
+	if got := operandClass(s, ir.Instr{Off: 0, Op: OpIter, Len: 2}); got != "" {
+		t.Errorf("operandClass = %q, want empty", got)
+	}
+	if got := Normalize(s); len(got) != 0 {
+		t.Errorf("Normalize = %q, want nothing", got)
+	}
+}
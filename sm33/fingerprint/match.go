@@ -0,0 +1,139 @@
+package fingerprint
+
+import (
+	"sort"
+	"strings"
+)
+
+// Match pairs a function of the old tree with one of the new tree.
+type Match struct {
+	A, B  *Fingerprint
+	Score float64 // Similarity(A, B)
+	By    string  // "exact", "name" or "fuzzy"
+}
+
+// Matching is the result of matching two trees.
+type Matching struct {
+	Matches []Match
+	OnlyA   []*Fingerprint // functions of a with no counterpart
+	OnlyB   []*Fingerprint // functions of b with no counterpart
+}
+
+// MinScore is the Similarity a fuzzy match needs. Same-named functions
+// only need MinNameScore.
+const (
+	MinScore     = 0.6
+	MinNameScore = 0.3
+)
+
+// MatchTrees pairs the functions of a and b in three passes: fingerprints
+// whose Exact hash together with their atoms, then Exact alone, is unique
+// on both sides; functions
+// with the same stable display name; then the best-scoring remaining pairs
+// above MinScore, greedily. Anonymous names (anon#N) are not stable across
+// builds and are only matched by fingerprint.
+func MatchTrees(a, b []*Fingerprint) *Matching {
+	m := &Matching{}
+	usedA := map[*Fingerprint]bool{}
+	usedB := map[*Fingerprint]bool{}
+	pair := func(x, y *Fingerprint, by string) {
+		usedA[x], usedB[y] = true, true
+		m.Matches = append(m.Matches, Match{A: x, B: y, Score: Similarity(x, y), By: by})
+	}
+
+	for _, key := range []func(*Fingerprint) string{
+		func(f *Fingerprint) string { return f.Exact + strings.Join(f.Atoms, "\x00") },
+		func(f *Fingerprint) string { return f.Exact },
+	} {
+		ka, kb := unique(a, usedA, key), unique(b, usedB, key)
+		for k, x := range ka {
+			if y, ok := kb[k]; ok && x != nil && y != nil {
+				pair(x, y, "exact")
+			}
+		}
+	}
+
+	byName := map[string]*Fingerprint{}
+	for _, y := range b {
+		if !usedB[y] && stableName(y.Name) {
+			byName[y.Name] = y
+		}
+	}
+	for _, x := range a {
+		if y, ok := byName[x.Name]; ok && !usedA[x] && Similarity(x, y) >= MinNameScore {
+			pair(x, y, "name")
+		}
+	}
+
+	var cands []Match
+	for _, x := range a {
+		if usedA[x] {
+			continue
+		}
+		for _, y := range b {
+			if usedB[y] || !Comparable(x, y) {
+				continue
+			}
+			if s := Similarity(x, y); s >= MinScore {
+				cands = append(cands, Match{A: x, B: y, Score: s, By: "fuzzy"})
+			}
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].Score > cands[j].Score })
+	for _, c := range cands {
+		if !usedA[c.A] && !usedB[c.B] {
+			pair(c.A, c.B, c.By)
+		}
+	}
+
+	for _, x := range a {
+		if !usedA[x] {
+			m.OnlyA = append(m.OnlyA, x)
+		}
+	}
+	for _, y := range b {
+		if !usedB[y] {
+			m.OnlyB = append(m.OnlyB, y)
+		}
+	}
+	order := map[*Fingerprint]int{}
+	for i, x := range a {
+		order[x] = i
+	}
+	sort.SliceStable(m.Matches, func(i, j int) bool { return order[m.Matches[i].A] < order[m.Matches[j].A] })
+	return m
+}
+
+// unique maps key to the single unused fingerprint with that key, or nil
+// when several share it.
+func unique(fs []*Fingerprint, used map[*Fingerprint]bool, key func(*Fingerprint) string) map[string]*Fingerprint {
+	out := map[string]*Fingerprint{}
+	for _, f := range fs {
+		if used[f] {
+			continue
+		}
+		k := key(f)
+		if _, dup := out[k]; dup {
+			out[k] = nil
+		} else {
+			out[k] = f
+		}
+	}
+	return out
+}
+
+// stableName reports whether a display name survives rebuilds: it has no
+// anon#N component and no #N deduplication suffix.
+func stableName(name string) bool {
+	return !strings.Contains(name, "#")
+}
+
+// Comparable reports whether x and y are close enough in size to be
+// worth scoring; it prunes pairs whose sizes differ by more than half.
+func Comparable(x, y *Fingerprint) bool {
+	lo, hi := x.Ops, y.Ops
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return hi <= 2*lo+4
+}
@@ -0,0 +1,367 @@
+// Package identify guesses the engine and framework a bundle of compiled
+// scripts was built with.
+//
+// Every file contributes evidence: its XDR magic (which pins the
+// SpiderMonkey bytecode version), the JSVersion in its script headers,
+// the opcodes it uses, the source filename the compiler recorded, version
+// strings among its atoms, and, given a signature database, the library
+// functions it contains. Each piece of evidence backs a candidate with a
+// weight; a candidate's confidence is the noisy-or of the strongest weight
+// of each rule behind it, so independent clues reinforce each other while
+// a hundred files with the same clue count once.
+package identify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+// xdrBase is the value XDR magics count down from: the magic of bytecode
+// version N is xdrBase - N.
+const xdrBase = 0xb973c0de
+
+// Report is the result of identifying a bundle.
+type Report struct {
+	Files     int          `json:"files"`
+	Decoded   int          `json:"decoded"`
+	Engine    []*Candidate `json:"engine"`
+	Framework []*Candidate `json:"framework"`
+	Versions  []Count      `json:"versions"` // JSVersion of decoded scripts
+	Features  []Count      `json:"features"` // language features by opcode
+	Opcodes   int          `json:"opcodes"`  // distinct opcodes used
+	Errors    []string     `json:"errors,omitempty"`
+}
+
+// Candidate is one guess with its confidence in [0, 1] and the evidence
+// behind it, strongest first.
+type Candidate struct {
+	Name       string   `json:"name"`
+	Confidence float64  `json:"confidence"`
+	Evidence   []string `json:"evidence"`
+
+	rules map[string]*clue
+}
+
+type clue struct {
+	weight float64
+	text   string
+	files  int
+}
+
+// Count is a value and how many files it was seen in.
+type Count struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+}
+
+// Identifier accumulates evidence over the files of a bundle.
+type Identifier struct {
+	db  *sigdb.DB
+	opt sm33.Options
+
+	files, decoded int
+	engine         map[string]*Candidate
+	framework      map[string]*Candidate
+	versions       map[string]int
+	features       map[string]int
+	ops            [256]bool
+	matched        map[string]map[string]bool // library → signature names seen
+	errors         []string
+}
+
+// New returns an Identifier. db may be nil. Files are decoded in Strict
+// mode whatever opt says, so a file only counts as SpiderMonkey 33
+// bytecode if it decodes cleanly.
+func New(db *sigdb.DB, opt sm33.Options) *Identifier {
+	return &Identifier{
+		db:        db,
+		opt:       opt,
+		engine:    map[string]*Candidate{},
+		framework: map[string]*Candidate{},
+		versions:  map[string]int{},
+		features:  map[string]int{},
+		matched:   map[string]map[string]bool{},
+	}
+}
+
+// Add records the evidence of one file.
+func (id *Identifier) Add(name string, data []byte) {
+	id.files++
+	if len(data) < 4 {
+		id.errors = append(id.errors, fmt.Sprintf("%s: too short", name))
+		return
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	if v := xdrBase - int64(magic); magic != xdr.XdrMagic && v > 0 && v < 1000 {
+		add(id.engine, "SpiderMonkey (XDR bytecode "+fmt.Sprint(v)+")", "magic", 0.6,
+			fmt.Sprintf("XDR magic 0x%08x is bytecode version %d, not the SpiderMonkey 33 version 178", magic, v))
+	}
+	res, err := xdr.DecodeOpt(data, sm33.Options{Mode: sm33.Strict, MaxReadBytes: id.opt.MaxReadBytes})
+	if err != nil {
+		id.errors = append(id.errors, fmt.Sprintf("%s: %v", name, err))
+		return
+	}
+	id.decoded++
+	s := res.Value
+	add(id.engine, "SpiderMonkey 33", "magic", 0.9, "XDR magic 0xb973c02c (bytecode version 178) decodes")
+
+	id.versions[jsVersion(s.Version)]++
+	id.filename(s.Filename)
+
+	features := map[string]bool{}
+	atoms := map[string]bool{}
+	unknown := false
+	walk(s, func(s *sm33.Script) {
+		for _, in := range ir.Decode(s.Bytecode) {
+			name := bytecode.Opcodes[in.Op].Name
+			if name == "" || strings.HasPrefix(name, "unused") {
+				unknown = true
+				continue
+			}
+			id.ops[in.Op] = true
+			if f := opFeatures[name]; f != "" {
+				features[f] = true
+			}
+		}
+		for _, a := range s.Atoms {
+			atoms[a] = true
+		}
+	})
+	for f := range features {
+		id.features[f]++
+	}
+	if !unknown {
+		add(id.engine, "SpiderMonkey 33", "opcodes", 0.5, "every opcode is in the SpiderMonkey 33 table")
+	}
+	for a := range atoms {
+		id.atom(a)
+	}
+
+	if id.db != nil {
+		for _, l := range id.db.Match(s) {
+			if l.By == "inner" {
+				continue
+			}
+			if id.matched[l.Library] == nil {
+				id.matched[l.Library] = map[string]bool{}
+			}
+			id.matched[l.Library][l.Name] = true
+		}
+	}
+}
+
+// Report summarizes the evidence so far.
+func (id *Identifier) Report() *Report {
+	r := &Report{
+		Files:     id.files,
+		Decoded:   id.decoded,
+		Versions:  counts(id.versions),
+		Features:  counts(id.features),
+		Errors:    id.errors,
+		Engine:    []*Candidate{},
+		Framework: []*Candidate{},
+	}
+	for _, seen := range id.ops {
+		if seen {
+			r.Opcodes++
+		}
+	}
+	if id.db != nil {
+		for _, lib := range id.db.Libraries {
+			n := len(id.matched[lib.Name])
+			if n == 0 || lib.Functions == 0 {
+				continue
+			}
+			// Set rather than add: the counts are totals, and Report may
+			// run more than once.
+			candidate(id.framework, lib.Name).rules["signatures"] = &clue{
+				weight: min(0.95, float64(n)/float64(lib.Functions)),
+				text:   fmt.Sprintf("%d of %d signatures matched", n, lib.Functions),
+			}
+		}
+	}
+	r.Engine = rank(id.engine)
+	r.Framework = rank(id.framework)
+	return r
+}
+
+// walk calls f on s and every function script below it.
+func walk(s *sm33.Script, f func(*sm33.Script)) {
+	f(s)
+	for _, obj := range s.Objects {
+		if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+			walk(fn.Script, f)
+		}
+	}
+}
+
+// add records evidence text for rule behind candidate name.
+func add(m map[string]*Candidate, name, rule string, weight float64, text string) {
+	c := candidate(m, name)
+	cl := c.rules[rule]
+	if cl == nil {
+		cl = &clue{}
+		c.rules[rule] = cl
+	}
+	cl.files++
+	if weight > cl.weight {
+		cl.weight, cl.text = weight, text
+	}
+}
+
+func candidate(m map[string]*Candidate, name string) *Candidate {
+	c := m[name]
+	if c == nil {
+		c = &Candidate{Name: name, rules: map[string]*clue{}}
+		m[name] = c
+	}
+	return c
+}
+
+// rank computes confidences and orders candidates by them.
+func rank(m map[string]*Candidate) []*Candidate {
+	out := []*Candidate{}
+	for _, c := range m {
+		var clues []*clue
+		miss := 1.0
+		for _, cl := range c.rules {
+			miss *= 1 - cl.weight
+			clues = append(clues, cl)
+		}
+		sort.Slice(clues, func(i, j int) bool {
+			if clues[i].weight != clues[j].weight {
+				return clues[i].weight > clues[j].weight
+			}
+			return clues[i].text < clues[j].text
+		})
+		c.Confidence = 1 - miss
+		c.Evidence = []string{}
+		for _, cl := range clues {
+			text := cl.text
+			if cl.files > 1 {
+				text += fmt.Sprintf(" (%d files)", cl.files)
+			}
+			c.Evidence = append(c.Evidence, text)
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Confidence != out[j].Confidence {
+			return out[i].Confidence > out[j].Confidence
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func counts(m map[string]int) []Count {
+	out := []Count{}
+	for k, n := range m {
+		out = append(out, Count{k, n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Files != out[j].Files {
+			return out[i].Files > out[j].Files
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// jsVersion names a JSVersion header value.
+func jsVersion(v uint32) string {
+	switch v {
+	case 0:
+		return "default"
+	case 148:
+		return "148 (ECMA_3)"
+	case 160:
+		return "160 (1.6)"
+	case 170:
+		return "170 (1.7)"
+	case 180:
+		return "180 (1.8)"
+	case 185:
+		return "185 (ECMA_5)"
+	}
+	return fmt.Sprint(v)
+}
+
+// opFeatures maps opcodes to the language feature that emits them.
+var opFeatures = map[string]string{
+	"spreadcall":     "spread",
+	"spreadnew":      "spread",
+	"spreadeval":     "spread",
+	"lambda_arrow":   "arrow functions",
+	"generator":      "generators",
+	"yield":          "generators",
+	"pushblockscope": "block scope (let)",
+	"rest":           "rest parameters",
+	"enterwith":      "with",
+	"mutateproto":    "__proto__ literals",
+	"eval":           "eval",
+	"debugger":       "debugger",
+}
+
+// filenameRules map the source paths the compiler recorded to frameworks.
+var filenameRules = []struct {
+	re        *regexp.Regexp
+	framework string
+	weight    float64
+	text      string
+}{
+	{regexp.MustCompile(`(^|/)frameworks/runtime-src/`), "Cocos2d-x 3.x", 0.5, "project layout frameworks/runtime-src"},
+	{regexp.MustCompile(`(^|/)script/jsb_[a-z0-9_]+\.js$`), "Cocos2d-x 3.x", 0.5, "JSB binding script script/jsb_*.js"},
+	{regexp.MustCompile(`(^|/)script/studio/`), "Cocos2d-x 3.x", 0.3, "Cocos Studio parsers script/studio"},
+	{regexp.MustCompile(`(^|/)(jsb_boot|ccboot)\.js$`), "Cocos2d-x 3.x", 0.5, "boot script jsb_boot.js/CCBoot.js"},
+	{regexp.MustCompile(`(^|/)frameworks/cocos2d-html5/`), "Cocos2d-JS", 0.5, "engine sources frameworks/cocos2d-html5"},
+	{regexp.MustCompile(`(^|/)jsb-adapter/`), "Cocos Creator", 0.6, "Creator jsb-adapter"},
+	{regexp.MustCompile(`(^|/)(cocos2d-jsb|cocos2d-js-min)\.js$`), "Cocos Creator", 0.5, "Creator engine build"},
+}
+
+func (id *Identifier) filename(name string) {
+	if name == "" {
+		return
+	}
+	p := strings.ToLower(strings.ReplaceAll(name, `\`, "/"))
+	for _, r := range filenameRules {
+		if r.re.MatchString(p) {
+			add(id.framework, r.framework, "file:"+r.text, r.weight, fmt.Sprintf("%s, e.g. %s", r.text, path.Base(p)))
+		}
+	}
+}
+
+// Version strings the engines embed, e.g. cc.ENGINE_VERSION.
+var (
+	cocosVersion   = regexp.MustCompile(`(?i)^cocos2d-(js|x)[ -]v?(\d+\.\d+(?:\.\d+)?)`)
+	creatorVersion = regexp.MustCompile(`(?i)^cocos creator v?(\d+\.\d+(?:\.\d+)?)`)
+)
+
+// creatorAtoms are identifiers only Cocos Creator's module system emits.
+var creatorAtoms = map[string]bool{"_RF": true, "__require": true}
+
+func (id *Identifier) atom(a string) {
+	switch {
+	case cocosVersion.MatchString(a):
+		m := cocosVersion.FindStringSubmatch(a)
+		name := "Cocos2d-x " + m[2]
+		if strings.EqualFold(m[1], "js") {
+			name = "Cocos2d-JS " + m[2]
+		}
+		add(id.framework, name, "version", 0.9, fmt.Sprintf("version string %q", a))
+	case creatorVersion.MatchString(a):
+		add(id.framework, "Cocos Creator "+creatorVersion.FindStringSubmatch(a)[1], "version", 0.9, fmt.Sprintf("version string %q", a))
+	case creatorAtoms[a]:
+		add(id.framework, "Cocos Creator", "atom:"+a, 0.4, fmt.Sprintf("module system identifier %q", a))
+	}
+}
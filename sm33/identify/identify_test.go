@@ -0,0 +1,87 @@
+package identify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/sigdb"
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+func TestIdentify(t *testing.T) {
+	files, err := filepath.Glob("../disasm/testdata/*.jsc")
+	if err != nil || len(files) == 0 {
+		t.Fatal("no testdata", err)
+	}
+	lib, err := xdr.DecodeFile("../disasm/testdata/functions.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sigdb.New()
+	db.Add("loader-1.0", "functions.js", lib)
+
+	id := New(db, sm33.DefaultOptions())
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id.Add(f, data)
+	}
+	id.Add("old.jsc", []byte{0x2d, 0xc0, 0x73, 0xb9, 0, 0, 0, 0})
+	id.Add("empty.jsc", nil)
+
+	r := id.Report()
+	if r.Files != len(files)+2 || r.Decoded != len(files) || len(r.Errors) != 2 {
+		t.Errorf("files = %d, decoded = %d, errors = %v", r.Files, r.Decoded, r.Errors)
+	}
+	if len(r.Engine) != 2 || r.Engine[0].Name != "SpiderMonkey 33" || r.Engine[0].Confidence < 0.9 {
+		t.Fatalf("engine = %+v", r.Engine)
+	}
+	if r.Engine[1].Name != "SpiderMonkey (XDR bytecode 177)" {
+		t.Errorf("engine[1] = %+v", r.Engine[1])
+	}
+
+	fw := map[string]*Candidate{}
+	for _, c := range r.Framework {
+		fw[c.Name] = c
+	}
+	if c := fw["Cocos2d-x 3.x"]; c == nil || c.Confidence < 0.5 {
+		t.Errorf("Cocos2d-x 3.x = %+v", c)
+	}
+	if c := fw["loader-1.0"]; c == nil || c.Confidence < 0.9 || len(c.Evidence) != 1 {
+		t.Errorf("loader-1.0 = %+v", c)
+	}
+	if len(r.Versions) != 1 || r.Versions[0] != (Count{"185 (ECMA_5)", len(files)}) {
+		t.Errorf("versions = %+v", r.Versions)
+	}
+
+	// Reports are repeatable.
+	again := id.Report()
+	if len(again.Framework) != len(r.Framework) || again.Framework[0].Confidence != r.Framework[0].Confidence {
+		t.Errorf("second report differs: %+v", again.Framework)
+	}
+}
+
+func TestVersionAtoms(t *testing.T) {
+	id := New(nil, sm33.DefaultOptions())
+	for _, a := range []string{"Cocos2d-JS v3.13", "cocos2d-x-3.17.2", "Cocos Creator v2.4.3", "_RF", "cc"} {
+		id.atom(a)
+	}
+	var got []string
+	for _, c := range rank(id.framework) {
+		got = append(got, c.Name)
+	}
+	want := []string{"Cocos Creator 2.4.3", "Cocos2d-JS 3.13", "Cocos2d-x 3.17.2", "Cocos Creator"}
+	if len(got) != len(want) {
+		t.Fatalf("candidates = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidates = %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package asmtest assembles SpiderMonkey 33 bytecode for tests. It holds
+// the opcodes the package tests use and helpers that encode their
+// operands, so a test can spell a script out instruction by instruction.
+package asmtest
+
+import "github.com/zboralski/spidermonkey-dumper/sm33"
+
+// Opcodes, numbered as in sm33/bytecode.
+const (
+	OpUndefined     = 1
+	OpReturn        = 5
+	OpGoto          = 6
+	OpIfeq          = 7
+	OpIfne          = 8
+	OpSwap          = 10
+	OpDup           = 12
+	OpBitxor        = 16
+	OpBitand        = 17
+	OpEq            = 18
+	OpLt            = 20
+	OpRsh           = 25
+	OpAdd           = 27
+	OpSub           = 28
+	OpMul           = 29
+	OpMod           = 31
+	OpNeg           = 34
+	OpTypeof        = 39
+	OpGetprop       = 53
+	OpSetprop       = 54
+	OpGetelem       = 55
+	OpSetelem       = 56
+	OpCall          = 58
+	OpName          = 59
+	OpDouble        = 60
+	OpString        = 61
+	OpZero          = 62
+	OpOne           = 63
+	OpThis          = 65
+	OpTrue          = 67
+	OpIter          = 75
+	OpPop           = 81
+	OpNew           = 82
+	OpGetarg        = 84
+	OpSetarg        = 85
+	OpGetlocal      = 86
+	OpSetlocal      = 87
+	OpUint16        = 88
+	OpNewinit       = 89
+	OpNewarray      = 90
+	OpNewobject     = 91
+	OpEndinit       = 92
+	OpInitprop      = 93
+	OpInitelemArray = 96
+	OpFuncall       = 108
+	OpBindname      = 110
+	OpSetname       = 111
+	OpLineno        = 119
+	OpEval          = 123
+	OpDeffun        = 127
+	OpDefvar        = 129
+	OpLambda        = 130
+	OpSetrval       = 152
+	OpRetrval       = 153
+	OpGetgname      = 154
+	OpSetgname      = 155
+	OpRegexp        = 160
+	OpCallprop      = 184
+	OpUint24        = 188
+	OpCallelem      = 193
+	OpBindgname     = 214
+	OpInt8          = 215
+	OpInt32         = 216
+)
+
+// Asm concatenates instructions.
+func Asm(parts ...[]byte) []byte {
+	var bc []byte
+	for _, p := range parts {
+		bc = append(bc, p...)
+	}
+	return bc
+}
+
+// AtomOp encodes op with a 32-bit atom, object or constant index.
+func AtomOp(op uint8, idx uint32) []byte {
+	return []byte{op, byte(idx >> 24), byte(idx >> 16), byte(idx >> 8), byte(idx)}
+}
+
+// ArgcOp encodes op with a 16-bit argument count.
+func ArgcOp(op uint8, argc uint16) []byte {
+	return []byte{op, byte(argc >> 8), byte(argc)}
+}
+
+// JumpOp encodes op with a 32-bit jump offset relative to op.
+func JumpOp(op uint8, rel int32) []byte {
+	return []byte{op, byte(rel >> 24), byte(rel >> 16), byte(rel >> 8), byte(rel)}
+}
+
+// LocalOp encodes op with a 24-bit local slot.
+func LocalOp(op uint8, n uint32) []byte {
+	return []byte{op, byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+// FnObject wraps s as the function object of a function named name.
+func FnObject(s *sm33.Script, name string) *sm33.Object {
+	return &sm33.Object{Kind: sm33.CkJSFunction, Function: &sm33.Function{Name: name, Nargs: s.Nargs, Script: s}}
+}
+
+// Program assembles bytecode with forward and backward jump labels.
+type Program struct {
+	bc     []byte
+	labels map[string]int
+	fixups map[int]string // jump operand offset → label
+}
+
+// NewProgram returns an empty program.
+func NewProgram() *Program {
+	return &Program{labels: map[string]int{}, fixups: map[int]string{}}
+}
+
+// Op appends raw bytes.
+func (p *Program) Op(b ...byte) *Program { p.bc = append(p.bc, b...); return p }
+
+// Atom appends op with atom index idx.
+func (p *Program) Atom(op uint8, idx uint32) *Program { return p.Op(AtomOp(op, idx)...) }
+
+// Getlocal pushes local n.
+func (p *Program) Getlocal(n byte) *Program { return p.Op(OpGetlocal, 0, 0, n) }
+
+// Setlocal stores the top of the stack to local n and pops it.
+func (p *Program) Setlocal(n byte) *Program { return p.Op(OpSetlocal, 0, 0, n, OpPop) }
+
+// Getarg pushes argument n.
+func (p *Program) Getarg(n byte) *Program { return p.Op(OpGetarg, 0, n) }
+
+// Call calls with argc arguments.
+func (p *Program) Call(argc uint16) *Program { return p.Op(ArgcOp(OpCall, argc)...) }
+
+// Method calls recv.atom(args) with recv already on the stack; args
+// pushes the arguments.
+func (p *Program) Method(atom uint32, args func()) *Program {
+	p.Op(OpDup).Atom(OpCallprop, atom).Op(OpSwap)
+	args()
+	return p
+}
+
+// Label names the next instruction.
+func (p *Program) Label(name string) *Program { p.labels[name] = len(p.bc); return p }
+
+// Jump appends op jumping to label, which may be defined later.
+func (p *Program) Jump(op uint8, label string) *Program {
+	p.fixups[len(p.bc)] = label
+	return p.Op(op, 0, 0, 0, 0)
+}
+
+// Bytes resolves the jumps and returns the bytecode.
+func (p *Program) Bytes() []byte {
+	for at, l := range p.fixups {
+		rel := int32(p.labels[l] - at)
+		copy(p.bc[at+1:], []byte{byte(rel >> 24), byte(rel >> 16), byte(rel >> 8), byte(rel)})
+	}
+	return p.bc
+}
@@ -0,0 +1,595 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// installGlobals builds the prototypes and the global object with the
+// side-effect-free subset of the standard library.
+func (m *Machine) installGlobals() {
+	m.objectProto = &Object{Class: "Object", builtin: true}
+	m.functionProto = &Object{Class: "Function", Proto: m.objectProto, builtin: true}
+	m.arrayProto = &Object{Class: "Object", Proto: m.objectProto, builtin: true}
+	m.stringProto = &Object{Class: "Object", Proto: m.objectProto, builtin: true}
+	m.numberProto = &Object{Class: "Object", Proto: m.objectProto, builtin: true}
+	m.Global = &Object{Class: "global", Proto: m.objectProto}
+
+	def := func(o *Object, name string, fn Native) {
+		o.Set(name, ObjValue(m.NewNative(fn)))
+	}
+
+	def(m.objectProto, "hasOwnProperty", func(m *Machine, this Value, args []Value) (Value, error) {
+		if this.Kind != Obj {
+			return Boolean(false), nil
+		}
+		name := arg(args, 0).String()
+		_, own := this.Obj.props[name]
+		if i, ok := arrayIndex(name); ok && i < len(this.Obj.Elems) {
+			own = true
+		}
+		return Boolean(own), nil
+	})
+	def(m.objectProto, "toString", func(m *Machine, this Value, args []Value) (Value, error) {
+		if this.Kind == Obj && this.Obj.Class != "Array" {
+			return Str("[object " + this.Obj.Class + "]"), nil
+		}
+		s, err := m.toString(this)
+		if err != nil {
+			return undefined, err
+		}
+		return Str(s), nil
+	})
+
+	def(m.functionProto, "call", func(m *Machine, this Value, args []Value) (Value, error) {
+		if len(args) == 0 {
+			return m.Call(this, undefined, nil)
+		}
+		return m.Call(this, args[0], args[1:])
+	})
+	def(m.functionProto, "apply", func(m *Machine, this Value, args []Value) (Value, error) {
+		var list []Value
+		if a := arg(args, 1); a.Kind == Obj {
+			list = append(list, a.Obj.Elems...)
+		}
+		return m.Call(this, arg(args, 0), list)
+	})
+
+	m.installArray(def)
+	m.installString(def)
+
+	def(m.numberProto, "toString", func(m *Machine, this Value, args []Value) (Value, error) {
+		radix := 10
+		if r := arg(args, 0); r.Kind != Undefined {
+			radix = int(r.number())
+		}
+		f := this.number()
+		if radix == 10 || radix < 2 || radix > 36 || f != math.Trunc(f) || math.IsInf(f, 0) {
+			return Str(numberString(f)), nil
+		}
+		return Str(strconv.FormatInt(int64(f), radix)), nil
+	})
+
+	g := m.Global
+	def(g, "parseInt", func(m *Machine, this Value, args []Value) (Value, error) {
+		return Num(parseInt(arg(args, 0).String(), int(arg(args, 1).number()))), nil
+	})
+	def(g, "parseFloat", func(m *Machine, this Value, args []Value) (Value, error) {
+		return Num(parseFloat(arg(args, 0).String())), nil
+	})
+	def(g, "isNaN", func(m *Machine, this Value, args []Value) (Value, error) {
+		return Boolean(math.IsNaN(arg(args, 0).number())), nil
+	})
+	def(g, "decodeURIComponent", func(m *Machine, this Value, args []Value) (Value, error) {
+		s, err := url.PathUnescape(arg(args, 0).String())
+		if err != nil {
+			return undefined, fmt.Errorf("URIError: malformed URI sequence")
+		}
+		return Str(s), nil
+	})
+	def(g, "encodeURIComponent", func(m *Machine, this Value, args []Value) (Value, error) {
+		return Str(strings.ReplaceAll(url.QueryEscape(arg(args, 0).String()), "+", "%20")), nil
+	})
+	g.Set("NaN", Num(math.NaN()))
+	g.Set("Infinity", Num(math.Inf(1)))
+	g.Set("undefined", undefined)
+
+	str := m.NewNative(func(m *Machine, this Value, args []Value) (Value, error) {
+		if len(args) == 0 {
+			return Str(""), nil
+		}
+		s, err := m.toString(args[0])
+		if err != nil {
+			return undefined, err
+		}
+		return Str(s), nil
+	})
+	str.Set("prototype", ObjValue(m.stringProto))
+	def(str, "fromCharCode", func(m *Machine, this Value, args []Value) (Value, error) {
+		u := make([]uint16, len(args))
+		for i, a := range args {
+			u[i] = uint16(toUint32(a.number()))
+		}
+		return Str(string(utf16.Decode(u))), nil
+	})
+	g.Set("String", ObjValue(str))
+
+	num := m.NewNative(func(m *Machine, this Value, args []Value) (Value, error) {
+		return Num(arg(args, 0).number()), nil
+	})
+	num.Set("prototype", ObjValue(m.numberProto))
+	g.Set("Number", ObjValue(num))
+
+	arr := m.NewNative(func(m *Machine, this Value, args []Value) (Value, error) {
+		if len(args) == 1 && args[0].Kind == Number {
+			n := int(toUint32(args[0].Num))
+			if n > maxElems {
+				return undefined, fmt.Errorf("RangeError: invalid array length")
+			}
+			if err := m.reserve(objectSize + valueSize*n); err != nil {
+				return undefined, err
+			}
+			return ObjValue(&Object{Class: "Array", Proto: m.arrayProto, Elems: make([]Value, n)}), nil
+		}
+		return ObjValue(m.NewArray(append([]Value{}, args...))), nil
+	})
+	arr.Set("prototype", ObjValue(m.arrayProto))
+	def(arr, "isArray", func(m *Machine, this Value, args []Value) (Value, error) {
+		a := arg(args, 0)
+		return Boolean(a.Kind == Obj && a.Obj.Class == "Array"), nil
+	})
+	g.Set("Array", ObjValue(arr))
+
+	obj := m.NewNative(func(m *Machine, this Value, args []Value) (Value, error) {
+		if a := arg(args, 0); a.Kind == Obj {
+			return a, nil
+		}
+		return ObjValue(m.NewObject()), nil
+	})
+	obj.Set("prototype", ObjValue(m.objectProto))
+	def(obj, "keys", func(m *Machine, this Value, args []Value) (Value, error) {
+		a := arg(args, 0)
+		if a.Kind != Obj {
+			return undefined, fmt.Errorf("TypeError: Object.keys called on non-object")
+		}
+		var keys []Value
+		for _, k := range a.Obj.Keys() {
+			keys = append(keys, Str(k))
+		}
+		return ObjValue(m.NewArray(keys)), nil
+	})
+	g.Set("Object", ObjValue(obj))
+
+	math1 := func(f func(float64) float64) Native {
+		return func(m *Machine, this Value, args []Value) (Value, error) {
+			return Num(f(arg(args, 0).number())), nil
+		}
+	}
+	mo := m.NewObject()
+	def(mo, "floor", math1(math.Floor))
+	def(mo, "ceil", math1(math.Ceil))
+	def(mo, "abs", math1(math.Abs))
+	def(mo, "sqrt", math1(math.Sqrt))
+	def(mo, "round", math1(func(f float64) float64 { return math.Floor(f + 0.5) }))
+	def(mo, "pow", func(m *Machine, this Value, args []Value) (Value, error) {
+		return Num(math.Pow(arg(args, 0).number(), arg(args, 1).number())), nil
+	})
+	def(mo, "max", func(m *Machine, this Value, args []Value) (Value, error) {
+		r := math.Inf(-1)
+		for _, a := range args {
+			r = math.Max(r, a.number())
+		}
+		return Num(r), nil
+	})
+	def(mo, "min", func(m *Machine, this Value, args []Value) (Value, error) {
+		r := math.Inf(1)
+		for _, a := range args {
+			r = math.Min(r, a.number())
+		}
+		return Num(r), nil
+	})
+	mo.Set("PI", Num(math.Pi))
+	g.Set("Math", ObjValue(mo))
+}
+
+func (m *Machine) installArray(def func(*Object, string, Native)) {
+	p := m.arrayProto
+	elems := func(this Value) (*Object, error) {
+		if this.Kind != Obj {
+			return nil, fmt.Errorf("TypeError: array method called on %s", typeOf(this))
+		}
+		return this.Obj, nil
+	}
+	def(p, "push", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		if len(o.Elems)+len(args) > maxElems {
+			return undefined, fmt.Errorf("RangeError: array too large")
+		}
+		if err := m.reserve(valueSize * len(args)); err != nil {
+			return undefined, err
+		}
+		o.Elems = append(o.Elems, args...)
+		return Num(float64(len(o.Elems))), nil
+	})
+	def(p, "pop", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil || len(o.Elems) == 0 {
+			return undefined, err
+		}
+		v := o.Elems[len(o.Elems)-1]
+		o.Elems = o.Elems[:len(o.Elems)-1]
+		return v, nil
+	})
+	def(p, "shift", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil || len(o.Elems) == 0 {
+			return undefined, err
+		}
+		v := o.Elems[0]
+		o.Elems = append(o.Elems[:0:0], o.Elems[1:]...)
+		return v, nil
+	})
+	def(p, "unshift", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		m.alloc(valueSize * len(args))
+		o.Elems = append(append([]Value{}, args...), o.Elems...)
+		return Num(float64(len(o.Elems))), nil
+	})
+	def(p, "join", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		sep := ","
+		if s := arg(args, 0); s.Kind != Undefined {
+			sep = s.String()
+		}
+		var b strings.Builder
+		if err := join(&b, o, sep, m.reserve, map[*Object]bool{}); err != nil {
+			return undefined, err
+		}
+		return Str(b.String()), nil
+	})
+	def(p, "slice", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		start, end := sliceRange(args, len(o.Elems))
+		return ObjValue(m.NewArray(append([]Value{}, o.Elems[start:end]...))), nil
+	})
+	def(p, "concat", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		out := append([]Value{}, o.Elems...)
+		for _, a := range args {
+			if a.Kind == Obj && a.Obj.Class == "Array" {
+				out = append(out, a.Obj.Elems...)
+			} else {
+				out = append(out, a)
+			}
+		}
+		return ObjValue(m.NewArray(out)), nil
+	})
+	def(p, "reverse", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		for i, j := 0, len(o.Elems)-1; i < j; i, j = i+1, j-1 {
+			o.Elems[i], o.Elems[j] = o.Elems[j], o.Elems[i]
+		}
+		return this, nil
+	})
+	def(p, "indexOf", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		for i, e := range o.Elems {
+			if strictEquals(e, arg(args, 0)) {
+				return Num(float64(i)), nil
+			}
+		}
+		return Num(-1), nil
+	})
+	def(p, "map", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		out := make([]Value, len(o.Elems))
+		for i, e := range o.Elems {
+			v, err := m.Call(arg(args, 0), arg(args, 1), []Value{e, Num(float64(i)), this})
+			if err != nil {
+				return undefined, err
+			}
+			out[i] = v
+		}
+		return ObjValue(m.NewArray(out)), nil
+	})
+	def(p, "forEach", func(m *Machine, this Value, args []Value) (Value, error) {
+		o, err := elems(this)
+		if err != nil {
+			return undefined, err
+		}
+		for i := 0; i < len(o.Elems); i++ {
+			if _, err := m.Call(arg(args, 0), arg(args, 1), []Value{o.Elems[i], Num(float64(i)), this}); err != nil {
+				return undefined, err
+			}
+		}
+		return undefined, nil
+	})
+}
+
+func (m *Machine) installString(def func(*Object, string, Native)) {
+	p := m.stringProto
+	method := func(name string, fn func(s string, u []uint16, args []Value) (Value, error)) {
+		def(p, name, func(m *Machine, this Value, args []Value) (Value, error) {
+			if this.Kind == Undefined || this.Kind == Null {
+				return undefined, fmt.Errorf("TypeError: String.prototype.%s called on %s", name, this)
+			}
+			s := this.String()
+			return fn(s, units(s), args)
+		})
+	}
+	method("toString", func(s string, u []uint16, args []Value) (Value, error) { return Str(s), nil })
+	method("valueOf", func(s string, u []uint16, args []Value) (Value, error) { return Str(s), nil })
+	method("charAt", func(s string, u []uint16, args []Value) (Value, error) {
+		i := toInteger(arg(args, 0), len(u))
+		if i < 0 || i >= len(u) {
+			return Str(""), nil
+		}
+		return Str(fromUnits(u[i : i+1])), nil
+	})
+	method("charCodeAt", func(s string, u []uint16, args []Value) (Value, error) {
+		i := toInteger(arg(args, 0), len(u))
+		if i < 0 || i >= len(u) {
+			return Num(math.NaN()), nil
+		}
+		return Num(float64(u[i])), nil
+	})
+	method("indexOf", func(s string, u []uint16, args []Value) (Value, error) {
+		return Num(float64(unitIndex(u, units(arg(args, 0).String())))), nil
+	})
+	method("lastIndexOf", func(s string, u []uint16, args []Value) (Value, error) {
+		sub := units(arg(args, 0).String())
+		for i := len(u) - len(sub); i >= 0; i-- {
+			if equalUnits(u[i:i+len(sub)], sub) {
+				return Num(float64(i)), nil
+			}
+		}
+		return Num(-1), nil
+	})
+	method("slice", func(s string, u []uint16, args []Value) (Value, error) {
+		start, end := sliceRange(args, len(u))
+		return Str(fromUnits(u[start:end])), nil
+	})
+	method("substring", func(s string, u []uint16, args []Value) (Value, error) {
+		start := clamp(toInteger(arg(args, 0), len(u)), len(u))
+		end := len(u)
+		if e := arg(args, 1); e.Kind != Undefined {
+			end = clamp(toInteger(e, len(u)), len(u))
+		}
+		if start > end {
+			start, end = end, start
+		}
+		return Str(fromUnits(u[start:end])), nil
+	})
+	method("substr", func(s string, u []uint16, args []Value) (Value, error) {
+		start := toInteger(arg(args, 0), len(u))
+		if start < 0 {
+			start += len(u)
+		}
+		start = clamp(start, len(u))
+		end := len(u)
+		if n := arg(args, 1); n.Kind != Undefined {
+			end = clamp(start+toInteger(n, len(u)), len(u))
+		}
+		if end < start {
+			end = start
+		}
+		return Str(fromUnits(u[start:end])), nil
+	})
+	method("toUpperCase", func(s string, u []uint16, args []Value) (Value, error) { return Str(strings.ToUpper(s)), nil })
+	method("toLowerCase", func(s string, u []uint16, args []Value) (Value, error) { return Str(strings.ToLower(s)), nil })
+	method("trim", func(s string, u []uint16, args []Value) (Value, error) { return Str(strings.TrimSpace(s)), nil })
+	method("concat", func(s string, u []uint16, args []Value) (Value, error) {
+		for _, a := range args {
+			s += a.String()
+		}
+		return Str(s), nil
+	})
+	def(p, "split", func(m *Machine, this Value, args []Value) (Value, error) {
+		s := this.String()
+		var parts []string
+		switch sep := arg(args, 0); {
+		case sep.Kind == Undefined:
+			parts = []string{s}
+		case sep.String() == "":
+			for _, c := range units(s) {
+				parts = append(parts, fromUnits([]uint16{c}))
+			}
+		default:
+			parts = strings.Split(s, sep.String())
+		}
+		out := make([]Value, len(parts))
+		for i, p := range parts {
+			out[i] = Str(p)
+		}
+		return ObjValue(m.NewArray(out)), nil
+	})
+	def(p, "replace", func(m *Machine, this Value, args []Value) (Value, error) {
+		s := this.String()
+		pat, rep := arg(args, 0), arg(args, 1)
+		if rep.IsCallable() {
+			return undefined, fmt.Errorf("replace with a function is not supported")
+		}
+		if pat.Kind == Obj && pat.Obj.Class == "RegExp" {
+			src := pat.Obj.Source
+			if strings.Contains(pat.Obj.Flags, "i") {
+				src = "(?i)" + src
+			}
+			re, err := regexp.Compile(src)
+			if err != nil {
+				return undefined, fmt.Errorf("unsupported regexp /%s/", pat.Obj.Source)
+			}
+			r := strings.ReplaceAll(rep.String(), "$&", "${0}")
+			if strings.Contains(pat.Obj.Flags, "g") {
+				return Str(re.ReplaceAllString(s, r)), nil
+			}
+			if loc := re.FindStringSubmatchIndex(s); loc != nil {
+				var dst []byte
+				dst = re.ExpandString(dst, r, s, loc)
+				return Str(s[:loc[0]] + string(dst) + s[loc[1]:]), nil
+			}
+			return Str(s), nil
+		}
+		return Str(strings.Replace(s, pat.String(), rep.String(), 1)), nil
+	})
+}
+
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return undefined
+}
+
+// sliceRange resolves slice(start, end) arguments against length n.
+func sliceRange(args []Value, n int) (int, int) {
+	rel := func(v Value, def int) int {
+		if v.Kind == Undefined {
+			return def
+		}
+		i := toInteger(v, n)
+		if i < 0 {
+			i += n
+		}
+		return clamp(i, n)
+	}
+	start, end := rel(arg(args, 0), 0), rel(arg(args, 1), n)
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// toInteger implements ToInteger for an index or count into a sequence of
+// length n: NaN is 0, and the value is clamped to [-n, n] before it is
+// converted, so infinities and huge values cannot overflow an int.
+func toInteger(v Value, n int) int {
+	f := v.number()
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f < float64(-n):
+		return -n
+	case f > float64(n):
+		return n
+	}
+	return int(f)
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+func unitIndex(u, sub []uint16) int {
+	for i := 0; i+len(sub) <= len(u); i++ {
+		if equalUnits(u[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalUnits(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseInt implements the global parseInt.
+func parseInt(s string, radix int) float64 {
+	s = strings.TrimSpace(s)
+	sign := 1.0
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+	if (radix == 0 || radix == 16) && len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		s, radix = s[2:], 16
+	}
+	if radix == 0 {
+		radix = 10
+	}
+	if radix < 2 || radix > 36 {
+		return math.NaN()
+	}
+	n, digits := 0.0, 0
+	for _, c := range s {
+		d := 99
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c >= 'a' && c <= 'z':
+			d = int(c-'a') + 10
+		case c >= 'A' && c <= 'Z':
+			d = int(c-'A') + 10
+		}
+		if d >= radix {
+			break
+		}
+		n = n*float64(radix) + float64(d)
+		digits++
+	}
+	if digits == 0 {
+		return math.NaN()
+	}
+	return sign * n
+}
+
+// parseFloat implements the global parseFloat on the longest numeric prefix.
+func parseFloat(s string) float64 {
+	s = strings.TrimSpace(s)
+	for end := len(s); end > 0; end-- {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil && !strings.ContainsAny(s[:end], "xXpP_") {
+			return f
+		}
+	}
+	if strings.HasPrefix(s, "Infinity") || strings.HasPrefix(s, "+Infinity") {
+		return math.Inf(1)
+	}
+	if strings.HasPrefix(s, "-Infinity") {
+		return math.Inf(-1)
+	}
+	return math.NaN()
+}
@@ -0,0 +1,1210 @@
+// Package interp is a small sandboxed interpreter for SM33 bytecode.
+//
+// It executes the decoded instructions of a Script tree directly:
+// arithmetic, strings, arrays, plain objects, closures over call objects,
+// and calls between functions of the same tree. Globals live in one
+// object the caller can read and seed, and names the script reads but
+// never defines can be supplied by a Host stub; nothing touches the real
+// host. Every run is bounded by a step limit and an allocation cap taken
+// from sm33.Options, and opcodes the interpreter does not
+// model (with, eval, generators, exception handling) stop the run with an
+// *Error rather than guessing.
+package interp
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+)
+
+// Opcodes the interpreter executes.
+const (
+	opNop             = 0
+	opUndefined       = 1
+	opReturn          = 5
+	opGoto            = 6
+	opIfeq            = 7
+	opIfne            = 8
+	opArguments       = 9
+	opSwap            = 10
+	opPopn            = 11
+	opDup             = 12
+	opDup2            = 13
+	opSetconst        = 14
+	opBitor           = 15
+	opBitxor          = 16
+	opBitand          = 17
+	opEq              = 18
+	opNe              = 19
+	opLt              = 20
+	opLe              = 21
+	opGt              = 22
+	opGe              = 23
+	opLsh             = 24
+	opRsh             = 25
+	opUrsh            = 26
+	opAdd             = 27
+	opSub             = 28
+	opMul             = 29
+	opDiv             = 30
+	opMod             = 31
+	opNot             = 32
+	opBitnot          = 33
+	opNeg             = 34
+	opPos             = 35
+	opDelname         = 36
+	opDelprop         = 37
+	opDelelem         = 38
+	opTypeof          = 39
+	opVoid            = 40
+	opDupat           = 44
+	opGetprop         = 53
+	opSetprop         = 54
+	opGetelem         = 55
+	opSetelem         = 56
+	opCall            = 58
+	opName            = 59
+	opDouble          = 60
+	opString          = 61
+	opZero            = 62
+	opOne             = 63
+	opNull            = 64
+	opThis            = 65
+	opFalse           = 66
+	opTrue            = 67
+	opOr              = 68
+	opAnd             = 69
+	opTableswitch     = 70
+	opRunonce         = 71
+	opStrictEq        = 72
+	opStrictNe        = 73
+	opIter            = 75
+	opMoreiter        = 76
+	opIternext        = 77
+	opEnditer         = 78
+	opFunapply        = 79
+	opPop             = 81
+	opNew             = 82
+	opGetarg          = 84
+	opSetarg          = 85
+	opGetlocal        = 86
+	opSetlocal        = 87
+	opUint16          = 88
+	opNewinit         = 89
+	opNewarray        = 90
+	opNewobject       = 91
+	opEndinit         = 92
+	opInitprop        = 93
+	opInitelem        = 94
+	opInitelemInc     = 95
+	opInitelemArray   = 96
+	opLabel           = 106
+	opFuncall         = 108
+	opLoophead        = 109
+	opBindname        = 110
+	opSetname         = 111
+	opIn              = 113
+	opInstanceof      = 114
+	opLineno          = 119
+	opCondswitch      = 120
+	opCase            = 121
+	opDefault         = 122
+	opDeffun          = 127
+	opDefconst        = 128
+	opDefvar          = 129
+	opLambda          = 130
+	opLambdaArrow     = 131
+	opCallee          = 132
+	opPick            = 133
+	opTry             = 134
+	opGetaliasedvar   = 136
+	opSetaliasedvar   = 137
+	opGetintrinsic    = 143
+	opSetrval         = 152
+	opRetrval         = 153
+	opGetgname        = 154
+	opSetgname        = 155
+	opRegexp          = 160
+	opCallprop        = 184
+	opUint24          = 188
+	opCallelem        = 193
+	opGetxprop        = 195
+	opTypeofExpr      = 197
+	opPushblockscope  = 198
+	opPopblockscope   = 199
+	opDebugleaveblock = 200
+	opArraypush       = 204
+	opBindgname       = 214
+	opInt8            = 215
+	opInt32           = 216
+	opLength          = 217
+	opHole            = 218
+	opToid            = 225
+	opImplicitthis    = 226
+	opLoopentry       = 227
+	opTostring        = 228
+)
+
+// jsprotoArray is the newinit operand for array initialisers.
+const jsprotoArray = 3
+
+// maxDepth bounds interpreted call recursion.
+const maxDepth = 256
+
+// Error reports why a run stopped.
+type Error struct {
+	Func string // function name, "main" for the top-level script
+	Off  int    // bytecode offset
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s @%05X: %s", e.Func, e.Off, e.Msg)
+}
+
+// Closure is an interpreted function with the scope it was created in.
+type Closure struct {
+	Func *sm33.Function
+	Env  *Env
+}
+
+// Env is a scope object: a call object, block scope or named-lambda
+// environment. Slots are addressed by the getaliasedvar coordinates.
+type Env struct {
+	Parent *Env
+	Slots  map[int]Value
+}
+
+// Get returns the slot hops scopes out, or undefined.
+func (e *Env) Get(hops, slot int) Value {
+	for ; e != nil && hops > 0; hops-- {
+		e = e.Parent
+	}
+	if e == nil {
+		return undefined
+	}
+	return e.Slots[slot]
+}
+
+func (e *Env) set(hops, slot int, v Value) {
+	for ; e != nil && hops > 0; hops-- {
+		e = e.Parent
+	}
+	if e != nil {
+		e.Slots[slot] = v
+	}
+}
+
+// callObjectReserved is the number of reserved call object slots before
+// the first aliased binding.
+const callObjectReserved = 2
+
+// Machine runs scripts against one global object.
+type Machine struct {
+	Global *Object
+
+	// Host, when set, resolves global names that are not defined on
+	// Global, such as engine objects (cc, jsb) the script expects the
+	// embedder to provide. The value is cached on Global.
+	Host func(m *Machine, name string) (Value, bool)
+
+	// OnLambda, when set, is called for every closure the run creates.
+	OnLambda func(c *Closure)
+
+	// OnGlobal, when set, is called for every global name the script
+	// reads, with the value it resolved to.
+	OnGlobal func(name string, v Value)
+
+	// OnSet, when set, is called for every assignment to a global or an
+	// object property. Literal initialisers are not reported.
+	OnSet func(obj *Object, name string, v Value)
+
+	// OnString, when set, is called for every string built by
+	// concatenation or returned by a native function.
+	OnString func(s string)
+
+	maxSteps int
+	steps    int
+	depth    int
+	maxHeap  int
+	heap     int
+	cur      *frame
+
+	objectProto   *Object
+	functionProto *Object
+	arrayProto    *Object
+	stringProto   *Object
+	numberProto   *Object
+}
+
+// New returns a machine with the standard globals installed and the step
+// and allocation limits taken from opt.
+func New(opt sm33.Options) *Machine {
+	m := &Machine{maxSteps: opt.EffectiveMaxSteps(), maxHeap: opt.EffectiveMaxHeapBytes()}
+	m.installGlobals()
+	m.heap = 0 // the standard library is not charged
+	return m
+}
+
+// Approximate allocation costs charged against the heap limit. A Value
+// is a kind, a bool, a number, a string header and a pointer.
+const (
+	objectSize = 64
+	valueSize  = 40
+)
+
+// NewObject returns an empty plain object.
+func (m *Machine) NewObject() *Object {
+	m.alloc(objectSize)
+	return &Object{Class: "Object", Proto: m.objectProto}
+}
+
+// NewArray returns an array holding elems.
+func (m *Machine) NewArray(elems []Value) *Object {
+	m.alloc(objectSize + valueSize*len(elems))
+	return &Object{Class: "Array", Proto: m.arrayProto, Elems: elems}
+}
+
+// NewNative wraps a Go function as a callable object.
+func (m *Machine) NewNative(fn Native) *Object {
+	m.alloc(objectSize)
+	return &Object{Class: "Function", Proto: m.functionProto, Native: fn}
+}
+
+// Steps returns the number of instructions executed so far.
+func (m *Machine) Steps() int { return m.steps }
+
+// ResetSteps restarts the step count, so the next Run or Call gets the
+// full step limit.
+func (m *Machine) ResetSteps() { m.steps = 0 }
+
+// Where returns the script and bytecode offset of the instruction being
+// executed, or nil, 0 outside a run.
+func (m *Machine) Where() (s *sm33.Script, off int) {
+	if m.cur == nil {
+		return nil, 0
+	}
+	return m.cur.s, m.cur.off
+}
+
+// HeapBytes returns the approximate number of bytes allocated so far.
+func (m *Machine) HeapBytes() int { return m.heap }
+
+// alloc charges n bytes against the heap limit. The limit is enforced
+// between instructions, so a single native call can overshoot it by at
+// most one result.
+func (m *Machine) alloc(n int) { m.heap += n }
+
+// reserve charges n bytes before an allocation whose size the script
+// controls, failing instead when it would exceed the heap limit.
+func (m *Machine) reserve(n int) error {
+	if m.heap+n > m.maxHeap {
+		return fmt.Errorf("heap limit %d bytes exceeded", m.maxHeap)
+	}
+	m.heap += n
+	return nil
+}
+
+// toString is ToString for values the script converts: the strings of
+// array joins are reserved as they grow.
+func (m *Machine) toString(v Value) (string, error) {
+	return stringify(v, m.reserve)
+}
+
+// set stores name on o after reserving any growth in properties or
+// elements.
+func (m *Machine) set(o *Object, name string, v Value) error {
+	if err := m.reserve(o.growth(name, v)); err != nil {
+		return err
+	}
+	o.Set(name, v)
+	return nil
+}
+
+// store is an assignment by the script: set plus the OnSet hook.
+func (f *frame) store(o *Object, name string, v Value) error {
+	if err := f.m.set(o, name, v); err != nil {
+		return err
+	}
+	if f.m.OnSet != nil {
+		f.m.OnSet(o, name, v)
+	}
+	return nil
+}
+
+// global resolves name on Global, falling back to the Host stub.
+func (m *Machine) global(name string) (Value, bool) {
+	if m.Global.Has(name) {
+		return m.Global.Get(name), true
+	}
+	if m.Host != nil {
+		if v, ok := m.Host(m, name); ok {
+			m.Global.Set(name, v)
+			return v, true
+		}
+	}
+	return undefined, false
+}
+
+// Run executes the top-level script s as global code and returns its
+// completion value.
+func (m *Machine) Run(s *sm33.Script) (Value, error) {
+	f := &frame{m: m, s: s, name: "main", this: ObjValue(m.Global), global: true}
+	return f.run()
+}
+
+// Call invokes fn with the given this and arguments.
+func (m *Machine) Call(fn Value, this Value, args []Value) (Value, error) {
+	if !fn.IsCallable() {
+		return undefined, &Error{Func: "call", Msg: fmt.Sprintf("%s is not a function", typeOf(fn))}
+	}
+	o := fn.Obj
+	if o.Native != nil {
+		v, err := o.Native(m, this, args)
+		if v.Kind == String {
+			m.alloc(len(v.Str))
+			if m.OnString != nil {
+				m.OnString(v.Str)
+			}
+		}
+		return v, err
+	}
+	if m.depth >= maxDepth {
+		return undefined, &Error{Func: funcName(o.Fn.Func), Msg: "call depth exceeded"}
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	fn1 := o.Fn.Func
+	s := fn1.Script
+	if s == nil || fn1.IsLazy {
+		return undefined, &Error{Func: funcName(fn1), Msg: "lazy function has no bytecode"}
+	}
+	f := &frame{m: m, s: s, name: funcName(fn1), this: this, callee: o, env: o.Fn.Env}
+	f.args = make([]Value, max(int(s.Nargs), len(args)))
+	copy(f.args, args)
+	f.actuals = args
+
+	if s.Bits&sm33.FlagFunNeedsDeclEnvObject != 0 {
+		f.env = &Env{Parent: f.env, Slots: map[int]Value{callObjectReserved: fn}}
+	}
+	if s.HasCallObject() {
+		f.env = &Env{Parent: f.env, Slots: map[int]Value{}}
+		rank := 0
+		for i, aliased := range s.Aliased {
+			if !aliased {
+				continue
+			}
+			if i < int(s.Nargs) {
+				f.env.Slots[callObjectReserved+rank] = f.args[i]
+			}
+			rank++
+		}
+	}
+	return f.run()
+}
+
+func funcName(fn *sm33.Function) string {
+	if fn.Name != "" {
+		return fn.Name
+	}
+	return "<anonymous>"
+}
+
+// frame is one activation.
+type frame struct {
+	m       *Machine
+	s       *sm33.Script
+	name    string
+	this    Value
+	callee  *Object
+	env     *Env
+	args    []Value
+	actuals []Value
+	locals  []Value
+	stack   []Value
+	rval    Value
+	global  bool
+	off     int
+}
+
+func (f *frame) errorf(format string, a ...any) error {
+	return &Error{Func: f.name, Off: f.off, Msg: fmt.Sprintf(format, a...)}
+}
+
+func (f *frame) push(v Value) { f.stack = append(f.stack, v) }
+
+func (f *frame) pop() Value {
+	if len(f.stack) == 0 {
+		return undefined
+	}
+	v := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return v
+}
+
+func (f *frame) popN(n int) []Value {
+	if n > len(f.stack) {
+		n = len(f.stack)
+	}
+	out := append([]Value(nil), f.stack[len(f.stack)-n:]...)
+	f.stack = f.stack[:len(f.stack)-n]
+	return out
+}
+
+func (f *frame) peek(n int) Value {
+	if n >= len(f.stack) {
+		return undefined
+	}
+	return f.stack[len(f.stack)-1-n]
+}
+
+// local returns local slot n, growing the slots on first use. Slots past
+// the script's variables and block locals are an error.
+func (f *frame) local(n int) (*Value, error) {
+	if n >= int(f.s.Nvars)+int(f.s.Nblocklocals) {
+		return nil, f.errorf("local %d out of range", n)
+	}
+	if n >= len(f.locals) {
+		if err := f.m.reserve(valueSize * (n + 1 - len(f.locals))); err != nil {
+			return nil, f.wrap(err)
+		}
+		for len(f.locals) <= n {
+			f.locals = append(f.locals, undefined)
+		}
+	}
+	return &f.locals[n], nil
+}
+
+func (f *frame) atom(off int) string {
+	idx, ok := bytecode.GetUint32Index(f.s.Bytecode, off)
+	if !ok || int(idx) >= len(f.s.Atoms) {
+		return ""
+	}
+	return f.s.Atoms[idx]
+}
+
+func (f *frame) index(off int) int {
+	idx, _ := bytecode.GetUint32Index(f.s.Bytecode, off)
+	return int(idx)
+}
+
+// run executes the frame from its first instruction to a return.
+func (f *frame) run() (Value, error) {
+	m := f.m
+	prev := m.cur
+	m.cur = f
+	defer func() { m.cur = prev }()
+	bc := f.s.Bytecode
+	for pc := 0; pc < len(bc); {
+		m.steps++
+		if m.steps > m.maxSteps {
+			f.off = pc
+			return undefined, f.errorf("step limit %d exceeded", m.maxSteps)
+		}
+		if m.heap > m.maxHeap {
+			f.off = pc
+			return undefined, f.errorf("heap limit %d bytes exceeded", m.maxHeap)
+		}
+		f.off = pc
+		op := bc[pc]
+		n := bytecode.InstrLen(bc, pc)
+		if n <= 0 {
+			return undefined, f.errorf("undecodable instruction 0x%02x", op)
+		}
+		if pc+n > len(bc) {
+			return undefined, f.errorf("instruction 0x%02x truncated by the end of the bytecode", op)
+		}
+		next := pc + n
+		jump := func() int {
+			j, _ := bytecode.GetJumpOffset(bc, pc)
+			return pc + int(j)
+		}
+
+		switch op {
+		case opNop, opLabel, opLoophead, opLoopentry, opLineno, opCondswitch,
+			opEndinit, opTry, opDebugleaveblock, opRunonce, opToid:
+		case opUndefined, opImplicitthis:
+			f.push(undefined)
+		case opNull:
+			f.push(null)
+		case opTrue:
+			f.push(Boolean(true))
+		case opFalse:
+			f.push(Boolean(false))
+		case opZero:
+			f.push(Num(0))
+		case opOne:
+			f.push(Num(1))
+		case opHole:
+			f.push(undefined)
+		case opInt8:
+			v, _ := bytecode.GetInt8(bc, pc)
+			f.push(Num(float64(v)))
+		case opInt32:
+			v, _ := bytecode.GetInt32(bc, pc)
+			f.push(Num(float64(v)))
+		case opUint16:
+			v, _ := bytecode.GetUint16(bc, pc)
+			f.push(Num(float64(v)))
+		case opUint24:
+			v, _ := bytecode.GetUint24(bc, pc)
+			f.push(Num(float64(v)))
+		case opString:
+			f.push(Str(f.atom(pc)))
+		case opDouble:
+			idx := f.index(pc)
+			if idx >= len(f.s.Consts) {
+				return undefined, f.errorf("constant %d out of range", idx)
+			}
+			f.push(constValue(f.s.Consts[idx]))
+		case opThis:
+			f.push(f.this)
+		case opCallee:
+			if f.callee == nil {
+				return undefined, f.errorf("callee outside a function")
+			}
+			f.push(ObjValue(f.callee))
+		case opArguments:
+			m.alloc(objectSize + valueSize*len(f.actuals))
+			f.push(ObjValue(&Object{Class: "Arguments", Proto: m.objectProto, Elems: append([]Value{}, f.actuals...)}))
+		case opRegexp:
+			m.alloc(objectSize)
+			re := &Object{Class: "RegExp", Proto: m.objectProto}
+			if idx := f.index(pc); idx < len(f.s.Regexps) {
+				re.Source = f.s.Regexps[idx].Source
+				re.Flags = regexpFlagString(f.s.Regexps[idx].Flags)
+			}
+			f.push(ObjValue(re))
+
+		// Stack shuffling
+		case opPop:
+			f.pop()
+		case opPopn:
+			k, _ := bytecode.GetUint16(bc, pc)
+			f.popN(int(k))
+		case opDup:
+			f.push(f.peek(0))
+		case opDup2:
+			a, b := f.peek(1), f.peek(0)
+			f.push(a)
+			f.push(b)
+		case opDupat:
+			k, _ := bytecode.GetUint24(bc, pc)
+			f.push(f.peek(int(k)))
+		case opSwap:
+			a, b := f.pop(), f.pop()
+			f.push(a)
+			f.push(b)
+		case opPick:
+			k := int(bc[pc+1])
+			if k < len(f.stack) {
+				i := len(f.stack) - 1 - k
+				v := f.stack[i]
+				f.stack = append(f.stack[:i], f.stack[i+1:]...)
+				f.push(v)
+			}
+
+		// Variables
+		case opGetarg:
+			k, _ := bytecode.GetArgno(bc, pc)
+			if int(k) < len(f.args) {
+				f.push(f.args[k])
+			} else {
+				f.push(undefined)
+			}
+		case opSetarg:
+			k, _ := bytecode.GetArgno(bc, pc)
+			if int(k) < len(f.args) {
+				f.args[k] = f.peek(0)
+				if int(k) < len(f.actuals) {
+					f.actuals[k] = f.peek(0)
+				}
+			}
+		case opGetlocal:
+			k, _ := bytecode.GetLocalno(bc, pc)
+			slot, err := f.local(int(k))
+			if err != nil {
+				return undefined, err
+			}
+			f.push(*slot)
+		case opSetlocal:
+			k, _ := bytecode.GetLocalno(bc, pc)
+			slot, err := f.local(int(k))
+			if err != nil {
+				return undefined, err
+			}
+			*slot = f.peek(0)
+		case opGetaliasedvar, opSetaliasedvar:
+			hops := int(bc[pc+1])
+			slot := int(bc[pc+2])<<16 | int(bc[pc+3])<<8 | int(bc[pc+4])
+			if op == opGetaliasedvar {
+				f.push(f.env.Get(hops, slot))
+			} else {
+				f.env.set(hops, slot, f.peek(0))
+			}
+		case opName, opGetgname, opGetintrinsic:
+			name := f.atom(pc)
+			v, ok := m.global(name)
+			if !ok {
+				if op == opName && f.nextIsTypeof(next) {
+					f.push(undefined)
+					break
+				}
+				return undefined, f.errorf("%s is not defined", name)
+			}
+			if m.OnGlobal != nil {
+				m.OnGlobal(name, v)
+			}
+			f.push(v)
+		case opBindname, opBindgname:
+			f.push(ObjValue(m.Global))
+		case opSetname, opSetgname:
+			v := f.pop()
+			scope := f.pop()
+			if scope.Kind == Obj {
+				if err := f.store(scope.Obj, f.atom(pc), v); err != nil {
+					return undefined, f.wrap(err)
+				}
+			} else {
+				if err := f.store(m.Global, f.atom(pc), v); err != nil {
+					return undefined, f.wrap(err)
+				}
+			}
+			f.push(v)
+		case opSetconst:
+			if err := f.store(m.Global, f.atom(pc), f.peek(0)); err != nil {
+				return undefined, f.wrap(err)
+			}
+		case opDefvar, opDefconst:
+			if name := f.atom(pc); !m.Global.Has(name) {
+				if err := m.set(m.Global, name, undefined); err != nil {
+					return undefined, f.wrap(err)
+				}
+			}
+		case opDeffun:
+			c, fn, err := f.lambda(pc)
+			if err != nil {
+				return undefined, err
+			}
+			if err := f.store(m.Global, fn.Name, ObjValue(c)); err != nil {
+				return undefined, f.wrap(err)
+			}
+		case opLambda:
+			c, _, err := f.lambda(pc)
+			if err != nil {
+				return undefined, err
+			}
+			f.push(ObjValue(c))
+		case opLambdaArrow:
+			f.pop() // this
+			c, _, err := f.lambda(pc)
+			if err != nil {
+				return undefined, err
+			}
+			f.push(ObjValue(c))
+		case opDelname:
+			m.Global.Delete(f.atom(pc))
+			f.push(Boolean(true))
+		case opPushblockscope:
+			f.env = &Env{Parent: f.env, Slots: map[int]Value{}}
+		case opPopblockscope:
+			if f.env != nil {
+				f.env = f.env.Parent
+			}
+
+		// Properties
+		case opGetprop, opCallprop, opLength, opGetxprop:
+			obj := f.pop()
+			v, err := m.getProp(obj, f.atom(pc))
+			if err != nil {
+				return undefined, f.wrap(err)
+			}
+			f.push(v)
+		case opSetprop:
+			v := f.pop()
+			obj := f.pop()
+			if obj.Kind != Obj {
+				return undefined, f.errorf("cannot set %s on %s", f.atom(pc), typeOf(obj))
+			}
+			if err := f.store(obj.Obj, f.atom(pc), v); err != nil {
+				return undefined, f.wrap(err)
+			}
+			f.push(v)
+		case opGetelem, opCallelem:
+			idx := f.pop()
+			obj := f.pop()
+			v, err := m.getProp(obj, idx.String())
+			if err != nil {
+				return undefined, f.wrap(err)
+			}
+			f.push(v)
+		case opSetelem:
+			v := f.pop()
+			idx := f.pop()
+			obj := f.pop()
+			if obj.Kind != Obj {
+				return undefined, f.errorf("cannot set [%s] on %s", idx, typeOf(obj))
+			}
+			if err := f.store(obj.Obj, idx.String(), v); err != nil {
+				return undefined, f.wrap(err)
+			}
+			f.push(v)
+		case opDelprop:
+			if obj := f.pop(); obj.Kind == Obj {
+				obj.Obj.Delete(f.atom(pc))
+			}
+			f.push(Boolean(true))
+		case opDelelem:
+			idx := f.pop()
+			if obj := f.pop(); obj.Kind == Obj {
+				obj.Obj.Delete(idx.String())
+			}
+			f.push(Boolean(true))
+
+		// Initialisers
+		case opNewinit:
+			if bc[pc+1] == jsprotoArray {
+				f.push(ObjValue(m.NewArray(nil)))
+			} else {
+				f.push(ObjValue(m.NewObject()))
+			}
+		case opNewarray:
+			f.push(ObjValue(m.NewArray(nil)))
+		case opNewobject:
+			// The template's shape is not decoded; properties arrive
+			// through initprop.
+			f.push(ObjValue(m.NewObject()))
+		case opInitprop:
+			v := f.pop()
+			if obj := f.peek(0); obj.Kind == Obj {
+				if err := m.set(obj.Obj, f.atom(pc), v); err != nil {
+					return undefined, f.wrap(err)
+				}
+			}
+		case opInitelem:
+			v := f.pop()
+			idx := f.pop()
+			if obj := f.peek(0); obj.Kind == Obj {
+				if err := m.set(obj.Obj, idx.String(), v); err != nil {
+					return undefined, f.wrap(err)
+				}
+			}
+		case opInitelemArray:
+			v := f.pop()
+			k, _ := bytecode.GetUint24(bc, pc)
+			if obj := f.peek(0); obj.Kind == Obj {
+				if err := m.set(obj.Obj, numberString(float64(k)), v); err != nil {
+					return undefined, f.wrap(err)
+				}
+			}
+		case opInitelemInc:
+			v := f.pop()
+			idx := f.pop()
+			if obj := f.peek(0); obj.Kind == Obj {
+				if err := m.set(obj.Obj, idx.String(), v); err != nil {
+					return undefined, f.wrap(err)
+				}
+			}
+			f.push(Num(idx.number() + 1))
+		case opArraypush:
+			v := f.pop()
+			if arr := f.pop(); arr.Kind == Obj {
+				if err := m.reserve(valueSize); err != nil {
+					return undefined, f.wrap(err)
+				}
+				arr.Obj.Elems = append(arr.Obj.Elems, v)
+			}
+
+		// Operators
+		case opAdd:
+			b, a := f.pop(), f.pop()
+			v, err := m.add(a, b)
+			if err != nil {
+				return undefined, f.wrap(err)
+			}
+			if v.Kind == String {
+				m.alloc(len(v.Str))
+				if m.OnString != nil {
+					m.OnString(v.Str)
+				}
+			}
+			f.push(v)
+		case opSub, opMul, opDiv, opMod:
+			b, a := f.pop().number(), f.pop().number()
+			f.push(Num(arith(op, a, b)))
+		case opBitor, opBitxor, opBitand, opLsh, opRsh, opUrsh:
+			b, a := f.pop().number(), f.pop().number()
+			f.push(Num(bitwise(op, a, b)))
+		case opEq, opNe:
+			b, a := f.pop(), f.pop()
+			f.push(Boolean(looseEquals(a, b) == (op == opEq)))
+		case opStrictEq, opStrictNe:
+			b, a := f.pop(), f.pop()
+			f.push(Boolean(strictEquals(a, b) == (op == opStrictEq)))
+		case opLt, opLe, opGt, opGe:
+			b, a := f.pop(), f.pop()
+			f.push(Boolean(compare(op, a, b)))
+		case opNot:
+			f.push(Boolean(!f.pop().Truthy()))
+		case opBitnot:
+			f.push(Num(float64(^toInt32(f.pop().number()))))
+		case opNeg:
+			f.push(Num(-f.pop().number()))
+		case opPos:
+			f.push(Num(f.pop().number()))
+		case opTypeof, opTypeofExpr:
+			f.push(Str(typeOf(f.pop())))
+		case opVoid:
+			f.pop()
+			f.push(undefined)
+		case opTostring:
+			s, err := m.toString(f.pop())
+			if err != nil {
+				return undefined, f.wrap(err)
+			}
+			f.push(Str(s))
+		case opIn:
+			obj := f.pop()
+			key := f.pop()
+			if obj.Kind != Obj {
+				return undefined, f.errorf("'in' on %s", typeOf(obj))
+			}
+			f.push(Boolean(obj.Obj.Has(key.String())))
+		case opInstanceof:
+			ctor := f.pop()
+			v := f.pop()
+			f.push(Boolean(m.instanceOf(v, ctor)))
+
+		// Control flow
+		case opGoto:
+			next = jump()
+		case opIfeq:
+			if !f.pop().Truthy() {
+				next = jump()
+			}
+		case opIfne:
+			if f.pop().Truthy() {
+				next = jump()
+			}
+		case opOr:
+			if f.peek(0).Truthy() {
+				next = jump()
+			}
+		case opAnd:
+			if !f.peek(0).Truthy() {
+				next = jump()
+			}
+		case opCase:
+			v := f.pop()
+			if strictEquals(f.peek(0), v) {
+				f.pop()
+				next = jump()
+			}
+		case opDefault:
+			f.pop()
+			next = jump()
+		case opTableswitch:
+			next = f.tableswitch(pc, f.pop())
+		case opSetrval:
+			f.rval = f.pop()
+		case opReturn:
+			return f.pop(), nil
+		case opRetrval:
+			return f.rval, nil
+
+		// Iteration
+		case opIter:
+			f.push(ObjValue(f.iterator(f.pop())))
+		case opMoreiter:
+			it := f.peek(0)
+			f.push(Boolean(it.Kind == Obj && len(it.Obj.Elems) > 0))
+		case opIternext:
+			it := f.peek(0)
+			if it.Kind != Obj || len(it.Obj.Elems) == 0 {
+				return undefined, f.errorf("iterator exhausted")
+			}
+			f.push(it.Obj.Elems[0])
+			it.Obj.Elems = it.Obj.Elems[1:]
+		case opEnditer:
+			f.pop()
+
+		// Calls
+		case opCall, opFuncall, opFunapply, opNew:
+			argc, _ := bytecode.GetUint16(bc, pc)
+			args := f.popN(int(argc))
+			this := f.pop()
+			callee := f.pop()
+			var v Value
+			var err error
+			if op == opNew {
+				v, err = m.construct(callee, args)
+			} else {
+				v, err = m.Call(callee, this, args)
+			}
+			if err != nil {
+				return undefined, f.wrap(err)
+			}
+			f.push(v)
+
+		default:
+			return undefined, f.errorf("unsupported opcode %s", bytecode.Opcodes[op].Name)
+		}
+		pc = next
+	}
+	return f.rval, nil
+}
+
+// wrap attributes errors raised by natives to the current instruction.
+func (f *frame) wrap(err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return f.errorf("%v", err)
+}
+
+// nextIsTypeof reports whether the instruction at off is typeof, so an
+// undeclared name yields undefined instead of a ReferenceError.
+func (f *frame) nextIsTypeof(off int) bool {
+	return off < len(f.s.Bytecode) && f.s.Bytecode[off] == opTypeof
+}
+
+// lambda creates a closure for the function object at the instruction's
+// operand, capturing the current scope.
+func (f *frame) lambda(pc int) (*Object, *sm33.Function, error) {
+	idx := f.index(pc)
+	if idx >= len(f.s.Objects) || f.s.Objects[idx].Function == nil {
+		return nil, nil, f.errorf("object %d is not a function", idx)
+	}
+	fn := f.s.Objects[idx].Function
+	c := &Closure{Func: fn, Env: f.env}
+	o := f.m.NewFunction(c)
+	if f.m.OnLambda != nil {
+		f.m.OnLambda(c)
+	}
+	return o, fn, nil
+}
+
+// NewFunction returns a callable object for c with a fresh prototype
+// object. A nil c.Env runs the function outside any enclosing scope:
+// free aliased variables read as undefined.
+func (m *Machine) NewFunction(c *Closure) *Object {
+	m.alloc(objectSize)
+	o := &Object{Class: "Function", Proto: m.functionProto, Fn: c}
+	proto := m.NewObject()
+	proto.Set("constructor", ObjValue(o))
+	o.Set("prototype", ObjValue(proto))
+	o.Set("length", Num(float64(c.Func.Nargs)))
+	return o
+}
+
+// tableswitch returns the jump target for v.
+func (f *frame) tableswitch(pc int, v Value) int {
+	bc := f.s.Bytecode
+	def, _ := bytecode.GetJumpOffset(bc, pc)
+	target := pc + int(def)
+	if v.Kind != Number || v.Num != math.Trunc(v.Num) || pc+13 > len(bc) {
+		return target
+	}
+	low := int32(bc[pc+5])<<24 | int32(bc[pc+6])<<16 | int32(bc[pc+7])<<8 | int32(bc[pc+8])
+	high := int32(bc[pc+9])<<24 | int32(bc[pc+10])<<16 | int32(bc[pc+11])<<8 | int32(bc[pc+12])
+	i := int64(v.Num) - int64(low)
+	if v.Num < float64(low) || v.Num > float64(high) {
+		return target
+	}
+	joff := pc + 13 + int(i)*4
+	if joff+4 > len(bc) {
+		return target
+	}
+	c := int32(bc[joff])<<24 | int32(bc[joff+1])<<16 | int32(bc[joff+2])<<8 | int32(bc[joff+3])
+	if c == 0 {
+		return target
+	}
+	return pc + int(c)
+}
+
+// iterator snapshots the enumerable keys of v for for-in.
+func (f *frame) iterator(v Value) *Object {
+	it := &Object{Class: "Iterator", Elems: []Value{}}
+	switch v.Kind {
+	case Obj:
+		seen := map[string]bool{}
+		for o := v.Obj; o != nil; o = o.Proto {
+			for _, k := range o.Keys() {
+				if !seen[k] && !o.builtin {
+					seen[k] = true
+					it.Elems = append(it.Elems, Str(k))
+				}
+			}
+		}
+	case String:
+		for i := range units(v.Str) {
+			it.Elems = append(it.Elems, Str(numberString(float64(i))))
+		}
+	}
+	return it
+}
+
+// regexpFlagString renders RegExp flag bits (ignoreCase 1, global 2,
+// multiline 4, sticky 8) as letters.
+func regexpFlagString(bits uint32) string {
+	var b []byte
+	for i, c := range "gimy" {
+		bit := [...]uint32{2, 1, 4, 8}[i]
+		if bits&bit != 0 {
+			b = append(b, byte(c))
+		}
+	}
+	return string(b)
+}
+
+// constValue converts a script constant.
+func constValue(c sm33.Const) Value {
+	switch c.Kind {
+	case sm33.ConstInt:
+		return Num(float64(c.Int))
+	case sm33.ConstDouble:
+		return Num(c.Double)
+	case sm33.ConstAtom:
+		return Str(c.Atom)
+	case sm33.ConstTrue:
+		return Boolean(true)
+	case sm33.ConstFalse:
+		return Boolean(false)
+	case sm33.ConstNull:
+		return null
+	}
+	return undefined
+}
+
+// construct implements new.
+func (m *Machine) construct(ctor Value, args []Value) (Value, error) {
+	if !ctor.IsCallable() {
+		return undefined, fmt.Errorf("%s is not a constructor", typeOf(ctor))
+	}
+	obj := m.NewObject()
+	if p := ctor.Obj.Get("prototype"); p.Kind == Obj {
+		obj.Proto = p.Obj
+	}
+	v, err := m.Call(ctor, ObjValue(obj), args)
+	if err != nil {
+		return undefined, err
+	}
+	if v.Kind == Obj {
+		return v, nil
+	}
+	return ObjValue(obj), nil
+}
+
+func (m *Machine) instanceOf(v, ctor Value) bool {
+	if v.Kind != Obj || ctor.Kind != Obj {
+		return false
+	}
+	p := ctor.Obj.Get("prototype")
+	if p.Kind != Obj {
+		return false
+	}
+	for o := v.Obj.Proto; o != nil; o = o.Proto {
+		if o == p.Obj {
+			return true
+		}
+	}
+	return false
+}
+
+// getProp reads a property of any value, boxing primitives.
+func (m *Machine) getProp(v Value, name string) (Value, error) {
+	switch v.Kind {
+	case Obj:
+		return v.Obj.Get(name), nil
+	case String:
+		u := units(v.Str)
+		if name == "length" {
+			return Num(float64(len(u))), nil
+		}
+		if i, ok := arrayIndex(name); ok {
+			if i < len(u) {
+				return Str(fromUnits(u[i : i+1])), nil
+			}
+			return undefined, nil
+		}
+		return m.stringProto.Get(name), nil
+	case Number:
+		return m.numberProto.Get(name), nil
+	case Bool:
+		return m.objectProto.Get(name), nil
+	}
+	return undefined, fmt.Errorf("cannot read %s of %s", name, v)
+}
+
+func (m *Machine) add(a, b Value) (Value, error) {
+	for _, v := range []*Value{&a, &b} {
+		if v.Kind == Obj {
+			s, err := m.toString(*v)
+			if err != nil {
+				return undefined, err
+			}
+			*v = Str(s)
+		}
+	}
+	if a.Kind == String || b.Kind == String {
+		return Str(a.String() + b.String()), nil
+	}
+	return Num(a.number() + b.number()), nil
+}
+
+func arith(op uint8, a, b float64) float64 {
+	switch op {
+	case opSub:
+		return a - b
+	case opMul:
+		return a * b
+	case opDiv:
+		return a / b
+	}
+	return math.Mod(a, b)
+}
+
+func bitwise(op uint8, a, b float64) float64 {
+	x, y := toInt32(a), toInt32(b)
+	shift := toUint32(b) & 31
+	switch op {
+	case opBitor:
+		return float64(x | y)
+	case opBitxor:
+		return float64(x ^ y)
+	case opBitand:
+		return float64(x & y)
+	case opLsh:
+		return float64(x << shift)
+	case opRsh:
+		return float64(x >> shift)
+	}
+	return float64(toUint32(a) >> shift)
+}
+
+func compare(op uint8, a, b Value) bool {
+	if a.Kind == String && b.Kind == String {
+		switch op {
+		case opLt:
+			return a.Str < b.Str
+		case opLe:
+			return a.Str <= b.Str
+		case opGt:
+			return a.Str > b.Str
+		}
+		return a.Str >= b.Str
+	}
+	x, y := a.number(), b.number()
+	switch op {
+	case opLt:
+		return x < y
+	case opLe:
+		return x <= y
+	case opGt:
+		return x > y
+	}
+	return x >= y
+}
@@ -0,0 +1,277 @@
+package interp
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+func TestRotateArray(t *testing.T) {
+	// (function (arr, n) { while (n--) arr.push(arr.shift()); })
+	rot := &sm33.Script{
+		Nargs: 2,
+		Atoms: []string{"push", "shift"},
+		Bytecode: Asm(
+			JumpOp(OpGoto, 32),                                                        // 0
+			ArgcOp(OpGetarg, 0), []byte{OpDup}, AtomOp(OpCallprop, 0), []byte{OpSwap}, // 5
+			ArgcOp(OpGetarg, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap}, // 15
+			ArgcOp(OpCall, 0), ArgcOp(OpCall, 1), []byte{OpPop}, // 25
+			ArgcOp(OpGetarg, 1), []byte{OpDup, OpOne, OpSub}, ArgcOp(OpSetarg, 1), []byte{OpPop}, // 32
+			JumpOp(OpIfne, -37), // 42
+			[]byte{OpRetrval},
+		),
+	}
+	// var a = ["x", "y", "z"]; (rot)(a, 1);
+	main := &sm33.Script{
+		Atoms:   []string{"a", "x", "y", "z"},
+		Objects: []*sm33.Object{FnObject(rot, "")},
+		Bytecode: Asm(
+			AtomOp(OpBindgname, 0), []byte{OpNewarray, 0, 0, 3},
+			AtomOp(OpString, 1), []byte{OpInitelemArray, 0, 0, 0},
+			AtomOp(OpString, 2), []byte{OpInitelemArray, 0, 0, 1},
+			AtomOp(OpString, 3), []byte{OpInitelemArray, 0, 0, 2},
+			[]byte{OpEndinit}, AtomOp(OpSetgname, 0), []byte{OpPop},
+			AtomOp(OpLambda, 0), []byte{OpUndefined}, AtomOp(OpGetgname, 0), []byte{OpOne},
+			ArgcOp(OpCall, 2), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+	m := New(sm33.DefaultOptions())
+	var closures int
+	m.OnLambda = func(*Closure) { closures++ }
+	if _, err := m.Run(main); err != nil {
+		t.Fatal(err)
+	}
+	if closures != 1 {
+		t.Errorf("OnLambda called %d times, want 1", closures)
+	}
+	a := m.Global.Get("a")
+	if got := a.String(); got != "y,z,x" {
+		t.Errorf("a = %q, want y,z,x", got)
+	}
+}
+
+func TestCallStrings(t *testing.T) {
+	// function (s) { return s.charAt(1) + String.fromCharCode(65) + (255).toString(16); }
+	fn := &sm33.Script{
+		Nargs: 1,
+		Atoms: []string{"charAt", "String", "fromCharCode", "toString"},
+		Bytecode: Asm(
+			ArgcOp(OpGetarg, 0), []byte{OpDup}, AtomOp(OpCallprop, 0), []byte{OpSwap},
+			[]byte{OpOne}, ArgcOp(OpCall, 1),
+			AtomOp(OpGetgname, 1), []byte{OpDup}, AtomOp(OpCallprop, 2), []byte{OpSwap},
+			[]byte{OpInt8, 65}, ArgcOp(OpCall, 1), []byte{OpAdd},
+			ArgcOp(OpUint16, 255), []byte{OpDup}, AtomOp(OpCallprop, 3), []byte{OpSwap},
+			[]byte{OpInt8, 16}, ArgcOp(OpCall, 1), []byte{OpAdd},
+			[]byte{OpReturn},
+		),
+	}
+	m := New(sm33.DefaultOptions())
+	f := ObjValue(&Object{Class: "Function", Proto: m.functionProto, Fn: &Closure{Func: FnObject(fn, "f").Function}})
+	v, err := m.Call(f, undefined, []Value{Str("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Str != "iAff" {
+		t.Errorf("f(\"hi\") = %q, want iAff", v.Str)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	s := &sm33.Script{Bytecode: JumpOp(OpGoto, 0)}
+	m := New(sm33.Options{MaxSteps: 100})
+	_, err := m.Run(s)
+	var e *Error
+	if !errors.As(err, &e) || e.Off != 0 {
+		t.Fatalf("err = %v, want step limit at 0", err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, tc := range []struct {
+		bc  []byte
+		off int
+	}{
+		{[]byte{OpUndefined, OpUndefined, 133}, 2}, // pick without its operand
+		{[]byte{OpUndefined, 136, 0, 0}, 1},        // getaliasedvar
+		{[]byte{OpUndefined, 137, 0, 0, 0}, 1},     // setaliasedvar
+		{[]byte{OpUndefined, 89, 1}, 1},            // newinit
+	} {
+		_, err := New(sm33.DefaultOptions()).Run(&sm33.Script{Bytecode: tc.bc})
+		var e *Error
+		if !errors.As(err, &e) || e.Off != tc.off {
+			t.Errorf("% x: err = %v, want a truncated instruction at %d", tc.bc, err, tc.off)
+		}
+	}
+}
+
+func TestHeapLimit(t *testing.T) {
+	// var s = "x"; for (;;) s = s + s;
+	s := &sm33.Script{
+		Atoms: []string{"s", "x"},
+		Bytecode: Asm(
+			AtomOp(OpBindgname, 0), AtomOp(OpString, 1), AtomOp(OpSetgname, 0), []byte{OpPop},
+			AtomOp(OpBindgname, 0), AtomOp(OpGetgname, 0), AtomOp(OpGetgname, 0), []byte{OpAdd},
+			AtomOp(OpSetgname, 0), []byte{OpPop},
+			JumpOp(OpGoto, -22),
+		),
+	}
+	m := New(sm33.Options{MaxHeapBytes: 1 << 20})
+	_, err := m.Run(s)
+	var e *Error
+	if !errors.As(err, &e) || e.Off != 32 {
+		t.Fatalf("err = %v, want heap limit at the store after add", err)
+	}
+	if n := len(m.Global.Get("s").Str); n < 1<<17 || n >= 1<<20 {
+		t.Errorf("len(s) = %d at the limit", n)
+	}
+}
+
+func TestHeapReserve(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		s    *sm33.Script
+		off  int
+	}{
+		// [][0xffffff] = 1
+		{"setelem", &sm33.Script{Bytecode: []byte{
+			OpNewarray, 0, 0, 0, OpEndinit, OpUint24, 0xff, 0xff, 0xff, OpInt8, 1, OpSetelem, OpPop, OpRetrval,
+		}}, 11},
+		// new Array(0xffffff)
+		{"Array", &sm33.Script{Atoms: []string{"Array"}, Bytecode: Asm(
+			AtomOp(OpName, 0), []byte{OpUint24, 0xff, 0xff, 0xff}, ArgcOp(OpNew, 1), []byte{OpPop, OpRetrval},
+		)}, 9},
+		// local 0xffffff = 1 in a script with one variable
+		{"setlocal", &sm33.Script{Nvars: 1, Bytecode: []byte{OpOne, OpSetlocal, 0xff, 0xff, 0xff, OpPop, OpRetrval}}, 1},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := New(sm33.DefaultOptions()).Run(tc.s)
+		runtime.ReadMemStats(&after)
+		var e *Error
+		if !errors.As(err, &e) || e.Off != tc.off {
+			t.Errorf("%s: err = %v, want an error at %d", tc.name, err, tc.off)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 16<<20 {
+			t.Errorf("%s: allocated %d bytes", tc.name, n)
+		}
+	}
+}
+
+func TestArrayString(t *testing.T) {
+	// var a = []; a.push(a); return a + "" + a.join("-");
+	cyclic := &sm33.Script{
+		Atoms: []string{"a", "push", "", "join", "-"},
+		Bytecode: Asm(
+			AtomOp(OpBindgname, 0), []byte{OpNewarray, 0, 0, 0, OpEndinit}, AtomOp(OpSetgname, 0), []byte{OpPop},
+			AtomOp(OpGetgname, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap},
+			AtomOp(OpGetgname, 0), ArgcOp(OpCall, 1), []byte{OpPop},
+			AtomOp(OpGetgname, 0), AtomOp(OpString, 2), []byte{OpAdd},
+			AtomOp(OpGetgname, 0), []byte{OpDup}, AtomOp(OpCallprop, 3), []byte{OpSwap},
+			AtomOp(OpString, 4), ArgcOp(OpCall, 1), []byte{OpAdd},
+			[]byte{OpSetrval, OpRetrval},
+		),
+	}
+	v, err := New(sm33.DefaultOptions()).Run(cyclic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Str != "" {
+		t.Errorf("cyclic array = %q, want \"\"", v.Str)
+	}
+
+	// var a = []; a = [a, a]; ... (24 times); return a + "";
+	// The string doubles with each level.
+	var bc []byte
+	bc = Asm(bc, AtomOp(OpBindgname, 0), []byte{OpNewarray, 0, 0, 0, OpEndinit}, AtomOp(OpSetgname, 0), []byte{OpPop})
+	for range 24 {
+		bc = Asm(bc, AtomOp(OpBindgname, 0), []byte{OpNewarray, 0, 0, 2},
+			AtomOp(OpGetgname, 0), []byte{OpInitelemArray, 0, 0, 0},
+			AtomOp(OpGetgname, 0), []byte{OpInitelemArray, 0, 0, 1},
+			[]byte{OpEndinit}, AtomOp(OpSetgname, 0), []byte{OpPop})
+	}
+	add := len(bc) + 10
+	bc = Asm(bc, AtomOp(OpGetgname, 0), AtomOp(OpString, 1), []byte{OpAdd, OpSetrval, OpRetrval})
+	nested := &sm33.Script{Atoms: []string{"a", ""}, Bytecode: bc}
+	_, err = New(sm33.Options{MaxHeapBytes: 1 << 20}).Run(nested)
+	var e *Error
+	if !errors.As(err, &e) || e.Off != add {
+		t.Errorf("nested arrays: err = %v, want heap limit at the add", err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	// return cc.x + typeof jsb;
+	s := &sm33.Script{
+		Atoms: []string{"cc", "x", "jsb"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0), AtomOp(OpGetprop, 1),
+			AtomOp(OpName, 2), []byte{OpTypeof, OpAdd, OpSetrval, OpRetrval},
+		),
+	}
+	m := New(sm33.DefaultOptions())
+	var asked []string
+	m.Host = func(m *Machine, name string) (Value, bool) {
+		asked = append(asked, name)
+		if name != "cc" {
+			return Value{}, false
+		}
+		o := m.NewObject()
+		o.Set("x", Num(1))
+		return ObjValue(o), true
+	}
+	v, err := m.Run(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Str != "1undefined" {
+		t.Errorf("result = %s, want \"1undefined\"", Inspect(v))
+	}
+	if len(asked) != 2 || !m.Global.Has("cc") || m.Global.Has("jsb") {
+		t.Errorf("host asked %v, global cc=%v jsb=%v", asked, m.Global.Has("cc"), m.Global.Has("jsb"))
+	}
+}
+
+func TestInspect(t *testing.T) {
+	m := New(sm33.DefaultOptions())
+	o := m.NewObject()
+	o.Set("a", Str("x"))
+	o.Set("b", ObjValue(m.NewArray([]Value{Num(1), Boolean(true), null})))
+	o.Set("self", ObjValue(o))
+	if got, want := Inspect(ObjValue(o)), `{a: "x", b: [1, true, null], self: [Circular]}`; got != want {
+		t.Errorf("Inspect = %s, want %s", got, want)
+	}
+}
+
+func TestStringIndex(t *testing.T) {
+	m := New(sm33.DefaultOptions())
+	inf := Num(math.Inf(1))
+	for _, tc := range []struct {
+		method string
+		args   []Value
+		want   string
+	}{
+		{"charAt", nil, "a"},
+		{"charAt", []Value{Num(math.NaN())}, "a"},
+		{"charAt", []Value{Num(-0.5)}, "a"},
+		{"charAt", []Value{inf}, ""},
+		{"charCodeAt", nil, "97"},
+		{"charCodeAt", []Value{Num(-math.MaxFloat64)}, "NaN"},
+		{"substr", []Value{Num(1), inf}, "bc"},
+		{"substr", []Value{Num(math.Inf(-1)), Num(1e300)}, "abc"},
+		{"substring", []Value{Num(math.NaN()), inf}, "abc"},
+		{"slice", []Value{Num(math.Inf(-1)), Num(-1)}, "ab"},
+	} {
+		v, err := m.Call(m.stringProto.Get(tc.method), Str("abc"), tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.String(); got != tc.want {
+			t.Errorf("%q.%s(%s) = %q, want %q", "abc", tc.method, Inspect(ObjValue(m.NewArray(tc.args))), got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package interp
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ValueKind is the type of a Value.
+type ValueKind uint8
+
+const (
+	Undefined ValueKind = iota
+	Null
+	Bool
+	Number
+	String
+	Obj
+)
+
+// Value is a JavaScript value. Strings are held as Go strings; string
+// operations index them by UTF-16 code unit, as JavaScript does.
+type Value struct {
+	Kind ValueKind
+	Bool bool
+	Num  float64
+	Str  string
+	Obj  *Object
+}
+
+// Constructors for the primitive values.
+var (
+	undefined = Value{}
+	null      = Value{Kind: Null}
+)
+
+// Num returns a number value.
+func Num(f float64) Value { return Value{Kind: Number, Num: f} }
+
+// Str returns a string value.
+func Str(s string) Value { return Value{Kind: String, Str: s} }
+
+// Boolean returns a boolean value.
+func Boolean(b bool) Value { return Value{Kind: Bool, Bool: b} }
+
+// ObjValue returns an object value.
+func ObjValue(o *Object) Value { return Value{Kind: Obj, Obj: o} }
+
+// Native is a host function implemented in Go.
+type Native func(m *Machine, this Value, args []Value) (Value, error)
+
+// Object is a JavaScript object. Arrays keep their dense elements in Elems;
+// functions carry either an interpreted closure or a Native.
+type Object struct {
+	Class  string // "Object", "Array", "Function", "Arguments", "RegExp"
+	Proto  *Object
+	Elems  []Value // dense elements, Class "Array" and "Arguments"
+	Fn     *Closure
+	Native Native
+	Source string // RegExp source
+	Flags  string // RegExp flags, e.g. "gi"
+
+	// Missing, when set, supplies properties that neither the object nor
+	// its prototypes define. The result is cached as an own property.
+	Missing func(name string) (Value, bool)
+
+	props   map[string]Value
+	builtin bool     // standard prototype: its properties are not enumerable
+	keys    []string // property names in insertion order
+}
+
+// Get returns the own or inherited property name, or undefined.
+func (o *Object) Get(name string) Value {
+	for p := o; p != nil; p = p.Proto {
+		if p.Elems != nil || p.Class == "Array" {
+			if name == "length" {
+				return Num(float64(len(p.Elems)))
+			}
+			if i, ok := arrayIndex(name); ok {
+				if i < len(p.Elems) {
+					return p.Elems[i]
+				}
+				continue
+			}
+		}
+		if v, ok := p.props[name]; ok {
+			return v
+		}
+	}
+	if o.Missing != nil {
+		if v, ok := o.Missing(name); ok {
+			o.Set(name, v)
+			return v
+		}
+	}
+	return undefined
+}
+
+// Set assigns an own property. Array indices and length resize Elems.
+func (o *Object) Set(name string, v Value) {
+	if o.Class == "Array" || o.Elems != nil {
+		if i, ok := arrayIndex(name); ok && i < maxElems {
+			for len(o.Elems) <= i {
+				o.Elems = append(o.Elems, undefined)
+			}
+			o.Elems[i] = v
+			return
+		}
+		if name == "length" {
+			n := int(toUint32(v.number()))
+			if n < len(o.Elems) {
+				o.Elems = o.Elems[:n]
+			}
+			for len(o.Elems) < n && n <= maxElems {
+				o.Elems = append(o.Elems, undefined)
+			}
+			return
+		}
+	}
+	if o.props == nil {
+		o.props = map[string]Value{}
+	}
+	if _, ok := o.props[name]; !ok {
+		o.keys = append(o.keys, name)
+	}
+	o.props[name] = v
+}
+
+// growth returns the bytes Set(name, v) would add to o.
+func (o *Object) growth(name string, v Value) int {
+	if o.Class == "Array" || o.Elems != nil {
+		if i, ok := arrayIndex(name); ok && i < maxElems {
+			return valueSize * max(0, i+1-len(o.Elems))
+		}
+		if name == "length" {
+			if n := int(toUint32(v.number())); n <= maxElems {
+				return valueSize * max(0, n-len(o.Elems))
+			}
+			return 0
+		}
+	}
+	if _, ok := o.props[name]; ok {
+		return 0
+	}
+	return valueSize + len(name)
+}
+
+// Has reports whether the object or its prototypes define name.
+func (o *Object) Has(name string) bool {
+	for p := o; p != nil; p = p.Proto {
+		if p.Elems != nil || p.Class == "Array" {
+			if name == "length" {
+				return true
+			}
+			if i, ok := arrayIndex(name); ok && i < len(p.Elems) {
+				return true
+			}
+		}
+		if _, ok := p.props[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes an own property.
+func (o *Object) Delete(name string) {
+	if _, ok := o.props[name]; !ok {
+		return
+	}
+	delete(o.props, name)
+	for i, k := range o.keys {
+		if k == name {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the own enumerable property names: array indices first,
+// then named properties in insertion order.
+func (o *Object) Keys() []string {
+	var out []string
+	for i := range o.Elems {
+		out = append(out, strconv.Itoa(i))
+	}
+	return append(out, o.keys...)
+}
+
+// maxElems caps array growth through index and length assignment.
+const maxElems = 1 << 24
+
+func arrayIndex(name string) (int, bool) {
+	if name == "" || (len(name) > 1 && name[0] == '0') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// IsCallable reports whether v is a function.
+func (v Value) IsCallable() bool {
+	return v.Kind == Obj && (v.Obj.Fn != nil || v.Obj.Native != nil)
+}
+
+// Truthy implements ToBoolean.
+func (v Value) Truthy() bool {
+	switch v.Kind {
+	case Bool:
+		return v.Bool
+	case Number:
+		return v.Num != 0 && !math.IsNaN(v.Num)
+	case String:
+		return v.Str != ""
+	case Obj:
+		return true
+	}
+	return false
+}
+
+// String implements ToString for primitives; objects render as
+// "[object Class]", arrays as their joined elements, with an array nested
+// in itself as "".
+func (v Value) String() string {
+	s, _ := stringify(v, nil)
+	return s
+}
+
+// stringify is ToString with charge, when not nil, called with the length
+// of each piece an array join appends before it is appended, so a caller
+// can bound the result. It stops at the first error charge returns.
+func stringify(v Value, charge func(n int) error) (string, error) {
+	if v.Kind == Obj && v.Obj.Class == "Array" {
+		var b strings.Builder
+		err := join(&b, v.Obj, ",", charge, map[*Object]bool{})
+		return b.String(), err
+	}
+	switch v.Kind {
+	case Undefined:
+		return "undefined", nil
+	case Null:
+		return "null", nil
+	case Bool:
+		if v.Bool {
+			return "true", nil
+		}
+		return "false", nil
+	case Number:
+		return numberString(v.Num), nil
+	case String:
+		return v.Str, nil
+	}
+	o := v.Obj
+	switch {
+	case o.Class == "RegExp":
+		return "/" + o.Source + "/", nil
+	case o.Fn != nil || o.Native != nil:
+		return "function () { [native code] }", nil
+	}
+	return "[object " + o.Class + "]", nil
+}
+
+// join writes the elements of o to b separated by sep, undefined and null
+// as "". Arrays in seen are being joined already, so an array that
+// contains itself renders there as "", as in browsers.
+func join(b *strings.Builder, o *Object, sep string, charge func(n int) error, seen map[*Object]bool) error {
+	if seen[o] {
+		return nil
+	}
+	seen[o] = true
+	defer delete(seen, o)
+	write := func(s string) error {
+		if charge != nil {
+			if err := charge(len(s)); err != nil {
+				return err
+			}
+		}
+		b.WriteString(s)
+		return nil
+	}
+	for i, e := range o.Elems {
+		if i > 0 {
+			if err := write(sep); err != nil {
+				return err
+			}
+		}
+		switch {
+		case e.Kind == Undefined || e.Kind == Null:
+		case e.Kind == Obj && e.Obj.Class == "Array":
+			if err := join(b, e.Obj, ",", charge, seen); err != nil {
+				return err
+			}
+		default:
+			if err := write(e.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Inspect renders v as a JavaScript literal for display: strings quoted,
+// arrays and plain objects expanded to a bounded depth, functions by name.
+func Inspect(v Value) string {
+	var b strings.Builder
+	inspect(&b, v, 0, map[*Object]bool{})
+	return b.String()
+}
+
+// inspectDepth bounds how far Inspect expands nested objects.
+const inspectDepth = 4
+
+func inspect(b *strings.Builder, v Value, depth int, seen map[*Object]bool) {
+	switch v.Kind {
+	case String:
+		b.WriteString(strconv.Quote(v.Str))
+		return
+	case Obj:
+	default:
+		b.WriteString(v.String())
+		return
+	}
+	o := v.Obj
+	switch {
+	case o.Fn != nil:
+		b.WriteString("[Function " + funcName(o.Fn.Func) + "]")
+		return
+	case o.Native != nil:
+		b.WriteString("[Function native]")
+		return
+	case o.Class == "RegExp":
+		b.WriteString("/" + o.Source + "/" + o.Flags)
+		return
+	case seen[o]:
+		b.WriteString("[Circular]")
+		return
+	case depth >= inspectDepth:
+		b.WriteString("[" + o.Class + "]")
+		return
+	}
+	seen[o] = true
+	defer delete(seen, o)
+	if o.Class == "Array" || o.Class == "Arguments" {
+		b.WriteByte('[')
+		for i, e := range o.Elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			inspect(b, e, depth+1, seen)
+		}
+		b.WriteByte(']')
+		return
+	}
+	b.WriteByte('{')
+	for i, k := range o.Keys() {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(k + ": ")
+		inspect(b, o.Get(k), depth+1, seen)
+	}
+	b.WriteByte('}')
+}
+
+// number implements ToNumber.
+func (v Value) number() float64 {
+	switch v.Kind {
+	case Null:
+		return 0
+	case Bool:
+		if v.Bool {
+			return 1
+		}
+		return 0
+	case Number:
+		return v.Num
+	case String:
+		return stringNumber(v.Str)
+	case Obj:
+		return stringNumber(v.String())
+	}
+	return math.NaN()
+}
+
+// stringNumber implements ToNumber for strings, including hex literals.
+func stringNumber(s string) float64 {
+	t := strings.TrimSpace(s)
+	if t == "" {
+		return 0
+	}
+	if len(t) > 2 && t[0] == '0' && (t[1] == 'x' || t[1] == 'X') {
+		if n, err := strconv.ParseUint(t[2:], 16, 64); err == nil {
+			return float64(n)
+		}
+		return math.NaN()
+	}
+	switch t {
+	case "Infinity", "+Infinity":
+		return math.Inf(1)
+	case "-Infinity":
+		return math.Inf(-1)
+	}
+	if f, err := strconv.ParseFloat(t, 64); err == nil && !strings.ContainsAny(t, "xXpP_") {
+		return f
+	}
+	return math.NaN()
+}
+
+// numberString renders a number the way JavaScript's ToString does.
+func numberString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	case f == math.Trunc(f) && math.Abs(f) < 1e21:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if a := math.Abs(f); a >= 1e-6 && a < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	// Go writes 1e-07; JavaScript writes 1e-7.
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mant, exp := s[:i], s[i+1:]
+		sign := exp[0]
+		exp = strings.TrimLeft(exp[1:], "0")
+		s = mant + "e" + string(sign) + exp
+	}
+	return s
+}
+
+// toInt32 implements ToInt32.
+func toInt32(f float64) int32 {
+	return int32(toUint32(f))
+}
+
+// toUint32 implements ToUint32.
+func toUint32(f float64) uint32 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return uint32(int64(math.Mod(math.Trunc(f), 1<<32)))
+}
+
+// typeOf implements the typeof operator.
+func typeOf(v Value) string {
+	switch v.Kind {
+	case Undefined:
+		return "undefined"
+	case Null:
+		return "object"
+	case Bool:
+		return "boolean"
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	}
+	if v.IsCallable() {
+		return "function"
+	}
+	return "object"
+}
+
+// strictEquals implements ===.
+func strictEquals(a, b Value) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	switch a.Kind {
+	case Undefined, Null:
+		return true
+	case Bool:
+		return a.Bool == b.Bool
+	case Number:
+		return a.Num == b.Num
+	case String:
+		return a.Str == b.Str
+	}
+	return a.Obj == b.Obj
+}
+
+// looseEquals implements ==.
+func looseEquals(a, b Value) bool {
+	if a.Kind == b.Kind {
+		return strictEquals(a, b)
+	}
+	nullish := func(v Value) bool { return v.Kind == Undefined || v.Kind == Null }
+	switch {
+	case nullish(a) || nullish(b):
+		return nullish(a) && nullish(b)
+	case a.Kind == Obj:
+		return looseEquals(Str(a.String()), b)
+	case b.Kind == Obj:
+		return looseEquals(a, Str(b.String()))
+	}
+	return a.number() == b.number()
+}
+
+// units returns s as UTF-16 code units.
+func units(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+// fromUnits builds a string from UTF-16 code units.
+func fromUnits(u []uint16) string {
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,289 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExprKind classifies a symbolic stack value.
+type ExprKind uint8
+
+const (
+	Unknown    ExprKind = iota // value the model cannot describe
+	Lit                        // literal; see LitKind
+	Name                       // scope or global lookup (name, getgname, bindname, getintrinsic)
+	This                       // this
+	Arg                        // getarg Slot
+	Local                      // getlocal Slot
+	Aliased                    // getaliasedvar Hops Slot
+	Prop                       // Obj.Atom
+	Elem                       // Obj[Index]
+	CallResult                 // value returned by Call
+	Lambda                     // lambda/lambda_arrow; Func is the object index
+	Object                     // newinit/newobject/object literal
+	Array                      // newarray literal
+	Regexp                     // regexp literal; Slot is the regexp index
+	Op                         // operator result; Op is the opcode, Args the operands
+	Callee                     // the running function (callee)
+	Arguments                  // arguments object
+)
+
+// LitKind identifies the type of a literal expression.
+type LitKind uint8
+
+const (
+	LitString LitKind = iota
+	LitNumber
+	LitBool
+	LitNull
+	LitUndefined
+)
+
+// Expr is a symbolic value on the abstract stack.
+type Expr struct {
+	Kind ExprKind
+
+	// Literals
+	LitKind LitKind
+	Str     string
+	Num     float64
+	Bool    bool
+
+	Atom  string // property or name atom (Name, Prop)
+	Text  string // binding name for Arg/Local, when known
+	Slot  int    // Arg/Local slot, Aliased slot, Regexp index
+	Off   int    // offset of the load instruction for Arg/Local/Aliased
+	Hops  int    // Aliased scope hops
+	Func  int    // object index for Lambda
+	Obj   *Expr  // receiver for Prop/Elem
+	Index *Expr  // key for Elem
+	Call  *Call  // producing call for CallResult
+	Op    uint8  // opcode for Op
+	Args  []*Expr
+}
+
+// IsLit reports whether e is a literal of kind k.
+func (e *Expr) IsLit(k LitKind) bool {
+	return e != nil && e.Kind == Lit && e.LitKind == k
+}
+
+// maxRenderDepth bounds String() recursion for deeply nested expressions.
+const maxRenderDepth = 8
+
+// String renders e as JavaScript-like source text.
+func (e *Expr) String() string {
+	var b strings.Builder
+	e.render(&b, 0)
+	return b.String()
+}
+
+func (e *Expr) render(b *strings.Builder, depth int) {
+	if e == nil {
+		b.WriteString("?")
+		return
+	}
+	if depth > maxRenderDepth {
+		b.WriteString("…")
+		return
+	}
+	switch e.Kind {
+	case Lit:
+		b.WriteString(e.litString())
+	case Name:
+		b.WriteString(e.Atom)
+	case This:
+		b.WriteString("this")
+	case Arg:
+		if e.Text != "" {
+			b.WriteString(e.Text)
+		} else {
+			fmt.Fprintf(b, "arg%d", e.Slot)
+		}
+	case Local:
+		if e.Text != "" {
+			b.WriteString(e.Text)
+		} else {
+			fmt.Fprintf(b, "local%d", e.Slot)
+		}
+	case Aliased:
+		if e.Text != "" {
+			b.WriteString(e.Text)
+		} else {
+			fmt.Fprintf(b, "aliased(%d,%d)", e.Hops, e.Slot)
+		}
+	case Prop:
+		e.Obj.render(b, depth+1)
+		b.WriteByte('.')
+		b.WriteString(e.Atom)
+	case Elem:
+		e.Obj.render(b, depth+1)
+		b.WriteByte('[')
+		e.Index.render(b, depth+1)
+		b.WriteByte(']')
+	case CallResult:
+		if e.Call == nil {
+			b.WriteString("?()")
+			return
+		}
+		if e.Call.Kind == CallNew || e.Call.Kind == CallSpreadNew {
+			b.WriteString("new ")
+		}
+		e.Call.Callee.render(b, depth+1)
+		b.WriteString("()")
+	case Lambda:
+		fmt.Fprintf(b, "lambda#%d", e.Func)
+	case Object:
+		b.WriteString("{}")
+	case Array:
+		b.WriteString("[]")
+	case Regexp:
+		fmt.Fprintf(b, "regexp#%d", e.Slot)
+	case Op:
+		e.renderOp(b, depth)
+	case Callee:
+		b.WriteString("callee")
+	case Arguments:
+		b.WriteString("arguments")
+	default:
+		b.WriteString("?")
+	}
+}
+
+func (e *Expr) litString() string {
+	switch e.LitKind {
+	case LitString:
+		return strconv.Quote(e.Str)
+	case LitNumber:
+		return strconv.FormatFloat(e.Num, 'g', -1, 64)
+	case LitBool:
+		if e.Bool {
+			return "true"
+		}
+		return "false"
+	case LitNull:
+		return "null"
+	default:
+		return "undefined"
+	}
+}
+
+// opSymbols maps operator opcodes to their source spelling.
+var opSymbols = map[uint8]string{
+	opBitor: "|", opBitxor: "^", opBitand: "&",
+	opEq: "==", opNe: "!=", opLt: "<", opLe: "<=", opGt: ">", opGe: ">=",
+	opLsh: "<<", opRsh: ">>", opUrsh: ">>>",
+	opAdd: "+", opSub: "-", opMul: "*", opDiv: "/", opMod: "%",
+	opStrictEq: "===", opStrictNe: "!==", opIn: "in", opInstanceof: "instanceof",
+	opNot: "!", opBitnot: "~", opNeg: "-", opPos: "+",
+	opTypeof: "typeof ", opTypeofExpr: "typeof ", opVoid: "void ",
+}
+
+func (e *Expr) renderOp(b *strings.Builder, depth int) {
+	sym, ok := opSymbols[e.Op]
+	if !ok {
+		b.WriteString("?")
+		return
+	}
+	switch len(e.Args) {
+	case 1:
+		b.WriteString(sym)
+		e.Args[0].render(b, depth+1)
+	case 2:
+		b.WriteByte('(')
+		e.Args[0].render(b, depth+1)
+		b.WriteString(" " + sym + " ")
+		e.Args[1].render(b, depth+1)
+		b.WriteByte(')')
+	default:
+		b.WriteString("?")
+	}
+}
+
+// LastAtom returns the final property or name atom of e, or "".
+func (e *Expr) LastAtom() string {
+	if e == nil {
+		return ""
+	}
+	if e.Kind == Name || e.Kind == Prop {
+		return e.Atom
+	}
+	return ""
+}
+
+// Path renders a static property path (a.b.c, this.x), or "" when e
+// contains calls or unknown parts.
+func (e *Expr) Path() string {
+	if e == nil {
+		return ""
+	}
+	switch e.Kind {
+	case Name:
+		return e.Atom
+	case This:
+		return "this"
+	case Prop:
+		if obj := e.Obj.Path(); obj != "" {
+			return obj + "." + e.Atom
+		}
+	}
+	return ""
+}
+
+// CallKind identifies the invoking opcode of a call site.
+type CallKind uint8
+
+const (
+	CallNormal     CallKind = iota // call
+	CallNew                        // new
+	CallFuncall                    // fun.call(thisArg, ...)
+	CallFunapply                   // fun.apply(thisArg, args)
+	CallEval                       // eval
+	CallSpread                     // spreadcall
+	CallSpreadNew                  // spreadnew
+	CallSpreadEval                 // spreadeval
+)
+
+var callKindNames = [...]string{
+	CallNormal:     "call",
+	CallNew:        "new",
+	CallFuncall:    "funcall",
+	CallFunapply:   "funapply",
+	CallEval:       "eval",
+	CallSpread:     "spreadcall",
+	CallSpreadNew:  "spreadnew",
+	CallSpreadEval: "spreadeval",
+}
+
+func (k CallKind) String() string {
+	if int(k) < len(callKindNames) {
+		return callKindNames[k]
+	}
+	return fmt.Sprintf("callkind(%d)", k)
+}
+
+// Call is a call site recovered by stack simulation.
+type Call struct {
+	Offset int
+	Kind   CallKind
+	// Callee is the invoked function. For funcall/funapply through a
+	// ".call"/".apply" property it is the function receiving the call.
+	Callee *Expr
+	This   *Expr
+	Argc   int     // operand of the call opcode; -1 for spread variants
+	Args   []*Expr // one entry per argument slot (the spread array for spread variants)
+}
+
+// Target returns the callee's full receiver path,
+// e.g. "cc.director.getScheduler().schedule".
+func (c *Call) Target() string {
+	return c.Callee.String()
+}
+
+// Name returns the callee's final atom, or Target() when it has none.
+func (c *Call) Name() string {
+	if a := c.Callee.LastAtom(); a != "" {
+		return a
+	}
+	return c.Target()
+}
@@ -0,0 +1,86 @@
+// Package ir models SM33 bytecode as decoded instructions and symbolic
+// stack expressions, so analyses can ask "what is on the stack here"
+// instead of guessing from nearby opcodes.
+package ir
+
+import (
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+)
+
+// Instr is one decoded bytecode instruction.
+type Instr struct {
+	Off int
+	Op  uint8
+	Len int
+}
+
+// Name returns the opcode mnemonic.
+func (in Instr) Name() string {
+	return bytecode.Opcodes[in.Op].Name
+}
+
+// Next returns the offset of the following instruction.
+func (in Instr) Next() int {
+	return in.Off + in.Len
+}
+
+// Decode splits bytecode into instructions. Decoding stops at the first
+// instruction whose length cannot be determined or whose operands run
+// past the end of bc, so every returned instruction lies within bc.
+func Decode(bc []byte) []Instr {
+	var out []Instr
+	off := 0
+	for off < len(bc) {
+		n := bytecode.InstrLen(bc, off)
+		if n <= 0 || off+n > len(bc) {
+			break
+		}
+		out = append(out, Instr{Off: off, Op: bc[off], Len: n})
+		off += n
+	}
+	return out
+}
+
+// Atom returns the atom the instruction at off of s names, or "" when
+// its index is out of range.
+func Atom(s *sm33.Script, off int) string {
+	if idx, ok := bytecode.GetUint32Index(s.Bytecode, off); ok && int(idx) < len(s.Atoms) {
+		return s.Atoms[idx]
+	}
+	return ""
+}
+
+// JumpTargets returns the branch targets of the instruction at bc[off],
+// including every tableswitch case. Fall-through is not included.
+func JumpTargets(bc []byte, off int) []int {
+	op := bc[off]
+	switch bytecode.JofType(bytecode.Opcodes[op].Format) {
+	case bytecode.JOF_JUMP:
+		if jumpOff, ok := bytecode.GetJumpOffset(bc, off); ok {
+			return []int{off + int(jumpOff)}
+		}
+	case bytecode.JOF_TABLESWITCH:
+		defOff, ok := bytecode.GetJumpOffset(bc, off)
+		if !ok || off+13 > len(bc) {
+			return nil
+		}
+		targets := []int{off + int(defOff)}
+		lowVal := int32(bc[off+5])<<24 | int32(bc[off+6])<<16 | int32(bc[off+7])<<8 | int32(bc[off+8])
+		highVal := int32(bc[off+9])<<24 | int32(bc[off+10])<<16 | int32(bc[off+11])<<8 | int32(bc[off+12])
+		n := int(highVal) - int(lowVal) + 1
+		maxN := (len(bc) - (off + 13)) / 4
+		if n < 0 || n > maxN {
+			n = 0
+		}
+		for i := 0; i < n; i++ {
+			joff := off + 13 + i*4
+			caseOff := int32(bc[joff])<<24 | int32(bc[joff+1])<<16 | int32(bc[joff+2])<<8 | int32(bc[joff+3])
+			if caseOff != 0 {
+				targets = append(targets, off+int(caseOff))
+			}
+		}
+		return targets
+	}
+	return nil
+}
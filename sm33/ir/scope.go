@@ -0,0 +1,73 @@
+package ir
+
+import "github.com/zboralski/spidermonkey-dumper/sm33"
+
+// callObjectReserved is the number of reserved slots in a call object
+// before the first aliased binding (enclosing scope, callee).
+const callObjectReserved = 2
+
+// ScopeOwner returns the function script whose call object an aliased
+// access from s with the given hop count lands in, or nil. parent returns
+// the enclosing script, or nil for the top-level script.
+//
+// Only scripts with a call object, and named lambdas with a DeclEnv
+// object, add a hop; the top-level script has no call object.
+func ScopeOwner(s *sm33.Script, hops int, parent func(*sm33.Script) *sm33.Script) *sm33.Script {
+	for cur := s; cur != nil; cur = parent(cur) {
+		if parent(cur) == nil {
+			return nil
+		}
+		if cur.HasCallObject() {
+			if hops == 0 {
+				return cur
+			}
+			hops--
+		}
+		if cur.Bits&sm33.FlagFunNeedsDeclEnvObject != 0 {
+			if hops == 0 {
+				return nil // the lambda's own name
+			}
+			hops--
+		}
+	}
+	return nil
+}
+
+// AliasedName returns the name of the binding held in call object slot
+// of s, or "" if the slot does not map to an aliased binding.
+func AliasedName(s *sm33.Script, slot int) string {
+	n := slot - callObjectReserved
+	if n < 0 {
+		return ""
+	}
+	for i, aliased := range s.Aliased {
+		if !aliased {
+			continue
+		}
+		if n == 0 {
+			if i < len(s.Bindings) {
+				return s.Bindings[i]
+			}
+			return ""
+		}
+		n--
+	}
+	return ""
+}
+
+// BindingName returns the source name of argument slot (or local slot
+// when local is set) of s, or "" if unknown.
+func BindingName(s *sm33.Script, slot int, local bool) string {
+	if local {
+		if uint32(slot) >= s.Nvars {
+			return ""
+		}
+		slot += int(s.Nargs)
+	} else if slot >= int(s.Nargs) {
+		return ""
+	}
+	if slot >= 0 && slot < len(s.Bindings) {
+		return s.Bindings[slot]
+	}
+	return ""
+}
@@ -0,0 +1,556 @@
+package ir
+
+import (
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+)
+
+// Opcodes the stack model interprets. All others fall back to the
+// generic nuses/ndefs effect with unknown results.
+const (
+	opUndefined      = 1
+	opEnterwith      = 3
+	opReturn         = 5
+	opGoto           = 6
+	opIfeq           = 7
+	opIfne           = 8
+	opArguments      = 9
+	opSwap           = 10
+	opPopn           = 11
+	opDup            = 12
+	opDup2           = 13
+	opSetconst       = 14
+	opBitor          = 15
+	opBitxor         = 16
+	opBitand         = 17
+	opEq             = 18
+	opNe             = 19
+	opLt             = 20
+	opLe             = 21
+	opGt             = 22
+	opGe             = 23
+	opLsh            = 24
+	opRsh            = 25
+	opUrsh           = 26
+	opAdd            = 27
+	opSub            = 28
+	opMul            = 29
+	opDiv            = 30
+	opMod            = 31
+	opNot            = 32
+	opBitnot         = 33
+	opNeg            = 34
+	opPos            = 35
+	opTypeof         = 39
+	opVoid           = 40
+	opSpreadcall     = 41
+	opSpreadnew      = 42
+	opSpreadeval     = 43
+	opDupat          = 44
+	opGetprop        = 53
+	opSetprop        = 54
+	opGetelem        = 55
+	opSetelem        = 56
+	opCall           = 58
+	opName           = 59
+	opDouble         = 60
+	opString         = 61
+	opZero           = 62
+	opOne            = 63
+	opNull           = 64
+	opThis           = 65
+	opFalse          = 66
+	opTrue           = 67
+	opOr             = 68
+	opAnd            = 69
+	opTableswitch    = 70
+	opStrictEq       = 72
+	opStrictNe       = 73
+	opFunapply       = 79
+	opObject         = 80
+	opPop            = 81
+	opNew            = 82
+	opGetarg         = 84
+	opSetarg         = 85
+	opGetlocal       = 86
+	opSetlocal       = 87
+	opUint16         = 88
+	opNewinit        = 89
+	opNewarray       = 90
+	opNewobject      = 91
+	opInitprop       = 93
+	opInitelem       = 94
+	opInitelemInc    = 95
+	opInitelemArray  = 96
+	opInitpropGetter = 97
+	opInitpropSetter = 98
+	opInitelemGetter = 99
+	opInitelemSetter = 100
+	opFuncall        = 108
+	opSetname        = 111
+	opThrow          = 112
+	opIn             = 113
+	opInstanceof     = 114
+	opGosub          = 116
+	opRetsub         = 117
+	opCase           = 121
+	opDefault        = 122
+	opEval           = 123
+	opLambda         = 130
+	opLambdaArrow    = 131
+	opCallee         = 132
+	opPick           = 133
+	opGetaliasedvar  = 136
+	opSetaliasedvar  = 137
+	opGetintrinsic   = 143
+	opSetintrinsic   = 144
+	opRetrval        = 153
+	opGetgname       = 154
+	opSetgname       = 155
+	opRegexp         = 160
+	opCallprop       = 184
+	opUint24         = 188
+	opCallelem       = 193
+	opMutateproto    = 194
+	opGetxprop       = 195
+	opTypeofExpr     = 197
+	opInt8           = 215
+	opInt32          = 216
+	opLength         = 217
+	opRest           = 224
+	opToid           = 225
+	opImplicitthis   = 226
+	opTostring       = 228
+)
+
+// Stack is the abstract operand stack seen by a Visitor.
+// Visitors must treat it and the expressions on it as read-only.
+type Stack struct {
+	vals []*Expr
+}
+
+// Len returns the modelled stack depth.
+func (st *Stack) Len() int {
+	return len(st.vals)
+}
+
+// Peek returns the value i slots below the top (0 is the top).
+// Out-of-range slots read as Unknown.
+func (st *Stack) Peek(i int) *Expr {
+	if i < 0 || i >= len(st.vals) {
+		return unknown()
+	}
+	return st.vals[len(st.vals)-1-i]
+}
+
+func (st *Stack) push(e *Expr) {
+	st.vals = append(st.vals, e)
+}
+
+func (st *Stack) pop() *Expr {
+	if len(st.vals) == 0 {
+		return unknown()
+	}
+	e := st.vals[len(st.vals)-1]
+	st.vals = st.vals[:len(st.vals)-1]
+	return e
+}
+
+// popN pops n values and returns them in push order (deepest first).
+func (st *Stack) popN(n int) []*Expr {
+	out := make([]*Expr, n)
+	for i := n - 1; i >= 0; i-- {
+		out[i] = st.pop()
+	}
+	return out
+}
+
+func (st *Stack) snapshot() []*Expr {
+	cp := make([]*Expr, len(st.vals))
+	copy(cp, st.vals)
+	return cp
+}
+
+func unknown() *Expr {
+	return &Expr{Kind: Unknown}
+}
+
+// Visitor is called before each instruction executes, with the stack
+// as it stands on entry to that instruction.
+type Visitor func(in Instr, st *Stack)
+
+// simulator carries the state of one Simulate run.
+type simulator struct {
+	s       *sm33.Script
+	bc      []byte
+	st      Stack
+	pending map[int][]*Expr // forward-jump states keyed by target
+	back    map[int][]*Expr // backward-jump states from the previous pass
+	calls   []*Call
+	decoded map[int]string
+}
+
+// Decoded holds, per script of a tree, the plain strings that calls at
+// known offsets return, as found by package deobf. The stack model pushes
+// such a string in place of the call result.
+type Decoded map[*sm33.Script]map[int]string
+
+// Simulate runs the abstract stack model over s's bytecode in offset
+// order and returns every call site it finds.
+//
+// The model is path-insensitive: states from forward jumps are merged at
+// their targets (disagreeing slots become Unknown), and blocks reached
+// only by a backward jump start from the state recorded at that jump on a
+// first pass. visit may be nil.
+func Simulate(s *sm33.Script, visit Visitor) []*Call {
+	return SimulateDecoded(s, nil, visit)
+}
+
+// SimulateDecoded is Simulate with the call results of dec, which maps
+// call offsets of s to the plain strings they return, taken as literals.
+func SimulateDecoded(s *sm33.Script, dec map[int]string, visit Visitor) []*Call {
+	if s == nil {
+		return nil
+	}
+	instrs := Decode(s.Bytecode)
+	back := map[int][]*Expr{}
+	var sim *simulator
+	for pass := 0; pass < 2; pass++ {
+		sim = &simulator{s: s, bc: s.Bytecode, pending: map[int][]*Expr{}, back: back, decoded: dec}
+		live := true
+		for _, in := range instrs {
+			if saved, ok := sim.pending[in.Off]; ok {
+				if live {
+					sim.st.vals = mergeStacks(sim.st.vals, saved)
+				} else {
+					sim.st.vals = saved
+				}
+				delete(sim.pending, in.Off)
+			} else if !live {
+				sim.st.vals = nil
+				if saved, ok := back[in.Off]; ok {
+					sim.st.vals = append([]*Expr(nil), saved...)
+				}
+			}
+			if pass == 1 && visit != nil {
+				visit(in, &sim.st)
+			}
+			live = sim.step(in)
+		}
+	}
+	return sim.calls
+}
+
+// Calls returns the call sites of s found by stack simulation.
+func Calls(s *sm33.Script) []*Call {
+	return Simulate(s, nil)
+}
+
+// jump records the current stack as the entry state of target.
+func (sim *simulator) jump(from, target int) {
+	if target <= from {
+		if _, ok := sim.back[target]; !ok {
+			sim.back[target] = sim.st.snapshot()
+		}
+		return
+	}
+	snap := sim.st.snapshot()
+	if prev, ok := sim.pending[target]; ok {
+		snap = mergeStacks(prev, snap)
+	}
+	sim.pending[target] = snap
+}
+
+// mergeStacks joins two entry states. Mismatched depths keep a;
+// disagreeing slots become Unknown.
+func mergeStacks(a, b []*Expr) []*Expr {
+	if len(a) != len(b) {
+		return a
+	}
+	out := make([]*Expr, len(a))
+	for i := range a {
+		if a[i] == b[i] || a[i].String() == b[i].String() {
+			out[i] = a[i]
+		} else {
+			out[i] = unknown()
+		}
+	}
+	return out
+}
+
+func (sim *simulator) atom(off int) string {
+	return Atom(sim.s, off)
+}
+
+func (sim *simulator) index(off int) int {
+	idx, _ := bytecode.GetUint32Index(sim.bc, off)
+	return int(idx)
+}
+
+func num(v float64) *Expr {
+	return &Expr{Kind: Lit, LitKind: LitNumber, Num: v}
+}
+
+// ConstExpr converts a script constant to a literal expression.
+func ConstExpr(c sm33.Const) *Expr {
+	switch c.Kind {
+	case sm33.ConstInt:
+		return num(float64(c.Int))
+	case sm33.ConstDouble:
+		return num(c.Double)
+	case sm33.ConstAtom:
+		return &Expr{Kind: Lit, LitKind: LitString, Str: c.Atom}
+	case sm33.ConstTrue:
+		return &Expr{Kind: Lit, LitKind: LitBool, Bool: true}
+	case sm33.ConstFalse:
+		return &Expr{Kind: Lit, LitKind: LitBool}
+	case sm33.ConstNull:
+		return &Expr{Kind: Lit, LitKind: LitNull}
+	case sm33.ConstVoid, sm33.ConstHole:
+		return &Expr{Kind: Lit, LitKind: LitUndefined}
+	case sm33.ConstObject:
+		return &Expr{Kind: Object}
+	}
+	return unknown()
+}
+
+// bindingName returns the source name for an arg or local slot, if known.
+func (sim *simulator) bindingName(slot int, local bool) string {
+	return BindingName(sim.s, slot, local)
+}
+
+// step applies one instruction and reports whether control can fall
+// through to the next instruction.
+func (sim *simulator) step(in Instr) bool {
+	st := &sim.st
+	bc := sim.bc
+	off := in.Off
+
+	switch in.Op {
+	case opUndefined, opImplicitthis:
+		st.push(&Expr{Kind: Lit, LitKind: LitUndefined})
+	case opNull:
+		st.push(&Expr{Kind: Lit, LitKind: LitNull})
+	case opTrue:
+		st.push(&Expr{Kind: Lit, LitKind: LitBool, Bool: true})
+	case opFalse:
+		st.push(&Expr{Kind: Lit, LitKind: LitBool})
+	case opZero:
+		st.push(num(0))
+	case opOne:
+		st.push(num(1))
+	case opInt8:
+		v, _ := bytecode.GetInt8(bc, off)
+		st.push(num(float64(v)))
+	case opInt32:
+		v, _ := bytecode.GetInt32(bc, off)
+		st.push(num(float64(v)))
+	case opUint16:
+		v, _ := bytecode.GetUint16(bc, off)
+		st.push(num(float64(v)))
+	case opUint24:
+		v, _ := bytecode.GetUint24(bc, off)
+		st.push(num(float64(v)))
+	case opString:
+		st.push(&Expr{Kind: Lit, LitKind: LitString, Str: sim.atom(off)})
+	case opDouble:
+		if idx := sim.index(off); idx < len(sim.s.Consts) {
+			st.push(ConstExpr(sim.s.Consts[idx]))
+		} else {
+			st.push(unknown())
+		}
+	case opThis:
+		st.push(&Expr{Kind: This})
+	case opCallee:
+		st.push(&Expr{Kind: Callee})
+	case opArguments:
+		st.push(&Expr{Kind: Arguments})
+	case opRest, opNewarray:
+		st.push(&Expr{Kind: Array})
+	case opNewinit, opNewobject, opObject:
+		st.push(&Expr{Kind: Object})
+	case opRegexp:
+		st.push(&Expr{Kind: Regexp, Slot: sim.index(off)})
+	case opLambda:
+		st.push(&Expr{Kind: Lambda, Func: sim.index(off)})
+	case opLambdaArrow:
+		st.pop()
+		st.push(&Expr{Kind: Lambda, Func: sim.index(off)})
+
+	case opName, opGetgname, opGetintrinsic:
+		st.push(&Expr{Kind: Name, Atom: sim.atom(off)})
+	case opGetarg:
+		v, _ := bytecode.GetArgno(bc, off)
+		st.push(&Expr{Kind: Arg, Slot: int(v), Off: off, Text: sim.bindingName(int(v), false)})
+	case opGetlocal:
+		v, _ := bytecode.GetLocalno(bc, off)
+		st.push(&Expr{Kind: Local, Slot: int(v), Off: off, Text: sim.bindingName(int(v), true)})
+	case opGetaliasedvar:
+		if off+5 <= len(bc) {
+			hops := int(bc[off+1])
+			slot := int(bc[off+2])<<16 | int(bc[off+3])<<8 | int(bc[off+4])
+			st.push(&Expr{Kind: Aliased, Hops: hops, Slot: slot, Off: off})
+		} else {
+			st.push(unknown())
+		}
+	case opSetarg, opSetlocal, opSetaliasedvar, opSetconst:
+		// value stays on the stack
+
+	case opGetprop, opCallprop, opLength, opGetxprop:
+		obj := st.pop()
+		st.push(&Expr{Kind: Prop, Obj: obj, Atom: sim.atom(off)})
+	case opGetelem, opCallelem:
+		idx := st.pop()
+		obj := st.pop()
+		st.push(&Expr{Kind: Elem, Obj: obj, Index: idx})
+	case opSetprop, opSetname, opSetgname, opSetintrinsic:
+		val := st.pop()
+		st.pop()
+		st.push(val)
+	case opSetelem:
+		val := st.pop()
+		st.popN(2)
+		st.push(val)
+
+	case opInitprop, opInitpropGetter, opInitpropSetter, opInitelemArray, opMutateproto:
+		st.pop()
+	case opInitelem, opInitelemGetter, opInitelemSetter:
+		st.popN(2)
+	case opInitelemInc:
+		st.popN(2)
+		st.push(unknown())
+
+	case opSwap:
+		a := st.pop()
+		b := st.pop()
+		st.push(a)
+		st.push(b)
+	case opDup:
+		st.push(st.Peek(0))
+	case opDup2:
+		a, b := st.Peek(1), st.Peek(0)
+		st.push(a)
+		st.push(b)
+	case opDupat:
+		n, _ := bytecode.GetUint24(bc, off)
+		st.push(st.Peek(int(n)))
+	case opPick:
+		if off+2 <= len(bc) {
+			n := int(bc[off+1])
+			if n < len(st.vals) {
+				i := len(st.vals) - 1 - n
+				e := st.vals[i]
+				st.vals = append(st.vals[:i], st.vals[i+1:]...)
+				st.push(e)
+			}
+		}
+	case opPop:
+		st.pop()
+	case opPopn:
+		n, _ := bytecode.GetUint16(bc, off)
+		st.popN(int(n))
+	case opToid, opTostring:
+		// identity for modelling purposes
+
+	case opBitor, opBitxor, opBitand, opEq, opNe, opLt, opLe, opGt, opGe,
+		opLsh, opRsh, opUrsh, opAdd, opSub, opMul, opDiv, opMod,
+		opStrictEq, opStrictNe, opIn, opInstanceof:
+		args := st.popN(2)
+		st.push(&Expr{Kind: Op, Op: in.Op, Args: args})
+	case opNot, opBitnot, opNeg, opPos, opTypeof, opTypeofExpr, opVoid:
+		a := st.pop()
+		st.push(&Expr{Kind: Op, Op: in.Op, Args: []*Expr{a}})
+
+	case opCall, opNew, opFuncall, opFunapply, opEval:
+		argc, _ := bytecode.GetUint16(bc, off)
+		args := st.popN(int(argc))
+		sim.call(in, callKindOf(in.Op), int(argc), args)
+	case opSpreadcall, opSpreadnew, opSpreadeval:
+		arr := st.pop()
+		sim.call(in, callKindOf(in.Op), -1, []*Expr{arr})
+
+	// Control flow
+	case opGoto:
+		for _, t := range JumpTargets(bc, off) {
+			sim.jump(off, t)
+		}
+		return false
+	case opIfeq, opIfne:
+		st.pop()
+		for _, t := range JumpTargets(bc, off) {
+			sim.jump(off, t)
+		}
+	case opOr, opAnd, opGosub:
+		for _, t := range JumpTargets(bc, off) {
+			sim.jump(off, t)
+		}
+	case opCase:
+		st.pop() // rval
+		lval := st.pop()
+		for _, t := range JumpTargets(bc, off) {
+			sim.jump(off, t)
+		}
+		st.push(lval)
+	case opDefault, opTableswitch:
+		st.pop()
+		for _, t := range JumpTargets(bc, off) {
+			sim.jump(off, t)
+		}
+		return false
+	case opRetsub:
+		st.popN(2)
+		return false
+	case opReturn, opThrow:
+		st.pop()
+		return false
+	case opRetrval:
+		return false
+	case opEnterwith:
+		st.pop()
+
+	default:
+		st.popN(bytecode.StackUses(bc, off))
+		for i := bytecode.StackDefs(bc, off); i > 0; i-- {
+			st.push(unknown())
+		}
+	}
+	return true
+}
+
+func callKindOf(op uint8) CallKind {
+	switch op {
+	case opNew:
+		return CallNew
+	case opFuncall:
+		return CallFuncall
+	case opFunapply:
+		return CallFunapply
+	case opEval:
+		return CallEval
+	case opSpreadcall:
+		return CallSpread
+	case opSpreadnew:
+		return CallSpreadNew
+	case opSpreadeval:
+		return CallSpreadEval
+	}
+	return CallNormal
+}
+
+// call pops this and callee, records the call site and pushes its result,
+// or the plain string when deobfuscation decoded the call.
+func (sim *simulator) call(in Instr, kind CallKind, argc int, args []*Expr) {
+	this := sim.st.pop()
+	callee := sim.st.pop()
+	if (kind == CallFuncall || kind == CallFunapply) && callee.Kind == Prop &&
+		(callee.Atom == "call" || callee.Atom == "apply") {
+		callee = callee.Obj
+	}
+	c := &Call{Offset: in.Off, Kind: kind, Callee: callee, This: this, Argc: argc, Args: args}
+	sim.calls = append(sim.calls, c)
+	if str, ok := sim.decoded[in.Off]; ok {
+		sim.st.push(&Expr{Kind: Lit, LitKind: LitString, Str: str})
+		return
+	}
+	sim.st.push(&Expr{Kind: CallResult, Call: c})
+}
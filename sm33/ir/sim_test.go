@@ -0,0 +1,149 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+func TestReceiverPath(t *testing.T) {
+	// cc.director.getScheduler().schedule(fn, 0)
+	s := &sm33.Script{
+		Atoms: []string{"cc", "director", "getScheduler", "schedule"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0),
+			AtomOp(OpGetprop, 1),
+			[]byte{OpDup}, AtomOp(OpCallprop, 2), []byte{OpSwap},
+			ArgcOp(OpCall, 0),
+			[]byte{OpDup}, AtomOp(OpCallprop, 3), []byte{OpSwap},
+			AtomOp(OpLambda, 0),
+			[]byte{OpZero},
+			ArgcOp(OpCall, 2),
+			[]byte{OpPop},
+		),
+	}
+	calls := Calls(s)
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if got := calls[0].Target(); got != "cc.director.getScheduler" {
+		t.Errorf("calls[0] = %q", got)
+	}
+	c := calls[1]
+	if got := c.Target(); got != "cc.director.getScheduler().schedule" {
+		t.Errorf("calls[1] = %q", got)
+	}
+	if c.Argc != 2 || len(c.Args) != 2 {
+		t.Fatalf("argc=%d args=%d, want 2", c.Argc, len(c.Args))
+	}
+	if c.Args[0].Kind != Lambda || c.Args[0].Func != 0 {
+		t.Errorf("arg0 = %s, want lambda#0", c.Args[0])
+	}
+	if c.This.String() != "cc.director.getScheduler()" {
+		t.Errorf("this = %s", c.This)
+	}
+}
+
+func TestArgumentPropertyReads(t *testing.T) {
+	// f(a.b) — the last getprop before the call is an argument, not the callee.
+	s := &sm33.Script{
+		Atoms: []string{"f", "a", "b"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0), []byte{OpUndefined},
+			AtomOp(OpName, 1), AtomOp(OpGetprop, 2),
+			ArgcOp(OpCall, 1),
+		),
+	}
+	calls := Calls(s)
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	if calls[0].Target() != "f" {
+		t.Errorf("target = %q, want f", calls[0].Target())
+	}
+	if calls[0].Args[0].String() != "a.b" {
+		t.Errorf("arg0 = %q, want a.b", calls[0].Args[0])
+	}
+}
+
+func TestCallKinds(t *testing.T) {
+	// new Foo(1); bar.call(this, "x"); eval("1")
+	s := &sm33.Script{
+		Atoms: []string{"Foo", "bar", "call", "x", "eval", "1"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0), []byte{OpUndefined}, []byte{OpOne}, ArgcOp(OpNew, 1), []byte{OpPop},
+			AtomOp(OpName, 1), []byte{OpDup}, AtomOp(OpCallprop, 2), []byte{OpSwap},
+			[]byte{OpThis}, AtomOp(OpString, 3), ArgcOp(OpFuncall, 2), []byte{OpPop},
+			AtomOp(OpName, 4), []byte{OpUndefined}, AtomOp(OpString, 5), ArgcOp(OpEval, 1), []byte{OpPop},
+		),
+	}
+	calls := Calls(s)
+	want := []struct {
+		kind   CallKind
+		target string
+	}{
+		{CallNew, "Foo"},
+		{CallFuncall, "bar"},
+		{CallEval, "eval"},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("got %d calls, want %d", len(calls), len(want))
+	}
+	for i, w := range want {
+		if calls[i].Kind != w.kind || calls[i].Target() != w.target {
+			t.Errorf("calls[%d] = %s %s, want %s %s", i, calls[i].Kind, calls[i].Target(), w.kind, w.target)
+		}
+	}
+}
+
+func TestBranchMerge(t *testing.T) {
+	// f(c ? "a" : "b") — disagreeing branches merge to Unknown, call survives.
+	s := &sm33.Script{
+		Atoms: []string{"f", "c", "a", "b"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0), []byte{OpUndefined},
+			AtomOp(OpName, 1),
+			[]byte{OpIfeq, 0, 0, 0, 15}, // -> else
+			AtomOp(OpString, 2),
+			[]byte{OpGoto, 0, 0, 0, 10}, // -> join
+			AtomOp(OpString, 3),         // else
+			ArgcOp(OpCall, 1),           // join
+		),
+	}
+	calls := Calls(s)
+	if len(calls) != 1 || calls[0].Target() != "f" {
+		t.Fatalf("calls = %v", calls)
+	}
+	if calls[0].Args[0].Kind != Unknown {
+		t.Errorf("arg0 = %s, want unknown", calls[0].Args[0])
+	}
+}
+
+func TestSampleCalls(t *testing.T) {
+	s, err := xdr.DecodeFile("../disasm/testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := Calls(s)
+	if len(calls) != 1 || calls[0].Target() != "cc.Scene.extend" {
+		t.Fatalf("main calls = %v", calls)
+	}
+	if calls[0].Args[0].Kind != Object {
+		t.Errorf("extend arg = %s, want object literal", calls[0].Args[0])
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	// undefined; getaliasedvar with two of its four operand bytes
+	ins := Decode([]byte{1, 136, 0, 0})
+	if len(ins) != 1 || ins[0].Op != 1 {
+		t.Fatalf("Decode = %+v, want only the undefined", ins)
+	}
+	for _, in := range Decode([]byte{1, 1, 133}) {
+		if in.Next() > 3 {
+			t.Errorf("%s @%d runs past the end", in.Name(), in.Off)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+// Package literals extracts the strings of a set of scripts: every atom,
+// string constant, regexp source and object-literal key, with the
+// encoding it was stored in, the functions that reference it and how
+// each reference uses it.
+//
+// Atoms cover string literals as well as property and global names; the
+// instruction of each reference tells them apart ("string" pushes a
+// literal, "callprop" names a method). For literals the reference also
+// carries the use of the pushed value, as the argument of a call
+// (`arg 0 to label.setString`), the value of a property
+// (`value of .title`), a comparison operand or a returned value.
+package literals
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// Opcodes that consume a literal in a way worth reporting.
+const (
+	opReturn        = 5
+	opEq            = 18
+	opNe            = 19
+	opAdd           = 27
+	opGetprop       = 53
+	opSetprop       = 54
+	opGetelem       = 55
+	opSetelem       = 56
+	opString        = 61
+	opStricteq      = 72
+	opStrictne      = 73
+	opObject        = 80
+	opSetarg        = 85
+	opSetlocal      = 87
+	opNewobject     = 91
+	opInitprop      = 93
+	opInitelem      = 94
+	opInitelemArray = 96
+	opSetname       = 111
+	opIn            = 113
+	opCase          = 121
+	opSetrval       = 152
+	opSetgname      = 155
+	opRegexp        = 160
+	opCallelem      = 193
+)
+
+// Kinds of entries.
+const (
+	Atom   = "atom"
+	Const  = "const"
+	Regexp = "regexp"
+	Key    = "key"
+)
+
+// Encodings an atom is stored in.
+const (
+	Latin1 = "latin1"
+	UTF16  = "utf16"
+)
+
+// Ref is one instruction referencing a string.
+type Ref struct {
+	File    string `json:"file,omitempty"`
+	Func    string `json:"func"`
+	Off     int    `json:"off"`
+	Line    int    `json:"line"`
+	Op      string `json:"op"`
+	Context string `json:"context,omitempty"` // use of a pushed literal
+}
+
+// Entry is one distinct string of one kind.
+type Entry struct {
+	Kind     string   `json:"kind"`
+	Value    string   `json:"value"`
+	Encoding string   `json:"encoding"`
+	Funcs    []string `json:"funcs"` // "file:func" of each referencing function, or of those holding it when unreferenced
+	Refs     []Ref    `json:"refs"`
+
+	owners []string
+	owned  map[string]bool
+}
+
+// Report accumulates the strings of one or more scripts.
+type Report struct {
+	entries []*Entry
+	index   map[[2]string]*Entry
+}
+
+// New returns an empty report.
+func New() *Report {
+	return &Report{index: map[[2]string]*Entry{}}
+}
+
+// entry returns the entry of kind and value, adding it on first use, and
+// records owner as a function that holds it.
+func (r *Report) entry(kind, value string, twoByte bool, owner string) *Entry {
+	k := [2]string{kind, value}
+	e := r.index[k]
+	if e == nil {
+		e = &Entry{Kind: kind, Value: value, Encoding: Latin1, owned: map[string]bool{}}
+		r.index[k] = e
+		r.entries = append(r.entries, e)
+	}
+	if twoByte {
+		e.Encoding = UTF16
+	}
+	if !e.owned[owner] {
+		e.owned[owner] = true
+		e.owners = append(e.owners, owner)
+	}
+	return e
+}
+
+// Add extracts the strings of root, read from file.
+func (r *Report) Add(file string, root *sm33.Script) {
+	nm := names.Infer(root)
+	var walk func(s *sm33.Script, name string)
+	walk = func(s *sm33.Script, name string) {
+		r.scan(file, s, name)
+		for _, obj := range s.Objects {
+			if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+				walk(fn.Script, nm.Of(fn))
+			}
+		}
+	}
+	walk(root, "main")
+}
+
+func (r *Report) scan(file string, s *sm33.Script, fn string) {
+	owner := qualify(file, fn)
+	atoms := make([]*Entry, len(s.Atoms))
+	for i, a := range s.Atoms {
+		atoms[i] = r.entry(Atom, a, i < len(s.TwoByte) && s.TwoByte[i], owner)
+	}
+	consts := make([]*Entry, len(s.Consts))
+	for i, c := range s.Consts {
+		switch c.Kind {
+		case sm33.ConstAtom:
+			consts[i] = r.entry(Const, c.Atom, c.TwoByte, owner)
+		case sm33.ConstObject:
+			r.literal(c.Object, "", owner, nil)
+		}
+	}
+	regexps := make([]*Entry, len(s.Regexps))
+	for i, re := range s.Regexps {
+		regexps[i] = r.entry(Regexp, re.Source, re.TwoByte, owner)
+	}
+	literals := make([][]literalEntry, len(s.Objects))
+	for i, obj := range s.Objects {
+		literals[i] = r.literal(obj.Literal, "", owner, nil)
+	}
+
+	uses := contexts(s)
+	bc := s.Bytecode
+	var lines *srcnote.Lines
+	for _, in := range ir.Decode(bc) {
+		var e *Entry
+		var lits []literalEntry
+		idx, _ := bytecode.GetUint32Index(bc, in.Off)
+		switch bytecode.JofType(bytecode.Opcodes[in.Op].Format) {
+		case bytecode.JOF_ATOM, bytecode.JOF_ATOMOBJECT:
+			if int(idx) < len(atoms) {
+				e = atoms[idx]
+			}
+		case bytecode.JOF_DOUBLE:
+			if int(idx) < len(consts) {
+				e = consts[idx]
+			}
+		case bytecode.JOF_REGEXP:
+			if int(idx) < len(regexps) {
+				e = regexps[idx]
+			}
+		case bytecode.JOF_OBJECT:
+			if int(idx) < len(literals) {
+				lits = literals[idx]
+			}
+		}
+		if e == nil && len(lits) == 0 {
+			continue
+		}
+		if lines == nil {
+			lines = srcnote.NewLines(s)
+		}
+		ref := Ref{File: file, Func: fn, Off: in.Off, Line: lines.Line(in.Off), Op: in.Name(), Context: uses[in.Off]}
+		if e != nil {
+			e.Refs = append(e.Refs, ref)
+			continue
+		}
+		for _, l := range lits {
+			ref := ref
+			if l.context != "" {
+				ref.Context = l.context
+			}
+			l.e.Refs = append(l.e.Refs, ref)
+		}
+	}
+}
+
+type literalEntry struct {
+	e       *Entry
+	context string
+}
+
+// literal appends to out the keys and string values of lit and of the
+// object literals nested in it; path locates lit within the outermost
+// literal.
+func (r *Report) literal(lit *sm33.Literal, path, owner string, out []literalEntry) []literalEntry {
+	if lit == nil {
+		return out
+	}
+	for _, p := range lit.Props {
+		if !p.Int {
+			out = append(out, literalEntry{r.entry(Key, p.Key, p.TwoByte, owner), ""})
+		}
+		out = r.value(p.Value, path+"."+p.Key, "value of "+path+"."+p.Key, owner, out)
+	}
+	for j, c := range lit.Elems {
+		context := fmt.Sprintf("element %d", j)
+		if path != "" {
+			context += " of " + path
+		}
+		out = r.value(c, fmt.Sprintf("%s[%d]", path, j), context, owner, out)
+	}
+	return out
+}
+
+// value appends the string c holds, or the strings of the object literal
+// it holds, to out.
+func (r *Report) value(c sm33.Const, path, context, owner string, out []literalEntry) []literalEntry {
+	switch c.Kind {
+	case sm33.ConstAtom:
+		out = append(out, literalEntry{r.entry(Const, c.Atom, c.TwoByte, owner), context})
+	case sm33.ConstObject:
+		out = r.literal(c.Object, path, owner, out)
+	}
+	return out
+}
+
+// contexts describes, for each instruction pushing a literal, how the
+// pushed value is used.
+func contexts(s *sm33.Script) map[int]string {
+	out := map[int]string{}
+	pushed := map[*ir.Expr]int{}
+	var prev ir.Instr
+	have := false
+	use := func(e *ir.Expr, what string) {
+		if off, ok := pushed[e]; ok {
+			if _, seen := out[off]; !seen {
+				out[off] = what
+			}
+		}
+	}
+	calls := ir.Simulate(s, func(in ir.Instr, st *ir.Stack) {
+		if have {
+			switch prev.Op {
+			case opString, opRegexp, opObject, opNewobject:
+				pushed[st.Peek(0)] = prev.Off
+			default:
+				if bytecode.JofType(bytecode.Opcodes[prev.Op].Format) == bytecode.JOF_DOUBLE {
+					pushed[st.Peek(0)] = prev.Off
+				}
+			}
+		}
+		prev, have = in, true
+
+		atom := func() string { return ir.Atom(s, in.Off) }
+		switch in.Op {
+		case opInitprop:
+			use(st.Peek(0), "value of ."+atom())
+		case opSetprop:
+			use(st.Peek(0), "assigned to "+st.Peek(1).String()+"."+atom())
+		case opSetname, opSetgname:
+			use(st.Peek(0), "assigned to "+atom())
+		case opSetlocal, opSetarg:
+			use(st.Peek(0), "assigned to "+slotName(s, in))
+		case opGetprop:
+			use(st.Peek(0), "receiver of ."+atom())
+		case opGetelem, opCallelem:
+			use(st.Peek(0), "index into "+st.Peek(1).String())
+		case opSetelem, opInitelem:
+			use(st.Peek(1), "key of "+st.Peek(2).String())
+			use(st.Peek(0), "value of "+st.Peek(2).String()+"["+st.Peek(1).String()+"]")
+		case opInitelemArray:
+			use(st.Peek(0), "array element")
+		case opEq, opNe, opStricteq, opStrictne:
+			use(st.Peek(0), "compared with "+st.Peek(1).String())
+			use(st.Peek(1), "compared with "+st.Peek(0).String())
+		case opCase:
+			use(st.Peek(0), "switch case")
+		case opAdd:
+			use(st.Peek(0), "concatenated")
+			use(st.Peek(1), "concatenated")
+		case opIn:
+			use(st.Peek(1), "key tested with in")
+		case opReturn, opSetrval:
+			use(st.Peek(0), "returned")
+		}
+	})
+	for _, c := range calls {
+		for i, a := range c.Args {
+			use(a, "arg "+strconv.Itoa(i)+" to "+c.Target())
+		}
+		use(c.This, "receiver of "+c.Target())
+	}
+	return out
+}
+
+func slotName(s *sm33.Script, in ir.Instr) string {
+	var slot int
+	if in.Op == opSetlocal {
+		v, _ := bytecode.GetUint24(s.Bytecode, in.Off)
+		slot = int(v)
+	} else {
+		v, _ := bytecode.GetUint16(s.Bytecode, in.Off)
+		slot = int(v)
+	}
+	if n := ir.BindingName(s, slot, in.Op == opSetlocal); n != "" {
+		return n
+	}
+	if in.Op == opSetlocal {
+		return "local " + strconv.Itoa(slot)
+	}
+	return "arg " + strconv.Itoa(slot)
+}
+
+// qualify prefixes fn with its file, when there is one.
+func qualify(file, fn string) string {
+	if file == "" {
+		return fn
+	}
+	return file + ":" + fn
+}
+
+// Entries returns the entries in the order they were first seen.
+func (r *Report) Entries() []*Entry {
+	for _, e := range r.entries {
+		e.Funcs = e.Funcs[:0]
+		seen := map[string]bool{}
+		for _, ref := range e.Refs {
+			if f := qualify(ref.File, ref.Func); !seen[f] {
+				seen[f] = true
+				e.Funcs = append(e.Funcs, f)
+			}
+		}
+		if len(e.Refs) == 0 {
+			e.Funcs = append(e.Funcs, e.owners...)
+		}
+		if e.Refs == nil {
+			e.Refs = []Ref{}
+		}
+	}
+	return r.entries
+}
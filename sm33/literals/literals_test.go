@@ -0,0 +1,103 @@
+package literals
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+// tree builds:
+//
+//	localStorage.setItem("k", "é");
+//	({title: "Hi"});
+//	/a+b/;
+//	title == "k";
+func tree() *sm33.Script {
+	return &sm33.Script{
+		Atoms:   []string{"localStorage", "setItem", "k", "title", "é"},
+		TwoByte: []bool{false, false, false, false, true},
+		Regexps: []sm33.Regexp{{Source: "a+b"}},
+		Objects: []*sm33.Object{{Kind: sm33.CkJSObject, Literal: &sm33.Literal{Props: []sm33.LiteralProp{
+			{Key: "title", Value: sm33.Const{Kind: sm33.ConstAtom, Atom: "Hi"}},
+		}}}},
+		Bytecode: Asm(
+			AtomOp(OpGetgname, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap}, // 0
+			AtomOp(OpString, 2), AtomOp(OpString, 4), []byte{OpCall, 0, 2, OpPop}, // 12
+			AtomOp(OpNewobject, 0), []byte{OpPop}, // 26
+			AtomOp(OpRegexp, 0), []byte{OpPop}, // 32
+			AtomOp(OpName, 3), AtomOp(OpString, 2), []byte{OpEq, OpPop}, // 38
+			[]byte{OpRetrval}, // 50
+		),
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := New()
+	r.Add("a.jsc", tree())
+	r.Add("b.jsc", tree())
+	es := r.Entries()
+
+	var got []string
+	byKey := map[string]*Entry{}
+	for _, e := range es {
+		got = append(got, e.Kind+":"+e.Value)
+		byKey[e.Kind+":"+e.Value] = e
+	}
+	want := "atom:localStorage atom:setItem atom:k atom:title atom:é regexp:a+b key:title const:Hi"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("entries = %s, want %s", strings.Join(got, " "), want)
+	}
+
+	k := byKey["atom:k"]
+	if k.Encoding != Latin1 || strings.Join(k.Funcs, " ") != "a.jsc:main b.jsc:main" || len(k.Refs) != 4 {
+		t.Errorf("k = %+v", k)
+	}
+	for i, w := range []struct {
+		off int
+		ctx string
+	}{{12, "arg 0 to localStorage.setItem"}, {43, "compared with title"}} {
+		if ref := k.Refs[i]; ref.File != "a.jsc" || ref.Off != w.off || ref.Op != "string" || ref.Context != w.ctx {
+			t.Errorf("k ref %d = %+v, want @%d %q", i, ref, w.off, w.ctx)
+		}
+	}
+	if e := byKey["atom:é"]; e.Encoding != UTF16 || e.Refs[0].Context != "arg 1 to localStorage.setItem" {
+		t.Errorf("é = %+v", e)
+	}
+	if e := byKey["key:title"]; len(e.Refs) != 2 || e.Refs[0].Op != "newobject" || e.Refs[0].Off != 26 {
+		t.Errorf("key title = %+v", e)
+	}
+	if e := byKey["const:Hi"]; len(e.Refs) != 2 || e.Refs[0].Context != "value of .title" {
+		t.Errorf("const Hi = %+v", e)
+	}
+	if e := byKey["regexp:a+b"]; len(e.Refs) != 2 || e.Refs[0].Off != 32 || e.Refs[0].Op != "regexp" {
+		t.Errorf("regexp = %+v", e)
+	}
+	if e := byKey["atom:setItem"]; e.Refs[0].Op != "callprop" || e.Refs[0].Context != "" {
+		t.Errorf("setItem = %+v", e)
+	}
+}
+
+func TestNestedLiteral(t *testing.T) {
+	// { menu: ["Play"] }
+	inner := &sm33.Literal{Array: true, Elems: []sm33.Const{{Kind: sm33.ConstAtom, Atom: "Play"}}}
+	s := &sm33.Script{
+		Objects: []*sm33.Object{{Kind: sm33.CkJSObject, Literal: &sm33.Literal{Props: []sm33.LiteralProp{
+			{Key: "menu", Value: sm33.Const{Kind: sm33.ConstObject, Object: inner}},
+		}}}},
+		Bytecode: Asm(AtomOp(OpNewobject, 0), []byte{OpPop, OpRetrval}),
+	}
+	r := New()
+	r.Add("", s)
+	var got []string
+	for _, e := range r.Entries() {
+		for _, ref := range e.Refs {
+			got = append(got, e.Kind+":"+e.Value+"@"+ref.Context)
+		}
+	}
+	want := "key:menu@ const:Play@element 0 of .menu"
+	if strings.Join(got, " ") != want {
+		t.Errorf("refs = %s, want %s", strings.Join(got, " "), want)
+	}
+}
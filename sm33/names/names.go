@@ -0,0 +1,235 @@
+// Package names assigns display names to the functions of a script tree.
+//
+// SpiderMonkey stores a display atom for most functions, but anonymous
+// lambdas assigned to properties, variables or object-literal keys come
+// through nameless. Infer walks each parent script with the abstract
+// stack model and names a lambda after the store that consumes it:
+//
+//	Foo.prototype.bar = function () {}          → Foo.prototype.bar
+//	var GameLayer = cc.Layer.extend({           → GameLayer.onEnter
+//	    onEnter: function () {} })
+//	handlers.click = function () {}             → handlers.click
+//
+// Functions with no naming context fall back to anon#N (index in the
+// parent's object table), qualified by the parent below main. Every name
+// is unique within the tree: later duplicates get a "#2", "#3" suffix in
+// depth-first object order, so names are stable across runs.
+package names
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+)
+
+// opcode constants for stores that can consume a lambda.
+const (
+	opSetconst      = 14
+	opSetelem       = 56
+	opSetprop       = 54
+	opSetarg        = 85
+	opSetlocal      = 87
+	opInitprop      = 93
+	opSetname       = 111
+	opSetaliasedvar = 137
+	opSetgname      = 155
+)
+
+// Names maps the functions of one script tree to display names.
+type Names struct {
+	byFunc map[*sm33.Function]string
+	byName map[string]*sm33.Function
+}
+
+// Infer names every function reachable from root.
+func Infer(root *sm33.Script) *Names {
+	n := &Names{byFunc: map[*sm33.Function]string{}, byName: map[string]*sm33.Function{}}
+	w := &walker{names: n, parent: map[*sm33.Script]*sm33.Script{}, used: map[string]int{"main": 1}}
+	w.walk(root, "main")
+	return n
+}
+
+// Of returns the display name of fn. Functions outside the tree get their
+// own atom, or "anonymous".
+func (n *Names) Of(fn *sm33.Function) string {
+	if name, ok := n.byFunc[fn]; ok {
+		return name
+	}
+	if fn != nil && fn.Name != "" {
+		return fn.Name
+	}
+	return "anonymous"
+}
+
+// Func returns the function with display name name, or nil. Names are
+// unique, so the match is exact.
+func (n *Names) Func(name string) *sm33.Function {
+	return n.byName[name]
+}
+
+// walker carries the state of one Infer run.
+type walker struct {
+	names  *Names
+	parent map[*sm33.Script]*sm33.Script
+	used   map[string]int
+}
+
+func (w *walker) walk(s *sm33.Script, name string) {
+	inferred := infer(s, func(s *sm33.Script) *sm33.Script { return w.parent[s] })
+	for i, obj := range s.Objects {
+		fn := obj.Function
+		if obj.Kind != sm33.CkJSFunction || fn == nil {
+			continue
+		}
+		display := fn.Name
+		if display == "" {
+			display = inferred[i]
+		}
+		if display == "" {
+			display = fmt.Sprintf("anon#%d", i)
+			if name != "main" {
+				display = name + "/" + display
+			}
+		}
+		display = w.unique(display)
+		w.names.byFunc[fn] = display
+		w.names.byName[display] = fn
+		if fn.Script != nil {
+			w.parent[fn.Script] = s
+			w.walk(fn.Script, display)
+		}
+	}
+}
+
+// unique returns name, or name#N if it is already taken.
+func (w *walker) unique(name string) string {
+	w.used[name]++
+	if k := w.used[name]; k > 1 {
+		return w.unique(name + "#" + strconv.Itoa(k))
+	}
+	return name
+}
+
+// literal records the lambdas stored into one object literal, by key path.
+type literal struct {
+	funcs []int    // object indices
+	keys  []string // key path within the literal, e.g. "onEnter" or "a.b"
+}
+
+// infer returns candidate names for the anonymous lambdas of s, keyed by
+// object index. The first store that consumes a lambda wins.
+func infer(s *sm33.Script, parent func(*sm33.Script) *sm33.Script) map[int]string {
+	out := map[int]string{}
+	lits := map[*ir.Expr]*literal{}
+	bc := s.Bytecode
+
+	var assign func(val *ir.Expr, target string)
+	assign = func(val *ir.Expr, target string) {
+		if target == "" {
+			return
+		}
+		switch val.Kind {
+		case ir.Lambda:
+			if anonymous(s, val.Func) {
+				if _, ok := out[val.Func]; !ok {
+					out[val.Func] = target
+				}
+			}
+		case ir.Object:
+			if lit := lits[val]; lit != nil {
+				for k, fi := range lit.funcs {
+					if _, ok := out[fi]; !ok {
+						out[fi] = target + "." + lit.keys[k]
+					}
+				}
+				delete(lits, val)
+			}
+		case ir.CallResult:
+			// var Foo = Base.extend({...}): members belong to Foo.
+			if c := val.Call; c != nil && c.Callee.Kind == ir.Prop && c.Callee.Atom == "extend" {
+				for _, a := range c.Args {
+					assign(a, target)
+				}
+			}
+		}
+	}
+
+	ir.Simulate(s, func(in ir.Instr, st *ir.Stack) {
+		off := in.Off
+		if st.Len() == 0 {
+			return
+		}
+		val := st.Peek(0)
+		switch in.Op {
+		case opInitprop:
+			if st.Len() < 2 {
+				return
+			}
+			key := ir.Atom(s, off)
+			lit := lits[st.Peek(1)]
+			if lit == nil {
+				lit = &literal{}
+				lits[st.Peek(1)] = lit
+			}
+			switch {
+			case val.Kind == ir.Lambda && anonymous(s, val.Func):
+				lit.funcs = append(lit.funcs, val.Func)
+				lit.keys = append(lit.keys, key)
+			case val.Kind == ir.Object && lits[val] != nil:
+				inner := lits[val]
+				for k, fi := range inner.funcs {
+					lit.funcs = append(lit.funcs, fi)
+					lit.keys = append(lit.keys, key+"."+inner.keys[k])
+				}
+				delete(lits, val)
+			}
+		case opSetprop:
+			if st.Len() >= 2 {
+				if obj := st.Peek(1).Path(); obj != "" {
+					assign(val, obj+"."+ir.Atom(s, off))
+				}
+			}
+		case opSetelem:
+			if st.Len() >= 3 {
+				idx := st.Peek(1)
+				if obj := st.Peek(2).Path(); obj != "" && idx.IsLit(ir.LitString) {
+					assign(val, obj+"."+idx.Str)
+				}
+			}
+		case opSetname, opSetgname, opSetconst:
+			assign(val, ir.Atom(s, off))
+		case opSetlocal:
+			v, _ := bytecode.GetLocalno(bc, off)
+			if slot := int(s.Nargs) + int(v); uint32(v) < s.Nvars && slot < len(s.Bindings) {
+				assign(val, s.Bindings[slot])
+			}
+		case opSetarg:
+			v, _ := bytecode.GetArgno(bc, off)
+			if int(v) < int(s.Nargs) && int(v) < len(s.Bindings) {
+				assign(val, s.Bindings[v])
+			}
+		case opSetaliasedvar:
+			if off+5 > len(bc) {
+				return
+			}
+			hops := int(bc[off+1])
+			slot := int(bc[off+2])<<16 | int(bc[off+3])<<8 | int(bc[off+4])
+			if owner := ir.ScopeOwner(s, hops, parent); owner != nil {
+				assign(val, ir.AliasedName(owner, slot))
+			}
+		}
+	})
+	return out
+}
+
+// anonymous reports whether s.Objects[i] is a function without an atom.
+func anonymous(s *sm33.Script, i int) bool {
+	if i < 0 || i >= len(s.Objects) {
+		return false
+	}
+	obj := s.Objects[i]
+	return obj.Kind == sm33.CkJSFunction && obj.Function != nil && obj.Function.Name == ""
+}
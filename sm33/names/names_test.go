@@ -0,0 +1,96 @@
+package names
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+func anonFuncs(n int) []*sm33.Object {
+	objs := make([]*sm33.Object, n)
+	for i := range objs {
+		objs[i] = &sm33.Object{Kind: sm33.CkJSFunction, Function: &sm33.Function{Script: &sm33.Script{}}}
+	}
+	return objs
+}
+
+func TestInfer(t *testing.T) {
+	// Foo.prototype.bar = function () {};
+	// GameLayer = cc.Layer.extend({onEnter: function () {}});
+	// var cb = function () {};
+	// (function () {})();
+	s := &sm33.Script{
+		Nvars:    1,
+		Bindings: []string{"cb"},
+		Atoms:    []string{"Foo", "prototype", "bar", "GameLayer", "cc", "Layer", "extend", "onEnter"},
+		Objects:  anonFuncs(4),
+		Bytecode: Asm(
+			AtomOp(OpName, 0), AtomOp(OpGetprop, 1), AtomOp(OpLambda, 0), AtomOp(OpSetprop, 2), []byte{OpPop},
+			AtomOp(OpBindgname, 3),
+			AtomOp(OpName, 4), AtomOp(OpGetprop, 5),
+			[]byte{OpDup}, AtomOp(OpCallprop, 6), []byte{OpSwap},
+			[]byte{OpNewinit, 0, 0, 0, 0}, AtomOp(OpLambda, 1), AtomOp(OpInitprop, 7), []byte{OpEndinit},
+			[]byte{OpCall, 0, 1},
+			AtomOp(OpSetgname, 3), []byte{OpPop},
+			AtomOp(OpLambda, 2), []byte{OpSetlocal, 0, 0, 0}, []byte{OpPop},
+			AtomOp(OpLambda, 3), []byte{OpUndefined}, []byte{OpCall, 0, 0}, []byte{OpPop},
+		),
+	}
+	n := Infer(s)
+	want := []string{"Foo.prototype.bar", "GameLayer.onEnter", "cb", "anon#3"}
+	for i, w := range want {
+		if got := n.Of(s.Objects[i].Function); got != w {
+			t.Errorf("object %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestFunc(t *testing.T) {
+	// var cb = function () {}; (function () {})();
+	s := &sm33.Script{
+		Nvars:    1,
+		Bindings: []string{"cb"},
+		Objects:  anonFuncs(2),
+		Bytecode: Asm(
+			AtomOp(OpLambda, 0), []byte{OpSetlocal, 0, 0, 0}, []byte{OpPop},
+			AtomOp(OpLambda, 1), []byte{OpUndefined}, []byte{OpCall, 0, 0}, []byte{OpPop},
+		),
+	}
+	n := Infer(s)
+	for name, want := range map[string]*sm33.Function{
+		"cb":     s.Objects[0].Function,
+		"anon#1": s.Objects[1].Function,
+		"main":   nil,
+		"anon#0": nil,
+	} {
+		if got := n.Func(name); got != want {
+			t.Errorf("Func(%q) = %p, want %p", name, got, want)
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+	// Two inner functions share a display atom; each has an anonymous child.
+	objs := anonFuncs(2)
+	objs[0].Function.Name = "f"
+	objs[1].Function.Name = "f"
+	objs[0].Function.Script.Objects = anonFuncs(1)
+	objs[1].Function.Script.Objects = anonFuncs(1)
+	s := &sm33.Script{Objects: objs}
+
+	n := Infer(s)
+	got := []string{
+		n.Of(objs[0].Function), n.Of(objs[0].Function.Script.Objects[0].Function),
+		n.Of(objs[1].Function), n.Of(objs[1].Function.Script.Objects[0].Function),
+	}
+	want := []string{"f", "f/anon#0", "f#2", "f#2/anon#0"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("name %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if n.Func("f#2") != objs[1].Function || n.Func("g") != nil {
+		t.Errorf("Func(f#2) = %p, want %p", n.Func("f#2"), objs[1].Function)
+	}
+}
@@ -0,0 +1,210 @@
+// Package project indexes a set of scripts that make up one game.
+//
+// Cocos2d-x games split their logic across many .jsc files that share
+// globals: one file declares var GameManager = ... and another calls
+// GameManager.getInstance(). A Project records, for every global name,
+// the instructions that define it (defvar, defconst, a top-level deffun,
+// setname/setgname) and those that read it (name, getgname), and merges
+// the per-file callgraphs into one, linking calls that leave a file to
+// the function another file defines under that name.
+package project
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// Opcodes that define or read a global.
+const (
+	opName     = 59
+	opSetname  = 111
+	opDeffun   = 127
+	opDefconst = 128
+	opDefvar   = 129
+	opGetgname = 154
+	opSetgname = 155
+)
+
+// File is one script of the project.
+type File struct {
+	Path   string
+	Name   string // base name of the source, unique within the project
+	Script *sm33.Script
+	Graph  *callgraph.Graph
+}
+
+// Node returns the project graph node of function fn of f.
+func (f *File) Node(fn string) string { return f.Name + ":" + fn }
+
+// Nodes returns the project graph nodes of f's functions.
+func (f *File) Nodes() []string {
+	var out []string
+	for _, n := range f.Graph.Nodes {
+		out = append(out, f.Node(n))
+	}
+	return out
+}
+
+// Ref is one instruction that defines or reads a global.
+type Ref struct {
+	File string `json:"file"`
+	Func string `json:"func"`
+	Off  int    `json:"off"`
+	Line int    `json:"line"`
+	Op   string `json:"op"`
+}
+
+// Project is the index over a set of files.
+type Project struct {
+	Files []*File
+	Defs  map[string][]Ref // global → defining instructions
+	Uses  map[string][]Ref // global → reading instructions
+
+	names map[string]int
+}
+
+// New returns an empty project.
+func New() *Project {
+	return &Project{Defs: map[string][]Ref{}, Uses: map[string][]Ref{}, names: map[string]int{}}
+}
+
+// Add indexes the script s read from path.
+func (p *Project) Add(path string, s *sm33.Script) *File {
+	base := filepath.Base(path)
+	if s.Filename != "" {
+		base = filepath.Base(strings.ReplaceAll(s.Filename, `\`, "/"))
+	}
+	p.names[base]++
+	if n := p.names[base]; n > 1 {
+		base += "#" + strconv.Itoa(n)
+	}
+	f := &File{Path: path, Name: base, Script: s, Graph: callgraph.Build(s)}
+	p.Files = append(p.Files, f)
+
+	nm := names.Infer(s)
+	var walk func(s *sm33.Script, fn string)
+	walk = func(s *sm33.Script, fn string) {
+		p.scan(f, s, fn, fn == "main")
+		for _, obj := range s.Objects {
+			if inner := obj.Function; obj.Kind == sm33.CkJSFunction && inner != nil && inner.Script != nil {
+				walk(inner.Script, nm.Of(inner))
+			}
+		}
+	}
+	walk(s, "main")
+	return f
+}
+
+// scan records the global definitions and reads of one script.
+func (p *Project) scan(f *File, s *sm33.Script, fn string, top bool) {
+	bc := s.Bytecode
+	var lines *srcnote.Lines
+	ref := func(in ir.Instr) Ref {
+		if lines == nil {
+			lines = srcnote.NewLines(s)
+		}
+		return Ref{File: f.Name, Func: fn, Off: in.Off, Line: lines.Line(in.Off), Op: in.Name()}
+	}
+	for _, in := range ir.Decode(bc) {
+		switch in.Op {
+		case opDefvar, opDefconst, opSetname, opSetgname:
+			if a := ir.Atom(s, in.Off); a != "" {
+				p.Defs[a] = append(p.Defs[a], ref(in))
+			}
+		case opDeffun:
+			// Function declarations are global only at top level.
+			idx, _ := bytecode.GetUint32Index(bc, in.Off)
+			if top && int(idx) < len(s.Objects) {
+				if inner := s.Objects[idx].Function; inner != nil && inner.Name != "" {
+					p.Defs[inner.Name] = append(p.Defs[inner.Name], ref(in))
+				}
+			}
+		case opName, opGetgname:
+			if a := ir.Atom(s, in.Off); a != "" {
+				p.Uses[a] = append(p.Uses[a], ref(in))
+			}
+		}
+	}
+}
+
+// Globals returns every global name defined or read, sorted.
+func (p *Project) Globals() []string {
+	var out []string
+	for n := range p.Defs {
+		out = append(out, n)
+	}
+	for n := range p.Uses {
+		if _, ok := p.Defs[n]; !ok {
+			out = append(out, n)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Graph merges the file callgraphs. Nodes are named "<file>:<function>".
+// A call to a function outside its file is linked to every other file
+// whose tree has a function of that display name (for new X(), also
+// X.ctor, the constructor of a cc.Class.extend class); other external
+// callees keep their receiver path. Anonymous functions, including nested
+// ones such as outer/anon#2, are never linked across files.
+func (p *Project) Graph() *callgraph.Graph {
+	byName := map[string][]*File{}
+	for _, f := range p.Files {
+		for _, n := range f.Graph.Nodes {
+			if n != "main" && !strings.HasPrefix(n[strings.LastIndex(n, "/")+1:], "anon#") {
+				byName[n] = append(byName[n], f)
+			}
+		}
+	}
+	g := &callgraph.Graph{}
+	for _, f := range p.Files {
+		g.Nodes = append(g.Nodes, f.Nodes()...)
+		local := map[string]bool{}
+		for _, n := range f.Graph.Nodes {
+			local[n] = true
+		}
+		for _, e := range f.Graph.Edges {
+			e.Caller = f.Node(e.Caller)
+			if local[e.Callee] {
+				e.Callee = f.Node(e.Callee)
+				g.Edges = append(g.Edges, e)
+				continue
+			}
+			targets := others(byName[e.Callee], f)
+			callee := e.Callee
+			if len(targets) == 0 && e.Kind == callgraph.Constructs {
+				callee += ".ctor"
+				targets = others(byName[callee], f)
+			}
+			if len(targets) == 0 {
+				g.Edges = append(g.Edges, e)
+				continue
+			}
+			for _, t := range targets {
+				e.Callee = t.Node(callee)
+				g.Edges = append(g.Edges, e)
+			}
+		}
+	}
+	return g
+}
+
+func others(fs []*File, f *File) []*File {
+	var out []*File
+	for _, x := range fs {
+		if x != f {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package project
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+// files returns two scripts:
+//
+//	lib.js:  function helper() { return 1; }  var level = 1;
+//	game.js: helper(); level;
+func files() (lib, game *sm33.Script) {
+	helper := &sm33.Script{Bytecode: []byte{OpOne, 5}}
+	lib = &sm33.Script{
+		Filename: `C:\game\src\lib.js`,
+		Atoms:    []string{"level"},
+		Objects:  []*sm33.Object{{Kind: sm33.CkJSFunction, Function: &sm33.Function{Name: "helper", Script: helper}}},
+		Bytecode: Asm(
+			AtomOp(OpDefvar, 0), AtomOp(OpDeffun, 0),
+			AtomOp(OpBindname, 0), []byte{OpOne}, AtomOp(OpSetname, 0), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+	game = &sm33.Script{
+		Filename: "/game/src/game.js",
+		Atoms:    []string{"helper", "level"},
+		Bytecode: Asm(
+			AtomOp(OpName, 0), []byte{OpUndefined, OpCall, 0, 0, OpPop},
+			AtomOp(OpName, 1), []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+	return lib, game
+}
+
+func TestProject(t *testing.T) {
+	lib, game := files()
+	p := New()
+	p.Add("lib.jsc", lib)
+	p.Add("game.jsc", game)
+	p.Add("copy/lib.jsc", lib)
+
+	if got := p.Files[2].Name; got != "lib.js#2" {
+		t.Errorf("duplicate file name = %q", got)
+	}
+	defs := p.Defs["level"]
+	if len(defs) != 4 || defs[0].Op != "defvar" || defs[1].Op != "setname" || defs[0].File != "lib.js" {
+		t.Errorf("defs of level = %+v", defs)
+	}
+	if d := p.Defs["helper"]; len(d) != 2 || d[0].Op != "deffun" {
+		t.Errorf("defs of helper = %+v", d)
+	}
+	if u := p.Uses["level"]; len(u) != 1 || u[0].File != "game.js" || u[0].Func != "main" || u[0].Off != 10 {
+		t.Errorf("uses of level = %+v", u)
+	}
+	if got := p.Globals(); len(got) != 2 || got[0] != "helper" || got[1] != "level" {
+		t.Errorf("globals = %v", got)
+	}
+
+	g := p.Graph()
+	var calls []string
+	for _, e := range g.Edges {
+		if e.Kind == callgraph.Calls {
+			calls = append(calls, e.Caller+" -> "+e.Callee)
+		}
+	}
+	want := []string{"game.js:main -> lib.js:helper", "game.js:main -> lib.js#2:helper"}
+	if len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestGraphSkipsAnonymous(t *testing.T) {
+	lib, game := files()
+	p := New()
+	p.Add("lib.jsc", lib)
+	p.Add("game.jsc", game)
+	p.Files[0].Graph.Nodes = append(p.Files[0].Graph.Nodes, "helper/anon#0")
+	p.Files[1].Graph.Edges = append(p.Files[1].Graph.Edges,
+		callgraph.Edge{Caller: "main", Callee: "helper/anon#0", Kind: callgraph.Calls})
+
+	for _, e := range p.Graph().Edges {
+		if e.Callee == "lib.js:helper/anon#0" {
+			t.Errorf("anonymous function linked across files: %+v", e)
+		}
+	}
+}
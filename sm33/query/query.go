@@ -0,0 +1,729 @@
+// Package query matches declarative patterns against the instruction
+// stream of a script tree.
+//
+// A query is a sequence of elements separated by semicolons, optionally
+// scoped to a set of functions:
+//
+//	in SplashScene<.*: callprop "setItem"; ...; call arg0=str
+//	$url=string /^https?:/ ; ...4; call target=/\.open$/
+//
+// Each element matches one instruction. It starts with an opcode glob
+// ("call", "get*", "*"), or alternatives of them ("initprop|set*"), and
+// is followed by predicates on the operand:
+//
+//	"lit"     string operand equal to lit
+//	/re/      string operand matching re
+//	N, lo..hi numeric operand in range (either bound may be omitted)
+//
+// The operand is the atom, constant, regexp source or function display
+// name the instruction references, the integer it pushes, or the
+// constant-folded value it computes (a concatenation, a String.fromCharCode
+// call). For call instructions it is the folded callee path, with argc as
+// the numeric operand, and keyed predicates test the call site:
+//
+//	target="cc.log" target=/re/   folded callee path
+//	argN=class|"lit"|/re/|lo..hi  folded argument N; classes are str, num,
+//	                              bool, null, undefined, lit, fn, obj,
+//	                              array, regexp, name and any
+//	argc=N argc=lo..hi            argument count
+//
+// "..." skips up to 16 instructions, "...N" up to N. "$name=" before an
+// element captures its operand. nop and lineno never take part in a match.
+//
+// The scope is a glob or /re/ over display names; a function is in scope
+// when its name or the name of an enclosing function matches, so
+// "in SplashScene<.onEnter:" covers the lambdas onEnter defines.
+package query
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/constprop"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// Opcodes with an implicit operand, and those skipped when matching.
+const (
+	opNop    = 0
+	opZero   = 62
+	opOne    = 63
+	opUint16 = 88
+	opLineno = 119
+	opUint24 = 188
+	opInt8   = 215
+	opInt32  = 216
+)
+
+// defaultGap is how many instructions a bare "..." skips at most.
+const defaultGap = 16
+
+// Query is a compiled pattern.
+type Query struct {
+	Source string
+	scope  func(string) bool
+	elems  []elem
+}
+
+type elem struct {
+	gap     int // > 0: skip up to gap instructions
+	capture string
+	ops     []string
+	preds   []pred
+}
+
+type predKey uint8
+
+const (
+	keyOperand predKey = iota
+	keyTarget
+	keyArg
+	keyArgc
+)
+
+type pred struct {
+	key    predKey
+	arg    int
+	lit    *string
+	re     *regexp.Regexp
+	lo, hi float64
+	rng    bool
+	class  string
+}
+
+// Parse compiles a query.
+func Parse(src string) (*Query, error) {
+	q := &Query{Source: src}
+	rest := strings.TrimSpace(src)
+	if strings.HasPrefix(rest, "in ") {
+		scope, after, err := parseScope(strings.TrimSpace(rest[3:]))
+		if err != nil {
+			return nil, err
+		}
+		q.scope, rest = scope, after
+	}
+	parts, err := split(rest, ';')
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		e, err := parseElem(p)
+		if err != nil {
+			return nil, fmt.Errorf("query: %q: %v", strings.TrimSpace(p), err)
+		}
+		q.elems = append(q.elems, e)
+	}
+	for len(q.elems) > 0 && q.elems[0].gap > 0 {
+		q.elems = q.elems[1:]
+	}
+	for len(q.elems) > 0 && q.elems[len(q.elems)-1].gap > 0 {
+		q.elems = q.elems[:len(q.elems)-1]
+	}
+	if len(q.elems) == 0 {
+		return nil, fmt.Errorf("query: no instructions to match")
+	}
+	return q, nil
+}
+
+// parseScope reads "<glob|/re/>:" and returns the matcher and the rest.
+func parseScope(s string) (func(string) bool, string, error) {
+	if strings.HasPrefix(s, "/") {
+		end := regexEnd(s)
+		if end < 0 {
+			return nil, "", fmt.Errorf("query: unterminated scope regexp")
+		}
+		re, err := compile(s[1:end])
+		if err != nil {
+			return nil, "", err
+		}
+		after := strings.TrimSpace(s[end+1:])
+		if !strings.HasPrefix(after, ":") {
+			return nil, "", fmt.Errorf("query: missing ':' after scope")
+		}
+		return re.MatchString, after[1:], nil
+	}
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(s, ":") {
+			return nil, "", fmt.Errorf("query: missing ': ' after scope")
+		}
+		i = len(s) - 1
+	}
+	glob := s[:i]
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, "", fmt.Errorf("query: scope %q: %v", glob, err)
+	}
+	return func(name string) bool {
+		ok, _ := path.Match(glob, name)
+		return ok
+	}, s[i+1:], nil
+}
+
+// split cuts s at sep outside quoted strings and regexps.
+func split(s string, sep byte) ([]string, error) {
+	var out []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			end := quoteEnd(s[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("query: unterminated string")
+			}
+			i += end
+		case c == '/' && (i == 0 || strings.ContainsRune(" \t=;", rune(s[i-1]))):
+			end := regexEnd(s[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("query: unterminated regexp")
+			}
+			i += end
+		case c == sep || sep == ' ' && c == '\t':
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:]), nil
+}
+
+// quoteEnd returns the index of the quote closing the string s starts with.
+func quoteEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// regexEnd returns the index of the slash closing the regexp s starts with.
+func regexEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+func compile(src string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(strings.ReplaceAll(src, `\/`, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	return re, nil
+}
+
+func parseElem(s string) (elem, error) {
+	toks, err := split(strings.TrimSpace(s), ' ')
+	if err != nil {
+		return elem{}, err
+	}
+	var fields []string
+	for _, t := range toks {
+		if t != "" {
+			fields = append(fields, t)
+		}
+	}
+	var e elem
+	head := fields[0]
+	if strings.HasPrefix(head, "...") {
+		if len(fields) > 1 {
+			return e, fmt.Errorf("a gap takes no predicates")
+		}
+		e.gap = defaultGap
+		if n := head[3:]; n != "" {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 1 {
+				return e, fmt.Errorf("bad gap %q", head)
+			}
+			e.gap = v
+		}
+		return e, nil
+	}
+	if strings.HasPrefix(head, "$") {
+		i := strings.IndexByte(head, '=')
+		if i < 2 {
+			return e, fmt.Errorf("capture needs a name: $name=op")
+		}
+		e.capture, head = head[1:i], head[i+1:]
+		if head == "" {
+			return e, fmt.Errorf("capture of nothing")
+		}
+	}
+	for _, op := range strings.Split(head, "|") {
+		if _, err := path.Match(op, ""); op == "" || err != nil {
+			return e, fmt.Errorf("bad opcode pattern %q", head)
+		}
+		e.ops = append(e.ops, op)
+	}
+	for _, f := range fields[1:] {
+		p, err := parsePred(f)
+		if err != nil {
+			return e, err
+		}
+		e.preds = append(e.preds, p)
+	}
+	return e, nil
+}
+
+func parsePred(s string) (pred, error) {
+	var p pred
+	if s[0] != '"' && s[0] != '/' {
+		if i := strings.IndexByte(s, '='); i > 0 {
+			key, val := s[:i], s[i+1:]
+			switch {
+			case key == "target":
+				p.key = keyTarget
+			case key == "argc":
+				p.key = keyArgc
+			case strings.HasPrefix(key, "arg"):
+				n, err := strconv.Atoi(key[3:])
+				if err != nil || n < 0 {
+					return p, fmt.Errorf("bad argument key %q", key)
+				}
+				p.key, p.arg = keyArg, n
+			default:
+				return p, fmt.Errorf("unknown key %q", key)
+			}
+			if val == "" {
+				return p, fmt.Errorf("%s= needs a value", key)
+			}
+			if err := p.value(val); err != nil {
+				return p, err
+			}
+			switch {
+			case p.key == keyArgc && !p.rng:
+				return p, fmt.Errorf("argc takes a number or range")
+			case p.key == keyTarget && p.lit == nil && p.re == nil:
+				return p, fmt.Errorf("target takes a string or regexp")
+			case p.class != "" && p.key != keyArg:
+				return p, fmt.Errorf("class %q only applies to arguments", p.class)
+			}
+			return p, nil
+		}
+	}
+	if err := p.value(s); err != nil {
+		return p, err
+	}
+	if p.class != "" {
+		return p, fmt.Errorf("unexpected %q", s)
+	}
+	return p, nil
+}
+
+var classes = map[string]bool{
+	"str": true, "num": true, "bool": true, "null": true, "undefined": true,
+	"lit": true, "fn": true, "obj": true, "array": true, "regexp": true,
+	"name": true, "any": true,
+}
+
+// value parses a predicate value: "lit", /re/, a number or range, or a class.
+func (p *pred) value(s string) error {
+	switch {
+	case s[0] == '"':
+		if quoteEnd(s) != len(s)-1 {
+			return fmt.Errorf("bad string %s", s)
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return fmt.Errorf("bad string %s", s)
+		}
+		p.lit = &v
+	case s[0] == '/':
+		if regexEnd(s) != len(s)-1 {
+			return fmt.Errorf("bad regexp %s", s)
+		}
+		re, err := compile(s[1 : len(s)-1])
+		if err != nil {
+			return err
+		}
+		p.re = re
+	case classes[s]:
+		p.class = s
+	default:
+		lo, hi, ok := parseRange(s)
+		if !ok {
+			return fmt.Errorf("bad predicate %q", s)
+		}
+		p.lo, p.hi, p.rng = lo, hi, true
+	}
+	return nil
+}
+
+func parseRange(s string) (lo, hi float64, ok bool) {
+	num := func(s string, def float64) (float64, bool) {
+		if s == "" {
+			return def, true
+		}
+		if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return float64(v), true
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		return v, err == nil
+	}
+	a, b := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		a, b = s[:i], s[i+2:]
+	} else if s == "" {
+		return 0, 0, false
+	}
+	lo, ok1 := num(a, -1e308)
+	hi, ok2 := num(b, 1e308)
+	return lo, hi, ok1 && ok2 && s != ".."
+}
+
+// Match is one match of a query.
+type Match struct {
+	Func     string            `json:"func"`
+	Off      int               `json:"off"`    // first matched instruction
+	End      int               `json:"end"`    // last matched instruction
+	Line     int               `json:"line"`   // source line of Off
+	Offs     []int             `json:"offs"`   // instruction matched by each non-gap element
+	Instrs   []string          `json:"instrs"` // those instructions, with their operands
+	Captures map[string]string `json:"captures,omitempty"`
+
+	Script *sm33.Script `json:"-"`
+}
+
+// Run matches q against every function of root in scope, returning the
+// matches in function then offset order. Matches within one function do
+// not overlap.
+func (q *Query) Run(root *sm33.Script) []Match {
+	nm := names.Infer(root)
+	var out []Match
+	var walk func(s *sm33.Script, name string, in bool)
+	walk = func(s *sm33.Script, name string, in bool) {
+		in = in || q.scope == nil || q.scope(name)
+		if in {
+			out = append(out, q.runScript(s, name, nm)...)
+		}
+		for _, obj := range s.Objects {
+			if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+				walk(fn.Script, nm.Of(fn), in)
+			}
+		}
+	}
+	walk(root, "main", false)
+	return out
+}
+
+// operand is the value an instruction references or computes.
+type operand struct {
+	text  string
+	str   bool // text is a string value
+	num   float64
+	isNum bool
+	call  *ir.Call
+	args  []*ir.Expr // folded call arguments
+}
+
+// run holds the per-function state of one Run.
+type run struct {
+	s     *sm33.Script
+	nm    *names.Names
+	vals  *constprop.Values
+	calls map[int]*ir.Call
+	ins   []ir.Instr
+	ops   map[int]*operand
+}
+
+func (q *Query) runScript(s *sm33.Script, name string, nm *names.Names) []Match {
+	r := &run{s: s, nm: nm, ops: map[int]*operand{}}
+	for _, in := range ir.Decode(s.Bytecode) {
+		if in.Op != opNop && in.Op != opLineno {
+			r.ins = append(r.ins, in)
+		}
+	}
+	var out []Match
+	var lines *srcnote.Lines
+	for i := 0; i < len(r.ins); i++ {
+		var offs []int
+		caps := map[string]string{}
+		end, ok := q.match(r, 0, i, &offs, caps)
+		if !ok {
+			continue
+		}
+		if lines == nil {
+			lines = srcnote.NewLines(s)
+		}
+		m := Match{
+			Func:   name,
+			Off:    r.ins[i].Off,
+			End:    r.ins[end].Off,
+			Line:   lines.Line(r.ins[i].Off),
+			Offs:   offs,
+			Script: s,
+		}
+		if len(caps) > 0 {
+			m.Captures = caps
+		}
+		for _, off := range offs {
+			m.Instrs = append(m.Instrs, r.text(off))
+		}
+		out = append(out, m)
+		i = end
+	}
+	return out
+}
+
+// match matches elems[k:] starting at instruction i and returns the index
+// of the last instruction matched. Gaps are lazy: the shortest skip that
+// lets the rest match wins.
+func (q *Query) match(r *run, k, i int, offs *[]int, caps map[string]string) (int, bool) {
+	e := q.elems[k]
+	if e.gap > 0 {
+		for skip := 0; skip <= e.gap && i+skip < len(r.ins); skip++ {
+			n := len(*offs)
+			if end, ok := q.match(r, k+1, i+skip, offs, caps); ok {
+				return end, true
+			}
+			*offs = (*offs)[:n]
+		}
+		return 0, false
+	}
+	if i >= len(r.ins) {
+		return 0, false
+	}
+	in := r.ins[i]
+	if !e.opMatch(in.Name()) {
+		return 0, false
+	}
+	v := r.operand(in)
+	for _, p := range e.preds {
+		if !p.test(v) {
+			return 0, false
+		}
+	}
+	if k == len(q.elems)-1 {
+		*offs = append(*offs, in.Off)
+		if e.capture != "" {
+			caps[e.capture] = v.text
+		}
+		return i, true
+	}
+	*offs = append(*offs, in.Off)
+	end, ok := q.match(r, k+1, i+1, offs, caps)
+	if ok && e.capture != "" {
+		caps[e.capture] = v.text
+	}
+	return end, ok
+}
+
+func (e elem) opMatch(name string) bool {
+	for _, op := range e.ops {
+		if ok, _ := path.Match(op, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// operand returns the operand of in, computing it on first use.
+func (r *run) operand(in ir.Instr) *operand {
+	if v, ok := r.ops[in.Off]; ok {
+		return v
+	}
+	v := r.decode(in)
+	r.ops[in.Off] = v
+	return v
+}
+
+func (r *run) decode(in ir.Instr) *operand {
+	s, bc := r.s, r.s.Bytecode
+	v := &operand{}
+	setNum := func(f float64) {
+		v.num, v.isNum = f, true
+		v.text = ir.ConstExpr(sm33.Const{Kind: sm33.ConstDouble, Double: f}).String()
+	}
+	if r.vals == nil {
+		r.vals = constprop.Analyze(s)
+		r.calls = map[int]*ir.Call{}
+		for _, c := range r.vals.Calls() {
+			r.calls[c.Offset] = c
+		}
+	}
+	if c := r.calls[in.Off]; c != nil {
+		v.call = c
+		v.text, v.str = r.vals.Target(c), true
+		v.num, v.isNum = float64(c.Argc), true
+		v.args = r.vals.FoldAll(c.Args)
+		return v
+	}
+	switch in.Op {
+	case opZero:
+		setNum(0)
+		return v
+	case opOne:
+		setNum(1)
+		return v
+	case opUint16:
+		n, _ := bytecode.GetUint16(bc, in.Off)
+		setNum(float64(n))
+		return v
+	case opUint24:
+		n, _ := bytecode.GetUint24(bc, in.Off)
+		setNum(float64(n))
+		return v
+	case opInt8:
+		n, _ := bytecode.GetInt8(bc, in.Off)
+		setNum(float64(n))
+		return v
+	case opInt32:
+		n, _ := bytecode.GetInt32(bc, in.Off)
+		setNum(float64(n))
+		return v
+	}
+	idx, _ := bytecode.GetUint32Index(bc, in.Off)
+	switch bytecode.JofType(bytecode.Opcodes[in.Op].Format) {
+	case bytecode.JOF_ATOM, bytecode.JOF_ATOMOBJECT:
+		if int(idx) < len(s.Atoms) {
+			v.text, v.str = s.Atoms[idx], true
+		}
+		return v
+	case bytecode.JOF_DOUBLE:
+		if int(idx) < len(s.Consts) {
+			lit(v, ir.ConstExpr(s.Consts[idx]))
+		}
+		return v
+	case bytecode.JOF_REGEXP:
+		if int(idx) < len(s.Regexps) {
+			v.text, v.str = s.Regexps[idx].Source, true
+		}
+		return v
+	case bytecode.JOF_OBJECT:
+		if int(idx) < len(s.Objects) && s.Objects[idx].Function != nil {
+			v.text, v.str = r.nm.Of(s.Objects[idx].Function), true
+		}
+		return v
+	}
+	if e := r.vals.At(in.Off); e != nil {
+		lit(v, e)
+	}
+	return v
+}
+
+// text renders the instruction at off with its operand, a call with its
+// folded target and arguments.
+func (r *run) text(off int) string {
+	i := sort.Search(len(r.ins), func(i int) bool { return r.ins[i].Off >= off })
+	in := r.ins[i]
+	v := r.operand(in)
+	switch {
+	case v.call != nil:
+		args := make([]string, len(v.args))
+		for i, a := range v.args {
+			args[i] = a.String()
+		}
+		return fmt.Sprintf("%s %s(%s)", in.Name(), v.text, strings.Join(args, ", "))
+	case v.str:
+		return in.Name() + " " + strconv.Quote(v.text)
+	case v.text != "":
+		return in.Name() + " " + v.text
+	}
+	return in.Name()
+}
+
+// lit sets v from the literal e.
+func lit(v *operand, e *ir.Expr) {
+	switch {
+	case e.IsLit(ir.LitString):
+		v.text, v.str = e.Str, true
+	case e.IsLit(ir.LitNumber):
+		v.text, v.num, v.isNum = e.String(), e.Num, true
+	default:
+		v.text = e.String()
+	}
+}
+
+func (p pred) test(v *operand) bool {
+	switch p.key {
+	case keyTarget:
+		return v.call != nil && p.testString(v.text, true)
+	case keyArgc:
+		return v.call != nil && v.call.Argc >= 0 && p.testNum(float64(v.call.Argc), true)
+	case keyArg:
+		if v.call == nil || p.arg >= len(v.args) {
+			return false
+		}
+		return p.testExpr(v.args[p.arg])
+	}
+	if p.rng {
+		return p.testNum(v.num, v.isNum)
+	}
+	return p.testString(v.text, v.str)
+}
+
+func (p pred) testString(s string, ok bool) bool {
+	switch {
+	case p.lit != nil:
+		return ok && s == *p.lit
+	case p.re != nil:
+		return (ok || s != "") && p.re.MatchString(s)
+	}
+	return false
+}
+
+func (p pred) testNum(f float64, ok bool) bool {
+	return ok && f >= p.lo && f <= p.hi
+}
+
+func (p pred) testExpr(e *ir.Expr) bool {
+	if e == nil {
+		return false
+	}
+	switch {
+	case p.rng:
+		return p.testNum(e.Num, e.IsLit(ir.LitNumber))
+	case p.lit != nil:
+		return e.IsLit(ir.LitString) && e.Str == *p.lit
+	case p.re != nil:
+		if e.IsLit(ir.LitString) {
+			return p.re.MatchString(e.Str)
+		}
+		return p.re.MatchString(e.String())
+	}
+	switch p.class {
+	case "str":
+		return e.IsLit(ir.LitString)
+	case "num":
+		return e.IsLit(ir.LitNumber)
+	case "bool":
+		return e.IsLit(ir.LitBool)
+	case "null":
+		return e.IsLit(ir.LitNull)
+	case "undefined":
+		return e.IsLit(ir.LitUndefined)
+	case "lit":
+		return e.Kind == ir.Lit
+	case "fn":
+		return e.Kind == ir.Lambda
+	case "obj":
+		return e.Kind == ir.Object
+	case "array":
+		return e.Kind == ir.Array
+	case "regexp":
+		return e.Kind == ir.Regexp
+	case "name":
+		return e.Kind == ir.Name
+	case "any":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+// tree builds:
+//
+//	localStorage.setItem("k", v);
+//	localStorage.setItem(v, "k");
+//	(function () { "http://x"; });
+//	7;
+func tree() *sm33.Script {
+	f := &sm33.Script{Bytecode: Asm(AtomOp(OpString, 0), []byte{OpPop, OpRetrval}), Atoms: []string{"http://x"}}
+	return &sm33.Script{
+		Atoms:   []string{"localStorage", "setItem", "k", "v"},
+		Objects: []*sm33.Object{{Kind: sm33.CkJSFunction, Function: &sm33.Function{Script: f}}},
+		Bytecode: Asm(
+			AtomOp(OpGetgname, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap}, // 0
+			AtomOp(OpString, 2), AtomOp(OpName, 3), []byte{OpCall, 0, 2, OpPop}, // 12
+			AtomOp(OpGetgname, 0), []byte{OpDup}, AtomOp(OpCallprop, 1), []byte{OpSwap}, // 26
+			AtomOp(OpName, 3), AtomOp(OpString, 2), []byte{OpCall, 0, 2, OpPop}, // 38
+			AtomOp(OpLambda, 0), []byte{OpPop, OpLineno, 0, 4, OpInt8, 7, OpPop}, // 52
+			[]byte{OpRetrval}, // 64
+		),
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := tree()
+	for _, tc := range []struct {
+		src  string
+		want [][2]int // Off, End of each match
+		caps map[string]string
+	}{
+		{`callprop "setItem"; ...; call arg0=str`, [][2]int{{6, 22}}, nil},
+		{`call target="localStorage.setItem" arg1=str`, [][2]int{{48, 48}}, nil},
+		{`call /setItem$/ argc=2`, [][2]int{{22, 22}, {48, 48}}, nil},
+		{`call argc=3..`, nil, nil},
+		{`$s=string /^http/`, [][2]int{{0, 0}}, map[string]string{"s": "http://x"}},
+		{`$a=int8 5..10`, [][2]int{{61, 61}}, map[string]string{"a": "7"}},
+		{`int8 8..`, nil, nil},
+		{`lambda; pop; int8`, [][2]int{{52, 61}}, nil},
+		{`get*; dup; $p=callprop; swap; ...2; call`, [][2]int{{0, 22}, {26, 48}}, map[string]string{"p": "setItem"}},
+		{`getgname|callprop "setItem"`, [][2]int{{6, 6}, {32, 32}}, nil},
+		{`in anon*: string`, [][2]int{{0, 0}}, nil},
+		{`in /^main$/: string "k"`, [][2]int{{12, 12}, {43, 43}}, nil},
+	} {
+		q, err := Parse(tc.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.src, err)
+			continue
+		}
+		ms := q.Run(root)
+		var got [][2]int
+		for _, m := range ms {
+			got = append(got, [2]int{m.Off, m.End})
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%q = %v, want %v", tc.src, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%q = %v, want %v", tc.src, got, tc.want)
+				break
+			}
+		}
+		for k, v := range tc.caps {
+			if ms[0].Captures[k] != v {
+				t.Errorf("%q: $%s = %q, want %q", tc.src, k, ms[0].Captures[k], v)
+			}
+		}
+	}
+}
+
+func TestInstrs(t *testing.T) {
+	q, err := Parse(`callprop; ...; call`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := q.Run(tree())
+	want := []string{`callprop "setItem"`, `call localStorage.setItem("k", v)`}
+	if len(ms) == 0 {
+		t.Fatal("no match")
+	}
+	if strings.Join(ms[0].Instrs, "|") != strings.Join(want, "|") {
+		t.Errorf("Instrs = %q, want %q", ms[0].Instrs, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`...`,
+		`call foo=1`,
+		`call "x`,
+		`call /x`,
+		`$=call`,
+		`call argc=str`,
+		`call target=1`,
+		`string str`,
+		`...x`,
+		`in main string`,
+		`call|`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) succeeded", src)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# literal keys
+[literal-key]
+query    = call target=/\.setItem$/ arg0=str
+severity = warning
+
+; second rule
+[lambdas]
+query = lambda
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Name != "literal-key" || rules[0].Fields["severity"] != "warning" || rules[1].Name != "lambdas" {
+		t.Fatalf("rules = %+v", rules)
+	}
+	if _, ok := rules[0].Fields["query"]; ok {
+		t.Errorf("query kept in Fields")
+	}
+
+	var b strings.Builder
+	if err := WriteRule(&b, rules[0]); err != nil {
+		t.Fatal(err)
+	}
+	want := "[literal-key]\nquery = call target=/\\.setItem$/ arg0=str\nseverity = warning\n"
+	if b.String() != want {
+		t.Errorf("WriteRule = %q, want %q", b.String(), want)
+	}
+
+	for _, src := range []string{
+		"[a]\nseverity = note\n",
+		"query = call\n",
+		"[a]\nquery = call\n[a]\nquery = new\n",
+		"[a]\nquery = call foo=1\n",
+		"[a]\nquery\n",
+	} {
+		if _, err := ParseRules(strings.NewReader(src)); err == nil {
+			t.Errorf("ParseRules(%q) succeeded", src)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Rule is a named query read from a rule file.
+//
+// A rule file is a list of sections, one per rule:
+//
+//	# localStorage keys written from literals
+//	[storage-literal-key]
+//	query   = call target=/\.setItem$/ arg0=str
+//	message = literal localStorage key
+//
+// Every rule needs a query; other keys are kept in Fields for the tool
+// that runs the rules (the audit scanner reads message and severity).
+// Blank lines and lines starting with # or ; are ignored.
+type Rule struct {
+	Name   string
+	Query  *Query
+	Fields map[string]string
+}
+
+// ParseRules reads a rule file.
+func ParseRules(r io.Reader) ([]*Rule, error) {
+	var out []*Rule
+	var cur *Rule
+	var start int
+	done := func() error {
+		if cur == nil {
+			return nil
+		}
+		src, ok := cur.Fields["query"]
+		if !ok {
+			return fmt.Errorf("line %d: rule %q has no query", start, cur.Name)
+		}
+		delete(cur.Fields, "query")
+		q, err := Parse(src)
+		if err != nil {
+			return fmt.Errorf("line %d: rule %q: %v", start, cur.Name, err)
+		}
+		cur.Query = q
+		out = append(out, cur)
+		return nil
+	}
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				return nil, fmt.Errorf("line %d: bad section %q", n, line)
+			}
+			if err := done(); err != nil {
+				return nil, err
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if seen[name] {
+				return nil, fmt.Errorf("line %d: duplicate rule %q", n, name)
+			}
+			seen[name] = true
+			cur, start = &Rule{Name: name, Fields: map[string]string{}}, n
+		default:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key = value", n)
+			}
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: key outside a rule", n)
+			}
+			cur.Fields[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := done(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadRules reads the rule file at path.
+func LoadRules(path string) ([]*Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// WriteRule writes r in rule file syntax: the query first, then the
+// other fields sorted by key.
+func WriteRule(w io.Writer, r *Rule) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\nquery = %s\n", r.Name, strings.TrimSpace(r.Query.Source))
+	keys := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", k, r.Fields[k])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
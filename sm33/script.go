@@ -50,6 +50,10 @@ type Script struct {
 	// Atoms referenced by bytecode
 	Atoms []string
 
+	// TwoByte reports, per atom, whether it was stored as UTF-16 rather
+	// than Latin-1.
+	TwoByte []bool
+
 	// Constants referenced by bytecode
 	Consts []Const
 
@@ -64,6 +68,36 @@ type Script struct {
 
 	// Binding names (args + vars)
 	Bindings []string
+
+	// Aliased reports, per binding, whether it lives in the call object
+	// (captured by an inner function) rather than a frame slot.
+	Aliased []bool
+
+	// Bits holds the XDR scriptBits flags; see the Flag constants.
+	Bits uint32
+}
+
+// Script flag bits, as stored in Script.Bits.
+const (
+	FlagStrict                = 1 << 2
+	FlagFunHasExtensibleScope = 1 << 4
+	FlagFunNeedsDeclEnvObject = 1 << 5
+	FlagIsLegacyGenerator     = 1 << 10
+	FlagIsStarGenerator       = 1 << 11
+)
+
+// HasCallObject reports whether a function script creates a call object
+// for its aliased bindings when invoked.
+func (s *Script) HasCallObject() bool {
+	if s.Bits&FlagFunHasExtensibleScope != 0 {
+		return true
+	}
+	for _, a := range s.Aliased {
+		if a {
+			return true
+		}
+	}
+	return false
 }
 
 // ConstKind identifies the type of a script constant.
@@ -84,21 +118,43 @@ const (
 // Const is a decoded script constant.
 type Const struct {
 	Kind   ConstKind
-	Int    int32   // valid when Kind == ConstInt
-	Double float64 // valid when Kind == ConstDouble
-	Atom   string  // valid when Kind == ConstAtom
+	Int    int32    // valid when Kind == ConstInt
+	Double float64  // valid when Kind == ConstDouble
+	Atom   string   // valid when Kind == ConstAtom
+	Object *Literal // valid when Kind == ConstObject
+
+	TwoByte bool // Atom was stored as UTF-16
 }
 
 // Regexp is a decoded script regexp.
 type Regexp struct {
-	Source string
-	Flags  uint32
+	Source  string
+	Flags   uint32
+	TwoByte bool // Source was stored as UTF-16
 }
 
 // Object is a decoded XDR object entry.
 type Object struct {
 	Kind     uint32
 	Function *Function // non-nil when Kind == CkJSFunction
+	Literal  *Literal  // non-nil when Kind == CkJSObject
+}
+
+// Literal is the template of an object or array literal (CkJSObject).
+// Nested object literals are ConstObject values holding their own
+// Literal in Const.Object.
+type Literal struct {
+	Array bool
+	Elems []Const       // dense elements
+	Props []LiteralProp // named properties, in order
+}
+
+// LiteralProp is one named property of a Literal.
+type LiteralProp struct {
+	Key     string // integer ids in decimal
+	Int     bool   // the id is an integer
+	TwoByte bool   // Key was stored as UTF-16
+	Value   Const
 }
 
 // Function is a decoded inner function.
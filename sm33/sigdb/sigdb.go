@@ -0,0 +1,269 @@
+// Package sigdb recognizes known library functions, FLIRT style.
+//
+// A signature database holds the fingerprints of every function of a set
+// of library scripts (the Cocos2d-x framework files every game bundles),
+// each labelled with its library and display name. Match fingerprints a
+// target tree and labels its functions that match a signature, so
+// disassembly and callgraphs can mark, collapse or hide library code.
+//
+// Databases are versioned JSON files:
+//
+//	{"version": 1,
+//	 "libraries": [{"name": "cocos2d-js-3.13", "files": ["jsb_cocos2d.js"], "functions": 812}],
+//	 "signatures": [{"library": "cocos2d-js-3.13", "file": "jsb_cocos2d.js", "name": "cc.Node.ctor", ...}]}
+package sigdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/fingerprint"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+)
+
+// Version is the database format version Read accepts and Write emits.
+const Version = 1
+
+// MinOps is the normalized size below which functions get no signature:
+// tiny getters and wrappers look alike across unrelated code.
+const MinOps = 8
+
+// MinScore is the Similarity a fuzzy match needs.
+const MinScore = 0.85
+
+// DB is a signature database.
+type DB struct {
+	Version    int          `json:"version"`
+	Libraries  []*Library   `json:"libraries"`
+	Signatures []*Signature `json:"signatures"`
+
+	exact map[string][]*Signature // Exact hash → signatures
+	byOps []*Signature            // signatures by size
+}
+
+// Library describes the scripts one library was built from.
+type Library struct {
+	Name      string   `json:"name"`
+	Files     []string `json:"files"`
+	Functions int      `json:"functions"` // signatures contributed
+}
+
+// Signature is the fingerprint of one library function.
+type Signature struct {
+	Library string `json:"library"`
+	File    string `json:"file,omitempty"`
+	fingerprint.Fingerprint
+}
+
+// New returns an empty database.
+func New() *DB {
+	return &DB{Version: Version, Libraries: []*Library{}, Signatures: []*Signature{}}
+}
+
+// Add fingerprints the functions of root, a script of library lib read
+// from file, and adds those not already in the database for lib. Main and
+// functions under MinOps are skipped. It returns the number added.
+func (db *DB) Add(lib, file string, root *sm33.Script) int {
+	l := db.library(lib)
+	l.Files = append(l.Files, file)
+	seen := map[string]bool{}
+	for _, s := range db.Signatures {
+		if s.Library == lib {
+			seen[key(&s.Fingerprint)] = true
+		}
+	}
+	n := 0
+	for _, fp := range fingerprint.Compute(root) {
+		if fp.Func == nil || fp.Ops < MinOps || seen[key(fp)] {
+			continue
+		}
+		seen[key(fp)] = true
+		db.Signatures = append(db.Signatures, &Signature{Library: lib, File: file, Fingerprint: *fp})
+		n++
+	}
+	l.Functions += n
+	db.exact = nil
+	return n
+}
+
+func (db *DB) library(name string) *Library {
+	for _, l := range db.Libraries {
+		if l.Name == name {
+			return l
+		}
+	}
+	l := &Library{Name: name, Files: []string{}}
+	db.Libraries = append(db.Libraries, l)
+	return l
+}
+
+// key identifies a fingerprint by code and atoms.
+func key(fp *fingerprint.Fingerprint) string {
+	return fp.Exact + "\x00" + strings.Join(fp.Atoms, "\x00")
+}
+
+// Read decodes a database, rejecting other format versions.
+func Read(r io.Reader) (*DB, error) {
+	db := &DB{}
+	if err := json.NewDecoder(r).Decode(db); err != nil {
+		return nil, fmt.Errorf("signature database: %v", err)
+	}
+	if db.Version != Version {
+		return nil, fmt.Errorf("signature database version %d not supported (want %d)", db.Version, Version)
+	}
+	for i, l := range db.Libraries {
+		if l == nil {
+			return nil, fmt.Errorf("signature database: library %d is null", i)
+		}
+	}
+	for i, s := range db.Signatures {
+		if s == nil {
+			return nil, fmt.Errorf("signature database: signature %d is null", i)
+		}
+	}
+	return db, nil
+}
+
+// Load reads the database at path.
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Write encodes the database as indented JSON.
+func (db *DB) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(db)
+}
+
+// Label records that a target function matched a signature.
+type Label struct {
+	Func    string  `json:"func"` // display name in the target
+	Library string  `json:"library"`
+	Name    string  `json:"name"` // display name in the library
+	Score   float64 `json:"score"`
+	By      string  `json:"by"` // "exact", "fuzzy" or "inner"
+}
+
+// Match labels the functions of root found in the database, depth-first
+// in object order. A function matches the signature with the same code
+// and atoms, else the most similar one scoring at least MinScore.
+// Unmatched functions defined inside a labelled one inherit its library
+// and are labelled "inner".
+func (db *DB) Match(root *sm33.Script) []Label {
+	db.index()
+	found := map[*sm33.Function]Label{}
+	for _, fp := range fingerprint.Compute(root) {
+		if fp.Func == nil || fp.Ops < MinOps {
+			continue
+		}
+		if sig, score, by := db.best(fp); sig != nil {
+			found[fp.Func] = Label{Func: fp.Name, Library: sig.Library, Name: sig.Name, Score: score, By: by}
+		}
+	}
+
+	n := names.Infer(root)
+	var out []Label
+	var walk func(s *sm33.Script, parent *Label)
+	walk = func(s *sm33.Script, parent *Label) {
+		for _, obj := range s.Objects {
+			fn := obj.Function
+			if obj.Kind != sm33.CkJSFunction || fn == nil || fn.Script == nil {
+				continue
+			}
+			l, ok := found[fn]
+			if !ok && parent != nil {
+				name := n.Of(fn)
+				l = Label{Func: name, Library: parent.Library, Name: parent.Name + "/" + name[strings.LastIndex(name, "/")+1:], Score: parent.Score, By: "inner"}
+				ok = true
+			}
+			if !ok {
+				walk(fn.Script, nil)
+				continue
+			}
+			out = append(out, l)
+			walk(fn.Script, &l)
+		}
+	}
+	walk(root, nil)
+	return out
+}
+
+// LibraryFunc names the library function a script function was
+// recognized as.
+type LibraryFunc struct {
+	Library string // library label, e.g. "cocos2d-js-3.13"
+	Name    string // function name in the library
+}
+
+// LibraryView controls how disassembly and callgraphs show library
+// functions.
+type LibraryView int
+
+const (
+	// LibraryLabel shows library functions with a label comment.
+	LibraryLabel LibraryView = iota
+	// LibraryCollapse replaces each library function by its label.
+	LibraryCollapse
+	// LibraryHide omits library functions.
+	LibraryHide
+)
+
+// Functions maps labelled display names to their library function, in
+// the form disasm.View.Library takes.
+func Functions(labels []Label) map[string]LibraryFunc {
+	m := map[string]LibraryFunc{}
+	for _, l := range labels {
+		m[l.Func] = LibraryFunc{Library: l.Library, Name: l.Name}
+	}
+	return m
+}
+
+// best returns the signature fp matches best.
+func (db *DB) best(fp *fingerprint.Fingerprint) (*Signature, float64, string) {
+	k := key(fp)
+	for _, s := range db.exact[fp.Exact] {
+		if key(&s.Fingerprint) == k {
+			return s, 1, "exact"
+		}
+	}
+	var best *Signature
+	score := MinScore
+	i := sort.Search(len(db.byOps), func(i int) bool { return db.byOps[i].Ops*4 >= fp.Ops*3 })
+	for ; i < len(db.byOps) && db.byOps[i].Ops*3 <= fp.Ops*4; i++ {
+		s := db.byOps[i]
+		if s.Nargs != fp.Nargs || !fingerprint.Comparable(fp, &s.Fingerprint) {
+			continue
+		}
+		if x := fingerprint.Similarity(fp, &s.Fingerprint); x > score || best == nil && x >= score {
+			best, score = s, x
+		}
+	}
+	if best == nil {
+		return nil, 0, ""
+	}
+	return best, score, "fuzzy"
+}
+
+// index builds the lookup tables Match uses.
+func (db *DB) index() {
+	if db.exact != nil {
+		return
+	}
+	db.exact = map[string][]*Signature{}
+	db.byOps = append([]*Signature(nil), db.Signatures...)
+	for _, s := range db.Signatures {
+		db.exact[s.Exact] = append(db.exact[s.Exact], s)
+	}
+	sort.SliceStable(db.byOps, func(i, j int) bool { return db.byOps[i].Ops < db.byOps[j].Ops })
+}
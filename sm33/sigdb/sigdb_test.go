@@ -0,0 +1,81 @@
+package sigdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33/xdr"
+)
+
+func TestMatch(t *testing.T) {
+	lib, err := xdr.DecodeFile("../disasm/testdata/functions.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := xdr.DecodeFile("../disasm/testdata/simple.jsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := New()
+	n := db.Add("loader", "functions.js", lib)
+	if n == 0 || len(db.Signatures) != n {
+		t.Fatalf("Add = %d, %d signatures", n, len(db.Signatures))
+	}
+	if again := db.Add("loader", "functions.js", lib); again != 0 {
+		t.Errorf("second Add = %d, want 0", again)
+	}
+	if l := db.Libraries[0]; l.Name != "loader" || l.Functions != n {
+		t.Errorf("library = %+v", l)
+	}
+
+	// Round trip through the file format.
+	var buf bytes.Buffer
+	if err := db.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := db.Match(lib)
+	byFunc := map[string]Label{}
+	for _, l := range labels {
+		byFunc[l.Func] = l
+	}
+	if l := byFunc["createStyle"]; l.Library != "loader" || l.Name != "createStyle" || l.By != "exact" || l.Score != 1 {
+		t.Errorf("createStyle = %+v", l)
+	}
+	for _, l := range labels {
+		if l.By == "inner" && !strings.HasPrefix(l.Func, "startAnimation/") && !strings.HasPrefix(l.Func, "anon#") {
+			t.Errorf("unexpected inner label %+v", l)
+		}
+	}
+	if len(Functions(labels)) != len(labels) {
+		t.Errorf("Functions lost labels")
+	}
+
+	if labels := db.Match(other); len(labels) != 0 {
+		t.Errorf("unrelated script labelled: %+v", labels)
+	}
+}
+
+func TestReadVersion(t *testing.T) {
+	if _, err := Read(strings.NewReader(`{"version": 99, "signatures": []}`)); err == nil {
+		t.Error("Read accepted version 99")
+	}
+	for _, js := range []string{`{"version": 1, "signatures": [null]}`, `{"version": 1, "libraries": [null]}`} {
+		if _, err := Read(strings.NewReader(js)); err == nil {
+			t.Errorf("Read accepted %s", js)
+		}
+	}
+	db, err := Read(strings.NewReader(`{"version": 1, "libraries": [], "signatures": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Signatures) != 0 {
+		t.Errorf("signatures = %d", len(db.Signatures))
+	}
+}
@@ -0,0 +1,157 @@
+// Package srcnote decodes SM33 source notes and maps bytecode offsets
+// to source lines and columns.
+package srcnote
+
+import (
+	"sort"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+)
+
+// Type is a source note type (SourceNotes.h, SpiderMonkey 33).
+type Type uint8
+
+const (
+	Null        Type = 0
+	If          Type = 1
+	IfElse      Type = 2
+	Cond        Type = 3
+	For         Type = 4
+	While       Type = 5
+	ForIn       Type = 6
+	ForOf       Type = 7
+	Continue    Type = 8
+	Break       Type = 9
+	Break2Label Type = 10
+	SwitchBreak Type = 11
+	TableSwitch Type = 12
+	CondSwitch  Type = 13
+	NextCase    Type = 14
+	AssignOp    Type = 15
+	Try         Type = 16
+	ColSpan     Type = 17
+	Newline     Type = 18
+	SetLine     Type = 19
+	XDelta      Type = 24 // 24-31 are extended-delta notes
+)
+
+// arity is the operand count per note type.
+var arity = [32]int{
+	IfElse: 1, Cond: 1, For: 3, While: 1, ForIn: 1, ForOf: 1,
+	TableSwitch: 1, CondSwitch: 2, NextCase: 1, Try: 1,
+	ColSpan: 1, SetLine: 1,
+}
+
+const (
+	deltaBits   = 3
+	xdeltaBits  = 6
+	deltaMask   = 1<<deltaBits - 1
+	xdeltaMask  = 1<<xdeltaBits - 1
+	fourByte    = 0x80
+	colspanSpan = 1 << 23 // SN_COLSPAN_DOMAIN
+)
+
+// Note is one decoded source note.
+type Note struct {
+	Offset   int // bytecode offset the note applies to
+	Type     Type
+	Operands []int
+}
+
+// Decode parses a source note vector. Decoding stops at the terminator
+// or at the first truncated note.
+func Decode(notes []byte) []Note {
+	var out []Note
+	off := 0
+	for i := 0; i < len(notes); {
+		b := notes[i]
+		if b == 0 {
+			break
+		}
+		t := Type(b >> deltaBits)
+		delta := int(b & deltaMask)
+		if t >= XDelta {
+			t = XDelta
+			delta = int(b & xdeltaMask)
+		}
+		off += delta
+		i++
+		n := Note{Offset: off, Type: t}
+		for k := 0; k < arity[t]; k++ {
+			if i >= len(notes) {
+				return out
+			}
+			if notes[i]&fourByte != 0 {
+				if i+4 > len(notes) {
+					return out
+				}
+				v := int(notes[i]&^fourByte)<<24 | int(notes[i+1])<<16 | int(notes[i+2])<<8 | int(notes[i+3])
+				n.Operands = append(n.Operands, v)
+				i += 4
+			} else {
+				n.Operands = append(n.Operands, int(notes[i]))
+				i++
+			}
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// Pos is a source position. Lines are 1-based; columns 0-based.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Lines maps bytecode offsets of one script to source positions.
+type Lines struct {
+	start Pos
+	offs  []int
+	pos   []Pos
+}
+
+// NewLines builds the offset → position table for s.
+func NewLines(s *sm33.Script) *Lines {
+	l := &Lines{start: Pos{Line: int(s.Lineno), Column: int(s.Column)}}
+	cur := l.start
+	for _, n := range Decode(s.Srcnotes) {
+		switch n.Type {
+		case Newline:
+			cur.Line++
+			cur.Column = 0
+		case SetLine:
+			cur.Line = n.Operands[0]
+			cur.Column = 0
+		case ColSpan:
+			span := n.Operands[0]
+			if span >= colspanSpan/2 {
+				span -= colspanSpan
+			}
+			cur.Column += span
+		default:
+			continue
+		}
+		if k := len(l.offs); k > 0 && l.offs[k-1] == n.Offset {
+			l.pos[k-1] = cur
+			continue
+		}
+		l.offs = append(l.offs, n.Offset)
+		l.pos = append(l.pos, cur)
+	}
+	return l
+}
+
+// At returns the source position of the instruction at off.
+func (l *Lines) At(off int) Pos {
+	i := sort.Search(len(l.offs), func(i int) bool { return l.offs[i] > off })
+	if i == 0 {
+		return l.start
+	}
+	return l.pos[i-1]
+}
+
+// Line returns the source line of the instruction at off.
+func (l *Lines) Line(off int) int {
+	return l.At(off).Line
+}
@@ -0,0 +1,70 @@
+package srcnote
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+)
+
+func TestDecode(t *testing.T) {
+	notes := []byte{
+		byte(SetLine)<<3 | 2, 0x80, 0x00, 0x01, 0x2c, // +2 setline 300 (4-byte operand)
+		byte(Newline)<<3 | 5,       // +5 newline
+		0xc0 | 40,                  // xdelta +40
+		byte(ColSpan)<<3 | 1, 0x06, // +1 colspan 6
+		byte(For)<<3 | 0, 1, 2, 3, // +0 for
+		0, // terminator
+		byte(Newline) << 3,
+	}
+	got := Decode(notes)
+	want := []Note{
+		{2, SetLine, []int{300}},
+		{7, Newline, nil},
+		{47, XDelta, nil},
+		{48, ColSpan, []int{6}},
+		{48, For, []int{1, 2, 3}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d notes, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Offset != w.Offset || g.Type != w.Type || len(g.Operands) != len(w.Operands) {
+			t.Fatalf("note %d = %+v, want %+v", i, g, w)
+		}
+		for k := range w.Operands {
+			if g.Operands[k] != w.Operands[k] {
+				t.Errorf("note %d operand %d = %d, want %d", i, k, g.Operands[k], w.Operands[k])
+			}
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	s := &sm33.Script{
+		Lineno: 10,
+		Srcnotes: []byte{
+			byte(Newline)<<3 | 4,     // @4 line 11
+			byte(SetLine)<<3 | 6, 20, // @10 line 20
+			byte(ColSpan)<<3 | 0, 0x80, 0x7f, 0xff, 0xfd, // @10 colspan -3
+			0,
+		},
+	}
+	l := NewLines(s)
+	tests := []struct {
+		off  int
+		want Pos
+	}{
+		{0, Pos{10, 0}},
+		{3, Pos{10, 0}},
+		{4, Pos{11, 0}},
+		{9, Pos{11, 0}},
+		{10, Pos{20, -3}},
+		{99, Pos{20, -3}},
+	}
+	for _, tt := range tests {
+		if got := l.At(tt.off); got != tt.want {
+			t.Errorf("At(%d) = %+v, want %+v", tt.off, got, tt.want)
+		}
+	}
+}
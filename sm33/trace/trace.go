@@ -0,0 +1,229 @@
+// Package trace runs a script's top-level code in the sandboxed
+// interpreter against a recording stub environment and logs what it
+// wires up: global reads and writes, property sets, calls into the
+// engine, callbacks handed to it, and the strings built along the way.
+//
+// Stub globals (cc, jsb, sys, ...) are recording proxies. Reading any
+// property of a proxy returns another proxy named by its path
+// (cc.director), calling one records the call and returns a proxy for the
+// result (cc.director.getWinSize()), and new on one returns the same kind
+// of result proxy. Functions passed to a proxy, directly or as methods of
+// an object literal argument, and functions stored on a proxy are
+// recorded as callbacks:
+//
+//	cc.game.onStart = function () {}          → callback cc.game.onStart
+//	var L = cc.Layer.extend({ onEnter: fn })  → call cc.Layer.extend,
+//	                                            callback onEnter: L.onEnter
+package trace
+
+import (
+	"unicode/utf8"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/interp"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+)
+
+// Event kinds.
+const (
+	Get      = "get"      // global read
+	Set      = "set"      // global write
+	Prop     = "prop"     // property set on an object
+	Call     = "call"     // call to a stub
+	Callback = "callback" // function handed to a stub
+	String   = "string"   // string built by concatenation or a native
+)
+
+// Event is one effect of the run.
+type Event struct {
+	Kind  string   `json:"kind"`
+	Func  string   `json:"func"`            // display name of the executing function
+	Off   int      `json:"off"`             // bytecode offset
+	Name  string   `json:"name,omitempty"`  // global name, property path or stub path
+	Value string   `json:"value,omitempty"` // rendered value, truncated
+	Args  []string `json:"args,omitempty"`  // rendered call arguments
+}
+
+// Trace is the event log of one run.
+type Trace struct {
+	Stubs  []string `json:"stubs"`
+	All    bool     `json:"all,omitempty"`
+	Events []Event  `json:"events"`
+	Steps  int      `json:"steps"`
+	Error  string   `json:"error,omitempty"` // why the run stopped early
+}
+
+// Config selects the stub environment.
+type Config struct {
+	Stubs []string // globals served by recording proxies
+	All   bool     // also proxy every other undefined global
+}
+
+// DefaultStubs are the host globals Cocos2d-x scripts expect.
+var DefaultStubs = []string{"cc", "jsb", "sys", "window", "document", "console", "setTimeout", "setInterval", "require"}
+
+// maxValue caps the length of rendered values.
+const maxValue = 120
+
+// Run executes root's top-level code and returns its trace. An error that
+// stops the run is recorded in Trace.Error; the events before it are kept.
+func Run(root *sm33.Script, opt sm33.Options, cfg Config) *Trace {
+	r := &recorder{
+		m:     interp.New(opt),
+		t:     &Trace{Stubs: cfg.Stubs, All: cfg.All, Events: []Event{}},
+		names: names.Infer(root),
+		funcs: map[*sm33.Script]string{root: "main"},
+		paths: map[*interp.Object]string{},
+		stubs: map[string]bool{},
+	}
+	r.index(root)
+	for _, name := range cfg.Stubs {
+		r.stubs[name] = true
+	}
+	m := r.m
+	m.Host = func(m *interp.Machine, name string) (interp.Value, bool) {
+		if !r.stubs[name] && !cfg.All {
+			return interp.Value{}, false
+		}
+		return interp.ObjValue(r.proxy(name)), true
+	}
+	m.OnGlobal = func(name string, v interp.Value) {
+		r.emit(Event{Kind: Get, Name: name, Value: r.render(v)})
+	}
+	m.OnSet = r.set
+	m.OnString = func(s string) {
+		if s != "" {
+			r.emit(Event{Kind: String, Value: truncate(s)})
+		}
+	}
+
+	if _, err := m.Run(root); err != nil {
+		r.t.Error = err.Error()
+	}
+	r.t.Steps = m.Steps()
+	return r.t
+}
+
+// recorder carries the state of one Run.
+type recorder struct {
+	m     *interp.Machine
+	t     *Trace
+	names *names.Names
+	funcs map[*sm33.Script]string   // script → display name
+	paths map[*interp.Object]string // proxies and named objects → access path
+	stubs map[string]bool
+}
+
+func (r *recorder) index(s *sm33.Script) {
+	for _, obj := range s.Objects {
+		if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+			r.funcs[fn.Script] = r.names.Of(fn)
+			r.index(fn.Script)
+		}
+	}
+}
+
+func (r *recorder) emit(e Event) {
+	s, off := r.m.Where()
+	e.Func, e.Off = r.funcs[s], off
+	r.t.Events = append(r.t.Events, e)
+}
+
+// proxy returns a recording stub for path.
+func (r *recorder) proxy(path string) *interp.Object {
+	o := r.m.NewNative(func(m *interp.Machine, this interp.Value, args []interp.Value) (interp.Value, error) {
+		r.call(path, args)
+		return interp.ObjValue(r.proxy(path + "()")), nil
+	})
+	o.Missing = func(name string) (interp.Value, bool) {
+		return interp.ObjValue(r.proxy(path + "." + name)), true
+	}
+	r.paths[o] = path
+	return o
+}
+
+// call records a call to the stub at path and the callbacks it receives.
+func (r *recorder) call(path string, args []interp.Value) {
+	e := Event{Kind: Call, Name: path, Args: []string{}}
+	for _, a := range args {
+		e.Args = append(e.Args, r.render(a))
+	}
+	r.emit(e)
+	for _, a := range args {
+		if a.Kind != interp.Obj {
+			continue
+		}
+		if a.Obj.Fn != nil {
+			r.emit(Event{Kind: Callback, Name: path, Value: r.render(a)})
+			continue
+		}
+		if _, ok := r.paths[a.Obj]; ok || a.Obj.Class != "Object" {
+			continue
+		}
+		for _, k := range a.Obj.Keys() {
+			if v := a.Obj.Get(k); v.Kind == interp.Obj && v.Obj.Fn != nil {
+				r.emit(Event{Kind: Callback, Name: path, Value: k + ": " + r.render(v)})
+			}
+		}
+	}
+}
+
+// set records an assignment and names the stored object after it, so
+// later stores into it get a readable path.
+func (r *recorder) set(obj *interp.Object, name string, v interp.Value) {
+	var path string
+	if obj == r.m.Global {
+		path = name
+		r.emit(Event{Kind: Set, Name: name, Value: r.render(v)})
+	} else {
+		path = r.path(obj) + "." + name
+		r.emit(Event{Kind: Prop, Name: path, Value: r.render(v)})
+		if _, stub := r.paths[obj]; stub && obj.Native != nil && v.Kind == interp.Obj && v.Obj.Fn != nil {
+			r.emit(Event{Kind: Callback, Name: path, Value: r.render(v)})
+		}
+	}
+	if v.Kind == interp.Obj {
+		if _, ok := r.paths[v.Obj]; !ok {
+			r.paths[v.Obj] = path
+		}
+	}
+}
+
+// path returns the access path of obj: its proxy or assignment path, or
+// F.prototype for the prototype object of a named function F.
+func (r *recorder) path(obj *interp.Object) string {
+	if p, ok := r.paths[obj]; ok {
+		return p
+	}
+	if c := obj.Get("constructor"); c.Kind == interp.Obj {
+		if p, ok := r.paths[c.Obj]; ok && c.Obj.Get("prototype").Obj == obj {
+			return p + ".prototype"
+		}
+	}
+	return "<object>"
+}
+
+// render formats v for an event: stubs by path, interpreted functions by
+// display name, everything else as a literal.
+func (r *recorder) render(v interp.Value) string {
+	if v.Kind == interp.Obj {
+		if p, ok := r.paths[v.Obj]; ok && v.Obj.Native != nil {
+			return p
+		}
+		if v.Obj.Fn != nil {
+			return "function " + r.names.Of(v.Obj.Fn.Func)
+		}
+	}
+	return truncate(interp.Inspect(v))
+}
+
+func truncate(s string) string {
+	if len(s) <= maxValue {
+		return s
+	}
+	n := maxValue
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+func TestRun(t *testing.T) {
+	// cc.game.onStart = function () {};
+	// GameLayer = cc.Layer.extend({onEnter: function () {}});
+	// cc.log("v" + 1);
+	objs := make([]*sm33.Object, 2)
+	for i := range objs {
+		objs[i] = &sm33.Object{Kind: sm33.CkJSFunction, Function: &sm33.Function{Script: &sm33.Script{Bytecode: []byte{OpRetrval}}}}
+	}
+	s := &sm33.Script{
+		Atoms:   []string{"cc", "game", "onStart", "GameLayer", "Layer", "extend", "onEnter", "log", "v"},
+		Objects: objs,
+		Bytecode: Asm(
+			AtomOp(OpName, 0), AtomOp(OpGetprop, 1), AtomOp(OpLambda, 0), AtomOp(OpSetprop, 2), []byte{OpPop},
+			AtomOp(OpBindname, 3),
+			AtomOp(OpName, 0), AtomOp(OpGetprop, 4),
+			[]byte{OpDup}, AtomOp(OpCallprop, 5), []byte{OpSwap},
+			[]byte{OpNewinit, 1, 0, 0, 0}, AtomOp(OpLambda, 1), AtomOp(OpInitprop, 6), []byte{OpEndinit},
+			[]byte{OpCall, 0, 1},
+			AtomOp(OpSetname, 3), []byte{OpPop},
+			AtomOp(OpName, 0), []byte{OpDup}, AtomOp(OpCallprop, 7), []byte{OpSwap},
+			AtomOp(OpString, 8), []byte{OpOne, OpAdd},
+			[]byte{OpCall, 0, 1}, []byte{OpPop},
+			[]byte{OpRetrval},
+		),
+	}
+	tr := Run(s, sm33.DefaultOptions(), Config{Stubs: DefaultStubs})
+	if tr.Error != "" {
+		t.Fatal(tr.Error)
+	}
+	want := []Event{
+		{Kind: Get, Func: "main", Off: 0, Name: "cc", Value: "cc"},
+		{Kind: Prop, Func: "main", Off: 15, Name: "cc.game.onStart", Value: "function cc.game.onStart"},
+		{Kind: Callback, Func: "main", Off: 15, Name: "cc.game.onStart", Value: "function cc.game.onStart"},
+		{Kind: Get, Func: "main", Off: 26, Name: "cc", Value: "cc"},
+		{Kind: Call, Func: "main", Off: 59, Name: "cc.Layer.extend", Args: []string{"{onEnter: [Function <anonymous>]}"}},
+		{Kind: Callback, Func: "main", Off: 59, Name: "cc.Layer.extend", Value: "onEnter: function GameLayer.onEnter"},
+		{Kind: Set, Func: "main", Off: 62, Name: "GameLayer", Value: "cc.Layer.extend()"},
+		{Kind: Get, Func: "main", Off: 68, Name: "cc", Value: "cc"},
+		{Kind: String, Func: "main", Off: 86, Value: "v1"},
+		{Kind: Call, Func: "main", Off: 87, Name: "cc.log", Args: []string{`"v1"`}},
+	}
+	if len(tr.Events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(tr.Events), len(want), tr.Events)
+	}
+	for i, w := range want {
+		g := tr.Events[i]
+		if g.Kind != w.Kind || g.Func != w.Func || g.Off != w.Off || g.Name != w.Name || g.Value != w.Value || len(g.Args) != len(w.Args) || (len(w.Args) > 0 && g.Args[0] != w.Args[0]) {
+			t.Errorf("event %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestUndefinedGlobal(t *testing.T) {
+	s := &sm33.Script{Atoms: []string{"ccs"}, Bytecode: Asm(AtomOp(OpName, 0), []byte{OpPop, OpRetrval})}
+	if tr := Run(s, sm33.DefaultOptions(), Config{Stubs: DefaultStubs}); tr.Error == "" {
+		t.Errorf("undefined global ccs did not stop the run")
+	}
+	if tr := Run(s, sm33.DefaultOptions(), Config{All: true}); tr.Error != "" || len(tr.Events) != 1 {
+		t.Errorf("All: error %q, events %+v", tr.Error, tr.Events)
+	}
+}
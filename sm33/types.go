@@ -20,6 +20,10 @@ type Options struct {
 	// MaxReadBytes caps any single bytes() allocation during XDR decode; 0 uses sm33.MaxReadBytes.
 	// This is a DoS/OOM guard. Larger caps can be necessary for real-world .jsc files.
 	MaxReadBytes int
+
+	// MaxHeapBytes caps the memory the bytecode interpreter may allocate
+	// for objects, array elements and strings; 0 uses DefaultMaxHeapBytes.
+	MaxHeapBytes int
 }
 
 // DefaultOptions returns Strict mode with default step limit.
@@ -30,6 +34,17 @@ func DefaultOptions() Options {
 // DefaultMaxSteps is the default safety cap for iteration loops.
 const DefaultMaxSteps = 1 << 20
 
+// DefaultMaxHeapBytes is the default interpreter allocation cap.
+const DefaultMaxHeapBytes = 64 << 20
+
+// EffectiveMaxHeapBytes returns the effective interpreter allocation cap.
+func (o Options) EffectiveMaxHeapBytes() int {
+	if o.MaxHeapBytes <= 0 {
+		return DefaultMaxHeapBytes
+	}
+	return o.MaxHeapBytes
+}
+
 // EffectiveMaxSteps returns the effective step limit.
 func (o Options) EffectiveMaxSteps() int {
 	if o.MaxSteps <= 0 {
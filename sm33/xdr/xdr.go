@@ -6,6 +6,7 @@ import (
 	"io"
 	"math"
 	"os"
+	"strconv"
 	"unicode/utf16"
 
 	"github.com/zboralski/spidermonkey-dumper/sm33"
@@ -184,9 +185,16 @@ func (r *reader) cstring() (string, error) {
 
 // readAtom reads an XDR atom: uint32(length<<1|isLatin1) + chars.
 func (r *reader) readAtom() (string, error) {
+	a, _, err := r.readAtomEnc()
+	return a, err
+}
+
+// readAtomEnc is readAtom that also reports whether the atom was stored
+// as UTF-16.
+func (r *reader) readAtomEnc() (string, bool, error) {
 	val, err := r.u32()
 	if err != nil {
-		return "", fmt.Errorf("atom header: %w", err)
+		return "", false, fmt.Errorf("atom header: %w", err)
 	}
 	length := val >> 1
 	isLatin1 := val & 1
@@ -194,14 +202,14 @@ func (r *reader) readAtom() (string, error) {
 	if isLatin1 != 0 {
 		b, err := r.bytes(int(length))
 		if err != nil {
-			return "", fmt.Errorf("atom latin1 data: %w", err)
+			return "", false, fmt.Errorf("atom latin1 data: %w", err)
 		}
-		return string(b), nil
+		return string(b), false, nil
 	}
 	// UTF-16: 2 bytes per char (little-endian), decode surrogate pairs
 	raw, err := r.bytes(int(length) * 2)
 	if err != nil {
-		return "", fmt.Errorf("atom utf16 data: %w", err)
+		return "", true, fmt.Errorf("atom utf16 data: %w", err)
 	}
 	// Use actual bytes returned (may be shorter in BestEffort mode)
 	nchars := len(raw) / 2
@@ -209,7 +217,7 @@ func (r *reader) readAtom() (string, error) {
 	for i := 0; i < nchars; i++ {
 		u16s[i] = binary.LittleEndian.Uint16(raw[i*2:])
 	}
-	return string(utf16.Decode(u16s)), nil
+	return string(utf16.Decode(u16s)), true, nil
 }
 
 // clampCount validates a parsed count against remaining bytes and absolute cap.
@@ -387,6 +395,7 @@ func decodeScript(r *reader) (*sm33.Script, error) {
 	if err != nil {
 		return nil, fmt.Errorf("scriptBits: %w", err)
 	}
+	s.Bits = scriptBits
 
 	// XDRScriptBindings
 	nameCount := uint32(s.Nargs) + s.Nvars
@@ -401,11 +410,14 @@ func decodeScript(r *reader) (*sm33.Script, error) {
 			return nil, fmt.Errorf("binding atom %d: %w", i, err)
 		}
 	}
-	// Binding descriptors (1 byte each)
+	// Binding descriptors (1 byte each): kind<<1 | aliased
+	s.Aliased = make([]bool, nameCount)
 	for i := uint32(0); i < nameCount; i++ {
-		if _, err = r.u8(); err != nil {
+		desc, err := r.u8()
+		if err != nil {
 			return nil, fmt.Errorf("binding descriptor %d: %w", i, err)
 		}
+		s.Aliased[i] = desc&1 != 0
 	}
 
 	// ScriptSource (only if OwnSource)
@@ -459,8 +471,9 @@ func decodeScript(r *reader) (*sm33.Script, error) {
 		return nil, err
 	}
 	s.Atoms = make([]string, natoms)
+	s.TwoByte = make([]bool, natoms)
 	for i := uint32(0); i < natoms; i++ {
-		s.Atoms[i], err = r.readAtom()
+		s.Atoms[i], s.TwoByte[i], err = r.readAtomEnc()
 		if err != nil {
 			return nil, fmt.Errorf("atom %d: %w", i, err)
 		}
@@ -677,7 +690,7 @@ func decodeObject(r *reader) (*sm33.Object, error) {
 		}
 
 	case sm33.CkJSObject:
-		if err = skipObjectLiteral(r); err != nil {
+		if obj.Literal, err = readObjectLiteral(r); err != nil {
 			return nil, err
 		}
 
@@ -774,11 +787,11 @@ func decodeConst(r *reader) (sm33.Const, error) {
 		bits := binary.LittleEndian.Uint64(b)
 		return sm33.Const{Kind: sm33.ConstDouble, Double: math.Float64frombits(bits)}, nil
 	case scriptAtom:
-		s, err := r.readAtom()
+		s, twoByte, err := r.readAtomEnc()
 		if err != nil {
 			return sm33.Const{}, err
 		}
-		return sm33.Const{Kind: sm33.ConstAtom, Atom: s}, nil
+		return sm33.Const{Kind: sm33.ConstAtom, Atom: s, TwoByte: twoByte}, nil
 	case scriptTrue:
 		return sm33.Const{Kind: sm33.ConstTrue}, nil
 	case scriptFalse:
@@ -790,10 +803,11 @@ func decodeConst(r *reader) (sm33.Const, error) {
 	case scriptHole:
 		return sm33.Const{Kind: sm33.ConstHole}, nil
 	case scriptObject:
-		if err = skipObjectLiteral(r); err != nil {
+		lit, err := readObjectLiteral(r)
+		if err != nil {
 			return sm33.Const{}, err
 		}
-		return sm33.Const{Kind: sm33.ConstObject}, nil
+		return sm33.Const{Kind: sm33.ConstObject, Object: lit}, nil
 	default:
 		if r.mode == sm33.BestEffort {
 			r.diags = append(r.diags, sm33.Diagnostic{
@@ -807,15 +821,9 @@ func decodeConst(r *reader) (sm33.Const, error) {
 	}
 }
 
-// skipConst reads and discards one XDRScriptConst (used by skipObjectLiteral dense elements).
-func skipConst(r *reader) error {
-	_, err := decodeConst(r)
-	return err
-}
-
 // decodeRegexp reads one XDRScriptRegExpObject.
 func decodeRegexp(r *reader) (sm33.Regexp, error) {
-	source, err := r.readAtom()
+	source, twoByte, err := r.readAtomEnc()
 	if err != nil {
 		return sm33.Regexp{}, err
 	}
@@ -823,7 +831,7 @@ func decodeRegexp(r *reader) (sm33.Regexp, error) {
 	if err != nil {
 		return sm33.Regexp{}, err
 	}
-	return sm33.Regexp{Source: source, Flags: flags}, nil
+	return sm33.Regexp{Source: source, Flags: flags, TwoByte: twoByte}, nil
 }
 
 // skipStaticBlockObject reads and discards a StaticBlockObject.
@@ -851,72 +859,79 @@ func skipStaticBlockObject(r *reader) error {
 	return nil
 }
 
-// skipObjectLiteral reads and discards an XDRObjectLiteral.
-func skipObjectLiteral(r *reader) error {
+// readObjectLiteral reads an XDRObjectLiteral.
+func readObjectLiteral(r *reader) (*sm33.Literal, error) {
 	isArray, err := r.u32()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	lit := &sm33.Literal{Array: isArray != 0}
 
 	if isArray != 0 {
 		if _, err = r.u32(); err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		if _, err = r.u32(); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	// capacity
 	if _, err = r.u32(); err != nil {
-		return err
+		return nil, err
 	}
 
 	// initialized (dense elements count)
 	initialized, err := r.u32()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	initialized, err = r.clampCount(initialized, 4, "dense elements")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for i := uint32(0); i < initialized; i++ {
-		if err = skipConst(r); err != nil {
-			return fmt.Errorf("dense element %d: %w", i, err)
+		c, err := decodeConst(r)
+		if err != nil {
+			return nil, fmt.Errorf("dense element %d: %w", i, err)
 		}
+		lit.Elems = append(lit.Elems, c)
 	}
 
 	// nslot (named properties)
 	nslot, err := r.u32()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	nslot, err = r.clampCount(nslot, 8, "object slots")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for i := uint32(0); i < nslot; i++ {
 		idType, err := r.u32()
 		if err != nil {
-			return err
+			return nil, err
 		}
+		var p sm33.LiteralProp
 		if idType == 0 { // JSID_TYPE_STRING
-			if _, err = r.readAtom(); err != nil {
-				return fmt.Errorf("slot %d atom: %w", i, err)
+			if p.Key, p.TwoByte, err = r.readAtomEnc(); err != nil {
+				return nil, fmt.Errorf("slot %d atom: %w", i, err)
 			}
 		} else { // JSID_TYPE_INT
-			if _, err = r.u32(); err != nil {
-				return fmt.Errorf("slot %d int id: %w", i, err)
+			id, err := r.u32()
+			if err != nil {
+				return nil, fmt.Errorf("slot %d int id: %w", i, err)
 			}
+			p.Key, p.Int = strconv.Itoa(int(int32(id))), true
 		}
-		if err = skipConst(r); err != nil {
-			return fmt.Errorf("slot %d value: %w", i, err)
+		if p.Value, err = decodeConst(r); err != nil {
+			return nil, fmt.Errorf("slot %d value: %w", i, err)
 		}
+		lit.Props = append(lit.Props, p)
 	}
 
-	return nil
+	return lit, nil
 }
 
 // readPackedFields reads a LazyScript uint64 packedFields and extracts counts.
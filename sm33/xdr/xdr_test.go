@@ -178,3 +178,72 @@ func FuzzDecode(f *testing.F) {
 		DecodeOpt(data, sm33.Options{Mode: sm33.BestEffort})
 	})
 }
+
+func TestObjectLiteral(t *testing.T) {
+	var data []byte
+	u32 := func(vs ...uint32) {
+		for _, v := range vs {
+			data = binary.LittleEndian.AppendUint32(data, v)
+		}
+	}
+	// { title: "hi", "é€": 7, 3: true }, keys and values as SpiderMonkey
+	// 33 writes them: Latin-1 atoms have the low header bit set.
+	u32(0, 0, 4, 0, 3)
+	u32(0, 5<<1|1)
+	data = append(data, "title"...)
+	u32(scriptAtom, 2<<1|1)
+	data = append(data, "hi"...)
+	u32(0, 2<<1)
+	data = binary.LittleEndian.AppendUint16(data, 0xe9)
+	data = binary.LittleEndian.AppendUint16(data, 0x20ac)
+	u32(scriptInt, 7)
+	u32(1, 3, scriptTrue)
+
+	lit, err := readObjectLiteral(newReader(data, sm33.Strict, sm33.MaxReadBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sm33.LiteralProp{
+		{Key: "title", Value: sm33.Const{Kind: sm33.ConstAtom, Atom: "hi"}},
+		{Key: "é€", TwoByte: true, Value: sm33.Const{Kind: sm33.ConstInt, Int: 7}},
+		{Key: "3", Int: true, Value: sm33.Const{Kind: sm33.ConstTrue}},
+	}
+	if lit.Array || len(lit.Props) != len(want) {
+		t.Fatalf("literal = %+v", lit)
+	}
+	for i, p := range lit.Props {
+		if p != want[i] {
+			t.Errorf("prop %d = %+v, want %+v", i, p, want[i])
+		}
+	}
+}
+
+func TestNestedObjectLiteral(t *testing.T) {
+	var data []byte
+	u32 := func(vs ...uint32) {
+		for _, v := range vs {
+			data = binary.LittleEndian.AppendUint32(data, v)
+		}
+	}
+	// { a: { b: "c" } }
+	u32(0, 0, 4, 0, 1)
+	u32(0, 1<<1|1)
+	data = append(data, 'a')
+	u32(scriptObject, 0, 0, 4, 0, 1)
+	u32(0, 1<<1|1)
+	data = append(data, 'b')
+	u32(scriptAtom, 1<<1|1)
+	data = append(data, 'c')
+
+	lit, err := readObjectLiteral(newReader(data, sm33.Strict, sm33.MaxReadBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lit.Props) != 1 || lit.Props[0].Value.Kind != sm33.ConstObject {
+		t.Fatalf("literal = %+v", lit)
+	}
+	inner := lit.Props[0].Value.Object
+	if inner == nil || len(inner.Props) != 1 || inner.Props[0].Key != "b" || inner.Props[0].Value.Atom != "c" {
+		t.Errorf("inner literal = %+v", inner)
+	}
+}
@@ -0,0 +1,194 @@
+// Package xref indexes the cross references of a script tree: every
+// instruction that references an atom, a numeric constant, a regexp or a
+// function object, and every call site of each callee.
+//
+// Atoms cover property and global names as well as string literals.
+// Constants are keyed by their rendered value ("42", "0.5"), including
+// the small integers pushed by zero, one, int8, int32, uint16 and uint24.
+// Call sites come from the callgraph, keyed by the callee node for inner
+// functions and by receiver path for everything else
+// ("cc.director.runScene").
+package xref
+
+import (
+	"sort"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	"github.com/zboralski/spidermonkey-dumper/sm33/bytecode"
+	"github.com/zboralski/spidermonkey-dumper/sm33/callgraph"
+	"github.com/zboralski/spidermonkey-dumper/sm33/ir"
+	"github.com/zboralski/spidermonkey-dumper/sm33/names"
+	"github.com/zboralski/spidermonkey-dumper/sm33/srcnote"
+)
+
+// Opcodes that push an integer without a constant-table entry.
+const (
+	opZero   = 62
+	opOne    = 63
+	opUint16 = 88
+	opUint24 = 188
+	opInt8   = 215
+	opInt32  = 216
+)
+
+// Ref is one referencing instruction.
+type Ref struct {
+	Func string `json:"func"` // display name of the containing function
+	Off  int    `json:"off"`
+	Line int    `json:"line"`
+	Op   string `json:"op"`            // opcode, or edge kind for call sites
+	Via  string `json:"via,omitempty"` // receiving call, for registrations
+}
+
+// Index holds the references of one tree, each list in function then
+// offset order.
+type Index struct {
+	Atoms   map[string][]Ref `json:"atoms"`
+	Consts  map[string][]Ref `json:"consts"`
+	Regexps map[string][]Ref `json:"regexps"`
+	Funcs   map[string][]Ref `json:"funcs"` // lambda and deffun of each inner function
+	Calls   map[string][]Ref `json:"calls"` // call sites and registrations of each callee
+}
+
+// Build indexes root and its inner functions.
+func Build(root *sm33.Script) *Index {
+	x := &Index{
+		Atoms:   map[string][]Ref{},
+		Consts:  map[string][]Ref{},
+		Regexps: map[string][]Ref{},
+		Funcs:   map[string][]Ref{},
+		Calls:   map[string][]Ref{},
+	}
+	nm := names.Infer(root)
+	order := map[string]int{}
+	var walk func(s *sm33.Script, name string)
+	walk = func(s *sm33.Script, name string) {
+		order[name] = len(order)
+		x.scan(s, name, nm)
+		for _, obj := range s.Objects {
+			if fn := obj.Function; obj.Kind == sm33.CkJSFunction && fn != nil && fn.Script != nil {
+				walk(fn.Script, nm.Of(fn))
+			}
+		}
+	}
+	walk(root, "main")
+
+	for _, e := range callgraph.Build(root).Edges {
+		for _, site := range e.Sites {
+			x.Calls[e.Callee] = append(x.Calls[e.Callee], Ref{
+				Func: e.Caller,
+				Off:  site.Offset,
+				Line: site.Line,
+				Op:   e.Kind.String(),
+				Via:  site.Via,
+			})
+		}
+	}
+	for _, refs := range x.Calls {
+		sort.SliceStable(refs, func(i, j int) bool {
+			a, b := refs[i], refs[j]
+			if a.Func != b.Func {
+				return order[a.Func] < order[b.Func]
+			}
+			return a.Off < b.Off
+		})
+	}
+	return x
+}
+
+func (x *Index) scan(s *sm33.Script, fn string, nm *names.Names) {
+	bc := s.Bytecode
+	lines := srcnote.NewLines(s)
+	for _, in := range ir.Decode(bc) {
+		ref := func() Ref {
+			return Ref{Func: fn, Off: in.Off, Line: lines.Line(in.Off), Op: in.Name()}
+		}
+		idx, _ := bytecode.GetUint32Index(bc, in.Off)
+		switch in.Op {
+		case opZero, opOne, opUint16, opUint24, opInt8, opInt32:
+			k := num(intOperand(bc, in))
+			x.Consts[k] = append(x.Consts[k], ref())
+			continue
+		}
+		switch bytecode.JofType(bytecode.Opcodes[in.Op].Format) {
+		case bytecode.JOF_ATOM, bytecode.JOF_ATOMOBJECT:
+			if int(idx) < len(s.Atoms) {
+				a := s.Atoms[idx]
+				x.Atoms[a] = append(x.Atoms[a], ref())
+			}
+		case bytecode.JOF_DOUBLE:
+			if int(idx) < len(s.Consts) {
+				k := ir.ConstExpr(s.Consts[idx]).String()
+				x.Consts[k] = append(x.Consts[k], ref())
+			}
+		case bytecode.JOF_REGEXP:
+			if int(idx) < len(s.Regexps) {
+				k := s.Regexps[idx].Source
+				x.Regexps[k] = append(x.Regexps[k], ref())
+			}
+		case bytecode.JOF_OBJECT:
+			if int(idx) < len(s.Objects) && s.Objects[idx].Function != nil {
+				k := nm.Of(s.Objects[idx].Function)
+				x.Funcs[k] = append(x.Funcs[k], ref())
+			}
+		}
+	}
+}
+
+func intOperand(bc []byte, in ir.Instr) int32 {
+	switch in.Op {
+	case opOne:
+		return 1
+	case opUint16:
+		v, _ := bytecode.GetUint16(bc, in.Off)
+		return int32(v)
+	case opUint24:
+		v, _ := bytecode.GetUint24(bc, in.Off)
+		return int32(v)
+	case opInt8:
+		v, _ := bytecode.GetInt8(bc, in.Off)
+		return int32(v)
+	case opInt32:
+		v, _ := bytecode.GetInt32(bc, in.Off)
+		return v
+	}
+	return 0
+}
+
+func num(v int32) string {
+	return ir.ConstExpr(sm33.Const{Kind: sm33.ConstInt, Int: v}).String()
+}
+
+// Hit is the references to one name of one kind.
+type Hit struct {
+	Kind string `json:"kind"` // "atom", "const", "regexp", "func" or "call"
+	Name string `json:"name"`
+	Refs []Ref  `json:"refs"`
+}
+
+// Lookup returns the references to name of every kind, in the order
+// atom, const, regexp, func, call.
+func (x *Index) Lookup(name string) []Hit {
+	var out []Hit
+	for _, k := range []struct {
+		kind string
+		m    map[string][]Ref
+	}{
+		{"atom", x.Atoms},
+		{"const", x.Consts},
+		{"regexp", x.Regexps},
+		{"func", x.Funcs},
+		{"call", x.Calls},
+	} {
+		if refs := k.m[name]; len(refs) > 0 {
+			out = append(out, Hit{Kind: k.kind, Name: name, Refs: refs})
+		}
+	}
+	return out
+}
+
+// Callers returns the call sites and registrations of the inner function
+// name.
+func (x *Index) Callers(name string) []Ref {
+	return x.Calls[name]
+}
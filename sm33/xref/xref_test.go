@@ -0,0 +1,75 @@
+package xref
+
+import (
+	"testing"
+
+	"github.com/zboralski/spidermonkey-dumper/sm33"
+	. "github.com/zboralski/spidermonkey-dumper/sm33/internal/asmtest"
+)
+
+// tree builds:
+//
+//	var f = function (a) { return /x+/; };
+//	f("hi"); f(42); 2.5;
+func tree() *sm33.Script {
+	f := &sm33.Script{Nargs: 1, Bytecode: Asm(AtomOp(OpRegexp, 0), []byte{5}), Regexps: []sm33.Regexp{{Source: "x+"}}}
+	return &sm33.Script{
+		Atoms:   []string{"f", "hi"},
+		Consts:  []sm33.Const{{Kind: sm33.ConstDouble, Double: 2.5}},
+		Objects: []*sm33.Object{{Kind: sm33.CkJSFunction, Function: &sm33.Function{Nargs: 1, Script: f}}},
+		Bytecode: Asm(
+			AtomOp(OpBindname, 0), AtomOp(OpLambda, 0), AtomOp(OpSetname, 0), []byte{OpPop}, // 0
+			AtomOp(OpName, 0), []byte{OpUndefined}, AtomOp(OpString, 1), []byte{OpCall, 0, 1, OpPop}, // 16
+			AtomOp(OpName, 0), []byte{OpUndefined, OpInt8, 42, OpCall, 0, 1, OpPop}, // 31
+			AtomOp(OpDouble, 0), []byte{OpPop}, // 43
+			[]byte{OpRetrval},
+		),
+	}
+}
+
+func TestBuild(t *testing.T) {
+	x := Build(tree())
+
+	offs := func(refs []Ref) []int {
+		var out []int
+		for _, r := range refs {
+			out = append(out, r.Off)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		kind, name string
+		want       []int
+	}{
+		{"atom", "f", []int{0, 10, 16, 31}},
+		{"atom", "hi", []int{22}},
+		{"const", "42", []int{37}},
+		{"const", "2.5", []int{43}},
+		{"regexp", "x+", []int{0}},
+		{"func", "f", []int{5}},
+		{"call", "f", []int{27, 39}},
+	} {
+		var got []int
+		for _, h := range x.Lookup(tc.name) {
+			if h.Kind == tc.kind {
+				got = offs(h.Refs)
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s %q = %v, want %v", tc.kind, tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s %q = %v, want %v", tc.kind, tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+	if r := x.Regexps["x+"]; len(r) != 1 || r[0].Func != "f" || r[0].Op != "regexp" {
+		t.Errorf("regexp ref = %+v", r)
+	}
+	if c := x.Callers("f"); len(c) != 2 || c[0].Func != "main" || c[0].Op != "calls" {
+		t.Errorf("callers = %+v", c)
+	}
+}